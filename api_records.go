@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/envelope"
+	"github.com/DylanCoon99/delivery/internal/leadfile"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+// APIDeliveryConfig.Format values
+const (
+	apiFormatMultipartCSV = "multipart_csv"
+	apiFormatJSONArray    = "json_array"
+	apiFormatNDJSON       = "ndjson"
+	apiFormatPerLead      = "per_lead"
+)
+
+// historyPageSize is how many delivery history rows previouslySettledLeads reads at a time
+const historyPageSize = 50
+
+// Per-lead outcomes recorded in apiLeadResult.Status
+const (
+	leadStatusDelivered        = "delivered"
+	leadStatusAlreadyDelivered = "already_delivered" // Settled (delivered or rejected) by an earlier attempt of the same job
+	leadStatusRejected         = "rejected"          // Permanent (4xx) rejection; not retried
+	leadStatusFailed           = "failed"            // Retryable failure or not attempted
+)
+
+// isValidAPIFormat reports whether format is a supported APIDeliveryConfig.Format
+func isValidAPIFormat(format string) bool {
+	switch format {
+	case "", apiFormatMultipartCSV, apiFormatJSONArray, apiFormatNDJSON, apiFormatPerLead:
+		return true
+	}
+	return false
+}
+
+// apiDeliverySummary records the per-lead outcome of a JSON API delivery
+type apiDeliverySummary struct {
+	Format           string          `json:"format"`
+	Requests         int             `json:"requests"`
+	Delivered        int             `json:"delivered"`
+	AlreadyDelivered int             `json:"already_delivered,omitempty"`
+	Rejected         int             `json:"rejected,omitempty"`
+	Failed           int             `json:"failed,omitempty"`
+	Leads            []apiLeadResult `json:"leads,omitempty"`
+}
+
+type apiLeadResult struct {
+	LeadID     string `json:"lead_id,omitempty"`
+	Row        int    `json:"row"`
+	Status     string `json:"status"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Class      string `json:"class,omitempty"` // Response classification for non-2xx responses
+	Error      string `json:"error,omitempty"`
+}
+
+// recordFieldNames maps each column to its JSON field name. field_map may be keyed by
+// column key or header; unmapped base fields use their key and questions their text.
+func recordFieldNames(cfg APIDeliveryConfig, columns []leadfile.Column) []string {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		switch {
+		case cfg.FieldMap[col.Key] != "":
+			names[i] = cfg.FieldMap[col.Key]
+		case cfg.FieldMap[col.Header] != "":
+			names[i] = cfg.FieldMap[col.Header]
+		case col.Question:
+			names[i] = col.Header
+		default:
+			names[i] = col.Key
+		}
+	}
+	return names
+}
+
+// encodeRecords builds the request body for a batch of rows in the configured format.
+// Each record is encoded as its row is read, so the row can be wiped before the next.
+func encodeRecords(format string, names []string, table *leadfile.Table, rows []int) ([]byte, string, error) {
+	array := format != apiFormatPerLead && format != apiFormatNDJSON
+	var buf bytes.Buffer
+	if array {
+		buf.WriteByte('[')
+	}
+	record := make(map[string]string, len(names))
+	var row []string
+	for i, idx := range rows {
+		var err error
+		if row, err = table.Row(idx, row); err != nil {
+			return nil, "", err
+		}
+		for c, name := range names {
+			record[name] = row[c]
+		}
+		encoded, err := json.Marshal(record)
+		table.RowDone(idx)
+		if err != nil {
+			return nil, "", err
+		}
+		if array && i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(encoded)
+		envelope.Zero(encoded)
+		if format == apiFormatNDJSON {
+			buf.WriteByte('\n')
+		}
+	}
+	if array {
+		buf.WriteByte(']')
+	}
+
+	if format == apiFormatNDJSON {
+		return buf.Bytes(), "application/x-ndjson", nil
+	}
+	return buf.Bytes(), "application/json", nil
+}
+
+// deliverAPIRecords posts leads as JSON records: batches of a JSON array or NDJSON
+// lines, or one request per lead. Every lead's outcome goes into summary.API.
+// Permanent rejections of some leads don't fail the job; any retryable failure does,
+// and the retry skips leads in settled. A response carrying Retry-After stops the run
+// so the remaining leads wait for the retry instead of hammering the buyer.
+func deliverAPIRecords(ctx context.Context, job *queries.DeliveryJob, cfg APIDeliveryConfig, table *leadfile.Table, settled map[string]bool, summary *deliverySummary) error {
+	result := &apiDeliverySummary{Format: cfg.Format}
+	summary.API = result
+	names := recordFieldNames(cfg, table.Columns)
+
+	// Lead IDs are taken up front: the table only holds the page it is reading
+	var pending []int
+	ids := make(map[int]string)
+	for i := 0; i < table.Rows; i++ {
+		id, err := table.Lead(i)
+		if err != nil {
+			return fmt.Errorf("failed to read lead %d: %w", i, err)
+		}
+		if _, done := settled[id]; done {
+			result.AlreadyDelivered++
+			result.Leads = append(result.Leads, apiLeadResult{LeadID: id, Row: i, Status: leadStatusAlreadyDelivered})
+			continue
+		}
+		pending = append(pending, i)
+		ids[i] = id
+	}
+
+	batchSize := cfg.BatchSize
+	if cfg.Format == apiFormatPerLead {
+		batchSize = 1
+	}
+	if batchSize <= 0 {
+		batchSize = len(pending)
+	}
+
+	log.Printf("Sending %d leads to %s as %s (batch size %d, %d already delivered)",
+		len(pending), cfg.URL, cfg.Format, batchSize, result.AlreadyDelivered)
+
+	var lastRetryableErr, lastPermanentErr error
+	throttled := false
+	for start := 0; start < len(pending); start += batchSize {
+		batch := pending[start:min(start+batchSize, len(pending))]
+
+		status, errMsg, httpStatus, class := leadStatusDelivered, "", 0, ""
+		if throttled {
+			status, errMsg = leadStatusFailed, "not attempted: buyer asked to retry later"
+		} else if nearDeadline(ctx) {
+			lastRetryableErr = fmt.Errorf("invocation deadline reached with %d leads unsent", len(pending)-start)
+			status, errMsg = leadStatusFailed, "not attempted: invocation deadline reached"
+		} else {
+			body, contentType, err := encodeRecords(cfg.Format, names, table, batch)
+			if err != nil {
+				return fmt.Errorf("failed to encode lead records: %w", err)
+			}
+			result.Requests++
+			httpStatus, err = sendAPIRequest(ctx, cfg, apiBody{data: body}, contentType)
+			summary.APIResponse = newAPIResponseSummary(httpStatus, err)
+			if err != nil {
+				class = summary.APIResponse.Class
+			}
+			switch {
+			case err == nil:
+			case errors.Is(err, ErrPermanentAPIFailure):
+				lastPermanentErr = err
+				status, errMsg = leadStatusRejected, truncate(err.Error(), 200)
+			default:
+				lastRetryableErr = err
+				status, errMsg = leadStatusFailed, truncate(err.Error(), 200)
+				throttled = retryAfter(err) > 0
+			}
+		}
+
+		for _, idx := range batch {
+			result.Leads = append(result.Leads, apiLeadResult{
+				LeadID:     ids[idx],
+				Row:        idx,
+				Status:     status,
+				HTTPStatus: httpStatus,
+				Class:      class,
+				Error:      errMsg,
+			})
+			switch status {
+			case leadStatusDelivered:
+				result.Delivered++
+			case leadStatusRejected:
+				result.Rejected++
+			default:
+				result.Failed++
+			}
+		}
+	}
+
+	log.Printf("API record delivery: %d delivered, %d rejected, %d failed in %d requests",
+		result.Delivered, result.Rejected, result.Failed, result.Requests)
+
+	if lastRetryableErr != nil {
+		return fmt.Errorf("%d of %d leads not delivered: %w", result.Failed, table.Rows, lastRetryableErr)
+	}
+	if result.Delivered == 0 && result.AlreadyDelivered == 0 && lastPermanentErr != nil {
+		return fmt.Errorf("all %d leads rejected: %w", result.Rejected, lastPermanentErr)
+	}
+	return nil
+}
+
+// acceptedRows returns the rows of the leads the buyer has: delivered by this attempt, or
+// by an earlier one according to settled
+func (s *apiDeliverySummary) acceptedRows(settled map[string]bool) []int {
+	rows := []int{}
+	for _, lead := range s.Leads {
+		switch {
+		case lead.Status == leadStatusDelivered:
+		case lead.Status == leadStatusAlreadyDelivered && settled[lead.LeadID]:
+		default:
+			continue
+		}
+		rows = append(rows, lead.Row)
+	}
+	return rows
+}
+
+// previouslySettledLeads maps the leads that earlier attempts of this job settled to
+// whether the buyer accepted them (true) or permanently rejected them (false), from the
+// per-lead results in the job's delivery history
+func previouslySettledLeads(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob) map[string]bool {
+	settled := make(map[string]bool)
+	params := queries.ListHistoryByJobParams{
+		JobID:    utils.NullUUID(job.ID),
+		TenantID: job.TenantID,
+		Limit:    historyPageSize,
+	}
+	for {
+		history, err := q.ListHistoryByJob(ctx, params)
+		if err != nil {
+			log.Printf("Failed to load delivery history for job %s: %v", job.ID, err)
+			return nil
+		}
+		for _, h := range history {
+			addSettledLeads(settled, h)
+		}
+		if len(history) < historyPageSize {
+			return settled
+		}
+		params.Offset += historyPageSize
+	}
+}
+
+// addSettledLeads adds the leads one attempt delivered or rejected to settled
+func addSettledLeads(settled map[string]bool, h queries.DeliveryHistory) {
+	if !h.PayloadSummary.Valid {
+		return
+	}
+	var prev deliverySummary
+	if err := json.Unmarshal(h.PayloadSummary.RawMessage, &prev); err != nil || prev.API == nil {
+		return
+	}
+	for _, lead := range prev.API.Leads {
+		if lead.LeadID == "" {
+			continue
+		}
+		switch lead.Status {
+		case leadStatusDelivered:
+			settled[lead.LeadID] = true
+		case leadStatusRejected:
+			settled[lead.LeadID] = false
+		}
+	}
+}
+
+// truncate shortens s to at most n bytes for summaries and logs
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
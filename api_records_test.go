@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/leadfile"
+)
+
+// recordTable is a table of n leads, lead<i>, with a single email column
+func recordTable(n int) *leadfile.Table {
+	return &leadfile.Table{
+		Columns: []leadfile.Column{{Key: "email", Header: "Email", Value: func(row int) string {
+			return fmt.Sprintf("lead%d@example.com", row)
+		}}},
+		Rows:   n,
+		LeadID: func(row int) string { return fmt.Sprintf("lead%d", row) },
+	}
+}
+
+// recordServer answers each record by its email: respond picks the status and any
+// Retry-After for a request, and requests collects the emails each request carried
+type recordServer struct {
+	*httptest.Server
+	requests [][]string
+}
+
+func newRecordServer(t *testing.T, respond func(emails []string) (int, string)) *recordServer {
+	t.Helper()
+	s := &recordServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var emails []string
+		dec := json.NewDecoder(r.Body)
+		for dec.More() {
+			// An array of records, or one record per request or NDJSON line
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				t.Errorf("invalid body: %v", err)
+				return
+			}
+			var records []map[string]string
+			if raw[0] != '[' {
+				raw = append(append(json.RawMessage{'['}, raw...), ']')
+			}
+			if err := json.Unmarshal(raw, &records); err != nil {
+				t.Errorf("invalid records: %v", err)
+				return
+			}
+			for _, record := range records {
+				emails = append(emails, record["email"])
+			}
+		}
+		s.requests = append(s.requests, emails)
+		status, retryAfter := respond(emails)
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// leadStatuses lists each lead's outcome as "lead:status"
+func leadStatuses(s *apiDeliverySummary) []string {
+	statuses := make([]string, len(s.Leads))
+	for i, lead := range s.Leads {
+		statuses[i] = lead.LeadID + ":" + lead.Status
+	}
+	return statuses
+}
+
+func TestDeliverAPIRecordsFormats(t *testing.T) {
+	quietLogs(t)
+	tests := []struct {
+		format    string
+		batchSize int
+		requests  [][]string
+	}{
+		{format: apiFormatJSONArray, requests: [][]string{{"lead0@example.com", "lead1@example.com", "lead2@example.com"}}},
+		{format: apiFormatJSONArray, batchSize: 2, requests: [][]string{{"lead0@example.com", "lead1@example.com"}, {"lead2@example.com"}}},
+		{format: apiFormatNDJSON, batchSize: 2, requests: [][]string{{"lead0@example.com", "lead1@example.com"}, {"lead2@example.com"}}},
+		// The batch size doesn't apply per lead
+		{format: apiFormatPerLead, batchSize: 2, requests: [][]string{{"lead0@example.com"}, {"lead1@example.com"}, {"lead2@example.com"}}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s by %d", tt.format, tt.batchSize), func(t *testing.T) {
+			server := newRecordServer(t, func([]string) (int, string) { return http.StatusAccepted, "" })
+			cfg := APIDeliveryConfig{URL: server.URL, Format: tt.format, BatchSize: tt.batchSize}
+			summary := &deliverySummary{}
+			if err := deliverAPIRecords(context.Background(), testJob(), cfg, recordTable(3), nil, summary); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(server.requests, tt.requests) {
+				t.Errorf("requests %q, want %q", server.requests, tt.requests)
+			}
+			if api := summary.API; api.Requests != len(tt.requests) || api.Delivered != 3 || api.Failed != 0 {
+				t.Errorf("summary %+v, want 3 delivered in %d requests", api, len(tt.requests))
+			}
+		})
+	}
+}
+
+func TestDeliverAPIRecordsOutcomes(t *testing.T) {
+	quietLogs(t)
+	tests := []struct {
+		name      string
+		respond   map[string]int // Status by email; 200 otherwise
+		retry     string         // Retry-After sent with non-2xx responses
+		statuses  []string
+		requests  int
+		err       string // Empty for success
+		permanent bool
+	}{
+		{
+			name:     "some rejected",
+			respond:  map[string]int{"lead1@example.com": http.StatusUnprocessableEntity},
+			statuses: []string{"lead0:delivered", "lead1:rejected", "lead2:delivered", "lead3:delivered"},
+			requests: 4,
+		},
+		{
+			name:      "all rejected",
+			respond:   map[string]int{"lead0@example.com": 400, "lead1@example.com": 400, "lead2@example.com": 400, "lead3@example.com": 400},
+			statuses:  []string{"lead0:rejected", "lead1:rejected", "lead2:rejected", "lead3:rejected"},
+			requests:  4,
+			err:       "all 4 leads rejected",
+			permanent: true,
+		},
+		{
+			// Other leads are still tried after a server error
+			name:     "server error",
+			respond:  map[string]int{"lead1@example.com": http.StatusServiceUnavailable, "lead2@example.com": http.StatusBadRequest},
+			statuses: []string{"lead0:delivered", "lead1:failed", "lead2:rejected", "lead3:delivered"},
+			requests: 4,
+			err:      "1 of 4 leads not delivered",
+		},
+		{
+			// Retry-After leaves the rest for the retry
+			name:     "throttled",
+			respond:  map[string]int{"lead1@example.com": http.StatusTooManyRequests},
+			retry:    "60",
+			statuses: []string{"lead0:delivered", "lead1:failed", "lead2:failed", "lead3:failed"},
+			requests: 2,
+			err:      "3 of 4 leads not delivered",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordServer(t, func(emails []string) (int, string) {
+				if status, ok := tt.respond[emails[0]]; ok {
+					return status, tt.retry
+				}
+				return http.StatusOK, ""
+			})
+			cfg := APIDeliveryConfig{URL: server.URL, Format: apiFormatPerLead}
+			summary := &deliverySummary{}
+			err := deliverAPIRecords(context.Background(), testJob(), cfg, recordTable(4), nil, summary)
+
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+			if got := errors.Is(err, ErrPermanentAPIFailure); got != tt.permanent {
+				t.Errorf("permanent = %v, want %v", got, tt.permanent)
+			}
+			if got := leadStatuses(summary.API); !reflect.DeepEqual(got, tt.statuses) {
+				t.Errorf("statuses %q, want %q", got, tt.statuses)
+			}
+			if len(server.requests) != tt.requests || summary.API.Requests != tt.requests {
+				t.Errorf("%d requests (summary %d), want %d", len(server.requests), summary.API.Requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestDeliverAPIRecordsThrottledSummary(t *testing.T) {
+	quietLogs(t)
+	server := newRecordServer(t, func([]string) (int, string) { return http.StatusTooManyRequests, "120" })
+	cfg := APIDeliveryConfig{URL: server.URL, Format: apiFormatJSONArray, BatchSize: 1}
+	summary := &deliverySummary{}
+	err := deliverAPIRecords(context.Background(), testJob(), cfg, recordTable(2), nil, summary)
+	if got := retryAfter(err); got != 2*time.Minute {
+		t.Errorf("retryAfter = %s, want the buyer's 2m", got)
+	}
+
+	want := []apiLeadResult{
+		{LeadID: "lead0", Row: 0, Status: leadStatusFailed, HTTPStatus: http.StatusTooManyRequests, Class: apiClassRetryable, Error: "api responded with status 429: "},
+		{LeadID: "lead1", Row: 1, Status: leadStatusFailed, Error: "not attempted: buyer asked to retry later"},
+	}
+	if !reflect.DeepEqual(summary.API.Leads, want) {
+		t.Errorf("leads %+v, want %+v", summary.API.Leads, want)
+	}
+	if r := summary.APIResponse; r == nil || r.HTTPStatus != http.StatusTooManyRequests || r.RetryAfterSec != 120 {
+		t.Errorf("api response %+v, want the 429", r)
+	}
+}
+
+func TestDeliverAPIRecordsDeadline(t *testing.T) {
+	quietLogs(t)
+	server := newRecordServer(t, func([]string) (int, string) { return http.StatusOK, "" })
+	ctx, cancel := context.WithTimeout(context.Background(), invocationDeadlineReserve/2)
+	defer cancel()
+
+	summary := &deliverySummary{}
+	err := deliverAPIRecords(ctx, testJob(), APIDeliveryConfig{URL: server.URL, Format: apiFormatPerLead}, recordTable(2), nil, summary)
+	if err == nil || errors.Is(err, ErrPermanentAPIFailure) {
+		t.Errorf("err = %v, want a retryable error", err)
+	}
+	if len(server.requests) != 0 || summary.API.Failed != 2 {
+		t.Errorf("%d requests, %d failed inside the deadline reserve, want 0 and 2", len(server.requests), summary.API.Failed)
+	}
+}
+
+// historyRow is a delivery_history row for job carrying summary, or a NULL summary
+func historyRow(job *queries.DeliveryJob, summary *deliverySummary) []driver.Value {
+	var raw driver.Value
+	if summary != nil {
+		raw, _ = json.Marshal(summary)
+	}
+	return []driver.Value{uuid.New(), job.TenantID, job.ID, job.BuyerID, job.DeliveryMethodID, "failed", "api responded with status 503", raw, time.Now()}
+}
+
+func TestPreviouslySettledLeads(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	job := testJob()
+
+	// The history is newest first; the oldest attempt is on the second page
+	latest := &deliverySummary{API: &apiDeliverySummary{Leads: []apiLeadResult{
+		{LeadID: "lead0", Status: leadStatusAlreadyDelivered},
+		{LeadID: "lead2", Status: leadStatusDelivered},
+		{LeadID: "lead3", Status: leadStatusFailed},
+		{Status: leadStatusDelivered}, // No lead ID to match
+	}}}
+	oldest := &deliverySummary{API: &apiDeliverySummary{Leads: []apiLeadResult{
+		{LeadID: "lead0", Status: leadStatusDelivered},
+		{LeadID: "lead1", Status: leadStatusRejected},
+		{LeadID: "lead2", Status: leadStatusFailed},
+	}}}
+	first := sqlmock.NewRows(historyColumns).AddRow(historyRow(job, latest)...)
+	for range historyPageSize - 1 {
+		first.AddRow(historyRow(job, &deliverySummary{LeadCount: 4})...) // Not an API delivery
+	}
+	mock.ExpectQuery(queryName("ListHistoryByJob")).
+		WithArgs(job.ID, job.TenantID, historyPageSize, 0).
+		WillReturnRows(first)
+	mock.ExpectQuery(queryName("ListHistoryByJob")).
+		WithArgs(job.ID, job.TenantID, historyPageSize, historyPageSize).
+		WillReturnRows(sqlmock.NewRows(historyColumns).
+			AddRow(historyRow(job, nil)...).
+			AddRow(historyRow(job, oldest)...))
+
+	got := previouslySettledLeads(context.Background(), q, job)
+	want := map[string]bool{"lead0": true, "lead1": false, "lead2": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("settled = %v, want %v", got, want)
+	}
+}
+
+func TestPreviouslySettledLeadsHistoryFails(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	job := testJob()
+	mock.ExpectQuery(queryName("ListHistoryByJob")).WillReturnError(errors.New("connection reset"))
+	if got := previouslySettledLeads(context.Background(), q, job); got != nil {
+		t.Errorf("settled = %v, want nil", got)
+	}
+}
+
+// A retry sends only the leads the earlier attempt left unsettled
+func TestDeliverAPIRecordsRetrySkipsSettled(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	job := testJob()
+
+	failing := map[string]int{"lead1@example.com": http.StatusBadRequest, "lead2@example.com": http.StatusBadGateway}
+	server := newRecordServer(t, func(emails []string) (int, string) {
+		if status, ok := failing[emails[0]]; ok {
+			return status, ""
+		}
+		return http.StatusOK, ""
+	})
+	cfg := APIDeliveryConfig{URL: server.URL, Format: apiFormatPerLead}
+
+	first := &deliverySummary{}
+	if err := deliverAPIRecords(context.Background(), job, cfg, recordTable(4), nil, first); err == nil {
+		t.Fatal("first attempt succeeded")
+	}
+	mock.ExpectQuery(queryName("ListHistoryByJob")).
+		WillReturnRows(sqlmock.NewRows(historyColumns).AddRow(historyRow(job, first)...))
+	settled := previouslySettledLeads(context.Background(), q, job)
+
+	// The buyer has recovered
+	failing = nil
+	server.requests = nil
+	retry := &deliverySummary{}
+	if err := deliverAPIRecords(context.Background(), job, cfg, recordTable(4), settled, retry); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"lead2@example.com"}}; !reflect.DeepEqual(server.requests, want) {
+		t.Errorf("retry sent %q, want %q", server.requests, want)
+	}
+	wantStatuses := []string{"lead0:already_delivered", "lead1:already_delivered", "lead3:already_delivered", "lead2:delivered"}
+	if got := leadStatuses(retry.API); !reflect.DeepEqual(got, wantStatuses) {
+		t.Errorf("retry statuses %q, want %q", got, wantStatuses)
+	}
+	// The rejected lead was settled but never accepted
+	if got, want := retry.API.acceptedRows(settled), []int{0, 3, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("accepted rows %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response classes for APIDeliveryConfig.StatusClasses
+const (
+	apiClassRetryable = "retryable"
+	apiClassPermanent = "permanent"
+)
+
+// maxRetryAfter caps how far a buyer's Retry-After header can push a job out
+const maxRetryAfter = 24 * time.Hour
+
+// defaultStatusClasses lists the 4xx responses that are worth retrying: timeouts,
+// conflicts with in-flight requests, too-early and rate limiting. Other 4xx are permanent
+// and 5xx retryable unless a method overrides them.
+var defaultStatusClasses = map[int]string{
+	http.StatusRequestTimeout:  apiClassRetryable,
+	http.StatusConflict:        apiClassRetryable,
+	http.StatusTooEarly:        apiClassRetryable,
+	http.StatusTooManyRequests: apiClassRetryable,
+}
+
+// apiStatusError is a non-2xx response from a buyer's API
+type apiStatusError struct {
+	StatusCode int
+	Class      string
+	RetryAfter time.Duration // From the Retry-After header; zero when absent
+	Body       string
+}
+
+func (e *apiStatusError) Error() string {
+	if e.Class == apiClassPermanent {
+		return fmt.Sprintf("%v: status %d - %s", ErrPermanentAPIFailure, e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("api responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Unwrap makes permanent responses match ErrPermanentAPIFailure
+func (e *apiStatusError) Unwrap() error {
+	if e.Class == apiClassPermanent {
+		return ErrPermanentAPIFailure
+	}
+	return nil
+}
+
+// isValidStatusClasses checks a method's status_classes overrides. Keys are a status
+// code ("429") or a class of codes ("4xx").
+func isValidStatusClasses(classes map[string]string) error {
+	for key, class := range classes {
+		if class != apiClassRetryable && class != apiClassPermanent {
+			return fmt.Errorf("status_classes[%s]: unknown class %q", key, class)
+		}
+		if _, err := strconv.Atoi(key); err == nil && len(key) == 3 {
+			continue
+		}
+		if len(key) == 3 && key[0] >= '1' && key[0] <= '5' && strings.EqualFold(key[1:], "xx") {
+			continue
+		}
+		return fmt.Errorf("status_classes: invalid status %q", key)
+	}
+	return nil
+}
+
+// classifyStatus decides whether a non-2xx response is retryable. Method overrides for
+// the exact code win over overrides for its class, which win over the defaults.
+func classifyStatus(cfg APIDeliveryConfig, code int) string {
+	if class, ok := cfg.StatusClasses[strconv.Itoa(code)]; ok {
+		return class
+	}
+	codeClass := strconv.Itoa(code/100) + "xx"
+	for key, class := range cfg.StatusClasses {
+		if strings.EqualFold(key, codeClass) {
+			return class
+		}
+	}
+	if class, ok := defaultStatusClasses[code]; ok {
+		return class
+	}
+	if code >= 400 && code < 500 {
+		return apiClassPermanent
+	}
+	return apiClassRetryable
+}
+
+// parseRetryAfter reads a Retry-After header given as delay-seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	var delay time.Duration
+	if secs, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		delay = at.Sub(now)
+	}
+	if delay < 0 {
+		return 0
+	}
+	return min(delay, maxRetryAfter)
+}
+
+// retryAfter returns the Retry-After delay carried by a delivery error, if any
+func retryAfter(err error) time.Duration {
+	var statusErr *apiStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	var tokenErr *oauth2TokenError
+	if errors.As(err, &tokenErr) {
+		return tokenErr.RetryAfter
+	}
+	return 0
+}
+
+// apiResponseSummary records how the final API response was classified
+type apiResponseSummary struct {
+	HTTPStatus    int    `json:"http_status,omitempty"`
+	Class         string `json:"class"` // "success", "retryable" or "permanent"
+	RetryAfterSec int    `json:"retry_after_sec,omitempty"`
+}
+
+// newAPIResponseSummary describes the outcome of sendAPIRequest
+func newAPIResponseSummary(httpStatus int, err error) *apiResponseSummary {
+	if err == nil {
+		return &apiResponseSummary{HTTPStatus: httpStatus, Class: "success"}
+	}
+	var statusErr *apiStatusError
+	if errors.As(err, &statusErr) {
+		return &apiResponseSummary{
+			HTTPStatus:    statusErr.StatusCode,
+			Class:         statusErr.Class,
+			RetryAfterSec: int(statusErr.RetryAfter / time.Second),
+		}
+	}
+	// Transport errors and token failures never produced a response
+	class := apiClassRetryable
+	if errors.Is(err, ErrPermanentAPIFailure) {
+		class = apiClassPermanent
+	}
+	return &apiResponseSummary{Class: class}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		code      int
+		want      string
+	}{
+		{name: "bad request", code: http.StatusBadRequest, want: apiClassPermanent},
+		{name: "unauthorized", code: http.StatusUnauthorized, want: apiClassPermanent},
+		{name: "not found", code: http.StatusNotFound, want: apiClassPermanent},
+		{name: "unprocessable", code: http.StatusUnprocessableEntity, want: apiClassPermanent},
+		{name: "request timeout", code: http.StatusRequestTimeout, want: apiClassRetryable},
+		{name: "conflict", code: http.StatusConflict, want: apiClassRetryable},
+		{name: "too early", code: http.StatusTooEarly, want: apiClassRetryable},
+		{name: "rate limited", code: http.StatusTooManyRequests, want: apiClassRetryable},
+		{name: "server error", code: http.StatusInternalServerError, want: apiClassRetryable},
+		{name: "bad gateway", code: http.StatusBadGateway, want: apiClassRetryable},
+		{name: "unavailable", code: http.StatusServiceUnavailable, want: apiClassRetryable},
+		{name: "redirect", code: http.StatusFound, want: apiClassRetryable},
+
+		// Overrides
+		{name: "code override", overrides: map[string]string{"429": apiClassPermanent}, code: http.StatusTooManyRequests, want: apiClassPermanent},
+		{name: "code override of a permanent default", overrides: map[string]string{"404": apiClassRetryable}, code: http.StatusNotFound, want: apiClassRetryable},
+		{name: "class override", overrides: map[string]string{"4xx": apiClassRetryable}, code: http.StatusBadRequest, want: apiClassRetryable},
+		{name: "class override of a retryable default", overrides: map[string]string{"4xx": apiClassPermanent}, code: http.StatusTooManyRequests, want: apiClassPermanent},
+		{name: "5xx permanent", overrides: map[string]string{"5xx": apiClassPermanent}, code: http.StatusServiceUnavailable, want: apiClassPermanent},
+		{name: "code beats class", overrides: map[string]string{"4xx": apiClassRetryable, "400": apiClassPermanent}, code: http.StatusBadRequest, want: apiClassPermanent},
+		{name: "class applies to other codes", overrides: map[string]string{"4xx": apiClassRetryable, "400": apiClassPermanent}, code: http.StatusForbidden, want: apiClassRetryable},
+		{name: "class in upper case", overrides: map[string]string{"5XX": apiClassPermanent}, code: http.StatusBadGateway, want: apiClassPermanent},
+		{name: "other classes untouched", overrides: map[string]string{"5xx": apiClassPermanent, "401": apiClassRetryable}, code: http.StatusRequestTimeout, want: apiClassRetryable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := isValidStatusClasses(tt.overrides); err != nil {
+				t.Fatal(err)
+			}
+			if got := classifyStatus(APIDeliveryConfig{StatusClasses: tt.overrides}, tt.code); got != tt.want {
+				t.Errorf("classifyStatus(%d) = %s, want %s", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidStatusClasses(t *testing.T) {
+	for _, classes := range []map[string]string{
+		{"42": apiClassPermanent},
+		{"4x": apiClassPermanent},
+		{"6xx": apiClassPermanent},
+		{"4xxx": apiClassPermanent},
+		{"abc": apiClassRetryable},
+		{"429": "sometimes"},
+	} {
+		if err := isValidStatusClasses(classes); err == nil {
+			t.Errorf("accepted %v", classes)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "120", want: 2 * time.Minute},
+		{value: " 30 ", want: 30 * time.Second},
+		{value: "0", want: 0},
+		{value: "-5", want: 0},
+		{value: "86400", want: maxRetryAfter},
+		{value: "604800", want: maxRetryAfter}, // A week, capped
+		{value: "Tue, 10 Mar 2026 12:05:00 GMT", want: 5 * time.Minute},
+		{value: "Tuesday, 10-Mar-26 13:00:00 GMT", want: time.Hour}, // RFC 850
+		{value: "Tue Mar 10 12:00:45 2026", want: 45 * time.Second}, // ANSI C
+		{value: "Tue, 10 Mar 2026 11:00:00 GMT", want: 0},           // Already passed
+		{value: "Fri, 20 Mar 2026 12:00:00 GMT", want: maxRetryAfter},
+		{value: "soon", want: 0},
+		{value: "1.5", want: 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
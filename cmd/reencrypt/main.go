@@ -0,0 +1,120 @@
+// Command reencrypt moves values written by utils.EncryptString to the keyring's
+// current key after a rotation, and off the legacy AES-CFB format.
+//
+//	reencrypt -column delivery_methods.secret [-column schema.table.column ...] [-batch-size 500]
+//
+// The keyring is loaded like the worker's (see utils.DefaultKeyring) and must still
+// hold every key the stored values were written with. Each column is migrated in
+// batches, one transaction each, and values already under the current key are left
+// alone, so an interrupted run can simply be started again. Values that don't decrypt
+// are counted and left as they are.
+//
+// The database is DATABASE_URL if set, otherwise the worker's settings: credentials
+// from Secrets Manager (DB_SECRET_NAME) and HOST, PORT, DB_NAME.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+// columnList collects repeated -column flags
+type columnList []utils.EncryptedColumn
+
+func (c *columnList) String() string {
+	names := make([]string, len(*c))
+	for i, col := range *c {
+		names[i] = col.String()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *columnList) Set(value string) error {
+	col, err := utils.ParseEncryptedColumn(value)
+	if err != nil {
+		return err
+	}
+	*c = append(*c, col)
+	return nil
+}
+
+func main() {
+	var columns columnList
+	flag.Var(&columns, "column", "table.column holding encrypted values (repeatable)")
+	batchSize := flag.Int("batch-size", 500, "rows per transaction")
+	flag.Parse()
+
+	if len(columns) == 0 {
+		log.Fatal("at least one -column is required")
+	}
+	if *batchSize <= 0 {
+		log.Fatal("-batch-size must be positive")
+	}
+
+	ctx := context.Background()
+	keyring, err := utils.DefaultKeyring(ctx)
+	if err != nil {
+		log.Fatalf("failed to load keyring: %v", err)
+	}
+
+	db, err := openDB(ctx)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	failed := false
+	for _, col := range columns {
+		report, err := utils.ReencryptColumn(ctx, db, keyring, col, *batchSize)
+		fmt.Printf("%s: %d re-encrypted to %s, %d failed to decrypt, %d changed during the run\n",
+			col, report.Reencrypted, keyring.CurrentKeyID(), report.Failed, report.Skipped)
+		if err != nil {
+			log.Printf("%s: stopped: %v", col, err)
+			failed = true
+		}
+		if report.Failed > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// openDB connects with DATABASE_URL, or the same Secrets Manager credentials and
+// environment as the worker
+func openDB(ctx context.Context) (*sql.DB, error) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		secret, err := utils.GetDBSecret()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get database secret: %w", err)
+		}
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "5432"
+		}
+		dsn = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=require",
+			secret.Username, url.QueryEscape(secret.Password), os.Getenv("HOST"), port, os.Getenv("DB_NAME"))
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
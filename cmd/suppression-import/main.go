@@ -0,0 +1,164 @@
+// Command suppression-import loads a do-not-contact file into a suppression list.
+//
+//	suppression-import -tenant <id> -list <id> -file dnc.csv [-dry-run]
+//
+// CSV and XLSX (first sheet) files are read; columns are found by header (email,
+// phone, name or first_name/last_name, company, state). Emails are lowercased,
+// phones converted to E.164 and both hashed with utils.HashContact, as
+// Lead.EmailHash and Lead.PhoneHash are; company names are stored in their canonical
+// form. Values already on the list are skipped, and the whole import runs in one
+// transaction.
+//
+// The database is DATABASE_URL if set, otherwise the worker's settings: credentials
+// from Secrets Manager (DB_SECRET_NAME) and HOST, PORT, DB_NAME.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/suppression"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+		log.Fatal(err)
+	}
+}
+
+// run imports the file named in args and prints the report to out
+func run(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("suppression-import", flag.ContinueOnError)
+	tenantFlag := flags.String("tenant", "", "tenant ID that owns the list")
+	listFlag := flags.String("list", "", "suppression list ID")
+	file := flags.String("file", "", "CSV or XLSX file to import")
+	format := flags.String("format", "", "csv or xlsx (default: from the file extension)")
+	countryCode := flags.String("country-code", "1", "calling code for phone numbers without one")
+	batchSize := flags.Int("batch-size", 1000, "rows per insert statement")
+	dryRun := flags.Bool("dry-run", false, "report counts without writing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	tenantID, err := uuid.Parse(*tenantFlag)
+	if err != nil {
+		return fmt.Errorf("-tenant: %w", err)
+	}
+	listID, err := uuid.Parse(*listFlag)
+	if err != nil {
+		return fmt.Errorf("-list: %w", err)
+	}
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	rows, err := suppression.ReadRows(f, *format)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", *file, err)
+	}
+	records, err := suppression.ParseRecords(rows)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", *file, err)
+	}
+	set := suppression.Build(records, *countryCode)
+
+	if *dryRun {
+		printReport(out, set.Report, true)
+		return nil
+	}
+
+	db, err := openDB(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	q := queries.New(db)
+	if _, err := q.GetSuppressionList(ctx, queries.GetSuppressionListParams{ID: listID, TenantID: tenantID}); err != nil {
+		return fmt.Errorf("suppression list %s not found for tenant %s: %w", listID, tenantID, err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := set.Insert(ctx, q.WithTx(tx), tenantID, listID, *batchSize); err != nil {
+		return fmt.Errorf("import failed, nothing written: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit import: %w", err)
+	}
+	printReport(out, set.Report, false)
+	return nil
+}
+
+// openDB connects with DATABASE_URL, or the same Secrets Manager credentials and
+// environment as the worker
+func openDB(ctx context.Context) (*sql.DB, error) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		secret, err := utils.GetDBSecret()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get database secret: %w", err)
+		}
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "5432"
+		}
+		dsn = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=require",
+			secret.Username, url.QueryEscape(secret.Password), os.Getenv("HOST"), port, os.Getenv("DB_NAME"))
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func printReport(out io.Writer, r suppression.Report, dryRun bool) {
+	fmt.Fprintf(out, "rows read:            %d\n", r.Rows)
+	fmt.Fprintf(out, "empty rows:           %d\n", r.EmptyRows)
+	fmt.Fprintf(out, "invalid emails:       %d\n", r.InvalidEmails)
+	fmt.Fprintf(out, "invalid phones:       %d\n", r.InvalidPhones)
+	fmt.Fprintf(out, "duplicate contacts:   %d\n", r.DuplicateEntries)
+	fmt.Fprintf(out, "duplicate segments:   %d\n", r.DuplicateSegments)
+	fmt.Fprintf(out, "contact entries:      %d\n", r.Entries)
+	fmt.Fprintf(out, "companies:            %d\n", r.Companies)
+	fmt.Fprintf(out, "states:               %d\n", r.States)
+	if dryRun {
+		fmt.Fprintln(out, "dry run: nothing written")
+		return
+	}
+	fmt.Fprintf(out, "inserted entries:     %d\n", r.InsertedEntries)
+	fmt.Fprintf(out, "inserted companies:   %d\n", r.InsertedCompanies)
+	fmt.Fprintf(out, "inserted states:      %d\n", r.InsertedStates)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+)
+
+const importCSV = `Email,Phone,Company,State
+jane@example.com,555-123-4567,"Acme, Inc.",NY
+JANE@example.com,(555) 123-4567,ACME LLC,ny
+bad-address,020 7946 0958,,
+,,,
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func dryRun(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	args = append([]string{"-tenant", uuid.NewString(), "-list", uuid.NewString(), "-dry-run"}, args...)
+	err := run(context.Background(), args, &out)
+	return out.String(), err
+}
+
+func TestRunDryRun(t *testing.T) {
+	out, err := dryRun(t, "-file", writeFile(t, "dnc.csv", importCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `rows read:            4
+empty rows:           1
+invalid emails:       1
+invalid phones:       0
+duplicate contacts:   1
+duplicate segments:   2
+contact entries:      2
+companies:            1
+states:               1
+dry run: nothing written
+`
+	if out != want {
+		t.Errorf("report:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestRunCountryCode(t *testing.T) {
+	// Without a default country the national numbers can't be placed
+	out, err := dryRun(t, "-file", writeFile(t, "dnc.csv", importCSV), "-country-code", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "invalid phones:       3\n") {
+		t.Errorf("report:\n%s\nwant 3 invalid phones", out)
+	}
+}
+
+func TestRunXLSX(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+	f.SetSheetRow(sheet, "A1", &[]string{"Email", "Phone"})
+	f.SetSheetRow(sheet, "A2", &[]string{"jane@example.com", "+44 (0)20 7946 0958"})
+	path := filepath.Join(t.TempDir(), "DNC.XLSX")
+	if err := f.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// The format comes from the extension, whatever its case
+	out, err := dryRun(t, "-file", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "contact entries:      1\n") {
+		t.Errorf("report:\n%s\nwant 1 entry", out)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	csvFile := writeFile(t, "dnc.csv", importCSV)
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "bad tenant", args: []string{"-tenant", "acme", "-list", uuid.NewString(), "-file", csvFile}, want: "-tenant"},
+		{name: "bad list", args: []string{"-tenant", uuid.NewString(), "-file", csvFile}, want: "-list"},
+		{name: "no file", args: []string{"-tenant", uuid.NewString(), "-list", uuid.NewString()}, want: "-file is required"},
+		{name: "missing file", args: []string{"-tenant", uuid.NewString(), "-list", uuid.NewString(), "-file", filepath.Join(t.TempDir(), "none.csv")}, want: "no such file"},
+		{name: "unknown format", args: []string{"-tenant", uuid.NewString(), "-list", uuid.NewString(), "-file", writeFile(t, "dnc.txt", importCSV)}, want: `unsupported format "txt"`},
+		{name: "no columns", args: []string{"-tenant", uuid.NewString(), "-list", uuid.NewString(), "-file", writeFile(t, "ids.csv", "id\n1\n")}, want: "no recognized columns"},
+		{name: "unknown flag", args: []string{"-tenants", uuid.NewString()}, want: "not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := run(context.Background(), append(tt.args, "-dry-run"), &out)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("run = %v, want an error containing %q", err, tt.want)
+			}
+			if out.Len() != 0 {
+				t.Errorf("printed a report: %s", out.String())
+			}
+		})
+	}
+}
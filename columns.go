@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/leadfile"
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+// Computed columns a template can add
+const (
+	computedFullName    = "full_name"    // First and last name
+	computedEmailDomain = "email_domain" // Domain of the email address
+	computedCaptureDate = "capture_date" // captured_at in the column's time zone and format
+)
+
+// What happens to leads missing a required field
+const (
+	missingRequiredDrop = "drop" // Default: quarantine the lead and deliver the rest
+	missingRequiredFail = "fail" // Fail the whole job
+)
+
+// Where a job's column layout came from
+const (
+	columnSourceCampaign       = "campaign"
+	columnSourceBuyer          = "buyer"
+	columnSourceCsvFieldConfig = "csv_field_config"
+)
+
+// quarantineStageRequired marks lead_quarantine rows written by enforceRequired
+const quarantineStageRequired = "required_fields"
+
+const defaultCaptureDateFormat = "YYYY-MM-DD"
+
+var (
+	errInvalidColumnTemplate = errors.New("invalid column template")
+	errMissingRequiredField  = errors.New("leads missing required fields")
+)
+
+// columnTemplate is a buyer's or campaign's column_template:
+//
+//	{"columns": [
+//	   {"key": "email", "label": "Email Address", "order": 1, "required": true},
+//	   {"key": "full_name", "label": "Name", "order": 2, "computed": "full_name"},
+//	   {"key": "source", "label": "Source", "constant": "LeadShip"},
+//	   {"key": "captured_on", "label": "Date", "computed": "capture_date", "time_zone": "America/Chicago", "format": "MM/DD/YYYY"}],
+//	 "missing_required": "drop"}
+//
+// Entries keyed by a lead field or question ID relabel, order and require that column;
+// entries with a constant or computed value add a column. Columns without an order
+// follow the ordered ones, in their default order.
+type columnTemplate struct {
+	Columns         []templateColumn `json:"columns"`
+	MissingRequired string           `json:"missing_required,omitempty"` // "drop" (default) or "fail"
+}
+
+type templateColumn struct {
+	Key      string  `json:"key"`
+	Label    string  `json:"label,omitempty"`
+	Order    int32   `json:"order,omitempty"`
+	Required bool    `json:"required,omitempty"`
+	Constant *string `json:"constant,omitempty"`
+	Computed string  `json:"computed,omitempty"`  // "full_name", "email_domain" or "capture_date"
+	TimeZone string  `json:"time_zone,omitempty"` // capture_date: IANA zone, defaults to the stored offset
+	Format   string  `json:"format,omitempty"`    // capture_date: date_format tokens, defaults to YYYY-MM-DD
+
+	dateLayout string
+	location   *time.Location
+}
+
+// adds reports whether the entry adds a column rather than shaping an existing one
+func (c *templateColumn) adds() bool {
+	return c.Constant != nil || c.Computed != ""
+}
+
+// compile checks an added column and resolves its date settings
+func (c *templateColumn) compile() error {
+	if c.Constant != nil && c.Computed != "" {
+		return fmt.Errorf("column %s has both a constant and a computed value", c.Key)
+	}
+	if c.Required {
+		return fmt.Errorf("column %s is constant or computed and can't be required", c.Key)
+	}
+	switch c.Computed {
+	case "", computedFullName, computedEmailDomain:
+	case computedCaptureDate:
+		format := c.Format
+		if format == "" {
+			format = defaultCaptureDateFormat
+		}
+		c.dateLayout = leadfile.DateLayout(format)
+		if c.TimeZone != "" {
+			loc, err := time.LoadLocation(c.TimeZone)
+			if err != nil {
+				return fmt.Errorf("column %s has unknown time_zone %q", c.Key, c.TimeZone)
+			}
+			c.location = loc
+		}
+	default:
+		return fmt.Errorf("column %s has unknown computed value %q", c.Key, c.Computed)
+	}
+	return nil
+}
+
+// value computes an added column for one lead
+func (c *templateColumn) value(ctx context.Context, lead *payload.Lead, emails func(context.Context, *payload.Lead) (string, error)) (string, error) {
+	if c.Constant != nil {
+		return *c.Constant, nil
+	}
+	switch c.Computed {
+	case computedFullName:
+		return strings.TrimSpace(strings.TrimSpace(lead.FirstName) + " " + strings.TrimSpace(lead.LastName)), nil
+	case computedEmailDomain:
+		address, err := emails(ctx, lead)
+		if err != nil {
+			return "", fmt.Errorf("lead %s email: %w", lead.ID, err)
+		}
+		// The address is wiped once used; the domain must not share its bytes
+		if at := strings.LastIndex(address, "@"); at >= 0 {
+			return strings.Clone(strings.ToLower(strings.TrimSpace(address[at+1:]))), nil
+		}
+		return "", nil
+	case computedCaptureDate:
+		formatted, _ := leadfile.FormatTimestamp(lead.CapturedAt, c.dateLayout, c.location)
+		return formatted, nil
+	}
+	return "", nil
+}
+
+// columnLayout is the column template in effect for a job: csv_field_config's labels,
+// order and required flags, overridden by the campaign's or else the buyer's template
+type columnLayout struct {
+	source          string
+	columns         map[string]*templateColumn // Entries shaping existing columns, by key
+	added           []*templateColumn          // Constant and computed columns, in template order
+	missingRequired string
+}
+
+// columnSummary records the column layout a delivery used
+type columnSummary struct {
+	Source   string            `json:"source"` // "campaign", "buyer" or "csv_field_config"
+	Required []string          `json:"required,omitempty"`
+	Dropped  int               `json:"dropped,omitempty"`
+	Leads    []quarantinedLead `json:"leads,omitempty"`
+}
+
+// loadColumnLayout resolves the job's column layout. It returns nil when neither a
+// template nor csv_field_config shapes the columns. A template that can't be used
+// returns an error wrapping errInvalidColumnTemplate.
+func loadColumnLayout(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, campaign *queries.Campaign, p *payload.Payload) (*columnLayout, error) {
+	layout := &columnLayout{
+		columns:         make(map[string]*templateColumn),
+		missingRequired: missingRequiredDrop,
+	}
+	for _, entry := range p.CsvFieldConfig {
+		if entry.Label == "" && entry.Order == 0 && !entry.Required {
+			continue
+		}
+		layout.columns[entry.Key] = &templateColumn{Key: entry.Key, Label: entry.Label, Order: entry.Order, Required: entry.Required}
+		layout.source = columnSourceCsvFieldConfig
+	}
+
+	var raw []byte
+	var source string
+	if campaign != nil && campaign.ColumnTemplate.Valid {
+		raw, source = campaign.ColumnTemplate.RawMessage, columnSourceCampaign
+	} else {
+		buyer, err := q.GetBuyerByID(ctx, queries.GetBuyerByIDParams{ID: job.BuyerID, TenantID: job.TenantID})
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to fetch buyer: %w", err)
+		}
+		if buyer.ColumnTemplate.Valid {
+			raw, source = buyer.ColumnTemplate.RawMessage, columnSourceBuyer
+		}
+	}
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := layout.merge(raw, p); err != nil {
+			return nil, fmt.Errorf("%w: %s template: %v", errInvalidColumnTemplate, source, err)
+		}
+		layout.source = source
+	}
+
+	if layout.source == "" {
+		return nil, nil
+	}
+	return layout, nil
+}
+
+// merge layers a stored template over the layout
+func (l *columnLayout) merge(raw []byte, p *payload.Payload) error {
+	var tmpl columnTemplate
+	if err := json.Unmarshal(raw, &tmpl); err != nil {
+		return err
+	}
+	switch tmpl.MissingRequired {
+	case "":
+	case missingRequiredDrop, missingRequiredFail:
+		l.missingRequired = tmpl.MissingRequired
+	default:
+		return fmt.Errorf("unknown missing_required %q", tmpl.MissingRequired)
+	}
+
+	seen := make(map[string]bool)
+	for i := range tmpl.Columns {
+		col := tmpl.Columns[i]
+		switch {
+		case col.Key == "":
+			return fmt.Errorf("columns[%d].key is missing", i)
+		case seen[col.Key]:
+			return fmt.Errorf("column %s is listed twice", col.Key)
+		}
+		seen[col.Key] = true
+
+		if !col.adds() {
+			if existing, ok := l.columns[col.Key]; ok {
+				if col.Label == "" {
+					col.Label = existing.Label
+				}
+				if col.Order == 0 {
+					col.Order = existing.Order
+				}
+				col.Required = col.Required || existing.Required
+			}
+			l.columns[col.Key] = &col
+			continue
+		}
+		if _, ok := baseColumnByKey(col.Key); ok || isQuestionID(p, col.Key) {
+			return fmt.Errorf("column %s would replace a lead field", col.Key)
+		}
+		if err := col.compile(); err != nil {
+			return err
+		}
+		l.added = append(l.added, &col)
+	}
+	return nil
+}
+
+func isQuestionID(p *payload.Payload, key string) bool {
+	for _, q := range p.Questions {
+		if q.ID == key {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredKeys lists the required columns in key order
+func (l *columnLayout) requiredKeys() []string {
+	var keys []string
+	for key, col := range l.columns {
+		if col.Required {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// leadHasField reports whether lead has a value for a field key or question ID. Email
+// and phone count while they are still hashes or ciphers. Keys that are neither a
+// field nor a question report known false.
+func leadHasField(p *payload.Payload, lead *payload.Lead, key string) (has, known bool) {
+	switch key {
+	case "email":
+		return lead.Email != "" || len(lead.EmailCipher) > 0, true
+	case "phone":
+		return lead.Phone != "" || len(lead.PhoneCipher) > 0, true
+	}
+	if col, ok := baseColumnByKey(key); ok {
+		return strings.TrimSpace(col.Extractor(lead)) != "", true
+	}
+	if isQuestionID(p, key) {
+		return strings.TrimSpace(lead.CustomAnswers[key]) != "", true
+	}
+	return false, false
+}
+
+// enforceRequired removes leads missing a required field from a page of leads,
+// quarantining them, and returns the rest, or, when the template says "fail", returns
+// an error wrapping errMissingRequiredField. The summary is nil when there is no layout.
+func (l *columnLayout) enforceRequired(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, campaign *queries.Campaign, p *payload.Payload, leads []payload.Lead) ([]payload.Lead, *columnSummary, error) {
+	if l == nil {
+		return leads, nil, nil
+	}
+	result := &columnSummary{Source: l.source}
+	var required []string
+	for _, key := range l.requiredKeys() {
+		if _, known := leadHasField(p, &payload.Lead{}, key); !known {
+			log.Printf("Job %s: required column %s is not a lead field or question, ignoring", job.ID, key)
+			continue
+		}
+		required = append(required, key)
+	}
+	result.Required = required
+	if len(required) == 0 {
+		return leads, result, nil
+	}
+
+	params := queries.InsertLeadQuarantineParams{
+		TenantID: job.TenantID,
+		JobID:    utils.NullUUID(job.ID),
+		Stage:    quarantineStageRequired,
+	}
+	if campaign != nil {
+		params.CampaignID = utils.NullUUID(campaign.ID)
+	}
+	kept := leads[:0]
+	checked := len(leads)
+	for _, lead := range leads {
+		var missing []string
+		for _, key := range required {
+			if has, _ := leadHasField(p, &lead, key); !has {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) == 0 {
+			kept = append(kept, lead)
+			continue
+		}
+		reasons := make([]string, len(missing))
+		for i, key := range missing {
+			reasons[i] = fmt.Sprintf("%s: missing required field", key)
+		}
+		result.Dropped++
+		result.Leads = append(result.Leads, quarantinedLead{LeadID: lead.ID, Reasons: reasons})
+		params.LeadIds = append(params.LeadIds, lead.ID)
+		params.Reasons = append(params.Reasons, strings.Join(reasons, "; "))
+	}
+
+	if result.Dropped == 0 {
+		return kept, result, nil
+	}
+	if l.missingRequired == missingRequiredFail {
+		first := result.Leads[0]
+		return nil, result, fmt.Errorf("%w: %d of %d leads (lead %s: %s)",
+			errMissingRequiredField, result.Dropped, checked, first.LeadID, strings.Join(first.Reasons, "; "))
+	}
+	if _, err := q.InsertLeadQuarantine(ctx, params); err != nil {
+		return nil, nil, fmt.Errorf("failed to quarantine leads: %w", err)
+	}
+	log.Printf("Job %s: dropped %d leads missing required fields %v", job.ID, result.Dropped, required)
+	return kept, result, nil
+}
+
+// add counts another page's results in s
+func (s *columnSummary) add(page *columnSummary) {
+	s.Dropped += page.Dropped
+	s.Leads = append(s.Leads, page.Leads...)
+}
+
+// apply relabels, adds and orders the table's columns. Added columns read the
+// pager's current page, like the rest of the table.
+func (l *columnLayout) apply(table *leadfile.Table, pager *leadPager) {
+	if l == nil {
+		return
+	}
+
+	order := make([]int32, len(table.Columns))
+	for i := range table.Columns {
+		if col, ok := l.columns[table.Columns[i].Key]; ok {
+			if col.Label != "" {
+				table.Columns[i].Header = col.Label
+			}
+			order[i] = col.Order
+		}
+	}
+
+	for _, col := range l.added {
+		header := col.Label
+		if header == "" {
+			header = col.Key
+		}
+		column := leadfile.Column{Key: col.Key, Header: header}
+		if col.Computed == computedEmailDomain {
+			// Decrypting can fail, so a page's domains are worked out as it loads rather
+			// than as rows are written
+			var domains []string
+			pager.onLoad(func(ctx context.Context, page []payload.Lead) error {
+				// Email domains read the address without putting it in the email column
+				emails, wipe := leadEmailSource()
+				defer wipe()
+				domains = domains[:0]
+				for i := range page {
+					value, err := col.value(ctx, &page[i], emails)
+					if err != nil {
+						return err
+					}
+					domains = append(domains, value)
+				}
+				return nil
+			})
+			column.Value = func(row int) string { return domains[row-pager.from] }
+		} else {
+			column.Value = func(row int) string {
+				value, _ := col.value(pager.ctx, pager.lead(row), nil)
+				return value
+			}
+		}
+		table.Columns = append(table.Columns, column)
+		order = append(order, col.Order)
+	}
+
+	reorderColumns(table, order)
+}
+
+// reorderColumns sorts the columns by order; columns without one (0) keep their
+// relative position after the ordered ones
+func reorderColumns(table *leadfile.Table, order []int32) {
+	rank := func(i int) int64 {
+		if order[i] == 0 {
+			return 1 << 32
+		}
+		return int64(order[i])
+	}
+	perm := make([]int, len(table.Columns))
+	for i := range perm {
+		perm[i] = i
+	}
+	sort.SliceStable(perm, func(a, b int) bool { return rank(perm[a]) < rank(perm[b]) })
+	if slices.IsSorted(perm) {
+		return
+	}
+
+	columns := make([]leadfile.Column, len(perm))
+	for i, from := range perm {
+		columns[i] = table.Columns[from]
+	}
+	table.Columns = columns
+}
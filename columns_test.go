@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/sqlc-dev/pqtype"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/leadfile"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+func templateCampaign(template string) *queries.Campaign {
+	return &queries.Campaign{ColumnTemplate: pqtype.NullRawMessage{RawMessage: []byte(template), Valid: true}}
+}
+
+// layoutColumns flattens a layout's shaping entries for comparison
+func layoutColumns(l *columnLayout) map[string]templateColumn {
+	columns := make(map[string]templateColumn, len(l.columns))
+	for key, col := range l.columns {
+		columns[key] = templateColumn{Key: col.Key, Label: col.Label, Order: col.Order, Required: col.Required}
+	}
+	return columns
+}
+
+func TestLoadColumnLayoutMerging(t *testing.T) {
+	p := &payload.Payload{CsvFieldConfig: []payload.CsvFieldConfig{
+		{Key: "title", Label: "Job Title", Order: 1, Required: true, DeliverToBuyer: true},
+		{Key: "email", Order: 2, DeliverToBuyer: true},
+		{Key: "state", DeliverToBuyer: true}, // Nothing to shape
+	}}
+
+	layout, err := loadColumnLayout(context.Background(), nil, testJob(), templateCampaign(`{
+		"columns": [
+			{"key": "title", "label": "Role"},
+			{"key": "email", "label": "Work Email", "order": 5, "required": true},
+			{"key": "phone", "order": 3},
+			{"key": "source", "constant": "LeadShip"}
+		],
+		"missing_required": "fail"}`), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.source != columnSourceCampaign || layout.missingRequired != missingRequiredFail {
+		t.Errorf("source %q, missing_required %q", layout.source, layout.missingRequired)
+	}
+	// The template's labels and orders win; blanks keep csv_field_config's, and a
+	// column required by either stays required
+	want := map[string]templateColumn{
+		"title": {Key: "title", Label: "Role", Order: 1, Required: true},
+		"email": {Key: "email", Label: "Work Email", Order: 5, Required: true},
+		"phone": {Key: "phone", Order: 3},
+	}
+	if got := layoutColumns(layout); !reflect.DeepEqual(got, want) {
+		t.Errorf("columns = %+v, want %+v", got, want)
+	}
+	if len(layout.added) != 1 || layout.added[0].Key != "source" {
+		t.Errorf("added = %+v, want the source constant", layout.added)
+	}
+	if got := layout.requiredKeys(); !reflect.DeepEqual(got, []string{"email", "title"}) {
+		t.Errorf("requiredKeys = %v", got)
+	}
+}
+
+func TestLoadColumnLayoutSources(t *testing.T) {
+	quietLogs(t)
+	config := &payload.Payload{CsvFieldConfig: []payload.CsvFieldConfig{{Key: "title", Label: "Job Title", DeliverToBuyer: true}}}
+	tests := []struct {
+		name     string
+		campaign *queries.Campaign
+		buyer    any // The buyer's column_template, when the campaign has none
+		p        *payload.Payload
+		source   string
+	}{
+		{name: "nothing", buyer: nil, p: &payload.Payload{}},
+		{name: "csv_field_config", buyer: nil, p: config, source: columnSourceCsvFieldConfig},
+		{name: "buyer", buyer: []byte(`{"columns": [{"key": "title", "order": 1}]}`), p: config, source: columnSourceBuyer},
+		{name: "campaign over buyer", campaign: templateCampaign(`{"columns": [{"key": "title", "order": 1}]}`), p: config, source: columnSourceCampaign},
+		{name: "null campaign template", campaign: templateCampaign(`null`), p: &payload.Payload{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, mock := newMockQueries(t)
+			job := testJob()
+			if tt.campaign == nil {
+				mock.ExpectQuery(queryName("GetBuyerByID")).
+					WithArgs(job.BuyerID, job.TenantID).
+					WillReturnRows(sqlmock.NewRows(buyerColumns).
+						AddRow(job.BuyerID, job.TenantID, "Buyer", nil, true, nil, nil, nil, tt.buyer))
+			}
+			layout, err := loadColumnLayout(context.Background(), q, job, tt.campaign, tt.p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.source == "" {
+				if layout != nil {
+					t.Errorf("layout = %+v, want none", layout)
+				}
+				return
+			}
+			if layout == nil || layout.source != tt.source {
+				t.Errorf("layout = %+v, want source %q", layout, tt.source)
+			}
+		})
+	}
+}
+
+func TestLoadColumnLayoutInvalid(t *testing.T) {
+	p := &payload.Payload{Questions: []payload.Question{{ID: "q1", QuestionText: "Budget?"}}}
+	tests := map[string]string{
+		"not json":              `{"columns": `,
+		"unknown policy":        `{"missing_required": "skip"}`,
+		"missing key":           `{"columns": [{"label": "Email"}]}`,
+		"listed twice":          `{"columns": [{"key": "email"}, {"key": "email", "order": 2}]}`,
+		"replaces a lead field": `{"columns": [{"key": "email", "constant": "x"}]}`,
+		"replaces a question":   `{"columns": [{"key": "q1", "computed": "full_name"}]}`,
+		"constant and computed": `{"columns": [{"key": "name", "constant": "x", "computed": "full_name"}]}`,
+		"required added column": `{"columns": [{"key": "source", "constant": "x", "required": true}]}`,
+		"unknown computed":      `{"columns": [{"key": "age", "computed": "lead_age"}]}`,
+		"unknown time zone":     `{"columns": [{"key": "day", "computed": "capture_date", "time_zone": "Mars/Olympus"}]}`,
+	}
+	for name, template := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadColumnLayout(context.Background(), nil, testJob(), templateCampaign(template), p)
+			if !errors.Is(err, errInvalidColumnTemplate) {
+				t.Errorf("loadColumnLayout = %v, want errInvalidColumnTemplate", err)
+			}
+		})
+	}
+}
+
+func requiredLayout(t *testing.T, template string, p *payload.Payload) *columnLayout {
+	t.Helper()
+	layout, err := loadColumnLayout(context.Background(), nil, testJob(), templateCampaign(template), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layout
+}
+
+func requiredLeads() []payload.Lead {
+	return []payload.Lead{
+		{ID: "complete", Email: "hash", Title: "CTO", CustomAnswers: map[string]string{"q1": "10k"}},
+		{ID: "cipher-only", EmailCipher: []byte("x"), Title: "CFO", CustomAnswers: map[string]string{"q1": "5k"}},
+		{ID: "no-title", Email: "hash", Title: "  ", CustomAnswers: map[string]string{"q1": "1k"}},
+		{ID: "no-answer", Email: "hash", Title: "CEO"},
+		{ID: "nothing"},
+	}
+}
+
+func TestEnforceRequiredDrops(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	job := testJob()
+	p := &payload.Payload{Questions: []payload.Question{{ID: "q1", QuestionText: "Budget?"}}}
+	// fax is neither a field nor a question, so it's ignored
+	layout := requiredLayout(t, `{"columns": [
+		{"key": "title", "required": true},
+		{"key": "email", "required": true},
+		{"key": "q1", "required": true},
+		{"key": "fax", "required": true}]}`, p)
+
+	mock.ExpectExec(queryName("InsertLeadQuarantine")).
+		WithArgs(job.TenantID, nil, job.ID, quarantineStageRequired,
+			[]string{"no-title", "no-answer", "nothing"},
+			[]string{
+				"title: missing required field",
+				"q1: missing required field",
+				"email: missing required field; q1: missing required field; title: missing required field",
+			}).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	kept, summary, err := layout.enforceRequired(context.Background(), q, job, nil, p, requiredLeads())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := leadIDs(kept); !reflect.DeepEqual(got, []string{"complete", "cipher-only"}) {
+		t.Errorf("kept %v", got)
+	}
+	if summary.Source != columnSourceCampaign || summary.Dropped != 3 || !reflect.DeepEqual(summary.Required, []string{"email", "q1", "title"}) {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestEnforceRequiredFails(t *testing.T) {
+	quietLogs(t)
+	q, _ := newMockQueries(t) // Nothing is quarantined when the job fails
+	p := &payload.Payload{}
+	layout := requiredLayout(t, `{"columns": [{"key": "title", "required": true}], "missing_required": "fail"}`, p)
+
+	kept, summary, err := layout.enforceRequired(context.Background(), q, testJob(), nil, p, requiredLeads())
+	if !errors.Is(err, errMissingRequiredField) || kept != nil {
+		t.Fatalf("enforceRequired = %v, %v, want errMissingRequiredField", leadIDs(kept), err)
+	}
+	if !strings.Contains(err.Error(), "2 of 5 leads (lead no-title: title: missing required field)") {
+		t.Errorf("error %q doesn't name the first lead", err)
+	}
+	if summary.Dropped != 2 {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestEnforceRequiredNothingRequired(t *testing.T) {
+	p := &payload.Payload{}
+	leads := requiredLeads()
+
+	kept, summary, err := (*columnLayout)(nil).enforceRequired(context.Background(), nil, testJob(), nil, p, leads)
+	if err != nil || summary != nil || len(kept) != len(leads) {
+		t.Errorf("without a layout = %d leads, %+v, %v", len(kept), summary, err)
+	}
+
+	layout := requiredLayout(t, `{"columns": [{"key": "title", "label": "Role"}]}`, p)
+	kept, summary, err = layout.enforceRequired(context.Background(), nil, testJob(), nil, p, leads)
+	if err != nil || len(kept) != len(leads) || summary.Dropped != 0 || summary.Required != nil {
+		t.Errorf("without required columns = %d leads, %+v, %v", len(kept), summary, err)
+	}
+}
+
+func TestReorderColumns(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []int32
+		want  string
+	}{
+		{name: "unordered", order: []int32{0, 0, 0, 0, 0}, want: "abcde"},
+		{name: "ordered first", order: []int32{0, 2, 0, 1, 0}, want: "dbace"},
+		{name: "ties keep position", order: []int32{3, 1, 0, 1, 3}, want: "bdaec"},
+		{name: "all ordered", order: []int32{5, 4, 3, 2, 1}, want: "edcba"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &leadfile.Table{}
+			for _, key := range "abcde" {
+				table.Columns = append(table.Columns, leadfile.Column{Key: string(key)})
+			}
+			reorderColumns(table, tt.order)
+			var got string
+			for _, col := range table.Columns {
+				got += col.Key
+			}
+			if got != tt.want {
+				t.Errorf("order %v gave %s, want %s", tt.order, got, tt.want)
+			}
+		})
+	}
+}
+
+// tableRows reads every row of the table the way the file writers do
+func tableRows(t *testing.T, table *leadfile.Table) [][]string {
+	t.Helper()
+	rows := [][]string{table.Header()}
+	for r := range table.Rows {
+		if err := table.Load(r); err != nil {
+			t.Fatal(err)
+		}
+		row := make([]string, len(table.Columns))
+		for c, col := range table.Columns {
+			row[c] = col.Value(r)
+		}
+		table.Done(r)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestColumnLayoutApply(t *testing.T) {
+	quietLogs(t)
+	ctx := context.Background()
+	p := &payload.Payload{
+		Questions: []payload.Question{{ID: "q1", QuestionText: "Budget?"}, {ID: "q2", QuestionText: "Timeline?"}},
+		Leads: []payload.Lead{
+			{
+				ID: "l1", FirstName: "Jane", LastName: "Doe", Email: "jane@Example.COM", EmailCipher: []byte("x"), Title: "CTO",
+				CapturedAt: "2026-03-02T03:00:00Z", CustomAnswers: map[string]string{"q1": "10k", "q2": "Q3"},
+			},
+			{ID: "l2", FirstName: " Bob", Email: "bob@b.io", EmailCipher: []byte("x"), CustomAnswers: map[string]string{"q2": "now"}},
+		},
+	}
+	layout := requiredLayout(t, `{"columns": [
+		{"key": "email", "label": "Work Email", "order": 1},
+		{"key": "q2", "label": "When", "order": 2},
+		{"key": "full", "label": "Name", "computed": "full_name", "order": 3},
+		{"key": "source", "constant": "LeadShip"},
+		{"key": "domain", "computed": "email_domain"},
+		{"key": "captured_on", "label": "Date", "computed": "capture_date", "time_zone": "America/Chicago", "format": "MM/DD/YYYY"},
+		{"key": "first_name", "label": "Given Name"},
+		{"key": "industry", "label": "Sector", "order": 4}]}`, p)
+
+	leads := &embeddedLeads{p: p}
+	usage, _, err := scanLeads(ctx, leads, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager := newLeadPager(ctx, leads)
+	table := buildLeadTable(p, pager, usage, nil, false)
+	layout.apply(table, pager)
+
+	// Ordered columns lead; the rest keep the default order, base fields then questions
+	// then added columns. A template entry for a column the table doesn't have (industry)
+	// adds nothing.
+	want := [][]string{
+		{"Work Email", "When", "Name", "Given Name", "Last Name", "Title", "Date/Time Stamp", "Budget?", "source", "domain", "Date"},
+		{"jane@Example.COM", "Q3", "Jane Doe", "Jane", "Doe", "CTO", "2026-03-02T03:00:00Z", "10k", "LeadShip", "example.com", "03/01/2026"},
+		{"bob@b.io", "now", "Bob", " Bob", "", "", "", "", "LeadShip", "b.io", ""},
+	}
+	if got := tableRows(t, table); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows =\n%q\nwant\n%q", got, want)
+	}
+	wantKeys := []string{"email", "q2", "full", "first_name", "last_name", "title", "captured_at", "q1", "source", "domain", "captured_on"}
+	var keys []string
+	for _, col := range table.Columns {
+		keys = append(keys, col.Key)
+	}
+	if !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("keys = %v, want %v", keys, wantKeys)
+	}
+}
+
+func TestColumnLayoutApplyNil(t *testing.T) {
+	table := &leadfile.Table{Columns: []leadfile.Column{{Key: "email", Header: "Email"}}}
+	(*columnLayout)(nil).apply(table, nil)
+	if !reflect.DeepEqual(table.Header(), []string{"Email"}) {
+		t.Errorf("header = %v", table.Header())
+	}
+}
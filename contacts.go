@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+
+	"github.com/DylanCoon99/delivery/internal/envelope"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+var (
+	leadKMSOnce sync.Once
+	leadKMS     envelope.KMS
+	leadKMSErr  error
+)
+
+// leadKeyProvider returns the KMS that unwraps lead data keys. LEAD_KMS_PROVIDER=local
+// uses the keys in LEAD_LOCAL_KMS_KEYS instead of AWS KMS, for local runs.
+func leadKeyProvider() (envelope.KMS, error) {
+	leadKMSOnce.Do(func() {
+		if os.Getenv("LEAD_KMS_PROVIDER") == "local" {
+			leadKMS, leadKMSErr = envelope.ParseLocalKeys(os.Getenv("LEAD_LOCAL_KMS_KEYS"))
+			return
+		}
+		leadKMS = envelope.AWSKMS{Client: kms.NewFromConfig(awsCfg)}
+	})
+	return leadKMS, leadKMSErr
+}
+
+// pageDecrypter decrypts the contacts of each page a leadPager loads, replacing the
+// stored hashes in Email and/or Phone; leads without a cipher get an empty value rather
+// than their hash. The plaintext stays in the decryptor's buffers, which the leads'
+// values share, and each lead's is zeroed once its row is written. Every page gets a
+// fresh decryptor, and the previous page's keys are wiped first, so unwrapped keys
+// never outlive the page that needed them.
+type pageDecrypter struct {
+	kms          envelope.KMS
+	email, phone bool
+	dec          *envelope.Decryptor
+	page         []payload.Lead
+	plaintexts   [][2][]byte // Email and phone of each lead in page
+}
+
+// decrypt is a leadPager onLoad hook
+func (d *pageDecrypter) decrypt(ctx context.Context, page []payload.Lead) error {
+	d.wipe()
+	d.dec = envelope.NewDecryptor(d.kms)
+	d.page = page
+	d.plaintexts = make([][2][]byte, len(page))
+	for i := range page {
+		lead := &page[i]
+		if d.email {
+			plaintext, err := decryptContact(ctx, d.dec, lead, lead.EmailCipher)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt lead %s email: %w", lead.ID, err)
+			}
+			d.plaintexts[i][0] = plaintext
+			lead.Email = contactString(plaintext)
+		}
+		if d.phone {
+			plaintext, err := decryptContact(ctx, d.dec, lead, lead.PhoneCipher)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt lead %s phone: %w", lead.ID, err)
+			}
+			d.plaintexts[i][1] = plaintext
+			lead.Phone = contactString(plaintext)
+		}
+	}
+	return nil
+}
+
+// wipeRow zeroes the contacts of the page's i-th lead; it is a leadPager onDone hook
+func (d *pageDecrypter) wipeRow(i int) {
+	if i >= len(d.plaintexts) {
+		return
+	}
+	if d.email {
+		d.page[i].Email = ""
+	}
+	if d.phone {
+		d.page[i].Phone = ""
+	}
+	for _, plaintext := range d.plaintexts[i] {
+		envelope.Zero(plaintext)
+	}
+	d.plaintexts[i] = [2][]byte{}
+}
+
+// wipe zeroes the current page's contacts and keys
+func (d *pageDecrypter) wipe() {
+	for i := range d.plaintexts {
+		d.wipeRow(i)
+	}
+	d.page, d.plaintexts = nil, nil
+	if d.dec != nil {
+		d.dec.Wipe()
+		d.dec = nil
+	}
+}
+
+// decryptContact returns a contact's plaintext, owned by dec, or nil when the lead has
+// no cipher for it
+func decryptContact(ctx context.Context, dec *envelope.Decryptor, lead *payload.Lead, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, nil
+	}
+	return dec.Decrypt(ctx, lead.DekKmsKeyID, lead.DekWrapped, ciphertext)
+}
+
+// contactString returns a string sharing plaintext's bytes instead of copying them, so
+// zeroing the plaintext leaves no copy behind. The string is only valid until then;
+// anything that keeps a value derived from it must copy it.
+func contactString(plaintext []byte) string {
+	if len(plaintext) == 0 {
+		return ""
+	}
+	return unsafe.String(&plaintext[0], len(plaintext))
+}
+
+// leadEmailSource returns a function giving a lead's plaintext email without changing
+// the lead, and a wipe function for the keys it unwrapped. Email is used as is once it
+// holds an address rather than the stored hash; otherwise EmailCipher is decrypted,
+// with the decryptor created on first use. Leads with neither give "". A decrypted
+// address is zeroed by the next call or by wipe, so callers must copy what they keep.
+func leadEmailSource() (func(ctx context.Context, lead *payload.Lead) (string, error), func()) {
+	var decryptor *envelope.Decryptor
+	var last []byte
+	source := func(ctx context.Context, lead *payload.Lead) (string, error) {
+		envelope.Zero(last)
+		last = nil
+		if strings.Contains(lead.Email, "@") {
+			return lead.Email, nil
+		}
+		if len(lead.EmailCipher) == 0 {
+			return "", nil
+		}
+		if decryptor == nil {
+			keyProvider, err := leadKeyProvider()
+			if err != nil {
+				return "", fmt.Errorf("lead key provider unavailable: %w", err)
+			}
+			decryptor = envelope.NewDecryptor(keyProvider)
+		}
+		plaintext, err := decryptContact(ctx, decryptor, lead, lead.EmailCipher)
+		if err != nil {
+			return "", err
+		}
+		last = plaintext
+		return contactString(plaintext), nil
+	}
+	wipe := func() {
+		envelope.Zero(last)
+		if decryptor != nil {
+			decryptor.Wipe()
+		}
+	}
+	return source, wipe
+}
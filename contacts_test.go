@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DylanCoon99/delivery/internal/envelope"
+	"github.com/DylanCoon99/delivery/internal/leadfile"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+// zeroed reports whether a string's bytes have all been wiped
+func zeroed(s string) bool {
+	return strings.Trim(s, "\x00") == ""
+}
+
+func TestPageDecrypterWipesEachRow(t *testing.T) {
+	leads, kms := syntheticLeads(t, 3)
+	page, err := leads.page(context.Background(), 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page[2].PhoneCipher = nil // No phone on file
+
+	d := &pageDecrypter{kms: kms, email: true, phone: true}
+	if err := d.decrypt(context.Background(), page); err != nil {
+		t.Fatal(err)
+	}
+	if page[0].Email != "lead0@example.com" || page[1].Phone != "+15550000001" {
+		t.Errorf("decrypted %q and %q", page[0].Email, page[1].Phone)
+	}
+	if page[2].Phone != "" {
+		t.Errorf("lead without a phone cipher has phone %q, want none rather than its hash", page[2].Phone)
+	}
+
+	// The values share the plaintext buffers, so wiping a row leaves nothing behind
+	email, phone := page[0].Email, page[0].Phone
+	d.wipeRow(0)
+	if !zeroed(email) || !zeroed(phone) {
+		t.Errorf("row 0 not wiped: %q, %q", email, phone)
+	}
+	if page[0].Email != "" || page[0].Phone != "" {
+		t.Errorf("row 0 still holds contacts %q, %q", page[0].Email, page[0].Phone)
+	}
+	if page[1].Email != "lead1@example.com" {
+		t.Errorf("wiping row 0 changed row 1: %q", page[1].Email)
+	}
+
+	email = page[1].Email
+	d.wipe()
+	if !zeroed(email) {
+		t.Errorf("wipe left row 1's email %q", email)
+	}
+}
+
+func TestPageDecrypterOnlyRequestedContacts(t *testing.T) {
+	leads, kms := syntheticLeads(t, 1)
+	page, _ := leads.page(context.Background(), 0, 1)
+	hash := page[0].Phone
+
+	d := &pageDecrypter{kms: kms, email: true}
+	defer d.wipe()
+	if err := d.decrypt(context.Background(), page); err != nil {
+		t.Fatal(err)
+	}
+	if page[0].Email != "lead0@example.com" || page[0].Phone != hash {
+		t.Errorf("email %q, phone %q; want the phone left as stored", page[0].Email, page[0].Phone)
+	}
+}
+
+func TestPageDecrypterCorruptCipher(t *testing.T) {
+	leads, kms := syntheticLeads(t, 2)
+	page, _ := leads.page(context.Background(), 0, 2)
+	page[1].EmailCipher[len(page[1].EmailCipher)-1] ^= 1
+
+	d := &pageDecrypter{kms: kms, email: true}
+	defer d.wipe()
+	err := d.decrypt(context.Background(), page)
+	if !errors.Is(err, envelope.ErrCorrupt) || !strings.Contains(err.Error(), page[1].ID) {
+		t.Errorf("decrypt error = %v, want ErrCorrupt naming lead %s", err, page[1].ID)
+	}
+	if !isPermanentDeliveryFailure(err) {
+		t.Error("a corrupt cipher is retried")
+	}
+}
+
+// TestDeliveryFileWipesContacts renders a file over several pages and checks every
+// decrypted contact was in the file and is wiped afterwards
+func TestDeliveryFileWipesContacts(t *testing.T) {
+	quietLogs(t)
+	ctx := context.Background()
+	leads, kms := syntheticLeads(t, jobLeadPageSize+10)
+	p := &payload.Payload{Version: payload.CurrentVersion}
+	usage, _, err := scanLeads(ctx, leads, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager := newLeadPager(ctx, leads)
+	decrypter := &pageDecrypter{kms: kms, email: true, phone: true}
+	pager.onLoad(decrypter.decrypt)
+	pager.onDone(decrypter.wipeRow)
+	var seen []string
+	pager.onLoad(func(_ context.Context, page []payload.Lead) error {
+		for i := range page {
+			seen = append(seen, page[i].Email, page[i].Phone)
+		}
+		return nil
+	})
+
+	for _, format := range []string{leadfile.FormatCSV, leadfile.FormatXLSX} {
+		seen = nil
+		cfg, err := leadfile.ParseConfig([]byte(`{"file_format": "` + format + `"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		table := buildLeadTable(p, pager, usage, nil, false)
+		var out strings.Builder
+		if _, err := leadfile.New(table, cfg, leadfile.Metadata{}).WriteTo(&out); err != nil {
+			t.Fatal(err)
+		}
+		if format == leadfile.FormatCSV && !strings.Contains(out.String(), "lead1009@example.com,+15550001009") {
+			t.Error("csv is missing the last lead's contacts")
+		}
+		decrypter.wipe()
+		for _, value := range seen {
+			if !zeroed(value) {
+				t.Fatalf("%s: contact %q not wiped", format, value)
+			}
+		}
+		if len(seen) < 2*leads.Len() {
+			t.Errorf("%s: saw %d contacts, want at least %d", format, len(seen), 2*leads.Len())
+		}
+	}
+}
+
+func TestEncodeRecordsWipesEachRow(t *testing.T) {
+	quietLogs(t)
+	ctx := context.Background()
+	leads, kms := syntheticLeads(t, 3)
+	p := &payload.Payload{
+		Version: payload.CurrentVersion,
+		CsvFieldConfig: []payload.CsvFieldConfig{
+			{Key: "first_name", DeliverToBuyer: true},
+			{Key: "email", DeliverToBuyer: true},
+		},
+	}
+	usage, _, err := scanLeads(ctx, leads, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager := newLeadPager(ctx, leads)
+	decrypter := &pageDecrypter{kms: kms, email: true}
+	defer decrypter.wipe()
+	pager.onLoad(decrypter.decrypt)
+	pager.onDone(decrypter.wipeRow)
+	table := buildLeadTable(p, pager, usage, map[string]bool{"first_name": true, "email": true}, false)
+	names := recordFieldNames(APIDeliveryConfig{}, table.Columns)
+
+	tests := []struct {
+		format, want, contentType string
+	}{
+		{apiFormatJSONArray, `[{"email":"lead0@example.com","first_name":"First0"},{"email":"lead2@example.com","first_name":"First2"}]`, "application/json"},
+		{apiFormatNDJSON, "{\"email\":\"lead0@example.com\",\"first_name\":\"First0\"}\n{\"email\":\"lead2@example.com\",\"first_name\":\"First2\"}\n", "application/x-ndjson"},
+	}
+	for _, tt := range tests {
+		body, contentType, err := encodeRecords(tt.format, names, table, []int{0, 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != tt.want || contentType != tt.contentType {
+			t.Errorf("%s: body %s (%s), want %s (%s)", tt.format, body, contentType, tt.want, tt.contentType)
+		}
+		for _, row := range []int{0, 2} {
+			if email := pager.lead(row).Email; email != "" {
+				t.Errorf("%s: row %d still holds %q after encoding", tt.format, row, email)
+			}
+		}
+	}
+
+	body, _, err := encodeRecords(apiFormatPerLead, names, table, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"email":"lead1@example.com","first_name":"First1"}`; string(body) != want {
+		t.Errorf("per_lead body %s, want %s", body, want)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+)
+
+// newMockQueries returns queries over a sqlmock database. Expectations match the
+// queries' "-- name:" comments; any left unmet fail the test.
+func newMockQueries(t *testing.T) (*queries.Queries, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock := newMockDB(t)
+	return queries.New(conn), mock
+}
+
+// newMockDB returns a sqlmock database whose unmet expectations fail the test
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+	return conn, mock
+}
+
+// useMockDB points the worker's db and dbQueries at a sqlmock database for the test,
+// for code that opens its own transactions
+func useMockDB(t *testing.T) (*queries.Queries, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock := newMockDB(t)
+	savedDB, savedQueries := db, dbQueries
+	db, dbQueries = conn, queries.New(conn)
+	t.Cleanup(func() { db, dbQueries = savedDB, savedQueries })
+	return dbQueries, mock
+}
+
+// queryName is the expectation pattern for a named query
+func queryName(name string) string {
+	return `-- name: ` + name + ` `
+}
+
+// arrayConverter passes []string parameters through, as pgx takes them for text[]
+type arrayConverter struct{}
+
+func (arrayConverter) ConvertValue(v any) (driver.Value, error) {
+	if values, ok := v.([]string); ok {
+		return values, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+// argFunc matches a query argument with a function
+type argFunc func(driver.Value) bool
+
+func (f argFunc) Match(v driver.Value) bool {
+	return f(v)
+}
+
+var jobColumns = []string{"id", "tenant_id", "buyer_id", "delivery_method_id", "delivery_id", "payload", "description", "scheduled_at", "delivered_at", "status", "last_error", "attempts", "created_at", "updated_at", "lease_owner", "lease_expires_at", "next_attempt_at"}
+
+// jobRow is a delivery_jobs row for job, in jobColumns order
+func jobRow(job *queries.DeliveryJob) []driver.Value {
+	payload := []byte(job.Payload)
+	if payload == nil {
+		payload = []byte(`{}`)
+	}
+	status := job.Status
+	if status == "" {
+		status = "pending"
+	}
+	return []driver.Value{job.ID, job.TenantID, job.BuyerID, job.DeliveryMethodID, job.DeliveryID, payload, job.Description,
+		job.ScheduledAt, job.DeliveredAt, status, job.LastError, job.Attempts, job.CreatedAt, job.UpdatedAt,
+		job.LeaseOwner, job.LeaseExpiresAt, job.NextAttemptAt}
+}
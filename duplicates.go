@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+// defaultDuplicateLookbackDays applies to buyers without duplicate_lookback_days
+const defaultDuplicateLookbackDays = 90
+
+// How a duplicate was recognized
+const (
+	duplicateMatchEmail = "email"
+	duplicateMatchPhone = "phone"
+)
+
+// duplicateSummary records the leads removed because the buyer already has them
+type duplicateSummary struct {
+	LookbackDays        int             `json:"lookback_days"`
+	Checked             int             `json:"checked"`
+	Removed             int             `json:"removed"`
+	WithinJob           int             `json:"within_job,omitempty"`
+	PreviouslyDelivered int             `json:"previously_delivered,omitempty"`
+	Leads               []duplicateLead `json:"leads,omitempty"`
+}
+
+type duplicateLead struct {
+	LeadID      string    `json:"lead_id"`
+	Match       string    `json:"match"`
+	DuplicateOf string    `json:"duplicate_of"`     // Lead kept in this job, or delivered earlier
+	JobID       string    `json:"job_id,omitempty"` // Job that delivered DuplicateOf earlier
+	DeliveredAt time.Time `json:"delivered_at,omitzero"`
+}
+
+// priorDelivery is a ledger entry a lead can collide with
+type priorDelivery struct {
+	leadID      string
+	jobID       string
+	deliveredAt time.Time
+}
+
+// applyDuplicateCheck removes leads from a page whose email or phone hash repeats
+// within the job, or was delivered to the job's buyer within the buyer's lookback
+// window, and returns the rest. The first lead of a repeated contact is kept. earlier,
+// when set, holds the leads kept from the job's earlier pages.
+func applyDuplicateCheck(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, leads []payload.Lead, earlier *leadSpool) ([]payload.Lead, *duplicateSummary, error) {
+	lookbackDays := defaultDuplicateLookbackDays
+	buyer, err := q.GetBuyerByID(ctx, queries.GetBuyerByIDParams{ID: job.BuyerID, TenantID: job.TenantID})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, fmt.Errorf("failed to fetch buyer: %w", err)
+	}
+	if buyer.DuplicateLookbackDays.Valid {
+		lookbackDays = max(int(buyer.DuplicateLookbackDays.Int32), 0)
+	}
+
+	var emailHashes, phoneHashes []string
+	for i := range leads {
+		if h := ledgerHash(leads[i].Email); h != "" {
+			emailHashes = append(emailHashes, h)
+		}
+		if h := ledgerHash(leads[i].Phone); h != "" {
+			phoneHashes = append(phoneHashes, h)
+		}
+	}
+	hasContacts := len(emailHashes) > 0 || len(phoneHashes) > 0
+
+	emails := make(map[string]priorDelivery)
+	phones := make(map[string]priorDelivery)
+	if lookbackDays > 0 && hasContacts {
+		prior, err := q.ListBuyerDeliveredMatches(ctx, queries.ListBuyerDeliveredMatchesParams{
+			TenantID:    job.TenantID,
+			BuyerID:     job.BuyerID,
+			Since:       time.Now().AddDate(0, 0, -lookbackDays),
+			JobID:       job.ID,
+			EmailHashes: emailHashes,
+			PhoneHashes: phoneHashes,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to check delivered leads: %w", err)
+		}
+		// Newest first, so the first entry seen per hash is the latest delivery
+		for _, d := range prior {
+			entry := priorDelivery{leadID: d.LeadID.String(), deliveredAt: d.DeliveredAt}
+			if d.JobID.Valid {
+				entry.jobID = d.JobID.UUID.String()
+			}
+			if h := ledgerHash(d.EmailHash.String); h != "" {
+				if _, seen := emails[h]; !seen {
+					emails[h] = entry
+				}
+			}
+			if h := ledgerHash(d.PhoneHash.String); h != "" {
+				if _, seen := phones[h]; !seen {
+					phones[h] = entry
+				}
+			}
+		}
+	}
+	if earlier != nil && hasContacts {
+		kept, err := earlier.contacts(ctx, emailHashes, phoneHashes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to check the job's earlier leads: %w", err)
+		}
+		for _, k := range kept {
+			entry := priorDelivery{leadID: k.LeadID.String()}
+			if h := ledgerHash(k.EmailHash.String); h != "" {
+				if _, seen := emails[h]; !seen {
+					emails[h] = entry
+				}
+			}
+			if h := ledgerHash(k.PhoneHash.String); h != "" {
+				if _, seen := phones[h]; !seen {
+					phones[h] = entry
+				}
+			}
+		}
+	}
+
+	result := &duplicateSummary{LookbackDays: lookbackDays, Checked: len(leads)}
+	kept := leads[:0]
+	for _, lead := range leads {
+		emailHash, phoneHash := ledgerHash(lead.Email), ledgerHash(lead.Phone)
+		var match string
+		var prior priorDelivery
+		if d, ok := emails[emailHash]; ok && emailHash != "" {
+			match, prior = duplicateMatchEmail, d
+		} else if d, ok := phones[phoneHash]; ok && phoneHash != "" {
+			match, prior = duplicateMatchPhone, d
+		}
+		if match != "" {
+			if prior.deliveredAt.IsZero() {
+				result.WithinJob++
+			} else {
+				result.PreviouslyDelivered++
+			}
+			result.Removed++
+			result.Leads = append(result.Leads, duplicateLead{
+				LeadID:      lead.ID,
+				Match:       match,
+				DuplicateOf: prior.leadID,
+				JobID:       prior.jobID,
+				DeliveredAt: prior.deliveredAt,
+			})
+			continue
+		}
+
+		// Later leads in this job with the same contact are duplicates of this one
+		if emailHash != "" {
+			emails[emailHash] = priorDelivery{leadID: lead.ID}
+		}
+		if phoneHash != "" {
+			phones[phoneHash] = priorDelivery{leadID: lead.ID}
+		}
+		kept = append(kept, lead)
+	}
+
+	if result.Removed > 0 {
+		log.Printf("Job %s: removed %d duplicate leads (%d within the job, %d delivered to buyer %s in the last %d days)",
+			job.ID, result.Removed, result.WithinJob, result.PreviouslyDelivered, job.BuyerID, lookbackDays)
+	}
+	return kept, result, nil
+}
+
+// add counts another page's results in s
+func (s *duplicateSummary) add(page *duplicateSummary) {
+	s.Checked += page.Checked
+	s.Removed += page.Removed
+	s.WithinJob += page.WithinJob
+	s.PreviouslyDelivered += page.PreviouslyDelivered
+	s.Leads = append(s.Leads, page.Leads...)
+}
+
+// buyerLedgerEntries captures the leads' ids and contact hashes for the delivered-lead
+// ledger. The leads must still hold the stored hashes in Email and Phone.
+func buyerLedgerEntries(job *queries.DeliveryJob, campaign *queries.Campaign, leads []payload.Lead) queries.InsertBuyerDeliveredLeadsParams {
+	params := queries.InsertBuyerDeliveredLeadsParams{
+		TenantID: job.TenantID,
+		BuyerID:  job.BuyerID,
+		JobID:    utils.NullUUID(job.ID),
+	}
+	if campaign != nil {
+		params.CampaignID = utils.NullUUID(campaign.ID)
+	}
+	for i := range leads {
+		params.LeadIds = append(params.LeadIds, leads[i].ID)
+		params.EmailHashes = append(params.EmailHashes, ledgerHash(leads[i].Email))
+		params.PhoneHashes = append(params.PhoneHashes, ledgerHash(leads[i].Phone))
+	}
+	return params
+}
+
+// ledgerRows narrows ledger entries to the given rows of the leads they were built from
+func ledgerRows(entries queries.InsertBuyerDeliveredLeadsParams, rows []int) queries.InsertBuyerDeliveredLeadsParams {
+	narrowed := entries
+	narrowed.LeadIds, narrowed.EmailHashes, narrowed.PhoneHashes = nil, nil, nil
+	for _, row := range rows {
+		narrowed.LeadIds = append(narrowed.LeadIds, entries.LeadIds[row])
+		narrowed.EmailHashes = append(narrowed.EmailHashes, entries.EmailHashes[row])
+		narrowed.PhoneHashes = append(narrowed.PhoneHashes, entries.PhoneHashes[row])
+	}
+	return narrowed
+}
+
+// recordDeliveredLeads adds a delivery's leads to the buyer's ledger a page at a time:
+// all of them, or only the given rows when rows isn't nil. A failure only weakens later
+// duplicate checks, so it is logged rather than returned.
+func recordDeliveredLeads(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, campaign *queries.Campaign, leads jobLeads, rows []int) {
+	var delivered map[int]bool
+	if rows != nil {
+		delivered = make(map[int]bool, len(rows))
+		for _, row := range rows {
+			delivered[row] = true
+		}
+	}
+	err := eachLeadPage(ctx, leads, func(from int, page []payload.Lead) error {
+		entries := buyerLedgerEntries(job, campaign, page)
+		if delivered != nil {
+			var pageRows []int
+			for i := range page {
+				if delivered[from+i] {
+					pageRows = append(pageRows, i)
+				}
+			}
+			entries = ledgerRows(entries, pageRows)
+		}
+		if len(entries.LeadIds) == 0 {
+			return nil
+		}
+		_, err := q.InsertBuyerDeliveredLeads(ctx, entries)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to record delivered leads for buyer %s: %v", job.BuyerID, err)
+	}
+}
+
+// ledgerHash makes stored hex hashes comparable regardless of case
+func ledgerHash(h string) string {
+	return strings.ToLower(strings.TrimSpace(h))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+var buyerColumns = []string{"id", "tenant_id", "name", "contact_email", "is_active", "created_at", "updated_at", "duplicate_lookback_days", "column_template"}
+
+var deliveredColumns = []string{"id", "tenant_id", "buyer_id", "lead_id", "job_id", "campaign_id", "email_hash", "phone_hash", "delivered_at"}
+
+func testJob() *queries.DeliveryJob {
+	return &queries.DeliveryJob{ID: uuid.New(), TenantID: uuid.New(), BuyerID: uuid.New(), DeliveryMethodID: uuid.New()}
+}
+
+// expectBuyer answers GetBuyerByID for the job's buyer with a lookback, or without
+// one when lookbackDays is nil
+func expectBuyer(mock sqlmock.Sqlmock, job *queries.DeliveryJob, lookbackDays any) {
+	mock.ExpectQuery(queryName("GetBuyerByID")).
+		WithArgs(job.BuyerID, job.TenantID).
+		WillReturnRows(sqlmock.NewRows(buyerColumns).
+			AddRow(job.BuyerID, job.TenantID, "Buyer", nil, true, nil, nil, lookbackDays, nil))
+}
+
+func quietLogs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+func leadIDs(leads []payload.Lead) []string {
+	ids := []string{}
+	for _, lead := range leads {
+		ids = append(ids, lead.ID)
+	}
+	return ids
+}
+
+func TestApplyDuplicateCheckWithinJob(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	job := testJob()
+	// A zero lookback skips the ledger; only the job's own leads count
+	expectBuyer(mock, job, 0)
+
+	leads := []payload.Lead{
+		{ID: "a", Email: "e1", Phone: "p1"},
+		{ID: "b", Email: " E1 "}, // Same email hash, differently cased
+		{ID: "c", Phone: "p2"},
+		{ID: "d", Email: "e2", Phone: "P2"},
+		{ID: "e", Email: "e3", Phone: "p3"},
+	}
+	kept, summary, err := applyDuplicateCheck(context.Background(), q, job, leads, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := leadIDs(kept), []string{"a", "c", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+	want := &duplicateSummary{
+		LookbackDays: 0,
+		Checked:      5,
+		Removed:      2,
+		WithinJob:    2,
+		Leads: []duplicateLead{
+			{LeadID: "b", Match: duplicateMatchEmail, DuplicateOf: "a"},
+			{LeadID: "d", Match: duplicateMatchPhone, DuplicateOf: "c"},
+		},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+}
+
+func TestApplyDuplicateCheckEarlierPages(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	job := testJob()
+	p := &payload.Payload{}
+	spool := newLeadSpool(q, job, p)
+	first := uuid.New()
+
+	expectBuyer(mock, job, 0)
+	mock.ExpectQuery(queryName("ListDeliveryJobContactMatches")).
+		WithArgs(job.ID, job.TenantID, []string{"e1", "e2"}, []string{"p9"}).
+		WillReturnRows(sqlmock.NewRows([]string{"lead_id", "email_hash", "phone_hash"}).
+			AddRow(first, "E1", nil))
+
+	leads := []payload.Lead{{ID: "b", Email: "e1"}, {ID: "c", Email: "e2", Phone: "p9"}}
+	kept, summary, err := applyDuplicateCheck(context.Background(), q, job, leads, spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := leadIDs(kept), []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+	if summary.WithinJob != 1 || summary.Leads[0].DuplicateOf != first.String() {
+		t.Errorf("summary = %+v, want lead b a duplicate of %s within the job", summary, first)
+	}
+}
+
+func TestApplyDuplicateCheckLookback(t *testing.T) {
+	quietLogs(t)
+	deliveredAt := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	earlierJob, earlierLead := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name         string
+		lookbackDays any // Buyer's duplicate_lookback_days; nil takes the default
+		wantDays     int
+	}{
+		{name: "buyer lookback", lookbackDays: 30, wantDays: 30},
+		{name: "default lookback", lookbackDays: nil, wantDays: defaultDuplicateLookbackDays},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, mock := newMockQueries(t)
+			job := testJob()
+			expectBuyer(mock, job, tt.lookbackDays)
+
+			// Matches are scoped to this tenant and buyer, within the window, from other jobs
+			since := argFunc(func(v driver.Value) bool {
+				got, ok := v.(time.Time)
+				want := time.Now().AddDate(0, 0, -tt.wantDays)
+				return ok && got.Sub(want).Abs() < time.Minute
+			})
+			mock.ExpectQuery(queryName("ListBuyerDeliveredMatches")).
+				WithArgs(job.TenantID, job.BuyerID, since, job.ID, []string{"e1", "e2"}, []string{"p1"}).
+				WillReturnRows(sqlmock.NewRows(deliveredColumns).
+					AddRow(uuid.New(), job.TenantID, job.BuyerID, earlierLead, earlierJob, nil, "E1", nil, deliveredAt))
+
+			leads := []payload.Lead{{ID: "a", Email: "e1"}, {ID: "b", Email: "e2", Phone: "p1"}}
+			kept, summary, err := applyDuplicateCheck(context.Background(), q, job, leads, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := leadIDs(kept), []string{"b"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("kept %v, want %v", got, want)
+			}
+			want := &duplicateSummary{
+				LookbackDays:        tt.wantDays,
+				Checked:             2,
+				Removed:             1,
+				PreviouslyDelivered: 1,
+				Leads: []duplicateLead{{
+					LeadID:      "a",
+					Match:       duplicateMatchEmail,
+					DuplicateOf: earlierLead.String(),
+					JobID:       earlierJob.String(),
+					DeliveredAt: deliveredAt,
+				}},
+			}
+			if !reflect.DeepEqual(summary, want) {
+				t.Errorf("summary = %+v, want %+v", summary, want)
+			}
+		})
+	}
+}
+
+func TestBuyerLedgerEntries(t *testing.T) {
+	job := testJob()
+	campaign := &queries.Campaign{ID: uuid.New()}
+	leads := []payload.Lead{
+		{ID: "a", Email: " ABC ", Phone: "DEF"},
+		{ID: "b", Email: "abc"},
+	}
+	got := buyerLedgerEntries(job, campaign, leads)
+	want := queries.InsertBuyerDeliveredLeadsParams{
+		TenantID:    job.TenantID,
+		BuyerID:     job.BuyerID,
+		JobID:       uuid.NullUUID{UUID: job.ID, Valid: true},
+		CampaignID:  uuid.NullUUID{UUID: campaign.ID, Valid: true},
+		LeadIds:     []string{"a", "b"},
+		EmailHashes: []string{"abc", "abc"},
+		PhoneHashes: []string{"def", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buyerLedgerEntries = %+v, want %+v", got, want)
+	}
+
+	if got := buyerLedgerEntries(job, nil, leads); got.CampaignID.Valid {
+		t.Errorf("campaign id %v without a campaign", got.CampaignID)
+	}
+}
+
+func TestRecordDeliveredLeadsOnlyAccepted(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	job := testJob()
+	p := &payload.Payload{Leads: []payload.Lead{
+		{ID: "a", Email: "e1"},
+		{ID: "b", Email: "e2"},
+		{ID: "c", Phone: "P3"},
+	}}
+
+	mock.ExpectExec(queryName("InsertBuyerDeliveredLeads")).
+		WithArgs(job.TenantID, job.BuyerID, job.ID, nil, []string{"a", "c"}, []string{"e1", ""}, []string{"", "p3"}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	recordDeliveredLeads(context.Background(), q, job, nil, &embeddedLeads{job: job, p: p}, []int{0, 2})
+
+	// Nothing accepted records nothing
+	recordDeliveredLeads(context.Background(), q, job, nil, &embeddedLeads{job: job, p: p}, []int{})
+}
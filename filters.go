@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+// leadFilters runs the stages that remove leads before delivery (suppression,
+// targeting, required columns, validation and duplicates) over a job's leads a page at
+// a time, adding up each stage's summary across the pages
+type leadFilters struct {
+	q        *queries.Queries
+	job      *queries.DeliveryJob
+	campaign *queries.Campaign
+	p        *payload.Payload
+	layout   *columnLayout
+	earlier  *leadSpool // Leads kept from earlier pages, for the within-job duplicate check
+
+	suppressed *suppressionSummary
+	targeted   *targetingSummary
+	validated  *validationSummary
+	columns    *columnSummary
+	duplicates *duplicateSummary
+}
+
+// apply runs a page of leads through every stage and returns the leads they kept.
+// Criteria, rules or a template that can't be used return the stage's own error.
+func (f *leadFilters) apply(ctx context.Context, leads []payload.Lead) ([]payload.Lead, error) {
+	// Drop leads on the campaign's suppression list before anything is decrypted or sent
+	leads, suppressed, err := applySuppressionList(ctx, f.q, f.job, f.campaign, leads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply suppression list: %w", err)
+	}
+	if suppressed != nil {
+		if f.suppressed == nil {
+			f.suppressed = suppressed
+		} else {
+			f.suppressed.add(suppressed)
+		}
+	}
+
+	// Quarantine leads outside the campaign's targeting criteria
+	leads, targeted, err := applyTargeting(ctx, f.q, f.job, f.campaign, leads)
+	if err != nil {
+		return nil, err
+	}
+	if targeted != nil {
+		if f.targeted == nil {
+			f.targeted = targeted
+		} else {
+			f.targeted.add(targeted)
+		}
+	}
+
+	// Drop leads without the column template's required fields, which only needs their
+	// fields present, so validation never decrypts a lead this would drop
+	leads, columns, err := f.layout.enforceRequired(ctx, f.q, f.job, f.campaign, f.p, leads)
+	if err != nil {
+		return nil, err
+	}
+	if columns != nil {
+		if f.columns == nil {
+			f.columns = columns
+		} else {
+			f.columns.add(columns)
+		}
+	}
+
+	// Normalize lead fields and drop leads failing the campaign's validation rules
+	leads, validated, err := applyValidation(ctx, f.q, f.job, f.campaign, leads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate leads: %w", err)
+	}
+	if f.validated == nil {
+		f.validated = validated
+	} else {
+		f.validated.add(validated)
+	}
+
+	// Drop contacts this buyer already has, from this job or an earlier delivery
+	leads, duplicates, err := applyDuplicateCheck(ctx, f.q, f.job, leads, f.earlier)
+	if err != nil {
+		return nil, err
+	}
+	if f.duplicates == nil {
+		f.duplicates = duplicates
+	} else {
+		f.duplicates.add(duplicates)
+	}
+	return leads, nil
+}
+
+// report sends the supplier the validation report once every page is filtered
+func (f *leadFilters) report(ctx context.Context) {
+	reportValidation(ctx, f.q, f.job, f.p, f.validated)
+}
+
+// outcome is the history status of a job whose leads were all removed: that of the
+// last stage that removed any, or "" when none did
+func (f *leadFilters) outcome() string {
+	switch {
+	case f.duplicates != nil && f.duplicates.Removed > 0:
+		return "duplicate"
+	case f.columns != nil && f.columns.Dropped > 0, f.validated != nil && f.validated.Dropped > 0:
+		return "rejected"
+	case f.targeted != nil && f.targeted.Quarantined > 0:
+		return "quarantined"
+	case f.suppressed != nil && f.suppressed.Removed > 0:
+		return "suppressed"
+	}
+	return ""
+}
+
+// summary returns the delivery summary with the stages' sections filled in
+func (f *leadFilters) summary() *deliverySummary {
+	return &deliverySummary{
+		Suppression: f.suppressed,
+		Targeting:   f.targeted,
+		Validation:  f.validated,
+		Columns:     f.columns,
+		Duplicates:  f.duplicates,
+	}
+}
@@ -0,0 +1,15 @@
+-- Lease columns used by ClaimDueJobs / ReleaseDeliveryJob / ReapExpiredJobLeases.
+-- A job in status 'processing' is owned by lease_owner until lease_expires_at;
+-- after that the reaper returns it to 'pending'.
+
+ALTER TABLE delivery_jobs
+    ADD COLUMN IF NOT EXISTS lease_owner TEXT,
+    ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS delivery_jobs_due_idx
+    ON delivery_jobs (scheduled_at)
+    WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS delivery_jobs_lease_expiry_idx
+    ON delivery_jobs (lease_expires_at)
+    WHERE status = 'processing';
@@ -0,0 +1,10 @@
+-- Earliest time a retried job may be claimed again. NULL means "as soon as
+-- scheduled_at passes"; set by ReleaseDeliveryJob from the method's retry policy.
+
+ALTER TABLE delivery_jobs
+    ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS delivery_jobs_due_idx;
+CREATE INDEX IF NOT EXISTS delivery_jobs_due_idx
+    ON delivery_jobs (COALESCE(next_attempt_at, scheduled_at))
+    WHERE status = 'pending';
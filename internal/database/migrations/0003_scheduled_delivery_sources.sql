@@ -0,0 +1,17 @@
+-- Read by the schedule runner when it builds job payloads (GetCampaignByID,
+-- ListLeadsByBatch). These match the admin API's schema; IF NOT EXISTS keeps
+-- this a no-op where they are already present.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS csv_field_config JSONB;
+
+ALTER TABLE leads
+    ADD COLUMN IF NOT EXISTS custom_answers JSONB,
+    ADD COLUMN IF NOT EXISTS captured_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS delivery_schedules_due_idx
+    ON delivery_schedules (tenant_id, next_run_at)
+    WHERE is_active = TRUE;
+
+CREATE INDEX IF NOT EXISTS deliveries_lead_batch_idx
+    ON deliveries (tenant_id, lead_batch_id);
@@ -0,0 +1,6 @@
+-- ClaimDueJobsForTenant filters by tenant before ordering by due time.
+
+DROP INDEX IF EXISTS delivery_jobs_due_idx;
+CREATE INDEX IF NOT EXISTS delivery_jobs_tenant_due_idx
+    ON delivery_jobs (tenant_id, COALESCE(next_attempt_at, scheduled_at))
+    WHERE status = 'pending';
@@ -0,0 +1,5 @@
+-- Reference-mode jobs page through a batch's leads at send time
+-- (ListLeadsByBatchPage), keyed on upload order.
+
+CREATE INDEX IF NOT EXISTS leads_batch_page_idx
+    ON leads (tenant_id, lead_batch_id, (COALESCE(created_at, '-infinity')), id);
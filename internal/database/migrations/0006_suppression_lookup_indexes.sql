@@ -0,0 +1,9 @@
+-- Delivery matches leads against suppression entries by hash
+-- (ListSuppressionEntriesMatching), and imports check for existing rows.
+
+CREATE INDEX IF NOT EXISTS suppression_entries_email_idx
+    ON suppression_entries (suppression_list_id, email_hash);
+CREATE INDEX IF NOT EXISTS suppression_entries_phone_idx
+    ON suppression_entries (suppression_list_id, phone_hash);
+CREATE INDEX IF NOT EXISTS suppression_entries_name_idx
+    ON suppression_entries (suppression_list_id, name_hash);
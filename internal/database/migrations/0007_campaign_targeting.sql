@@ -0,0 +1,23 @@
+-- Targeting criteria a campaign's leads must meet at delivery (see
+-- internal/targeting), and the leads held back for failing them.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS targeting_criteria JSONB;
+
+CREATE TABLE IF NOT EXISTS lead_quarantine (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id   UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    lead_id     UUID NOT NULL REFERENCES leads(id) ON DELETE CASCADE,
+    campaign_id UUID REFERENCES campaigns(id) ON DELETE SET NULL,
+    job_id      UUID REFERENCES delivery_jobs(id) ON DELETE SET NULL,
+    stage       TEXT NOT NULL,
+    reasons     TEXT NOT NULL,
+    created_at  TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- A retried job quarantines the same leads again
+CREATE UNIQUE INDEX IF NOT EXISTS lead_quarantine_job_lead_idx
+    ON lead_quarantine (job_id, lead_id, stage);
+
+CREATE INDEX IF NOT EXISTS lead_quarantine_campaign_idx
+    ON lead_quarantine (tenant_id, campaign_id, created_at);
@@ -0,0 +1,20 @@
+-- Campaign lead caps and pacing. NULL caps are unlimited. delivered_lead_count
+-- and the window counts are reserved under a campaigns row lock before a job
+-- sends, and given back if the delivery fails.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS target_lead_count INTEGER,
+    ADD COLUMN IF NOT EXISTS daily_lead_cap INTEGER,
+    ADD COLUMN IF NOT EXISTS weekly_lead_cap INTEGER,
+    ADD COLUMN IF NOT EXISTS pacing TEXT DEFAULT 'asap';
+
+-- Leads delivered per campaign per UTC day ('day') and ISO week ('week', starting Monday)
+CREATE TABLE IF NOT EXISTS campaign_delivery_counts (
+    tenant_id    UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    campaign_id  UUID NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    period       TEXT NOT NULL,
+    window_start DATE NOT NULL,
+    lead_count   INTEGER NOT NULL DEFAULT 0,
+    updated_at   TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (campaign_id, period, window_start)
+);
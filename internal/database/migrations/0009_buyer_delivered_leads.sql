@@ -0,0 +1,27 @@
+-- Ledger of leads delivered to each buyer, keyed by the leads' email and phone
+-- hashes, so the same person isn't sold to a buyer twice within the buyer's
+-- lookback window (NULL: 90 days, 0: only within a job).
+
+ALTER TABLE buyers
+    ADD COLUMN IF NOT EXISTS duplicate_lookback_days INTEGER;
+
+CREATE TABLE IF NOT EXISTS buyer_delivered_leads (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id    UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    buyer_id     UUID NOT NULL REFERENCES buyers(id) ON DELETE CASCADE,
+    lead_id      UUID NOT NULL,
+    job_id       UUID REFERENCES delivery_jobs(id) ON DELETE SET NULL,
+    campaign_id  UUID REFERENCES campaigns(id) ON DELETE SET NULL,
+    email_hash   TEXT,
+    phone_hash   TEXT,
+    delivered_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS buyer_delivered_leads_job_lead_idx
+    ON buyer_delivered_leads (buyer_id, lead_id, job_id);
+CREATE INDEX IF NOT EXISTS buyer_delivered_leads_email_idx
+    ON buyer_delivered_leads (tenant_id, buyer_id, email_hash, delivered_at)
+    WHERE email_hash IS NOT NULL;
+CREATE INDEX IF NOT EXISTS buyer_delivered_leads_phone_idx
+    ON buyer_delivered_leads (tenant_id, buyer_id, phone_hash, delivered_at)
+    WHERE phone_hash IS NOT NULL;
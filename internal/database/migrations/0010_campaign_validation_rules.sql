@@ -0,0 +1,7 @@
+-- Per-campaign lead validation rules: which failed checks drop a lead and which
+-- only flag it (e.g. {"email": "drop", "state": "flag", "ip_address": "off"}).
+-- NULL uses the worker's defaults. Dropped leads are quarantined with
+-- stage 'validation'.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS validation_rules JSONB;
@@ -0,0 +1,9 @@
+-- Column templates shape the delivered file: header labels, column order,
+-- constant and computed columns, and required fields. A campaign's template
+-- overrides its buyer's; either is layered over the campaign's csv_field_config.
+
+ALTER TABLE buyers
+    ADD COLUMN IF NOT EXISTS column_template JSONB;
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS column_template JSONB;
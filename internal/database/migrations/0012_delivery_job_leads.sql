@@ -0,0 +1,23 @@
+-- The leads a reference-mode job delivers, in delivery order. The worker filters a
+-- referenced batch a page at a time and keeps each page's survivors here, so neither
+-- filtering nor writing the file holds the whole batch in memory. Each attempt
+-- rebuilds the job's rows.
+
+CREATE TABLE IF NOT EXISTS delivery_job_leads (
+    job_id          UUID NOT NULL REFERENCES delivery_jobs(id) ON DELETE CASCADE,
+    tenant_id       UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    position        INTEGER NOT NULL,
+    lead_id         UUID NOT NULL,
+    lead_created_at TIMESTAMPTZ,
+    email_hash      TEXT,
+    phone_hash      TEXT,
+    lead            JSONB NOT NULL, -- The lead as filtering left it (payload.Lead)
+    PRIMARY KEY (job_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS delivery_job_leads_email_idx
+    ON delivery_job_leads (job_id, email_hash)
+    WHERE email_hash IS NOT NULL;
+CREATE INDEX IF NOT EXISTS delivery_job_leads_phone_idx
+    ON delivery_job_leads (job_id, phone_hash)
+    WHERE phone_hash IS NOT NULL;
@@ -0,0 +1,6 @@
+-- When the batch's supplier was sent its lead validation report. Every buyer's job
+-- for a batch validates the same leads; the first to find issues claims the batch
+-- and reports, so the supplier gets one report per batch rather than one per job.
+
+ALTER TABLE lead_batches
+    ADD COLUMN IF NOT EXISTS validation_reported_at TIMESTAMPTZ;
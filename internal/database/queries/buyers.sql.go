@@ -15,7 +15,7 @@ import (
 const createBuyer = `-- name: CreateBuyer :one
 INSERT INTO buyers (tenant_id, name, contact_email)
 VALUES ($1, $2, $3)
-RETURNING id, tenant_id, name, contact_email, is_active, created_at, updated_at
+RETURNING id, tenant_id, name, contact_email, is_active, created_at, updated_at, duplicate_lookback_days, column_template
 `
 
 type CreateBuyerParams struct {
@@ -35,6 +35,8 @@ func (q *Queries) CreateBuyer(ctx context.Context, arg CreateBuyerParams) (Buyer
 		&i.IsActive,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DuplicateLookbackDays,
+		&i.ColumnTemplate,
 	)
 	return i, err
 }
@@ -74,7 +76,7 @@ func (q *Queries) DeleteBuyer(ctx context.Context, arg DeleteBuyerParams) error
 }
 
 const getBuyerByID = `-- name: GetBuyerByID :one
-SELECT id, tenant_id, name, contact_email, is_active, created_at, updated_at FROM buyers WHERE id = $1 AND tenant_id = $2
+SELECT id, tenant_id, name, contact_email, is_active, created_at, updated_at, duplicate_lookback_days, column_template FROM buyers WHERE id = $1 AND tenant_id = $2
 `
 
 type GetBuyerByIDParams struct {
@@ -93,6 +95,8 @@ func (q *Queries) GetBuyerByID(ctx context.Context, arg GetBuyerByIDParams) (Buy
 		&i.IsActive,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DuplicateLookbackDays,
+		&i.ColumnTemplate,
 	)
 	return i, err
 }
@@ -111,7 +115,7 @@ func (q *Queries) GetBuyerForUser(ctx context.Context, userID uuid.UUID) (uuid.U
 }
 
 const listBuyers = `-- name: ListBuyers :many
-SELECT id, tenant_id, name, contact_email, is_active, created_at, updated_at FROM buyers WHERE tenant_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3
+SELECT id, tenant_id, name, contact_email, is_active, created_at, updated_at, duplicate_lookback_days, column_template FROM buyers WHERE tenant_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3
 `
 
 type ListBuyersParams struct {
@@ -137,6 +141,8 @@ func (q *Queries) ListBuyers(ctx context.Context, arg ListBuyersParams) ([]Buyer
 			&i.IsActive,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.DuplicateLookbackDays,
+			&i.ColumnTemplate,
 		); err != nil {
 			return nil, err
 		}
@@ -159,7 +165,7 @@ SET name = $3,
   updated_at = NOW()
 WHERE id = $1
   AND tenant_id = $2
-RETURNING id, tenant_id, name, contact_email, is_active, created_at, updated_at
+RETURNING id, tenant_id, name, contact_email, is_active, created_at, updated_at, duplicate_lookback_days, column_template
 `
 
 type UpdateBuyerParams struct {
@@ -187,6 +193,8 @@ func (q *Queries) UpdateBuyer(ctx context.Context, arg UpdateBuyerParams) (Buyer
 		&i.IsActive,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DuplicateLookbackDays,
+		&i.ColumnTemplate,
 	)
 	return i, err
 }
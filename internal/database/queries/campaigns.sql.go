@@ -11,6 +11,46 @@ import (
 	"github.com/google/uuid"
 )
 
+const getCampaignByID = `-- name: GetCampaignByID :one
+SELECT id, tenant_id, buyer_id, suppression_list_id, name, delivery_schedule, is_active, description, start_date, end_date, created_at, updated_at, delivered_lead_count, csv_field_config, targeting_criteria, target_lead_count, daily_lead_cap, weekly_lead_cap, pacing, validation_rules, column_template
+FROM campaigns
+WHERE id = $1 AND tenant_id = $2
+`
+
+type GetCampaignByIDParams struct {
+	ID       uuid.UUID
+	TenantID uuid.UUID
+}
+
+func (q *Queries) GetCampaignByID(ctx context.Context, arg GetCampaignByIDParams) (Campaign, error) {
+	row := q.db.QueryRowContext(ctx, getCampaignByID, arg.ID, arg.TenantID)
+	var i Campaign
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.BuyerID,
+		&i.SuppressionListID,
+		&i.Name,
+		&i.DeliverySchedule,
+		&i.IsActive,
+		&i.Description,
+		&i.StartDate,
+		&i.EndDate,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeliveredLeadCount,
+		&i.CsvFieldConfig,
+		&i.TargetingCriteria,
+		&i.TargetLeadCount,
+		&i.DailyLeadCap,
+		&i.WeeklyLeadCap,
+		&i.Pacing,
+		&i.ValidationRules,
+		&i.ColumnTemplate,
+	)
+	return i, err
+}
+
 const incrementCampaignDeliveredCount = `-- name: IncrementCampaignDeliveredCount :exec
 UPDATE campaigns
 SET delivered_lead_count = delivered_lead_count + $3,
@@ -28,3 +68,88 @@ func (q *Queries) IncrementCampaignDeliveredCount(ctx context.Context, arg Incre
 	_, err := q.db.ExecContext(ctx, incrementCampaignDeliveredCount, arg.ID, arg.TenantID, arg.DeliveredLeadCount)
 	return err
 }
+
+const listCampaignQuestions = `-- name: ListCampaignQuestions :many
+SELECT id, tenant_id, campaign_id, question_text, display_order, created_at, updated_at
+FROM campaign_questions
+WHERE campaign_id = $1 AND tenant_id = $2
+ORDER BY display_order ASC
+`
+
+type ListCampaignQuestionsParams struct {
+	CampaignID uuid.UUID
+	TenantID   uuid.UUID
+}
+
+func (q *Queries) ListCampaignQuestions(ctx context.Context, arg ListCampaignQuestionsParams) ([]CampaignQuestion, error) {
+	rows, err := q.db.QueryContext(ctx, listCampaignQuestions, arg.CampaignID, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CampaignQuestion
+	for rows.Next() {
+		var i CampaignQuestion
+		if err := rows.Scan(
+			&i.ID,
+			&i.TenantID,
+			&i.CampaignID,
+			&i.QuestionText,
+			&i.DisplayOrder,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockCampaignForDelivery = `-- name: LockCampaignForDelivery :one
+SELECT id, tenant_id, buyer_id, suppression_list_id, name, delivery_schedule, is_active, description, start_date, end_date, created_at, updated_at, delivered_lead_count, csv_field_config, targeting_criteria, target_lead_count, daily_lead_cap, weekly_lead_cap, pacing, validation_rules, column_template
+FROM campaigns
+WHERE id = $1 AND tenant_id = $2
+FOR UPDATE
+`
+
+type LockCampaignForDeliveryParams struct {
+	ID       uuid.UUID
+	TenantID uuid.UUID
+}
+
+// Serializes lead reservations for a campaign; hold it only inside a transaction.
+func (q *Queries) LockCampaignForDelivery(ctx context.Context, arg LockCampaignForDeliveryParams) (Campaign, error) {
+	row := q.db.QueryRowContext(ctx, lockCampaignForDelivery, arg.ID, arg.TenantID)
+	var i Campaign
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.BuyerID,
+		&i.SuppressionListID,
+		&i.Name,
+		&i.DeliverySchedule,
+		&i.IsActive,
+		&i.Description,
+		&i.StartDate,
+		&i.EndDate,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeliveredLeadCount,
+		&i.CsvFieldConfig,
+		&i.TargetingCriteria,
+		&i.TargetLeadCount,
+		&i.DailyLeadCap,
+		&i.WeeklyLeadCap,
+		&i.Pacing,
+		&i.ValidationRules,
+		&i.ColumnTemplate,
+	)
+	return i, err
+}
@@ -14,6 +14,84 @@ import (
 	"github.com/google/uuid"
 )
 
+const claimDueJobsForTenant = `-- name: ClaimDueJobsForTenant :many
+WITH due AS (
+    SELECT id
+    FROM delivery_jobs
+    WHERE status = 'pending'
+      AND tenant_id = $4
+      AND scheduled_at <= NOW()
+      AND (next_attempt_at IS NULL OR next_attempt_at <= NOW())
+    ORDER BY COALESCE(next_attempt_at, scheduled_at) ASC
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+)
+UPDATE delivery_jobs AS j
+SET status = 'processing',
+    lease_owner = $1,
+    lease_expires_at = NOW() + make_interval(secs => $3::int),
+    updated_at = NOW()
+FROM due
+WHERE j.id = due.id
+RETURNING j.id, j.tenant_id, j.buyer_id, j.delivery_method_id, j.delivery_id, j.payload, j.description, j.scheduled_at, j.delivered_at, j.status, j.last_error, j.attempts, j.created_at, j.updated_at, j.lease_owner, j.lease_expires_at, j.next_attempt_at
+`
+
+type ClaimDueJobsForTenantParams struct {
+	LeaseOwner   sql.NullString
+	Limit        int32
+	LeaseSeconds int32
+	TenantID     uuid.UUID
+}
+
+// Moves up to $2 of a tenant's due pending jobs into 'processing' under a lease
+// owned by the caller. SKIP LOCKED lets concurrent workers claim disjoint sets;
+// claiming per tenant lets the worker give each tenant its own budget per cycle.
+func (q *Queries) ClaimDueJobsForTenant(ctx context.Context, arg ClaimDueJobsForTenantParams) ([]DeliveryJob, error) {
+	rows, err := q.db.QueryContext(ctx, claimDueJobsForTenant,
+		arg.LeaseOwner,
+		arg.Limit,
+		arg.LeaseSeconds,
+		arg.TenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeliveryJob
+	for rows.Next() {
+		var i DeliveryJob
+		if err := rows.Scan(
+			&i.ID,
+			&i.TenantID,
+			&i.BuyerID,
+			&i.DeliveryMethodID,
+			&i.DeliveryID,
+			&i.Payload,
+			&i.Description,
+			&i.ScheduledAt,
+			&i.DeliveredAt,
+			&i.Status,
+			&i.LastError,
+			&i.Attempts,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LeaseOwner,
+			&i.LeaseExpiresAt,
+			&i.NextAttemptAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createDeliveryJob = `-- name: CreateDeliveryJob :one
 
 INSERT INTO delivery_jobs (
@@ -38,7 +116,7 @@ VALUES (
     $8,                -- payload (jsonb)
     $9                 -- description
 )
-RETURNING id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at
+RETURNING id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at, lease_owner, lease_expires_at, next_attempt_at
 `
 
 type CreateDeliveryJobParams struct {
@@ -85,6 +163,9 @@ func (q *Queries) CreateDeliveryJob(ctx context.Context, arg CreateDeliveryJobPa
 		&i.Attempts,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.LeaseOwner,
+		&i.LeaseExpiresAt,
+		&i.NextAttemptAt,
 	)
 	return i, err
 }
@@ -106,7 +187,7 @@ func (q *Queries) DeleteDeliveryJob(ctx context.Context, arg DeleteDeliveryJobPa
 }
 
 const getDeliveryJob = `-- name: GetDeliveryJob :one
-SELECT id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at
+SELECT id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at, lease_owner, lease_expires_at, next_attempt_at
 FROM delivery_jobs
 WHERE id = $1
   AND tenant_id = $2
@@ -136,21 +217,102 @@ func (q *Queries) GetDeliveryJob(ctx context.Context, arg GetDeliveryJobParams)
 		&i.Attempts,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.LeaseOwner,
+		&i.LeaseExpiresAt,
+		&i.NextAttemptAt,
+	)
+	return i, err
+}
+
+const holdDeliveryJob = `-- name: HoldDeliveryJob :one
+UPDATE delivery_jobs
+SET status = 'pending',
+    attempts = GREATEST(attempts - 1, 0),
+    last_error = $3,
+    next_attempt_at = $5,
+    lease_owner = NULL,
+    lease_expires_at = NULL,
+    updated_at = now()
+WHERE id = $1
+  AND tenant_id = $2
+  AND status = 'processing'
+  AND lease_owner = $4
+RETURNING id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at, lease_owner, lease_expires_at, next_attempt_at
+`
+
+type HoldDeliveryJobParams struct {
+	ID            uuid.UUID
+	TenantID      uuid.UUID
+	LastError     sql.NullString
+	LeaseOwner    sql.NullString
+	NextAttemptAt sql.NullTime
+}
+
+// Puts a claimed job back to pending until next_attempt_at without counting
+// the claim as an attempt, for jobs deferred by campaign pacing rather than
+// failed. Matches no row (sql.ErrNoRows) if the lease was lost.
+func (q *Queries) HoldDeliveryJob(ctx context.Context, arg HoldDeliveryJobParams) (DeliveryJob, error) {
+	row := q.db.QueryRowContext(ctx, holdDeliveryJob,
+		arg.ID,
+		arg.TenantID,
+		arg.LastError,
+		arg.LeaseOwner,
+		arg.NextAttemptAt,
+	)
+	var i DeliveryJob
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.BuyerID,
+		&i.DeliveryMethodID,
+		&i.DeliveryID,
+		&i.Payload,
+		&i.Description,
+		&i.ScheduledAt,
+		&i.DeliveredAt,
+		&i.Status,
+		&i.LastError,
+		&i.Attempts,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LeaseOwner,
+		&i.LeaseExpiresAt,
+		&i.NextAttemptAt,
 	)
 	return i, err
 }
 
-const getDueJobs = `-- name: GetDueJobs :many
-SELECT id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at
+const incrementDeliveryJobAttempts = `-- name: IncrementDeliveryJobAttempts :exec
+UPDATE delivery_jobs 
+SET attempts = attempts + 1,
+    updated_at = now()
+WHERE id = $1 AND tenant_id = $2
+`
+
+type IncrementDeliveryJobAttemptsParams struct {
+	ID       uuid.UUID
+	TenantID uuid.UUID
+}
+
+func (q *Queries) IncrementDeliveryJobAttempts(ctx context.Context, arg IncrementDeliveryJobAttemptsParams) error {
+	_, err := q.db.ExecContext(ctx, incrementDeliveryJobAttempts, arg.ID, arg.TenantID)
+	return err
+}
+
+const listExpiredJobLeases = `-- name: ListExpiredJobLeases :many
+SELECT id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at, lease_owner, lease_expires_at, next_attempt_at
 FROM delivery_jobs
-WHERE status = 'pending'
-  AND scheduled_at <= NOW()
-ORDER BY scheduled_at ASC
-LIMIT 100
+WHERE status = 'processing'
+  AND lease_expires_at < NOW()
+ORDER BY lease_expires_at ASC
+LIMIT $1
 `
 
-func (q *Queries) GetDueJobs(ctx context.Context) ([]DeliveryJob, error) {
-	rows, err := q.db.QueryContext(ctx, getDueJobs)
+// Jobs whose worker died or timed out mid-delivery. The worker settles each
+// with ReleaseDeliveryJob under the expired lease owner, so a job claimed or
+// released in the meantime is left alone.
+func (q *Queries) ListExpiredJobLeases(ctx context.Context, limit int32) ([]DeliveryJob, error) {
+	rows, err := q.db.QueryContext(ctx, listExpiredJobLeases, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -173,6 +335,9 @@ func (q *Queries) GetDueJobs(ctx context.Context) ([]DeliveryJob, error) {
 			&i.Attempts,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.LeaseOwner,
+			&i.LeaseExpiresAt,
+			&i.NextAttemptAt,
 		); err != nil {
 			return nil, err
 		}
@@ -187,25 +352,8 @@ func (q *Queries) GetDueJobs(ctx context.Context) ([]DeliveryJob, error) {
 	return items, nil
 }
 
-const incrementDeliveryJobAttempts = `-- name: IncrementDeliveryJobAttempts :exec
-UPDATE delivery_jobs 
-SET attempts = attempts + 1,
-    updated_at = now()
-WHERE id = $1 AND tenant_id = $2
-`
-
-type IncrementDeliveryJobAttemptsParams struct {
-	ID       uuid.UUID
-	TenantID uuid.UUID
-}
-
-func (q *Queries) IncrementDeliveryJobAttempts(ctx context.Context, arg IncrementDeliveryJobAttemptsParams) error {
-	_, err := q.db.ExecContext(ctx, incrementDeliveryJobAttempts, arg.ID, arg.TenantID)
-	return err
-}
-
 const listPendingJobs = `-- name: ListPendingJobs :many
-SELECT id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at
+SELECT id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at, lease_owner, lease_expires_at, next_attempt_at
 FROM delivery_jobs
 WHERE status = 'pending'
   AND tenant_id = $1
@@ -243,6 +391,9 @@ func (q *Queries) ListPendingJobs(ctx context.Context, arg ListPendingJobsParams
 			&i.Attempts,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.LeaseOwner,
+			&i.LeaseExpiresAt,
+			&i.NextAttemptAt,
 		); err != nil {
 			return nil, err
 		}
@@ -257,6 +408,85 @@ func (q *Queries) ListPendingJobs(ctx context.Context, arg ListPendingJobsParams
 	return items, nil
 }
 
+const releaseDeliveryJob = `-- name: ReleaseDeliveryJob :one
+UPDATE delivery_jobs
+SET status = $2,
+    last_error = $4,
+    delivered_at = CASE WHEN $2 = 'success' THEN now() ELSE delivered_at END,
+    next_attempt_at = $6,
+    lease_owner = NULL,
+    lease_expires_at = NULL,
+    updated_at = now()
+WHERE id = $1
+  AND tenant_id = $3
+  AND status = 'processing'
+  AND lease_owner = $5
+RETURNING id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at, lease_owner, lease_expires_at, next_attempt_at
+`
+
+type ReleaseDeliveryJobParams struct {
+	ID            uuid.UUID
+	Status        string
+	TenantID      uuid.UUID
+	LastError     sql.NullString
+	LeaseOwner    sql.NullString
+	NextAttemptAt sql.NullTime
+}
+
+// Records the outcome of a claimed job and drops its lease. next_attempt_at
+// holds a retryable job back until its backoff elapses. Matches no row
+// (sql.ErrNoRows) if the lease expired and was reaped or re-claimed.
+func (q *Queries) ReleaseDeliveryJob(ctx context.Context, arg ReleaseDeliveryJobParams) (DeliveryJob, error) {
+	row := q.db.QueryRowContext(ctx, releaseDeliveryJob,
+		arg.ID,
+		arg.Status,
+		arg.TenantID,
+		arg.LastError,
+		arg.LeaseOwner,
+		arg.NextAttemptAt,
+	)
+	var i DeliveryJob
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.BuyerID,
+		&i.DeliveryMethodID,
+		&i.DeliveryID,
+		&i.Payload,
+		&i.Description,
+		&i.ScheduledAt,
+		&i.DeliveredAt,
+		&i.Status,
+		&i.LastError,
+		&i.Attempts,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LeaseOwner,
+		&i.LeaseExpiresAt,
+		&i.NextAttemptAt,
+	)
+	return i, err
+}
+
+const updateDeliveryJobPayload = `-- name: UpdateDeliveryJobPayload :exec
+UPDATE delivery_jobs
+SET payload = $3,
+    updated_at = now()
+WHERE id = $1
+  AND tenant_id = $2
+`
+
+type UpdateDeliveryJobPayloadParams struct {
+	ID       uuid.UUID
+	TenantID uuid.UUID
+	Payload  json.RawMessage
+}
+
+func (q *Queries) UpdateDeliveryJobPayload(ctx context.Context, arg UpdateDeliveryJobPayloadParams) error {
+	_, err := q.db.ExecContext(ctx, updateDeliveryJobPayload, arg.ID, arg.TenantID, arg.Payload)
+	return err
+}
+
 const updateDeliveryJobStatus = `-- name: UpdateDeliveryJobStatus :one
 UPDATE delivery_jobs
 SET status = $2,
@@ -265,7 +495,7 @@ SET status = $2,
     updated_at = now()
 WHERE id = $1
   AND tenant_id = $3
-RETURNING id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at
+RETURNING id, tenant_id, buyer_id, delivery_method_id, delivery_id, payload, description, scheduled_at, delivered_at, status, last_error, attempts, created_at, updated_at, lease_owner, lease_expires_at, next_attempt_at
 `
 
 type UpdateDeliveryJobStatusParams struct {
@@ -298,6 +528,9 @@ func (q *Queries) UpdateDeliveryJobStatus(ctx context.Context, arg UpdateDeliver
 		&i.Attempts,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.LeaseOwner,
+		&i.LeaseExpiresAt,
+		&i.NextAttemptAt,
 	)
 	return i, err
 }
@@ -108,9 +108,9 @@ const listDueSchedules = `-- name: ListDueSchedules :many
 SELECT id, tenant_id, buyer_id, cron_expression, timezone, is_active, last_run_at, next_run_at, created_at, updated_at
 FROM delivery_schedules
 WHERE is_active = TRUE
-  AND next_run_at <= now()
+  AND (next_run_at IS NULL OR next_run_at <= now())
   AND tenant_id = $1
-ORDER BY next_run_at
+ORDER BY next_run_at NULLS FIRST
 `
 
 func (q *Queries) ListDueSchedules(ctx context.Context, tenantID uuid.UUID) ([]DeliverySchedule, error) {
@@ -193,6 +193,41 @@ func (q *Queries) ListSchedulesByBuyer(ctx context.Context, arg ListSchedulesByB
 	return items, nil
 }
 
+const lockDueSchedule = `-- name: LockDueSchedule :one
+SELECT id, tenant_id, buyer_id, cron_expression, timezone, is_active, last_run_at, next_run_at, created_at, updated_at
+FROM delivery_schedules
+WHERE id = $1
+  AND tenant_id = $2
+  AND is_active = TRUE
+  AND (next_run_at IS NULL OR next_run_at <= now())
+FOR UPDATE SKIP LOCKED
+`
+
+type LockDueScheduleParams struct {
+	ID       uuid.UUID
+	TenantID uuid.UUID
+}
+
+// Locks a schedule for the rest of the transaction if it is still due.
+// Returns sql.ErrNoRows when another worker holds it or already advanced it.
+func (q *Queries) LockDueSchedule(ctx context.Context, arg LockDueScheduleParams) (DeliverySchedule, error) {
+	row := q.db.QueryRowContext(ctx, lockDueSchedule, arg.ID, arg.TenantID)
+	var i DeliverySchedule
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.BuyerID,
+		&i.CronExpression,
+		&i.Timezone,
+		&i.IsActive,
+		&i.LastRunAt,
+		&i.NextRunAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const updateDeliverySchedule = `-- name: UpdateDeliverySchedule :one
 UPDATE delivery_schedules
 SET cron_expression = COALESCE($2, cron_expression),
@@ -24,13 +24,27 @@ type AuditLog struct {
 }
 
 type Buyer struct {
-	ID           uuid.UUID
-	TenantID     uuid.UUID
-	Name         string
-	ContactEmail sql.NullString
-	IsActive     sql.NullBool
-	CreatedAt    sql.NullTime
-	UpdatedAt    sql.NullTime
+	ID                    uuid.UUID
+	TenantID              uuid.UUID
+	Name                  string
+	ContactEmail          sql.NullString
+	IsActive              sql.NullBool
+	CreatedAt             sql.NullTime
+	UpdatedAt             sql.NullTime
+	DuplicateLookbackDays sql.NullInt32
+	ColumnTemplate        pqtype.NullRawMessage
+}
+
+type BuyerDeliveredLead struct {
+	ID          uuid.UUID
+	TenantID    uuid.UUID
+	BuyerID     uuid.UUID
+	LeadID      uuid.UUID
+	JobID       uuid.NullUUID
+	CampaignID  uuid.NullUUID
+	EmailHash   sql.NullString
+	PhoneHash   sql.NullString
+	DeliveredAt time.Time
 }
 
 type BuyerUser struct {
@@ -40,18 +54,46 @@ type BuyerUser struct {
 }
 
 type Campaign struct {
-	ID                uuid.UUID
-	TenantID          uuid.UUID
-	BuyerID           uuid.NullUUID
-	SuppressionListID uuid.NullUUID
-	Name              string
-	DeliverySchedule  sql.NullString
-	IsActive          sql.NullBool
-	Description       sql.NullString
-	StartDate         sql.NullTime
-	EndDate           sql.NullTime
-	CreatedAt         sql.NullTime
-	UpdatedAt         sql.NullTime
+	ID                 uuid.UUID
+	TenantID           uuid.UUID
+	BuyerID            uuid.NullUUID
+	SuppressionListID  uuid.NullUUID
+	Name               string
+	DeliverySchedule   sql.NullString
+	IsActive           sql.NullBool
+	Description        sql.NullString
+	StartDate          sql.NullTime
+	EndDate            sql.NullTime
+	CreatedAt          sql.NullTime
+	UpdatedAt          sql.NullTime
+	DeliveredLeadCount int32
+	CsvFieldConfig     pqtype.NullRawMessage
+	TargetingCriteria  pqtype.NullRawMessage
+	TargetLeadCount    sql.NullInt32
+	DailyLeadCap       sql.NullInt32
+	WeeklyLeadCap      sql.NullInt32
+	Pacing             sql.NullString
+	ValidationRules    pqtype.NullRawMessage
+	ColumnTemplate     pqtype.NullRawMessage
+}
+
+type CampaignDeliveryCount struct {
+	TenantID    uuid.UUID
+	CampaignID  uuid.UUID
+	Period      string
+	WindowStart time.Time
+	LeadCount   int32
+	UpdatedAt   sql.NullTime
+}
+
+type CampaignQuestion struct {
+	ID           uuid.UUID
+	TenantID     uuid.UUID
+	CampaignID   uuid.UUID
+	QuestionText string
+	DisplayOrder int32
+	CreatedAt    sql.NullTime
+	UpdatedAt    sql.NullTime
 }
 
 type CampaignSupplier struct {
@@ -99,6 +141,20 @@ type DeliveryJob struct {
 	Attempts         int32
 	CreatedAt        time.Time
 	UpdatedAt        time.Time
+	LeaseOwner       sql.NullString
+	LeaseExpiresAt   sql.NullTime
+	NextAttemptAt    sql.NullTime
+}
+
+type DeliveryJobLead struct {
+	JobID         uuid.UUID
+	TenantID      uuid.UUID
+	Position      int32
+	LeadID        uuid.UUID
+	LeadCreatedAt sql.NullTime
+	EmailHash     sql.NullString
+	PhoneHash     sql.NullString
+	Lead          json.RawMessage
 }
 
 type DeliveryMethod struct {
@@ -167,18 +223,32 @@ type Lead struct {
 	NaicsCode           sql.NullString
 	CreatedAt           sql.NullTime
 	UpdatedAt           sql.NullTime
+	CustomAnswers       pqtype.NullRawMessage
+	CapturedAt          sql.NullTime
 }
 
 type LeadBatch struct {
+	ID                   uuid.UUID
+	TenantID             uuid.UUID
+	CampaignID           uuid.UUID
+	SupplierID           uuid.NullUUID
+	BatchName            string
+	TotalLeads           sql.NullInt32
+	Status               sql.NullString
+	CreatedAt            sql.NullTime
+	UpdatedAt            sql.NullTime
+	ValidationReportedAt sql.NullTime
+}
+
+type LeadQuarantine struct {
 	ID         uuid.UUID
 	TenantID   uuid.UUID
-	CampaignID uuid.UUID
-	SupplierID uuid.NullUUID
-	BatchName  string
-	TotalLeads sql.NullInt32
-	Status     sql.NullString
+	LeadID     uuid.UUID
+	CampaignID uuid.NullUUID
+	JobID      uuid.NullUUID
+	Stage      string
+	Reasons    string
 	CreatedAt  sql.NullTime
-	UpdatedAt  sql.NullTime
 }
 
 type Supplier struct {
@@ -62,6 +62,39 @@ func (q *Queries) GetTenantByID(ctx context.Context, id uuid.UUID) (Tenant, erro
 	return i, err
 }
 
+const listTenantIDsAfter = `-- name: ListTenantIDsAfter :many
+SELECT id FROM tenants WHERE id > $1 ORDER BY id ASC LIMIT $2
+`
+
+type ListTenantIDsAfterParams struct {
+	ID    uuid.UUID
+	Limit int32
+}
+
+// Keyset pagination over tenants; stable while tenants are created mid-sweep
+func (q *Queries) ListTenantIDsAfter(ctx context.Context, arg ListTenantIDsAfterParams) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, listTenantIDsAfter, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listTenants = `-- name: ListTenants :many
 SELECT id, name, contact_email, created_at, updated_at FROM tenants ORDER BY created_at DESC LIMIT $1 OFFSET $2
 `
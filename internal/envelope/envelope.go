@@ -0,0 +1,145 @@
+// Package envelope decrypts lead PII stored with envelope encryption.
+//
+// Each lead has its own 256-bit data key (DEK). The DEK is stored wrapped by a KMS key
+// (leads.dek_wrapped, leads.dek_kms_key_id), and each encrypted field (email_cipher,
+// phone_cipher) is AES-256-GCM: a 12-byte nonce followed by the sealed value.
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrCorrupt means a wrapped key or ciphertext failed authentication. The stored data is
+// damaged or was encrypted under a different key, so retrying won't help.
+var ErrCorrupt = errors.New("envelope data failed authentication")
+
+// KMS unwraps data keys. keyID is the wrapping key recorded with the lead; providers
+// that can infer it from the wrapped blob (like AWS KMS symmetric keys) may ignore it.
+type KMS interface {
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Decryptor decrypts lead fields, unwrapping each distinct DEK once. Call Wipe when
+// done to zero every DEK and plaintext it handed out.
+type Decryptor struct {
+	kms KMS
+
+	mu         sync.Mutex
+	deks       map[string][]byte // wrapped DEK -> DEK
+	plaintexts [][]byte
+}
+
+// NewDecryptor returns a Decryptor that unwraps keys with kms
+func NewDecryptor(kms KMS) *Decryptor {
+	return &Decryptor{kms: kms, deks: make(map[string][]byte)}
+}
+
+// Decrypt returns the plaintext of one field. The returned slice is owned by the
+// Decryptor and zeroed by Wipe; copy it if it must outlive the Decryptor.
+func (d *Decryptor) Decrypt(ctx context.Context, keyID string, wrappedDEK, ciphertext []byte) ([]byte, error) {
+	dek, err := d.dek(ctx, keyID, wrappedDEK)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := Open(dek, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.plaintexts = append(d.plaintexts, plaintext)
+	d.mu.Unlock()
+	return plaintext, nil
+}
+
+// dek returns the unwrapped key, asking the KMS only the first time a wrapped key is seen
+func (d *Decryptor) dek(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if len(wrapped) == 0 {
+		return nil, fmt.Errorf("%w: missing wrapped data key", ErrCorrupt)
+	}
+
+	d.mu.Lock()
+	dek, ok := d.deks[string(wrapped)]
+	d.mu.Unlock()
+	if ok {
+		return dek, nil
+	}
+
+	dek, err := d.kms.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	if len(dek) != 32 {
+		Zero(dek)
+		return nil, fmt.Errorf("%w: data key is %d bytes, want 32", ErrCorrupt, len(dek))
+	}
+
+	d.mu.Lock()
+	d.deks[string(wrapped)] = dek
+	d.mu.Unlock()
+	return dek, nil
+}
+
+// Wipe zeroes all DEKs and plaintexts and forgets them
+func (d *Decryptor) Wipe() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, dek := range d.deks {
+		Zero(dek)
+		delete(d.deks, k)
+	}
+	for _, p := range d.plaintexts {
+		Zero(p)
+	}
+	d.plaintexts = nil
+}
+
+// Seal encrypts plaintext under a 32-byte key in the nonce-prefixed GCM layout
+func Seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a nonce-prefixed GCM ciphertext
+func Open(key, ciphertext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrCorrupt)
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Zero overwrites b with zeros
+func Zero(b []byte) {
+	clear(b)
+}
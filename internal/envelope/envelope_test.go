@@ -0,0 +1,195 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// countingKMS counts the keys it unwraps
+type countingKMS struct {
+	KMS
+	unwraps int
+}
+
+func (c *countingKMS) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	c.unwraps++
+	return c.KMS.UnwrapKey(ctx, keyID, wrapped)
+}
+
+// testKey returns a 32-byte key filled with b
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// sealedField returns a local KMS holding key "k1", a DEK wrapped under it and value
+// sealed under the DEK
+func sealedField(t *testing.T, value string) (*LocalKMS, []byte, []byte) {
+	t.Helper()
+	kms := &LocalKMS{Keys: map[string][]byte{"k1": testKey(1)}}
+	dek := testKey(2)
+	wrapped, err := kms.WrapKey("k1", dek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := Seal(dek, []byte(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kms, wrapped, ciphertext
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	key := testKey(7)
+	for _, value := range []string{"", "lead@example.com", strings.Repeat("x", 4096)} {
+		ciphertext, err := Seal(key, []byte(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, err := Open(key, ciphertext)
+		if err != nil {
+			t.Fatalf("Open(%q): %v", value, err)
+		}
+		if string(plaintext) != value {
+			t.Errorf("round trip = %q, want %q", plaintext, value)
+		}
+	}
+
+	// Every seal draws a fresh nonce
+	a, _ := Seal(key, []byte("same"))
+	b, _ := Seal(key, []byte("same"))
+	if bytes.Equal(a, b) {
+		t.Error("two seals of the same value are identical")
+	}
+}
+
+func TestDecryptorRoundTrip(t *testing.T) {
+	kms, wrapped, ciphertext := sealedField(t, "lead@example.com")
+	dec := NewDecryptor(kms)
+	defer dec.Wipe()
+
+	plaintext, err := dec.Decrypt(context.Background(), "k1", wrapped, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "lead@example.com" {
+		t.Errorf("Decrypt = %q", plaintext)
+	}
+}
+
+func TestDecryptorRejectsDamagedData(t *testing.T) {
+	kms, wrapped, ciphertext := sealedField(t, "lead@example.com")
+	flip := func(b []byte, i int) []byte {
+		b = bytes.Clone(b)
+		b[i] ^= 0x01
+		return b
+	}
+
+	tests := []struct {
+		name       string
+		keyID      string
+		wrapped    []byte
+		ciphertext []byte
+	}{
+		{name: "tampered ciphertext", keyID: "k1", wrapped: wrapped, ciphertext: flip(ciphertext, len(ciphertext)-1)},
+		{name: "tampered nonce", keyID: "k1", wrapped: wrapped, ciphertext: flip(ciphertext, 0)},
+		{name: "truncated ciphertext", keyID: "k1", wrapped: wrapped, ciphertext: ciphertext[:10]},
+		{name: "tampered wrapped key", keyID: "k1", wrapped: flip(wrapped, len(wrapped)/2), ciphertext: ciphertext},
+		{name: "missing wrapped key", keyID: "k1", ciphertext: ciphertext},
+		{name: "unknown key id", keyID: "k2", wrapped: wrapped, ciphertext: ciphertext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := NewDecryptor(kms)
+			defer dec.Wipe()
+			_, err := dec.Decrypt(context.Background(), tt.keyID, tt.wrapped, tt.ciphertext)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("Decrypt error = %v, want ErrCorrupt", err)
+			}
+		})
+	}
+}
+
+func TestDecryptorRejectsShortDataKey(t *testing.T) {
+	kms := &LocalKMS{Keys: map[string][]byte{"k1": testKey(1)}}
+	wrapped, err := kms.WrapKey("k1", []byte("too short"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewDecryptor(kms).Decrypt(context.Background(), "k1", wrapped, make([]byte, 64))
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Decrypt error = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestDecryptorCachesKeysUntilWipe(t *testing.T) {
+	local, wrapped, ciphertext := sealedField(t, "lead@example.com")
+	kms := &countingKMS{KMS: local}
+	dec := NewDecryptor(kms)
+	ctx := context.Background()
+
+	first, err := dec.Decrypt(ctx, "k1", wrapped, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := dec.Decrypt(ctx, "k1", wrapped, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kms.unwraps != 1 {
+		t.Errorf("unwrapped the same key %d times, want once", kms.unwraps)
+	}
+
+	// A different lead's key is unwrapped separately
+	otherDEK := testKey(3)
+	otherWrapped, err := local.WrapKey("k1", otherDEK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCiphertext, _ := Seal(otherDEK, []byte("+15555550100"))
+	if _, err := dec.Decrypt(ctx, "k1", otherWrapped, otherCiphertext); err != nil {
+		t.Fatal(err)
+	}
+	if kms.unwraps != 2 {
+		t.Errorf("unwraps = %d after a second key, want 2", kms.unwraps)
+	}
+
+	dek := dec.deks[string(wrapped)]
+	dec.Wipe()
+	for name, b := range map[string][]byte{"first plaintext": first, "second plaintext": second, "data key": dek} {
+		if !bytes.Equal(b, make([]byte, len(b))) {
+			t.Errorf("%s not zeroed by Wipe: %q", name, b)
+		}
+	}
+	if len(dec.deks) != 0 || len(dec.plaintexts) != 0 {
+		t.Errorf("Wipe kept %d keys and %d plaintexts", len(dec.deks), len(dec.plaintexts))
+	}
+
+	// Keys are unwrapped again after a wipe
+	if _, err := dec.Decrypt(ctx, "k1", wrapped, ciphertext); err != nil {
+		t.Fatal(err)
+	}
+	if kms.unwraps != 3 {
+		t.Errorf("unwraps = %d after Wipe, want 3", kms.unwraps)
+	}
+}
+
+func TestParseLocalKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testKey(1))
+	kms, err := ParseLocalKeys(" k1:" + key + ", k2:" + key + ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kms.Keys) != 2 || !bytes.Equal(kms.Keys["k2"], testKey(1)) {
+		t.Errorf("ParseLocalKeys = %v", kms.Keys)
+	}
+
+	for _, spec := range []string{"", "k1", "k1:not-base64", "k1:" + base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := ParseLocalKeys(spec); err == nil {
+			t.Errorf("ParseLocalKeys(%q) succeeded", spec)
+		}
+	}
+}
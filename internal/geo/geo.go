@@ -0,0 +1,140 @@
+// Package geo holds the country, US state and region tables lead targeting and
+// validation check locations against.
+package geo
+
+import (
+	"strings"
+	"unicode"
+)
+
+// countries is the ISO 3166-1 alpha-2 code list
+var countries = map[string]bool{
+	"AD": true, "AE": true, "AF": true, "AG": true, "AI": true, "AL": true, "AM": true, "AO": true, "AQ": true, "AR": true, "AS": true, "AT": true, "AU": true, "AW": true, "AX": true, "AZ": true,
+	"BA": true, "BB": true, "BD": true, "BE": true, "BF": true, "BG": true, "BH": true, "BI": true, "BJ": true, "BL": true, "BM": true, "BN": true, "BO": true, "BQ": true, "BR": true, "BS": true, "BT": true, "BV": true, "BW": true, "BY": true, "BZ": true,
+	"CA": true, "CC": true, "CD": true, "CF": true, "CG": true, "CH": true, "CI": true, "CK": true, "CL": true, "CM": true, "CN": true, "CO": true, "CR": true, "CU": true, "CV": true, "CW": true, "CX": true, "CY": true, "CZ": true,
+	"DE": true, "DJ": true, "DK": true, "DM": true, "DO": true, "DZ": true,
+	"EC": true, "EE": true, "EG": true, "EH": true, "ER": true, "ES": true, "ET": true,
+	"FI": true, "FJ": true, "FK": true, "FM": true, "FO": true, "FR": true,
+	"GA": true, "GB": true, "GD": true, "GE": true, "GF": true, "GG": true, "GH": true, "GI": true, "GL": true, "GM": true, "GN": true, "GP": true, "GQ": true, "GR": true, "GS": true, "GT": true, "GU": true, "GW": true, "GY": true,
+	"HK": true, "HM": true, "HN": true, "HR": true, "HT": true, "HU": true,
+	"ID": true, "IE": true, "IL": true, "IM": true, "IN": true, "IO": true, "IQ": true, "IR": true, "IS": true, "IT": true,
+	"JE": true, "JM": true, "JO": true, "JP": true,
+	"KE": true, "KG": true, "KH": true, "KI": true, "KM": true, "KN": true, "KP": true, "KR": true, "KW": true, "KY": true, "KZ": true,
+	"LA": true, "LB": true, "LC": true, "LI": true, "LK": true, "LR": true, "LS": true, "LT": true, "LU": true, "LV": true, "LY": true,
+	"MA": true, "MC": true, "MD": true, "ME": true, "MF": true, "MG": true, "MH": true, "MK": true, "ML": true, "MM": true, "MN": true, "MO": true, "MP": true, "MQ": true, "MR": true, "MS": true, "MT": true, "MU": true, "MV": true, "MW": true, "MX": true, "MY": true, "MZ": true,
+	"NA": true, "NC": true, "NE": true, "NF": true, "NG": true, "NI": true, "NL": true, "NO": true, "NP": true, "NR": true, "NU": true, "NZ": true,
+	"OM": true,
+	"PA": true, "PE": true, "PF": true, "PG": true, "PH": true, "PK": true, "PL": true, "PM": true, "PN": true, "PR": true, "PS": true, "PT": true, "PW": true, "PY": true,
+	"QA": true,
+	"RE": true, "RO": true, "RS": true, "RU": true, "RW": true,
+	"SA": true, "SB": true, "SC": true, "SD": true, "SE": true, "SG": true, "SH": true, "SI": true, "SJ": true, "SK": true, "SL": true, "SM": true, "SN": true, "SO": true, "SR": true, "SS": true, "ST": true, "SV": true, "SX": true, "SY": true, "SZ": true,
+	"TC": true, "TD": true, "TF": true, "TG": true, "TH": true, "TJ": true, "TK": true, "TL": true, "TM": true, "TN": true, "TO": true, "TR": true, "TT": true, "TV": true, "TW": true, "TZ": true,
+	"UA": true, "UG": true, "UM": true, "US": true, "UY": true, "UZ": true,
+	"VA": true, "VC": true, "VE": true, "VG": true, "VI": true, "VN": true, "VU": true,
+	"WF": true, "WS": true,
+	"YE": true, "YT": true,
+	"ZA": true, "ZM": true, "ZW": true,
+}
+
+// countryAliases are non-ISO spellings leads arrive with
+var countryAliases = map[string]string{
+	"USA": "US", "U.S.": "US", "U.S.A.": "US", "UNITED STATES": "US",
+	"UK": "GB", "U.K.": "GB", "UNITED KINGDOM": "GB", "GBR": "GB",
+	"CAN": "CA", "MEX": "MX", "AUS": "AU", "DEU": "DE", "FRA": "FR", "IND": "IN",
+}
+
+// regions are the named geo regions a campaign can target
+var regions = map[string][]string{
+	"north_america": {"US", "CA", "MX"},
+	"latam": {"MX", "GT", "BZ", "SV", "HN", "NI", "CR", "PA", "CU", "DO", "PR", "JM", "HT",
+		"CO", "VE", "EC", "PE", "BO", "BR", "PY", "UY", "AR", "CL"},
+	"europe": {"GB", "IE", "FR", "DE", "NL", "BE", "LU", "CH", "AT", "IT", "ES", "PT", "DK",
+		"SE", "NO", "FI", "IS", "PL", "CZ", "SK", "HU", "RO", "BG", "GR", "HR", "SI", "EE",
+		"LV", "LT", "MT", "CY", "RS", "UA"},
+	"middle_east": {"AE", "SA", "QA", "KW", "BH", "OM", "IL", "JO", "LB", "TR", "EG"},
+	"africa":      {"ZA", "NG", "KE", "GH", "EG", "MA", "TN", "ET", "TZ", "UG", "RW", "CI", "SN"},
+	"apac": {"AU", "NZ", "JP", "KR", "CN", "HK", "TW", "SG", "MY", "TH", "VN", "PH", "ID",
+		"IN", "PK", "BD", "LK"},
+	"anz":  {"AU", "NZ"},
+	"dach": {"DE", "AT", "CH"},
+}
+
+func init() {
+	regions["emea"] = append(append(append([]string(nil), regions["europe"]...), regions["middle_east"]...), regions["africa"]...)
+}
+
+// usStates maps US state and territory names to their postal abbreviations
+var usStates = map[string]string{
+	"alabama": "AL", "alaska": "AK", "arizona": "AZ", "arkansas": "AR", "california": "CA",
+	"colorado": "CO", "connecticut": "CT", "delaware": "DE", "district of columbia": "DC",
+	"florida": "FL", "georgia": "GA", "hawaii": "HI", "idaho": "ID", "illinois": "IL",
+	"indiana": "IN", "iowa": "IA", "kansas": "KS", "kentucky": "KY", "louisiana": "LA",
+	"maine": "ME", "maryland": "MD", "massachusetts": "MA", "michigan": "MI", "minnesota": "MN",
+	"mississippi": "MS", "missouri": "MO", "montana": "MT", "nebraska": "NE", "nevada": "NV",
+	"new hampshire": "NH", "new jersey": "NJ", "new mexico": "NM", "new york": "NY",
+	"north carolina": "NC", "north dakota": "ND", "ohio": "OH", "oklahoma": "OK", "oregon": "OR",
+	"pennsylvania": "PA", "rhode island": "RI", "south carolina": "SC", "south dakota": "SD",
+	"tennessee": "TN", "texas": "TX", "utah": "UT", "vermont": "VT", "virginia": "VA",
+	"washington": "WA", "west virginia": "WV", "wisconsin": "WI", "wyoming": "WY",
+	"puerto rico": "PR", "guam": "GU", "us virgin islands": "VI", "american samoa": "AS",
+	"northern mariana islands": "MP",
+}
+
+// usStateCodes is the set of postal abbreviations in usStates
+var usStateCodes = make(map[string]bool, len(usStates))
+
+func init() {
+	for _, code := range usStates {
+		usStateCodes[code] = true
+	}
+}
+
+// IsCountry reports whether code is an ISO 3166-1 alpha-2 code (uppercase)
+func IsCountry(code string) bool {
+	return countries[code]
+}
+
+// NormalizeCountry uppercases a country code and maps common aliases ("USA", "UK")
+// to their ISO code. Unknown values are returned uppercased.
+func NormalizeCountry(s string) string {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), " "))
+	if code, ok := countryAliases[s]; ok {
+		return code
+	}
+	return s
+}
+
+// Region returns the country codes of a named region ("north_america", "emea", ...).
+// Names compare case-insensitively with spaces or dashes for underscores.
+func Region(name string) ([]string, bool) {
+	codes, ok := regions[strings.Join(words(name), "_")]
+	return codes, ok
+}
+
+// USStateCode returns the postal abbreviation for a US state or territory given by
+// name or abbreviation
+func USStateCode(s string) (string, bool) {
+	key := strings.Join(words(s), " ")
+	if code, ok := usStates[key]; ok {
+		return code, true
+	}
+	if code := strings.ToUpper(key); usStateCodes[code] {
+		return code, true
+	}
+	return "", false
+}
+
+// NormalizeState returns the postal abbreviation of a US state, or the lowercased
+// value for anything else
+func NormalizeState(s string) string {
+	if code, ok := USStateCode(s); ok {
+		return code
+	}
+	return strings.Join(words(s), " ")
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
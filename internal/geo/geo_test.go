@@ -0,0 +1,123 @@
+package geo
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeCountry(t *testing.T) {
+	tests := map[string]string{
+		"us":              "US",
+		" Gb ":            "GB",
+		"USA":             "US",
+		"u.s.a.":          "US",
+		"united   states": "US",
+		"UK":              "GB",
+		"United Kingdom":  "GB",
+		"deu":             "DE",
+		"narnia":          "NARNIA",
+		"":                "",
+	}
+	for in, want := range tests {
+		if got := NormalizeCountry(in); got != want {
+			t.Errorf("NormalizeCountry(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestIsCountry(t *testing.T) {
+	for _, code := range []string{"US", "GB", "DE", "ZW", "AX"} {
+		if !IsCountry(code) {
+			t.Errorf("IsCountry(%q) = false", code)
+		}
+	}
+	// Codes must be normalized first
+	for _, code := range []string{"us", "UK", "USA", "XX", ""} {
+		if IsCountry(code) {
+			t.Errorf("IsCountry(%q) = true", code)
+		}
+	}
+}
+
+func TestRegion(t *testing.T) {
+	tests := []struct {
+		name     string
+		includes []string
+		excludes []string
+	}{
+		{name: "north_america", includes: []string{"US", "CA", "MX"}, excludes: []string{"BR"}},
+		{name: "North America", includes: []string{"US"}},
+		{name: "north-america", includes: []string{"US"}},
+		{name: "DACH", includes: []string{"DE", "AT", "CH"}, excludes: []string{"FR"}},
+		{name: "emea", includes: []string{"GB", "AE", "ZA", "EG"}, excludes: []string{"US", "JP"}},
+	}
+	for _, tt := range tests {
+		codes, ok := Region(tt.name)
+		if !ok {
+			t.Errorf("Region(%q) not found", tt.name)
+			continue
+		}
+		for _, code := range tt.includes {
+			if !slices.Contains(codes, code) {
+				t.Errorf("Region(%q) is missing %s", tt.name, code)
+			}
+		}
+		for _, code := range tt.excludes {
+			if slices.Contains(codes, code) {
+				t.Errorf("Region(%q) includes %s", tt.name, code)
+			}
+		}
+	}
+	if _, ok := Region("atlantis"); ok {
+		t.Error("Region(atlantis) found")
+	}
+
+	// Every region's codes are countries
+	for name, codes := range regions {
+		for _, code := range codes {
+			if !IsCountry(code) {
+				t.Errorf("region %s has %q, not a country", name, code)
+			}
+		}
+	}
+}
+
+func TestUSStateCode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"CA", "CA", true},
+		{"ca", "CA", true},
+		{"California", "CA", true},
+		{"  new   york ", "NY", true},
+		{"New-Hampshire", "NH", true},
+		{"district of columbia", "DC", true},
+		{"Puerto Rico", "PR", true},
+		{"US Virgin Islands", "VI", true},
+		{"Ontario", "", false},
+		{"ZZ", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := USStateCode(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("USStateCode(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNormalizeState(t *testing.T) {
+	tests := map[string]string{
+		"texas":            "TX",
+		"TX":               "TX",
+		"British Columbia": "british columbia",
+		" Île-de-France ":  "île de france",
+	}
+	for in, want := range tests {
+		if got := NormalizeState(in); got != want {
+			t.Errorf("NormalizeState(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package leadfile
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// CSV encodings a dialect can ask for
+const (
+	csvEncodingUTF8        = "utf-8" // Default
+	csvEncodingUTF8BOM     = "utf-8-bom"
+	csvEncodingWindows1252 = "windows-1252"
+)
+
+// utf8BOM lets Excel recognize a UTF-8 file
+const utf8BOM = "\ufeff"
+
+// Dialect is the "csv" section of a delivery method's config, for buyers whose
+// import tools can't read plain RFC 4180 files:
+//
+//	{"csv": {"delimiter": "tab", "line_ending": "crlf", "encoding": "windows-1252",
+//	         "quote_all": true, "date_format": "MM/DD/YYYY hh:mm A", "time_zone": "America/New_York"}}
+//
+// The zero value writes comma-separated UTF-8 with \n line endings, quoting only
+// where needed.
+type Dialect struct {
+	Delimiter  string `json:"delimiter,omitempty"`   // ",", ";", "|", "tab" (or "\t"), "pipe", "semicolon"
+	LineEnding string `json:"line_ending,omitempty"` // "lf" (default) or "crlf"
+	Encoding   string `json:"encoding,omitempty"`    // "utf-8" (default), "utf-8-bom" or "windows-1252"
+	QuoteAll   bool   `json:"quote_all,omitempty"`   // Quote every field, not only those that need it
+	DateFormat string `json:"date_format,omitempty"` // captured_at format: "rfc3339", "unix", or tokens like "YYYY-MM-DD HH:mm:ss"
+	TimeZone   string `json:"time_zone,omitempty"`   // IANA zone captured_at is shown in; defaults to the stored offset
+
+	comma      rune
+	dateLayout string
+	location   *time.Location
+}
+
+// compile checks the dialect and resolves its settings
+func (d *Dialect) compile() error {
+	switch strings.ToLower(d.Delimiter) {
+	case "", ",", "comma":
+		d.comma = ','
+	case "\t", "tab":
+		d.comma = '\t'
+	case "|", "pipe":
+		d.comma = '|'
+	case ";", "semicolon":
+		d.comma = ';'
+	default:
+		return fmt.Errorf("unsupported csv delimiter %q", d.Delimiter)
+	}
+
+	switch strings.ToLower(d.LineEnding) {
+	case "", "lf", "crlf":
+	default:
+		return fmt.Errorf("unsupported csv line_ending %q", d.LineEnding)
+	}
+
+	switch strings.ToLower(d.Encoding) {
+	case "", "utf8", csvEncodingUTF8, csvEncodingUTF8BOM, csvEncodingWindows1252, "cp1252":
+	default:
+		return fmt.Errorf("unsupported csv encoding %q", d.Encoding)
+	}
+
+	if d.DateFormat != "" {
+		d.dateLayout = DateLayout(d.DateFormat)
+	}
+	if d.TimeZone != "" {
+		loc, err := time.LoadLocation(d.TimeZone)
+		if err != nil {
+			return fmt.Errorf("unsupported csv time_zone %q", d.TimeZone)
+		}
+		d.location = loc
+	}
+	return nil
+}
+
+func (d *Dialect) crlf() bool { return strings.EqualFold(d.LineEnding, "crlf") }
+
+func (d *Dialect) windows1252() bool {
+	return strings.EqualFold(d.Encoding, csvEncodingWindows1252) || strings.EqualFold(d.Encoding, "cp1252")
+}
+
+// dateTokens translate date_format tokens to Go layout elements, longest first
+var dateTokens = strings.NewReplacer(
+	"YYYY", "2006",
+	"YY", "06",
+	"MMMM", "January",
+	"MMM", "Jan",
+	"MM", "01",
+	"DD", "02",
+	"HH", "15",
+	"hh", "03",
+	"mm", "04",
+	"ss", "05",
+	"A", "PM",
+	"Z", "Z07:00",
+)
+
+// DateLayout turns a date_format into a Go time layout
+func DateLayout(format string) string {
+	switch strings.ToLower(format) {
+	case "rfc3339":
+		return time.RFC3339
+	case "unix":
+		return "unix"
+	}
+	return dateTokens.Replace(format)
+}
+
+// formatDate reformats a stored RFC 3339 timestamp; values that don't parse are kept
+func (d *Dialect) formatDate(value string) string {
+	if d.dateLayout == "" || value == "" {
+		return value
+	}
+	if formatted, ok := FormatTimestamp(value, d.dateLayout, d.location); ok {
+		return formatted
+	}
+	return value
+}
+
+// FormatTimestamp formats an RFC 3339 timestamp with a layout from DateLayout, in loc
+// when it is set
+func FormatTimestamp(value, layout string, loc *time.Location) (string, bool) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return "", false
+	}
+	if loc != nil {
+		t = t.In(loc)
+	}
+	if layout == "unix" {
+		return fmt.Sprint(t.Unix()), true
+	}
+	return t.Format(layout), true
+}
+
+// writeCSV writes the header and every row in the dialect
+func (t *Table) writeCSV(w io.Writer, d Dialect) error {
+	if err := d.compile(); err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	var enc io.Writer = out
+	switch {
+	case d.windows1252():
+		enc = &windows1252Writer{w: out}
+	case strings.EqualFold(d.Encoding, csvEncodingUTF8BOM):
+		if _, err := out.WriteString(utf8BOM); err != nil {
+			return err
+		}
+	}
+
+	dateColumn := -1
+	if d.dateLayout != "" {
+		for i, col := range t.Columns {
+			if col.Key == "captured_at" && !col.Question {
+				dateColumn = i
+			}
+		}
+	}
+
+	var write func(record []string) error
+	var writer *csv.Writer
+	if d.QuoteAll {
+		write = func(record []string) error { return writeQuotedRecord(enc, record, d.comma, d.crlf()) }
+	} else {
+		writer = csv.NewWriter(enc)
+		writer.Comma = d.comma
+		writer.UseCRLF = d.crlf()
+		write = writer.Write
+	}
+
+	if err := write(t.Header()); err != nil {
+		return err
+	}
+	row := make([]string, 0, len(t.Columns))
+	for r := 0; r < t.Rows; r++ {
+		var err error
+		if row, err = t.Row(r, row); err != nil {
+			return err
+		}
+		if dateColumn >= 0 {
+			row[dateColumn] = d.formatDate(row[dateColumn])
+		}
+		err = write(row)
+		t.RowDone(r)
+		if err != nil {
+			return err
+		}
+	}
+	if writer != nil {
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	}
+	if e, ok := enc.(*windows1252Writer); ok {
+		if err := e.Close(); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// writeQuotedRecord writes record with every field quoted, doubling embedded quotes.
+// Like csv.Writer with UseCRLF, newlines inside fields become \r\n too.
+func writeQuotedRecord(w io.Writer, record []string, comma rune, crlf bool) error {
+	var b strings.Builder
+	for i, field := range record {
+		if i > 0 {
+			b.WriteRune(comma)
+		}
+		field = strings.ReplaceAll(field, `"`, `""`)
+		if crlf {
+			field = strings.ReplaceAll(strings.ReplaceAll(field, "\r\n", "\n"), "\n", "\r\n")
+		}
+		b.WriteByte('"')
+		b.WriteString(field)
+		b.WriteByte('"')
+	}
+	if crlf {
+		b.WriteString("\r\n")
+	} else {
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// windows1252Writer transcodes UTF-8 to Windows-1252, writing "?" for characters the
+// code page lacks. A character split across writes is held until the next one; Close
+// writes any incomplete character left over.
+type windows1252Writer struct {
+	w       io.Writer
+	pending []byte
+}
+
+func (e *windows1252Writer) Write(p []byte) (int, error) {
+	s := p
+	if len(e.pending) > 0 {
+		s = append(e.pending, p...)
+		e.pending = nil
+	}
+	out := make([]byte, 0, len(s))
+	for len(s) > 0 {
+		if !utf8.FullRune(s) {
+			e.pending = append([]byte(nil), s...)
+			break
+		}
+		r, size := utf8.DecodeRune(s)
+		s = s[size:]
+		if b, ok := charmap.Windows1252.EncodeRune(r); ok && r != utf8.RuneError {
+			out = append(out, b)
+		} else {
+			out = append(out, '?')
+		}
+	}
+	if _, err := e.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close flushes an incomplete trailing character as "?"
+func (e *windows1252Writer) Close() error {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	e.pending = nil
+	_, err := e.w.Write([]byte{'?'})
+	return err
+}
@@ -0,0 +1,124 @@
+package leadfile
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	_ "time/tzdata" // time_zone cases shouldn't depend on the host's zoneinfo
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenTable covers what the dialects treat differently: delimiters and quotes inside
+// values, an embedded newline, accented and euro characters Windows-1252 has, CJK it
+// lacks, a timestamp and a value that isn't one
+func goldenTable() *Table {
+	rows := [][]string{
+		{"José", "Müller, Kraus & Co", "2024-03-10T06:30:00Z", "Price in €"},
+		{"Zoë", `The "Best" Company`, "2024-07-04T23:15:00-05:00", "Line one\nLine two"},
+		{"Tomás", "Tab\tand|pipe;semi", "not a date", "日本"},
+	}
+	return &Table{
+		Columns: []Column{
+			{Key: "first_name", Header: "First Name", Value: func(r int) string { return rows[r][0] }},
+			{Key: "company_name", Header: "Company Name", Value: func(r int) string { return rows[r][1] }},
+			{Key: "captured_at", Header: "Date/Time Stamp", Value: func(r int) string { return rows[r][2] }},
+			{Key: "q1", Header: "Notes", Question: true, Value: func(r int) string { return rows[r][3] }},
+		},
+		Rows:   len(rows),
+		LeadID: func(r int) string { return rows[r][0] },
+	}
+}
+
+func TestCSVDialectsMatchGoldenFiles(t *testing.T) {
+	tests := []struct {
+		golden string
+		config string
+	}{
+		{"default.csv", `{}`},
+		{"tab.csv", `{"csv": {"delimiter": "tab"}}`},
+		{"semicolon.csv", `{"csv": {"delimiter": ";"}}`},
+		{"crlf.csv", `{"csv": {"line_ending": "crlf"}}`},
+		{"quote_all.csv", `{"csv": {"quote_all": true}}`},
+		{"quote_all_crlf.csv", `{"csv": {"quote_all": true, "line_ending": "crlf", "delimiter": "pipe"}}`},
+		{"utf-8-bom.csv", `{"csv": {"encoding": "utf-8-bom"}}`},
+		{"windows-1252.csv", `{"csv": {"encoding": "windows-1252"}}`},
+		{"date_format.csv", `{"csv": {"date_format": "MM/DD/YYYY hh:mm A"}}`},
+		{"date_format_time_zone.csv", `{"csv": {"date_format": "YYYY-MM-DD HH:mm Z", "time_zone": "America/New_York"}}`},
+		{"date_unix.csv", `{"csv": {"date_format": "unix"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			cfg, err := ParseConfig([]byte(tt.config))
+			if err != nil {
+				t.Fatalf("ParseConfig(%s): %v", tt.config, err)
+			}
+			var buf bytes.Buffer
+			if _, err := New(goldenTable(), cfg, Metadata{}).WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo: %v", err)
+			}
+			checkGolden(t, tt.golden, buf.Bytes())
+		})
+	}
+}
+
+// The CSV writer hands the encoder whatever it buffered, so a character can arrive in
+// pieces; it must come out the same as when written whole
+func TestWindows1252WriterSplitsCharactersAcrossWrites(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var utf8CSV bytes.Buffer
+	if _, err := New(goldenTable(), cfg, Metadata{}).WriteTo(&utf8CSV); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunk := range []int{1, 2, 3, 5} {
+		var out bytes.Buffer
+		enc := &windows1252Writer{w: &out}
+		for data := utf8CSV.Bytes(); len(data) > 0; {
+			n := min(chunk, len(data))
+			if written, err := enc.Write(data[:n]); err != nil || written != n {
+				t.Fatalf("chunk %d: Write = %d, %v", chunk, written, err)
+			}
+			data = data[n:]
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "windows-1252.csv", out.Bytes())
+	}
+}
+
+func TestWindows1252WriterMarksTruncatedCharacter(t *testing.T) {
+	var out bytes.Buffer
+	enc := &windows1252Writer{w: &out}
+	enc.Write([]byte("ab\xe2\x82")) // "€" missing its last byte
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "ab?" {
+		t.Errorf("got %q, want %q", got, "ab?")
+	}
+}
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s\n got: %q\nwant: %q", path, got, want)
+	}
+}
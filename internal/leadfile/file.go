@@ -0,0 +1,154 @@
+package leadfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// File formats a delivery method can send, set by "file_format" in its config
+const (
+	FormatCSV  = "csv" // Default
+	FormatXLSX = "xlsx"
+)
+
+const (
+	ContentTypeCSV  = "text/csv"
+	ContentTypeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// Config is the part of every method config that shapes the lead file
+type Config struct {
+	FileFormat   string  `json:"file_format,omitempty"`   // "csv" (default) or "xlsx"
+	CSV          Dialect `json:"csv,omitempty"`           // Dialect of CSV files
+	StableSchema bool    `json:"stable_schema,omitempty"` // Deliver every contracted column, even when no lead has a value
+}
+
+// ParseConfig reads a method's file settings, defaulting to plain CSV
+func ParseConfig(config json.RawMessage) (Config, error) {
+	var cfg Config
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid method config json: %w", err)
+		}
+	}
+	switch format := strings.ToLower(strings.TrimSpace(cfg.FileFormat)); format {
+	case "":
+		cfg.FileFormat = FormatCSV
+	case FormatCSV, FormatXLSX:
+		cfg.FileFormat = format
+	default:
+		return cfg, fmt.Errorf("unknown file_format: %s", cfg.FileFormat)
+	}
+	if err := cfg.CSV.compile(); err != nil {
+		return cfg, fmt.Errorf("invalid csv config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Metadata describes a delivery on the XLSX metadata sheet
+type Metadata struct {
+	JobID         string
+	BuyerID       string
+	LeadBatchID   string
+	SnapshotHash  string
+	SchemaVersion string
+	GeneratedAt   time.Time
+}
+
+// File is the lead file a job sends, in the method's format. It is rendered from the
+// table each time it is written, never held whole in memory.
+type File struct {
+	Format        string
+	ContentType   string
+	SchemaVersion string // Fingerprint of the file's columns, see Table.SchemaVersion
+
+	table  *Table
+	config Config
+	meta   Metadata
+}
+
+// New prepares the table's file in the configured format
+func New(table *Table, cfg Config, meta Metadata) *File {
+	f := &File{Format: cfg.FileFormat, SchemaVersion: meta.SchemaVersion, table: table, config: cfg, meta: meta}
+	switch cfg.FileFormat {
+	case FormatXLSX:
+		f.ContentType = ContentTypeXLSX
+	default:
+		f.Format = FormatCSV
+		f.ContentType = ContentTypeCSV
+		if cfg.CSV.windows1252() {
+			f.ContentType += "; charset=windows-1252"
+		}
+	}
+	return f
+}
+
+// WriteTo renders the file to w and returns the bytes written
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	switch f.Format {
+	case FormatXLSX:
+		if err := f.table.writeXLSX(cw, f.meta); err != nil {
+			return cw.n, fmt.Errorf("failed to generate xlsx: %w", err)
+		}
+	default:
+		if err := f.table.writeCSV(cw, f.config.CSV); err != nil {
+			return cw.n, fmt.Errorf("failed to generate csv: %w", err)
+		}
+	}
+	return cw.n, nil
+}
+
+// Open streams a fresh rendering of the file. The reader returns the rendering's
+// error, if any, in place of EOF; closing it early stops the rendering.
+func (f *File) Open() io.ReadCloser {
+	return Stream(func(w io.Writer) error {
+		_, err := f.WriteTo(w)
+		return err
+	})
+}
+
+// streamBufferSize batches small writes so each read of a stream moves a useful chunk
+const streamBufferSize = 64 << 10
+
+// Stream runs write in a goroutine, piping what it writes to the returned reader
+func Stream(write func(w io.Writer) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		buf := bufio.NewWriterSize(pw, streamBufferSize)
+		err := write(buf)
+		if err == nil {
+			err = buf.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// Filename fills in name's {schema_version} and gives it the file's extension,
+// replacing a .csv or .xlsx one
+func (f *File) Filename(name string) string {
+	name = strings.ReplaceAll(name, "{schema_version}", f.SchemaVersion)
+	switch strings.ToLower(path.Ext(name)) {
+	case "." + FormatCSV, "." + FormatXLSX:
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+	return name + "." + f.Format
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,96 @@
+// Package leadfile renders a job's delivered leads as a CSV or XLSX file.
+//
+// A Table doesn't hold its values: each Column reads them from the job's leads as
+// rows are written, so a file is produced one row at a time. A Load hook lets the
+// caller fetch the leads a page at a time as the rows reach them, and a Done hook
+// lets it wipe each row once it is written. File.Open streams
+// the rendering through an io.Pipe straight into an upload, so writing the file adds
+// only a row plus the encoders' buffers to memory, whatever the batch size.
+package leadfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Column is one delivered column of a lead table
+type Column struct {
+	Key      string // Field key from csv_field_config (e.g. "email"), or the question ID
+	Header   string // Column header shown to the buyer
+	Question bool   // Custom question answer rather than a base lead field
+
+	Value func(row int) string // The column's value for a row
+}
+
+// Table is the delivered view of a job's leads: the columns that survived filtering,
+// in order, over Rows leads
+type Table struct {
+	Columns []Column
+	Rows    int
+	LeadID  func(row int) string // ID of the lead in a row
+
+	// Load, when set, readies a row before its values or ID are read. Every pass
+	// reads the rows in order.
+	Load func(row int) error
+	// Done, when set, is called once a row's values have been written out. They must
+	// not be used after that, which lets the caller wipe values like decrypted contacts
+	// as soon as each row is in the file.
+	Done func(row int)
+}
+
+// Header returns the column headers in order
+func (t *Table) Header() []string {
+	header := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		header[i] = col.Header
+	}
+	return header
+}
+
+// Row fills dst with a row's values in column order, reusing its storage
+func (t *Table) Row(row int, dst []string) ([]string, error) {
+	dst = dst[:0]
+	if t.Load != nil {
+		if err := t.Load(row); err != nil {
+			return dst, err
+		}
+	}
+	for _, col := range t.Columns {
+		value := ""
+		if col.Value != nil {
+			value = col.Value(row)
+		}
+		dst = append(dst, value)
+	}
+	return dst, nil
+}
+
+// Lead returns the ID of the lead in a row
+func (t *Table) Lead(row int) (string, error) {
+	if t.Load != nil {
+		if err := t.Load(row); err != nil {
+			return "", err
+		}
+	}
+	return t.LeadID(row), nil
+}
+
+// RowDone tells the table the values Row returned for a row have been written
+func (t *Table) RowDone(row int) {
+	if t.Done != nil {
+		t.Done(row)
+	}
+}
+
+// SchemaVersion fingerprints the delivered columns, their keys and headers in order,
+// so buyer integrations can tell a deliberate layout change from a new batch
+func (t *Table) SchemaVersion() string {
+	h := sha256.New()
+	for _, col := range t.Columns {
+		h.Write([]byte(col.Key))
+		h.Write([]byte{0})
+		h.Write([]byte(col.Header))
+		h.Write([]byte{0})
+	}
+	return "s1-" + hex.EncodeToString(h.Sum(nil))[:12]
+}
@@ -0,0 +1,299 @@
+package leadfile
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Sheets of an XLSX delivery
+const (
+	xlsxLeadSheet     = "Leads"
+	xlsxMetadataSheet = "Delivery"
+)
+
+// xlsxMaxColumnWidth caps auto-sized columns, in characters
+const xlsxMaxColumnWidth = 60
+
+// xlsxWidthSample is how many rows auto-sizing measures. The workbook is written in
+// one pass, so widths are settled before the rows stream out.
+const xlsxWidthSample = 1000
+
+// Cell styles, indexes into the cellXfs of xlsxStyles
+const (
+	xlsxStyleText   = 1 // "@" keeps cells text when the buyer edits them, too
+	xlsxStyleHeader = 2
+)
+
+const xlsxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+const xlsxContentTypes = xlsxHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet2.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+	`</Types>`
+
+const xlsxPackageRels = xlsxHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+const xlsxWorkbook = xlsxHeader + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+	`<sheets><sheet name="` + xlsxLeadSheet + `" sheetId="1" r:id="rId1"/><sheet name="` + xlsxMetadataSheet + `" sheetId="2" r:id="rId2"/></sheets>` +
+	`</workbook>`
+
+const xlsxWorkbookRels = xlsxHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>` +
+	`<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+	`</Relationships>`
+
+// xlsxStyles holds the text style and the bold white-on-blue header style
+const xlsxStyles = xlsxHeader + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><color rgb="FFFFFFFF"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="3"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill>` +
+	`<fill><patternFill patternType="solid"><fgColor rgb="FF305496"/></patternFill></fill></fills>` +
+	`<borders count="2"><border><left/><right/><top/><bottom/><diagonal/></border>` +
+	`<border><left/><right/><top/><bottom style="thin"><color rgb="FF1F3864"/></bottom><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="49" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="2" borderId="1" xfId="0" applyFont="1" applyFill="1" applyBorder="1" applyAlignment="1"><alignment vertical="center"/></xf></cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
+
+const xlsxWorksheetStart = xlsxHeader + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`
+
+// writeXLSX writes the table as a workbook: every value as a text cell, so ZIP codes
+// and phone numbers keep their digits, under a styled, frozen header row. A second
+// sheet describes the delivery. Rows go straight into the zip stream as inline
+// strings; nothing but the column widths is gathered up front.
+func (t *Table) writeXLSX(w io.Writer, meta Metadata) error {
+	zw := zip.NewWriter(w)
+	for _, part := range []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxPackageRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	} {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(pw, part.body); err != nil {
+			return err
+		}
+	}
+
+	pw, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if err := t.writeLeadSheet(pw); err != nil {
+		return err
+	}
+	pw, err = zw.Create("xl/worksheets/sheet2.xml")
+	if err != nil {
+		return err
+	}
+	if err := t.writeMetadataSheet(pw, meta); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeLeadSheet writes the lead rows under a frozen header
+func (t *Table) writeLeadSheet(w io.Writer) error {
+	sw := &sheetWriter{w: bufio.NewWriter(w)}
+	sw.WriteString(xlsxWorksheetStart)
+	sw.WriteString(`<sheetViews><sheetView tabSelected="1" workbookViewId="0">` +
+		`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>` +
+		`<selection pane="bottomLeft" activeCell="A2" sqref="A2"/></sheetView></sheetViews>`)
+	if len(t.Columns) > 0 {
+		widths, err := t.columnWidths()
+		if err != nil {
+			return err
+		}
+		sw.WriteString("<cols>")
+		for i, width := range widths {
+			fmt.Fprintf(sw, `<col min="%d" max="%d" width="%g" customWidth="1" style="%d"/>`, i+1, i+1, width, xlsxStyleText)
+		}
+		sw.WriteString("</cols>")
+	}
+
+	refs := make([]string, len(t.Columns))
+	for i := range refs {
+		refs[i] = columnName(i + 1)
+	}
+	sw.WriteString("<sheetData>")
+	sw.row(1, refs, t.Header(), xlsxStyleHeader, `ht="20" customHeight="1"`)
+	row := make([]string, 0, len(t.Columns))
+	for r := 0; r < t.Rows && sw.err == nil; r++ {
+		var err error
+		if row, err = t.Row(r, row); err != nil {
+			return err
+		}
+		sw.row(r+2, refs, row, xlsxStyleText, "")
+		t.RowDone(r)
+	}
+	sw.WriteString("</sheetData></worksheet>")
+	return sw.flush()
+}
+
+// writeMetadataSheet writes the delivery's details as field/value rows
+func (t *Table) writeMetadataSheet(w io.Writer, meta Metadata) error {
+	rows := [][]string{
+		{"Field", "Value"},
+		{"Job ID", meta.JobID},
+		{"Buyer ID", meta.BuyerID},
+		{"Lead Batch ID", meta.LeadBatchID},
+		{"Lead Count", fmt.Sprint(t.Rows)},
+		{"Columns", strings.Join(t.Header(), ", ")},
+		{"Schema Version", meta.SchemaVersion},
+		{"Snapshot Hash", meta.SnapshotHash},
+		{"Generated At", meta.GeneratedAt.UTC().Format(time.RFC3339)},
+	}
+	sw := &sheetWriter{w: bufio.NewWriter(w)}
+	sw.WriteString(xlsxWorksheetStart)
+	fmt.Fprintf(sw, `<cols><col min="1" max="1" width="16" customWidth="1"/><col min="2" max="2" width="%d" customWidth="1"/></cols>`, xlsxMaxColumnWidth)
+	sw.WriteString("<sheetData>")
+	refs := []string{"A", "B"}
+	for r, row := range rows {
+		style := 0
+		if r == 0 {
+			style = xlsxStyleHeader
+		}
+		sw.row(r+1, refs, row, style, "")
+	}
+	sw.WriteString("</sheetData></worksheet>")
+	return sw.flush()
+}
+
+// columnWidths sizes each column to its longest value in the first xlsxWidthSample
+// rows, within xlsxMaxColumnWidth
+func (t *Table) columnWidths() ([]float64, error) {
+	widths := make([]float64, len(t.Columns))
+	for i, col := range t.Columns {
+		widths[i] = float64(utf8.RuneCountInString(col.Header)) + 2
+	}
+	row := make([]string, 0, len(t.Columns))
+	for r := 0; r < min(t.Rows, xlsxWidthSample); r++ {
+		var err error
+		if row, err = t.Row(r, row); err != nil {
+			return nil, err
+		}
+		for i, value := range row {
+			widths[i] = max(widths[i], float64(utf8.RuneCountInString(value))+2)
+		}
+		t.RowDone(r)
+	}
+	for i := range widths {
+		widths[i] = min(widths[i], xlsxMaxColumnWidth)
+	}
+	return widths, nil
+}
+
+// sheetWriter writes worksheet XML, keeping the first error
+type sheetWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (s *sheetWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.w.Write(p)
+	s.err = err
+	return n, err
+}
+
+func (s *sheetWriter) WriteString(str string) {
+	if s.err == nil {
+		_, s.err = s.w.WriteString(str)
+	}
+}
+
+// row writes one row of inline string cells
+func (s *sheetWriter) row(r int, refs, values []string, style int, attrs string) {
+	num := strconv.Itoa(r)
+	s.WriteString(`<row r="`)
+	s.WriteString(num)
+	s.WriteString(`"`)
+	if attrs != "" {
+		s.WriteString(" ")
+		s.WriteString(attrs)
+	}
+	s.WriteString(">")
+	for i, value := range values {
+		s.WriteString(`<c r="`)
+		s.WriteString(refs[i])
+		s.WriteString(num)
+		s.WriteString(`"`)
+		if style != 0 {
+			s.WriteString(` s="`)
+			s.WriteString(strconv.Itoa(style))
+			s.WriteString(`"`)
+		}
+		s.WriteString(` t="inlineStr"><is><t xml:space="preserve">`)
+		s.escape(value)
+		s.WriteString("</t></is></c>")
+	}
+	s.WriteString("</row>")
+}
+
+// escape writes value as XML text. Characters XML can't carry, like control
+// characters and invalid UTF-8, become U+FFFD as with xml.EscapeText.
+func (s *sheetWriter) escape(value string) {
+	last := 0
+	for i, r := range value {
+		var esc string
+		switch {
+		case r == '&':
+			esc = "&amp;"
+		case r == '<':
+			esc = "&lt;"
+		case r == '>':
+			esc = "&gt;"
+		case r == '\r':
+			esc = "&#xD;"
+		case r == '\t' || r == '\n':
+			continue
+		case r < 0x20 || r == 0xFFFE || r == 0xFFFF || (r == utf8.RuneError && !strings.HasPrefix(value[i:], "\uFFFD")):
+			esc = "\uFFFD"
+		default:
+			continue
+		}
+		s.WriteString(value[last:i])
+		s.WriteString(esc)
+		_, size := utf8.DecodeRuneInString(value[i:])
+		last = i + size
+	}
+	s.WriteString(value[last:])
+}
+
+func (s *sheetWriter) flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.w.Flush()
+}
+
+// columnName returns the spreadsheet letters of a 1-based column number
+func columnName(n int) string {
+	var name []byte
+	for n > 0 {
+		n--
+		name = append([]byte{byte('A' + n%26)}, name...)
+		n /= 26
+	}
+	return string(name)
+}
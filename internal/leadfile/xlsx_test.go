@@ -0,0 +1,237 @@
+package leadfile
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// openXLSX writes the table as a workbook and opens it with excelize
+func openXLSX(t *testing.T, table *Table, meta Metadata) *excelize.File {
+	t.Helper()
+	cfg, err := ParseConfig([]byte(`{"file_format": "xlsx"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := New(table, cfg, meta).WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("excelize can't open the workbook: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// xlsxTable holds values a spreadsheet would otherwise turn into numbers, dates or
+// formulas, and characters XML must escape or can't carry
+func xlsxTable() *Table {
+	rows := [][]string{
+		{"02134", "+15550000001", "José & <Co>", "1e5"},
+		{"00501", "0044 20 7946 0958", `"Quoted" 'and' ]]>`, "=SUM(A1:A2)"},
+		{"10001-0001", "", "Line one\nLine two", "bell\x07 and \xff"},
+	}
+	return &Table{
+		Columns: []Column{
+			{Key: "zip", Header: "Zip", Value: func(r int) string { return rows[r][0] }},
+			{Key: "phone", Header: "Phone", Value: func(r int) string { return rows[r][1] }},
+			{Key: "company_name", Header: "Company Name", Value: func(r int) string { return rows[r][2] }},
+			{Key: "q1", Header: "Notes", Question: true, Value: func(r int) string { return rows[r][3] }},
+		},
+		Rows:   len(rows),
+		LeadID: func(r int) string { return fmt.Sprint(r) },
+	}
+}
+
+func TestXLSXCellsAreText(t *testing.T) {
+	f := openXLSX(t, xlsxTable(), Metadata{})
+	if got := f.GetSheetList(); !reflect.DeepEqual(got, []string{xlsxLeadSheet, xlsxMetadataSheet}) {
+		t.Fatalf("sheets %v", got)
+	}
+
+	rows, err := f.GetRows(xlsxLeadSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Zip", "Phone", "Company Name", "Notes"},
+		{"02134", "+15550000001", "José & <Co>", "1e5"},
+		{"00501", "0044 20 7946 0958", `"Quoted" 'and' ]]>`, "=SUM(A1:A2)"},
+		// Characters XML can't carry become U+FFFD
+		{"10001-0001", "", "Line one\nLine two", "bell� and �"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+
+	for _, cell := range []string{"A2", "A3", "B2", "D2", "D3"} {
+		typ, err := f.GetCellType(xlsxLeadSheet, cell)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != excelize.CellTypeInlineString {
+			t.Errorf("%s has cell type %v, want an inline string", cell, typ)
+		}
+		if formula, _ := f.GetCellFormula(xlsxLeadSheet, cell); formula != "" {
+			t.Errorf("%s holds formula %q", cell, formula)
+		}
+	}
+}
+
+func TestXLSXStylesAndFrozenHeader(t *testing.T) {
+	f := openXLSX(t, xlsxTable(), Metadata{})
+
+	panes, err := f.GetPanes(xlsxLeadSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !panes.Freeze || panes.YSplit != 1 || panes.XSplit != 0 || panes.TopLeftCell != "A2" {
+		t.Errorf("panes = %+v, want the header row frozen", panes)
+	}
+
+	header := cellStyle(t, f, "A1")
+	if header.Font == nil || !header.Font.Bold || len(header.Fill.Color) == 0 {
+		t.Errorf("header style = %+v, want bold on a fill", header)
+	}
+	// Text format ("@") keeps values text when the buyer edits them
+	for _, cell := range []string{"A2", "D4"} {
+		if style := cellStyle(t, f, cell); style.NumFmt != 49 {
+			t.Errorf("%s number format %d, want 49 (text)", cell, style.NumFmt)
+		}
+	}
+}
+
+func cellStyle(t *testing.T, f *excelize.File, cell string) *excelize.Style {
+	t.Helper()
+	idx, err := f.GetCellStyle(xlsxLeadSheet, cell)
+	if err != nil {
+		t.Fatal(err)
+	}
+	style, err := f.GetStyle(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return style
+}
+
+func TestXLSXColumnWidths(t *testing.T) {
+	long := strings.Repeat("x", 200)
+	values := []string{"short", long}
+	table := &Table{
+		Columns: []Column{
+			{Key: "zip", Header: "Zip", Value: func(r int) string { return "02134" }},
+			{Key: "company_name", Header: "Company Name", Value: func(r int) string { return values[r] }},
+			{Key: "title", Header: "Title", Value: func(r int) string { return "CEO" }},
+		},
+		Rows: len(values),
+	}
+	f := openXLSX(t, table, Metadata{})
+
+	// Each column fits its longest value or header, plus padding, within the cap
+	want := map[string]float64{"A": 7, "B": xlsxMaxColumnWidth, "C": 7}
+	for col, width := range want {
+		got, err := f.GetColWidth(xlsxLeadSheet, col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != width {
+			t.Errorf("column %s width %g, want %g", col, got, width)
+		}
+	}
+}
+
+func TestXLSXColumnWidthsSampleRows(t *testing.T) {
+	// Only the first xlsxWidthSample rows size the columns
+	table := &Table{
+		Columns: []Column{{Key: "note", Header: "N", Value: func(r int) string {
+			if r == xlsxWidthSample {
+				return strings.Repeat("x", 40)
+			}
+			return "abc"
+		}}},
+		Rows: xlsxWidthSample + 1,
+	}
+	var done int
+	table.Done = func(int) { done++ }
+	widths, err := table.columnWidths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(widths, []float64{5}) {
+		t.Errorf("widths = %v, want [5]", widths)
+	}
+	if done != xlsxWidthSample {
+		t.Errorf("%d rows done while sizing, want %d", done, xlsxWidthSample)
+	}
+}
+
+func TestXLSXMetadataSheet(t *testing.T) {
+	meta := Metadata{
+		JobID:         "job-1",
+		BuyerID:       "buyer-1",
+		LeadBatchID:   "batch-1",
+		SnapshotHash:  "abc123",
+		SchemaVersion: "v2",
+		GeneratedAt:   time.Date(2026, 3, 1, 9, 30, 0, 0, time.FixedZone("EST", -5*3600)),
+	}
+	f := openXLSX(t, xlsxTable(), meta)
+	rows, err := f.GetRows(xlsxMetadataSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Field", "Value"},
+		{"Job ID", "job-1"},
+		{"Buyer ID", "buyer-1"},
+		{"Lead Batch ID", "batch-1"},
+		{"Lead Count", "3"},
+		{"Columns", "Zip, Phone, Company Name, Notes"},
+		{"Schema Version", "v2"},
+		{"Snapshot Hash", "abc123"},
+		{"Generated At", "2026-03-01T14:30:00Z"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("metadata rows = %q, want %q", rows, want)
+	}
+}
+
+func TestXLSXEmptyTable(t *testing.T) {
+	f := openXLSX(t, &Table{Columns: xlsxTable().Columns}, Metadata{})
+	rows, err := f.GetRows(xlsxLeadSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, [][]string{{"Zip", "Phone", "Company Name", "Notes"}}) {
+		t.Errorf("rows = %q, want only the header", rows)
+	}
+}
+
+func TestXLSXRowsDone(t *testing.T) {
+	table := xlsxTable()
+	var done []int
+	table.Done = func(r int) { done = append(done, r) }
+	openXLSX(t, table, Metadata{})
+	// Once after sizing the columns, once after writing
+	if want := []int{0, 1, 2, 0, 1, 2}; !reflect.DeepEqual(done, want) {
+		t.Errorf("rows done %v, want %v", done, want)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	tests := map[int]string{1: "A", 2: "B", 26: "Z", 27: "AA", 52: "AZ", 53: "BA", 702: "ZZ", 703: "AAA", 16384: "XFD"}
+	for n, want := range tests {
+		if got := columnName(n); got != want {
+			t.Errorf("columnName(%d) = %q, want %q", n, got, want)
+		}
+		if got, err := excelize.ColumnNumberToName(n); err != nil || got != want {
+			t.Errorf("excelize names column %d %q", n, got)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayout matches how encoding/json serializes time.Time, so captured_at reads the
+// same whichever payload version carried it
+const timeLayout = time.RFC3339Nano
+
+// legacyPayload is the unversioned payload shape
+type legacyPayload struct {
+	Version        int              `json:"version"`
+	CampaignID     string           `json:"campaign_id"`
+	LeadBatchID    string           `json:"lead_batch_id"`
+	RecipientEmail string           `json:"recipient_email"`
+	TotalLeads     int              `json:"total_leads"`
+	Leads          []legacyLead     `json:"leads"`
+	Questions      []Question       `json:"questions"`
+	CsvFieldConfig []CsvFieldConfig `json:"csv_field_config"`
+}
+
+// legacyLead is a sqlc Lead row as encoding/json serializes it. Other row columns
+// (TenantID, CreatedAt, ...) are ignored.
+type legacyLead struct {
+	ID                  string        `json:"ID"`
+	FirstName           nullString    `json:"FirstName"`
+	LastName            nullString    `json:"LastName"`
+	EmailHash           nullString    `json:"EmailHash"`
+	PhoneHash           nullString    `json:"PhoneHash"`
+	EmailCipher         []byte        `json:"EmailCipher"`
+	PhoneCipher         []byte        `json:"PhoneCipher"`
+	DekWrapped          []byte        `json:"DekWrapped"`
+	DekKmsKeyID         nullString    `json:"DekKmsKeyID"`
+	IpAddress           nullInet      `json:"IpAddress"`
+	CompanyName         nullString    `json:"CompanyName"`
+	Address             nullString    `json:"Address"`
+	CountryCode         nullString    `json:"CountryCode"`
+	LinkedinContact     nullString    `json:"LinkedinContact"`
+	LinkedinCompany     nullString    `json:"LinkedinCompany"`
+	DownloadedAssetName nullString    `json:"DownloadedAssetName"`
+	PublisherName       nullString    `json:"PublisherName"`
+	Industry            nullString    `json:"Industry"`
+	RevenueSize         nullString    `json:"RevenueSize"`
+	EmployeeSize        nullString    `json:"EmployeeSize"`
+	State               nullString    `json:"State"`
+	Title               nullString    `json:"Title"`
+	NaicsCode           nullString    `json:"NaicsCode"`
+	CapturedAt          nullTime      `json:"CapturedAt"`
+	CustomAnswers       nullRawAnswer `json:"CustomAnswers"`
+}
+
+// decodeLegacy decodes an unversioned payload. Lead fields must have one of the
+// shapes sqlc rows serialize to; anything else is an error naming the lead and field.
+func decodeLegacy(raw []byte) (*Payload, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &top); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	var lp legacyPayload
+	// Decode leads one at a time so the error says which lead is malformed
+	var rawLeads []json.RawMessage
+	if leads, ok := top["leads"]; ok && !bytes.Equal(bytes.TrimSpace(leads), []byte("null")) {
+		if err := json.Unmarshal(leads, &rawLeads); err != nil {
+			return nil, fmt.Errorf("%w: leads: %v", ErrInvalidPayload, err)
+		}
+		lp.Leads = make([]legacyLead, len(rawLeads))
+		for i, rawLead := range rawLeads {
+			if err := json.Unmarshal(rawLead, &lp.Leads[i]); err != nil {
+				return nil, fmt.Errorf("%w: leads[%d]: %v", ErrInvalidPayload, i, err)
+			}
+		}
+		delete(top, "leads")
+	}
+	rest, _ := json.Marshal(top)
+	if err := json.Unmarshal(rest, &lp); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	p := &Payload{
+		Version:        LegacyVersion,
+		CampaignID:     lp.CampaignID,
+		LeadBatchID:    lp.LeadBatchID,
+		RecipientEmail: lp.RecipientEmail,
+		TotalLeads:     lp.TotalLeads,
+		Questions:      lp.Questions,
+		CsvFieldConfig: lp.CsvFieldConfig,
+	}
+	if lp.Leads != nil {
+		p.Leads = make([]Lead, len(lp.Leads))
+	}
+	for i, l := range lp.Leads {
+		p.Leads[i] = Lead{
+			ID:                  l.ID,
+			FirstName:           l.FirstName.String,
+			LastName:            l.LastName.String,
+			Email:               l.EmailHash.String,
+			Phone:               l.PhoneHash.String,
+			EmailCipher:         l.EmailCipher,
+			PhoneCipher:         l.PhoneCipher,
+			DekWrapped:          l.DekWrapped,
+			DekKmsKeyID:         l.DekKmsKeyID.String,
+			IPAddress:           l.IpAddress.IP,
+			CompanyName:         l.CompanyName.String,
+			Address:             l.Address.String,
+			CountryCode:         l.CountryCode.String,
+			LinkedinContact:     l.LinkedinContact.String,
+			LinkedinCompany:     l.LinkedinCompany.String,
+			DownloadedAssetName: l.DownloadedAssetName.String,
+			PublisherName:       l.PublisherName.String,
+			Industry:            l.Industry.String,
+			RevenueSize:         l.RevenueSize.String,
+			EmployeeSize:        l.EmployeeSize.String,
+			State:               l.State.String,
+			Title:               l.Title.String,
+			NaicsCode:           l.NaicsCode.String,
+			CapturedAt:          l.CapturedAt.Time,
+			CustomAnswers:       l.CustomAnswers.Answers,
+		}
+	}
+	return p, nil
+}
+
+// nullString accepts a sql.NullString object, a plain string or null
+type nullString struct {
+	String string
+}
+
+func (n *nullString) UnmarshalJSON(data []byte) error {
+	s, err := decodeScalar(data, "String")
+	if err != nil {
+		return err
+	}
+	n.String = s
+	return nil
+}
+
+// nullTime accepts a sql.NullTime object, a timestamp string or null
+type nullTime struct {
+	Time string
+}
+
+func (n *nullTime) UnmarshalJSON(data []byte) error {
+	s, err := decodeScalar(data, "Time")
+	if err != nil {
+		return err
+	}
+	if s != "" {
+		if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+			return fmt.Errorf("invalid timestamp %q", s)
+		}
+	}
+	n.Time = s
+	return nil
+}
+
+// nullInet accepts a pqtype.Inet object ({"IPNet": {"IP": ...}, "Valid": ...}), a plain string or null
+type nullInet struct {
+	IP string
+}
+
+func (n *nullInet) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		return json.Unmarshal(data, &n.IP)
+	}
+	var obj struct {
+		IPNet *struct {
+			IP *string `json:"IP"`
+		} `json:"IPNet"`
+		Valid *bool `json:"Valid"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("expected an IP address, string or null: %v", err)
+	}
+	if obj.Valid != nil && !*obj.Valid {
+		return nil
+	}
+	if obj.IPNet != nil && obj.IPNet.IP != nil {
+		n.IP = *obj.IPNet.IP
+	}
+	return nil
+}
+
+// nullRawAnswer accepts a pqtype.NullRawMessage object wrapping the answers, the answers object itself, or null
+type nullRawAnswer struct {
+	Answers map[string]string
+}
+
+func (n *nullRawAnswer) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("expected custom answers object or null: %v", err)
+	}
+	rawMsg, wrapped := obj["RawMessage"]
+	if !wrapped {
+		answers, err := decodeAnswers(data)
+		n.Answers = answers
+		return err
+	}
+	var valid bool
+	if v, ok := obj["Valid"]; ok {
+		if err := json.Unmarshal(v, &valid); err != nil {
+			return fmt.Errorf("custom answers Valid: %v", err)
+		}
+	}
+	if !valid {
+		return nil
+	}
+	answers, err := decodeAnswers(rawMsg)
+	n.Answers = answers
+	return err
+}
+
+// decodeScalar reads a string from a JSON string, null, or an object holding it under key
+// alongside a Valid flag, the shape database/sql null types serialize to
+func decodeScalar(data []byte, key string) (string, error) {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return "", nil
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		err := json.Unmarshal(data, &s)
+		return s, err
+	case len(data) > 0 && data[0] == '{':
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return "", err
+		}
+		if v, ok := obj["Valid"]; ok {
+			var valid bool
+			if err := json.Unmarshal(v, &valid); err != nil {
+				return "", fmt.Errorf("Valid: %v", err)
+			}
+			if !valid {
+				return "", nil
+			}
+		}
+		v, ok := obj[key]
+		if !ok || bytes.Equal(bytes.TrimSpace(v), []byte("null")) {
+			return "", nil
+		}
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			return "", fmt.Errorf("%s: %v", key, err)
+		}
+		return s, nil
+	}
+	return "", fmt.Errorf("expected string, null or {%q, \"Valid\"} object, got %s", key, truncate(data))
+}
+
+// decodeAnswers flattens a custom_answers object keyed by question ID. Answers may be
+// strings, numbers, booleans, lists of strings (joined with "; ") or NullString objects.
+func decodeAnswers(data []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("custom answers: %v", err)
+	}
+	answers := make(map[string]string, len(raw))
+	for questionID, v := range raw {
+		v = bytes.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		switch v[0] {
+		case '"', '{', 'n':
+			s, err := decodeScalar(v, "String")
+			if err != nil {
+				return nil, fmt.Errorf("custom answer %s: %v", questionID, err)
+			}
+			if s != "" {
+				answers[questionID] = s
+			}
+		case '[':
+			var list []string
+			if err := json.Unmarshal(v, &list); err != nil {
+				return nil, fmt.Errorf("custom answer %s: expected a list of strings", questionID)
+			}
+			if len(list) > 0 {
+				answers[questionID] = strings.Join(list, "; ")
+			}
+		case 't', 'f':
+			var b bool
+			if err := json.Unmarshal(v, &b); err != nil {
+				return nil, fmt.Errorf("custom answer %s: %v", questionID, err)
+			}
+			answers[questionID] = strconv.FormatBool(b)
+		default:
+			var num json.Number
+			if err := json.Unmarshal(v, &num); err != nil {
+				return nil, fmt.Errorf("custom answer %s: %v", questionID, err)
+			}
+			answers[questionID] = num.String()
+		}
+	}
+	return answers, nil
+}
+
+// truncate shortens raw JSON for error messages
+func truncate(data []byte) string {
+	if len(data) > 40 {
+		return string(data[:40]) + "..."
+	}
+	return string(data)
+}
@@ -0,0 +1,347 @@
+// Package payload defines the delivery job payload stored in delivery_jobs.payload.
+//
+// Version 2 payloads carry a "version" field and flat, snake_case leads. Payloads
+// without a version are the original shape, where leads are sqlc Lead rows serialized
+// as-is ({"String": ..., "Valid": ...} wrappers and so on); Decode accepts both.
+//
+// A version 2 payload in reference mode carries no leads at all, only the lead batch
+// and campaign; the worker loads the batch's current leads when it sends. After and
+// Through narrow it to part of the batch, in the order leads are read (see Cursor).
+package payload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+)
+
+// Payload versions
+const (
+	LegacyVersion  = 1 // sqlc-serialized leads, no version field
+	CurrentVersion = 2
+)
+
+// Payload modes
+const (
+	ModeEmbedded  = "embedded" // Leads are part of the payload (the default)
+	ModeReference = "reference"
+)
+
+// ErrInvalidPayload is wrapped by every decoding and validation error. Retrying a job
+// with a malformed payload can't succeed, so callers should fail it permanently.
+var ErrInvalidPayload = errors.New("invalid job payload")
+
+// Payload is the decoded content of a delivery job
+type Payload struct {
+	Version        int              `json:"version"`
+	Mode           string           `json:"mode,omitempty"`
+	CampaignID     string           `json:"campaign_id,omitempty"`
+	LeadBatchID    string           `json:"lead_batch_id,omitempty"`
+	RecipientEmail string           `json:"recipient_email,omitempty"`
+	TotalLeads     int              `json:"total_leads,omitempty"`
+	After          *Cursor          `json:"after,omitempty"`   // Reference mode: deliver only leads past this one
+	Through        *Cursor          `json:"through,omitempty"` // Reference mode: deliver no lead past this one
+	Leads          []Lead           `json:"leads"`
+	Questions      []Question       `json:"questions,omitempty"`
+	CsvFieldConfig []CsvFieldConfig `json:"csv_field_config,omitempty"`
+}
+
+// Lead is one lead's deliverable fields. Email and Phone hold the stored (hashed)
+// values; the encrypted originals travel in the cipher fields.
+type Lead struct {
+	ID                  string            `json:"id"`
+	FirstName           string            `json:"first_name,omitempty"`
+	LastName            string            `json:"last_name,omitempty"`
+	Email               string            `json:"email,omitempty"`
+	Phone               string            `json:"phone,omitempty"`
+	EmailCipher         []byte            `json:"email_cipher,omitempty"`
+	PhoneCipher         []byte            `json:"phone_cipher,omitempty"`
+	DekWrapped          []byte            `json:"dek_wrapped,omitempty"`
+	DekKmsKeyID         string            `json:"dek_kms_key_id,omitempty"`
+	IPAddress           string            `json:"ip_address,omitempty"`
+	CompanyName         string            `json:"company_name,omitempty"`
+	Address             string            `json:"address,omitempty"`
+	CountryCode         string            `json:"country_code,omitempty"`
+	LinkedinContact     string            `json:"linkedin_contact,omitempty"`
+	LinkedinCompany     string            `json:"linkedin_company,omitempty"`
+	DownloadedAssetName string            `json:"downloaded_asset_name,omitempty"`
+	PublisherName       string            `json:"publisher_name,omitempty"`
+	Industry            string            `json:"industry,omitempty"`
+	RevenueSize         string            `json:"revenue_size,omitempty"`
+	EmployeeSize        string            `json:"employee_size,omitempty"`
+	State               string            `json:"state,omitempty"`
+	Title               string            `json:"title,omitempty"`
+	NaicsCode           string            `json:"naics_code,omitempty"`
+	CapturedAt          string            `json:"captured_at,omitempty"` // RFC 3339
+	CustomAnswers       map[string]string `json:"custom_answers,omitempty"`
+}
+
+// Cursor is a lead's place in a batch. Batches are read in created_at order, leads
+// without one first, then by ID.
+type Cursor struct {
+	CreatedAt *time.Time `json:"created_at,omitempty"` // Nil when the lead has no created_at
+	ID        string     `json:"id"`
+}
+
+// Question is a campaign question whose answers become extra columns
+type Question struct {
+	ID           string `json:"id"`
+	QuestionText string `json:"question_text"`
+	DisplayOrder int    `json:"display_order"`
+}
+
+// CsvFieldConfig is one entry of the campaign's csv_field_config
+type CsvFieldConfig struct {
+	Key            string `json:"key"`
+	Label          string `json:"label,omitempty"`
+	DeliverToBuyer bool   `json:"deliver_to_buyer"`
+	Required       bool   `json:"required,omitempty"`
+	Order          int32  `json:"order,omitempty"`
+}
+
+// ValidationError lists every problem found in a payload
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrInvalidPayload, strings.Join(e.Problems, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidPayload
+}
+
+// Decode parses and validates a job payload of any supported version.
+// Questions are returned sorted by display order.
+func Decode(raw []byte) (*Payload, error) {
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	var p *Payload
+	var err error
+	switch probe.Version {
+	case 0, LegacyVersion:
+		p, err = decodeLegacy(raw)
+	case CurrentVersion:
+		p, err = decodeCurrent(raw)
+	default:
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidPayload, probe.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(p.Questions, func(i, j int) bool {
+		return p.Questions[i].DisplayOrder < p.Questions[j].DisplayOrder
+	})
+	return p, nil
+}
+
+// decodeCurrent decodes a version 2 payload, rejecting unknown fields
+func decodeCurrent(raw []byte) (*Payload, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	var p Payload
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	return &p, nil
+}
+
+// validate checks the fields every delivery relies on
+func (p *Payload) validate() error {
+	var problems []string
+	switch p.Mode {
+	case "", ModeEmbedded:
+		if p.Leads == nil {
+			problems = append(problems, "leads is missing")
+		}
+	case ModeReference:
+		if len(p.Leads) > 0 {
+			problems = append(problems, "reference payloads must not embed leads")
+		}
+		if _, err := uuid.Parse(p.LeadBatchID); err != nil {
+			problems = append(problems, fmt.Sprintf("lead_batch_id %q is not a uuid", p.LeadBatchID))
+		}
+		if p.CampaignID == "" {
+			problems = append(problems, "campaign_id is missing")
+		}
+		for name, c := range map[string]*Cursor{"after": p.After, "through": p.Through} {
+			if c == nil {
+				continue
+			}
+			if _, err := uuid.Parse(c.ID); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.id %q is not a uuid", name, c.ID))
+			}
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown mode %q", p.Mode))
+	}
+	if !p.IsReference() && (p.After != nil || p.Through != nil) {
+		problems = append(problems, "after and through need reference mode")
+	}
+	if p.CampaignID != "" {
+		if _, err := uuid.Parse(p.CampaignID); err != nil {
+			problems = append(problems, fmt.Sprintf("campaign_id %q is not a uuid", p.CampaignID))
+		}
+	}
+	if p.TotalLeads < 0 {
+		problems = append(problems, "total_leads is negative")
+	}
+	// Quarantine, the buyer ledger and per-lead API results all key on the lead ID
+	leadIDs := make(map[string]bool, len(p.Leads))
+	for i, lead := range p.Leads {
+		switch _, err := uuid.Parse(lead.ID); {
+		case lead.ID == "":
+			problems = append(problems, fmt.Sprintf("leads[%d].id is missing", i))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("leads[%d].id %q is not a uuid", i, lead.ID))
+		case leadIDs[lead.ID]:
+			problems = append(problems, fmt.Sprintf("leads[%d].id %s is duplicated", i, lead.ID))
+		}
+		leadIDs[lead.ID] = true
+	}
+	seen := make(map[string]bool, len(p.Questions))
+	for i, q := range p.Questions {
+		switch {
+		case q.ID == "":
+			problems = append(problems, fmt.Sprintf("questions[%d].id is missing", i))
+		case seen[q.ID]:
+			problems = append(problems, fmt.Sprintf("questions[%d].id %s is duplicated", i, q.ID))
+		}
+		seen[q.ID] = true
+	}
+	for i, entry := range p.CsvFieldConfig {
+		if entry.Key == "" {
+			problems = append(problems, fmt.Sprintf("csv_field_config[%d].key is missing", i))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// IsReference reports whether the leads must be loaded from the leads table
+func (p *Payload) IsReference() bool {
+	return p.Mode == ModeReference
+}
+
+// SnapshotHash fingerprints exactly what a delivery sends: the leads in order, the
+// questions and the field config. The encoding is canonical (struct field order,
+// sorted map keys), so the same content always hashes the same.
+func (p *Payload) SnapshotHash() string {
+	h := sha256.New()
+	encoder := json.NewEncoder(h)
+	encoder.Encode(p.Leads)
+	encoder.Encode(p.Questions)
+	encoder.Encode(p.CsvFieldConfig)
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// SnapshotHasher computes SnapshotHash over leads added one at a time, for leads that
+// are never all in memory at once. Adding a payload's leads in order gives the same
+// hash as its SnapshotHash.
+type SnapshotHasher struct {
+	h       hash.Hash
+	encoder *json.Encoder
+	leads   int
+}
+
+// NewSnapshotHasher starts a snapshot hash
+func NewSnapshotHasher() *SnapshotHasher {
+	h := sha256.New()
+	return &SnapshotHasher{h: h, encoder: json.NewEncoder(h)}
+}
+
+// Add hashes the next lead
+func (s *SnapshotHasher) Add(lead *Lead) {
+	if s.leads == 0 {
+		s.h.Write([]byte("["))
+	} else {
+		s.h.Write([]byte(","))
+	}
+	s.leads++
+	raw, _ := json.Marshal(lead)
+	s.h.Write(raw)
+}
+
+// Sum finishes the hash with the payload's questions and field config
+func (s *SnapshotHasher) Sum(questions []Question, config []CsvFieldConfig) string {
+	if s.leads == 0 {
+		s.h.Write([]byte("[]"))
+	} else {
+		s.h.Write([]byte("]"))
+	}
+	s.h.Write([]byte("\n"))
+	s.encoder.Encode(questions)
+	s.encoder.Encode(config)
+	return "sha256:" + hex.EncodeToString(s.h.Sum(nil))
+}
+
+// LeadCount is the number of leads the payload delivers
+func (p *Payload) LeadCount() int {
+	if len(p.Leads) > 0 {
+		return len(p.Leads)
+	}
+	return p.TotalLeads
+}
+
+// FromLead converts a leads row into a payload lead
+func FromLead(l queries.Lead) Lead {
+	lead := Lead{
+		ID:                  l.ID.String(),
+		FirstName:           l.FirstName.String,
+		LastName:            l.LastName.String,
+		Email:               l.EmailHash.String,
+		Phone:               l.PhoneHash.String,
+		EmailCipher:         l.EmailCipher,
+		PhoneCipher:         l.PhoneCipher,
+		DekWrapped:          l.DekWrapped,
+		DekKmsKeyID:         l.DekKmsKeyID.String,
+		CompanyName:         l.CompanyName.String,
+		Address:             l.Address.String,
+		CountryCode:         l.CountryCode.String,
+		LinkedinContact:     l.LinkedinContact.String,
+		LinkedinCompany:     l.LinkedinCompany.String,
+		DownloadedAssetName: l.DownloadedAssetName.String,
+		PublisherName:       l.PublisherName.String,
+		Industry:            l.Industry.String,
+		RevenueSize:         l.RevenueSize.String,
+		EmployeeSize:        l.EmployeeSize.String,
+		State:               l.State.String,
+		Title:               l.Title.String,
+		NaicsCode:           l.NaicsCode.String,
+	}
+	if l.IpAddress.Valid {
+		lead.IPAddress = l.IpAddress.IPNet.IP.String()
+	}
+	if l.CapturedAt.Valid {
+		lead.CapturedAt = l.CapturedAt.Time.Format(timeLayout)
+	}
+	if l.CustomAnswers.Valid {
+		// Rows that fail to parse deliver without answers rather than blocking the batch
+		lead.CustomAnswers, _ = decodeAnswers(l.CustomAnswers.RawMessage)
+	}
+	return lead
+}
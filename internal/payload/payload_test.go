@@ -0,0 +1,304 @@
+package payload
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	leadA     = "0b6f4c1e-7f5a-4d8e-9a51-1c2d3e4f5a6b"
+	leadB     = "5d2e8a90-3c4b-4f1a-8e7d-6b5a4c3d2e1f"
+	batchID   = "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"
+	campaign  = "1f2e3d4c-5b6a-4978-8695-a4b3c2d1e0f9"
+	questionX = "q-industry-detail"
+)
+
+var capturedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    *Payload
+		wantErr string // Substring of the error; empty when decoding succeeds
+	}{
+		{
+			name: "v1 sqlc rows",
+			raw: `{"campaign_id": "` + campaign + `", "recipient_email": "buyer@example.com", "total_leads": 1,
+				"leads": [{
+					"ID": "` + leadA + `", "TenantID": "ignored",
+					"FirstName": {"String": "Ada", "Valid": true},
+					"LastName": {"String": "", "Valid": false},
+					"EmailHash": {"String": "abc123", "Valid": true},
+					"EmailCipher": "AQID",
+					"IpAddress": {"IPNet": {"IP": "203.0.113.7", "Mask": "////AA=="}, "Valid": true},
+					"CapturedAt": {"Time": "2024-05-01T12:00:00Z", "Valid": true},
+					"CustomAnswers": {"RawMessage": {"` + questionX + `": ["Robotics", "Welding"], "q2": 42, "q3": true, "q4": null}, "Valid": true}
+				}],
+				"questions": [{"id": "q2", "question_text": "Employees", "display_order": 2}, {"id": "` + questionX + `", "question_text": "Focus", "display_order": 1}]}`,
+			want: &Payload{
+				Version:        LegacyVersion,
+				CampaignID:     campaign,
+				RecipientEmail: "buyer@example.com",
+				TotalLeads:     1,
+				Leads: []Lead{{
+					ID:          leadA,
+					FirstName:   "Ada",
+					Email:       "abc123",
+					EmailCipher: []byte{1, 2, 3},
+					IPAddress:   "203.0.113.7",
+					CapturedAt:  "2024-05-01T12:00:00Z",
+					CustomAnswers: map[string]string{
+						questionX: "Robotics; Welding",
+						"q2":      "42",
+						"q3":      "true",
+					},
+				}},
+				Questions: []Question{
+					{ID: questionX, QuestionText: "Focus", DisplayOrder: 1},
+					{ID: "q2", QuestionText: "Employees", DisplayOrder: 2},
+				},
+			},
+		},
+		{
+			name: "v1 plain values",
+			raw:  `{"leads": [{"ID": "` + leadA + `", "FirstName": "Ada", "IpAddress": "203.0.113.7", "CustomAnswers": {"q1": "yes"}}]}`,
+			want: &Payload{
+				Version: LegacyVersion,
+				Leads:   []Lead{{ID: leadA, FirstName: "Ada", IPAddress: "203.0.113.7", CustomAnswers: map[string]string{"q1": "yes"}}},
+			},
+		},
+		{
+			name:    "v1 malformed field names the lead",
+			raw:     `{"leads": [{"ID": "` + leadA + `"}, {"ID": "` + leadB + `", "FirstName": 7}]}`,
+			wantErr: "leads[1]",
+		},
+		{
+			name: "v2 embedded",
+			raw: `{"version": 2, "campaign_id": "` + campaign + `", "lead_batch_id": "` + batchID + `",
+				"leads": [{"id": "` + leadA + `", "first_name": "Ada", "email": "abc123", "custom_answers": {"q1": "yes"}},
+				          {"id": "` + leadB + `", "company_name": "Acme"}],
+				"csv_field_config": [{"key": "first_name", "deliver_to_buyer": true}]}`,
+			want: &Payload{
+				Version:     CurrentVersion,
+				CampaignID:  campaign,
+				LeadBatchID: batchID,
+				Leads: []Lead{
+					{ID: leadA, FirstName: "Ada", Email: "abc123", CustomAnswers: map[string]string{"q1": "yes"}},
+					{ID: leadB, CompanyName: "Acme"},
+				},
+				CsvFieldConfig: []CsvFieldConfig{{Key: "first_name", DeliverToBuyer: true}},
+			},
+		},
+		{
+			name:    "v2 unknown top-level field",
+			raw:     `{"version": 2, "leads": [], "recipient": "typo@example.com"}`,
+			wantErr: `unknown field "recipient"`,
+		},
+		{
+			name:    "v2 unknown lead field",
+			raw:     `{"version": 2, "leads": [{"id": "` + leadA + `", "FirstName": "Ada"}]}`,
+			wantErr: `unknown field "FirstName"`,
+		},
+		{
+			name:    "v2 leads missing",
+			raw:     `{"version": 2}`,
+			wantErr: "leads is missing",
+		},
+		{
+			name:    "unsupported version",
+			raw:     `{"version": 3, "leads": []}`,
+			wantErr: "unsupported version 3",
+		},
+		{
+			name:    "not json",
+			raw:     `leads: []`,
+			wantErr: "invalid job payload",
+		},
+		{
+			name: "reference mode",
+			raw:  `{"version": 2, "mode": "reference", "campaign_id": "` + campaign + `", "lead_batch_id": "` + batchID + `", "total_leads": 250}`,
+			want: &Payload{
+				Version:     CurrentVersion,
+				Mode:        ModeReference,
+				CampaignID:  campaign,
+				LeadBatchID: batchID,
+				TotalLeads:  250,
+			},
+		},
+		{
+			name: "reference mode narrowed by cursors",
+			raw: `{"version": 2, "mode": "reference", "campaign_id": "` + campaign + `", "lead_batch_id": "` + batchID + `",
+				"after": {"id": "` + leadA + `"}, "through": {"created_at": "2024-05-01T12:00:00Z", "id": "` + leadB + `"}}`,
+			want: &Payload{
+				Version:     CurrentVersion,
+				Mode:        ModeReference,
+				CampaignID:  campaign,
+				LeadBatchID: batchID,
+				After:       &Cursor{ID: leadA},
+				Through:     &Cursor{CreatedAt: &capturedAt, ID: leadB},
+			},
+		},
+		{
+			name:    "cursor without a uuid",
+			raw:     `{"version": 2, "mode": "reference", "campaign_id": "` + campaign + `", "lead_batch_id": "` + batchID + `", "after": {"id": "lead-1"}}`,
+			wantErr: `after.id "lead-1" is not a uuid`,
+		},
+		{
+			name:    "cursor on an embedded payload",
+			raw:     `{"version": 2, "leads": [], "through": {"id": "` + leadA + `"}}`,
+			wantErr: "after and through need reference mode",
+		},
+		{
+			name:    "reference mode with leads",
+			raw:     `{"version": 2, "mode": "reference", "campaign_id": "` + campaign + `", "lead_batch_id": "` + batchID + `", "leads": [{"id": "` + leadA + `"}]}`,
+			wantErr: "reference payloads must not embed leads",
+		},
+		{
+			name:    "reference mode without batch or campaign",
+			raw:     `{"version": 2, "mode": "reference", "lead_batch_id": "batch-7"}`,
+			wantErr: `lead_batch_id "batch-7" is not a uuid; campaign_id is missing`,
+		},
+		{
+			name:    "unknown mode",
+			raw:     `{"version": 2, "mode": "streaming", "leads": []}`,
+			wantErr: `unknown mode "streaming"`,
+		},
+		{
+			name:    "lead without an id",
+			raw:     `{"version": 2, "leads": [{"id": "` + leadA + `"}, {"first_name": "Ada"}]}`,
+			wantErr: "leads[1].id is missing",
+		},
+		{
+			name:    "v1 lead without an id",
+			raw:     `{"leads": [{"FirstName": "Ada"}]}`,
+			wantErr: "leads[0].id is missing",
+		},
+		{
+			name:    "lead id not a uuid",
+			raw:     `{"version": 2, "leads": [{"id": "lead-1"}]}`,
+			wantErr: `leads[0].id "lead-1" is not a uuid`,
+		},
+		{
+			name:    "duplicate lead id",
+			raw:     `{"version": 2, "leads": [{"id": "` + leadA + `"}, {"id": "` + leadA + `"}]}`,
+			wantErr: "leads[1].id " + leadA + " is duplicated",
+		},
+		{
+			name:    "bad campaign, question and field config",
+			raw:     `{"version": 2, "campaign_id": "c-1", "total_leads": -1, "leads": [], "questions": [{"id": "q1"}, {"id": "q1"}, {"question_text": "?"}], "csv_field_config": [{"label": "x"}]}`,
+			wantErr: `campaign_id "c-1" is not a uuid; total_leads is negative; questions[1].id q1 is duplicated; questions[2].id is missing; csv_field_config[0].key is missing`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode([]byte(tt.raw))
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("Decode succeeded, want error containing %q", tt.wantErr)
+				}
+				if !errors.Is(err, ErrInvalidPayload) {
+					t.Errorf("error %v doesn't wrap ErrInvalidPayload", err)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeReportsEveryProblem(t *testing.T) {
+	_, err := Decode([]byte(`{"version": 2, "leads": [{"id": ""}, {"id": "x"}]}`))
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("err = %v, want a *ValidationError", err)
+	}
+	if len(validation.Problems) != 2 {
+		t.Errorf("problems = %q, want one per lead", validation.Problems)
+	}
+}
+
+func TestPayloadModes(t *testing.T) {
+	embedded := &Payload{Leads: []Lead{{ID: leadA}, {ID: leadB}}, TotalLeads: 5}
+	if embedded.IsReference() || embedded.LeadCount() != 2 {
+		t.Errorf("embedded: IsReference = %v, LeadCount = %d", embedded.IsReference(), embedded.LeadCount())
+	}
+	reference := &Payload{Mode: ModeReference, TotalLeads: 250}
+	if !reference.IsReference() || reference.LeadCount() != 250 {
+		t.Errorf("reference: IsReference = %v, LeadCount = %d", reference.IsReference(), reference.LeadCount())
+	}
+}
+
+// The snapshot hash covers what is sent, in order, and nothing else
+func TestSnapshotHash(t *testing.T) {
+	base := func() *Payload {
+		return &Payload{
+			Version:        CurrentVersion,
+			RecipientEmail: "buyer@example.com",
+			Leads:          []Lead{{ID: leadA, FirstName: "Ada", CustomAnswers: map[string]string{"q1": "a", "q2": "b"}}, {ID: leadB}},
+			Questions:      []Question{{ID: "q1", QuestionText: "One"}},
+		}
+	}
+	hash := base().SnapshotHash()
+	if !strings.HasPrefix(hash, "sha256:") {
+		t.Errorf("hash %s lacks the sha256: prefix", hash)
+	}
+	if again := base().SnapshotHash(); again != hash {
+		t.Errorf("same content hashed differently: %s, %s", hash, again)
+	}
+
+	unsent := base()
+	unsent.RecipientEmail = "other@example.com"
+	unsent.TotalLeads = 9
+	if unsent.SnapshotHash() != hash {
+		t.Error("fields that aren't sent changed the hash")
+	}
+
+	for name, change := range map[string]func(p *Payload){
+		"lead value":    func(p *Payload) { p.Leads[0].FirstName = "Grace" },
+		"lead order":    func(p *Payload) { p.Leads[0], p.Leads[1] = p.Leads[1], p.Leads[0] },
+		"answer":        func(p *Payload) { p.Leads[0].CustomAnswers["q2"] = "c" },
+		"question":      func(p *Payload) { p.Questions[0].QuestionText = "Uno" },
+		"field config":  func(p *Payload) { p.CsvFieldConfig = []CsvFieldConfig{{Key: "email"}} },
+		"lead removed":  func(p *Payload) { p.Leads = p.Leads[:1] },
+		"cipher change": func(p *Payload) { p.Leads[1].EmailCipher = []byte{1} },
+	} {
+		p := base()
+		change(p)
+		if p.SnapshotHash() == hash {
+			t.Errorf("%s didn't change the hash", name)
+		}
+	}
+}
+
+func TestSnapshotHasherMatchesSnapshotHash(t *testing.T) {
+	for _, leads := range [][]Lead{
+		{},
+		{{ID: leadA, FirstName: "Ada <&>", CustomAnswers: map[string]string{"q2": "b", "q1": "a"}}},
+		{{ID: leadA, EmailCipher: []byte{1, 2}}, {ID: leadB, CompanyName: "Acme"}},
+	} {
+		p := &Payload{
+			Leads:          leads,
+			Questions:      []Question{{ID: "q1", QuestionText: "One"}},
+			CsvFieldConfig: []CsvFieldConfig{{Key: "email", DeliverToBuyer: true}},
+		}
+		hasher := NewSnapshotHasher()
+		for i := range leads {
+			hasher.Add(&leads[i])
+		}
+		if got, want := hasher.Sum(p.Questions, p.CsvFieldConfig), p.SnapshotHash(); got != want {
+			t.Errorf("%d leads: hasher = %s, SnapshotHash = %s", len(leads), got, want)
+		}
+	}
+}
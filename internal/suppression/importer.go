@@ -0,0 +1,269 @@
+package suppression
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+)
+
+// headerAliases maps normalized column headers to import fields
+var headerAliases = map[string]string{
+	"email": "email", "email_address": "email", "e_mail": "email",
+	"phone": "phone", "phone_number": "phone", "telephone": "phone", "mobile": "phone",
+	"name": "name", "full_name": "name", "contact_name": "name",
+	"first_name": "first_name", "firstname": "first_name",
+	"last_name": "last_name", "lastname": "last_name", "surname": "last_name",
+	"company": "company", "company_name": "company", "organization": "company", "account": "company",
+	"state": "state", "state_name": "state", "province": "state",
+}
+
+// Record is one row of an import file
+type Record struct {
+	Email     string
+	Phone     string
+	Name      string
+	FirstName string
+	LastName  string
+	Company   string
+	State     string
+}
+
+// Entry is a suppression_entries row; empty hashes are stored as NULL
+type Entry struct {
+	NameHash  string
+	EmailHash string
+	PhoneHash string
+}
+
+// Report counts what an import found and, unless it was a dry run, inserted
+type Report struct {
+	Rows              int
+	EmptyRows         int
+	InvalidEmails     int
+	InvalidPhones     int
+	DuplicateEntries  int
+	DuplicateSegments int
+	Entries           int
+	Companies         int
+	States            int
+	InsertedEntries   int64
+	InsertedCompanies int64
+	InsertedStates    int64
+}
+
+// ImportSet is the normalized, deduplicated content of an import file
+type ImportSet struct {
+	Entries   []Entry
+	Companies []string
+	States    []string
+	Report    Report
+}
+
+// ReadRows reads every row of a CSV file, or of the first sheet of an XLSX workbook
+func ReadRows(r io.Reader, format string) ([][]string, error) {
+	switch format {
+	case "csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		return reader.ReadAll()
+	case "xlsx":
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sheets := f.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, fmt.Errorf("workbook has no sheets")
+		}
+		return f.GetRows(sheets[0])
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// ParseRecords maps rows to records using the header row. At least one recognized
+// column is required; unrecognized columns are ignored.
+func ParseRecords(rows [][]string) ([]Record, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("file is empty")
+	}
+
+	columns := make(map[string]int)
+	for i, h := range rows[0] {
+		key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		key = strings.NewReplacer(" ", "_", "-", "_", ".", "_").Replace(key)
+		if field, ok := headerAliases[key]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no recognized columns in header %v", rows[0])
+	}
+
+	cell := func(row []string, field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	records := make([]Record, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		records = append(records, Record{
+			Email:     cell(row, "email"),
+			Phone:     cell(row, "phone"),
+			Name:      cell(row, "name"),
+			FirstName: cell(row, "first_name"),
+			LastName:  cell(row, "last_name"),
+			Company:   cell(row, "company"),
+			State:     cell(row, "state"),
+		})
+	}
+	return records, nil
+}
+
+// Build normalizes, hashes and dedupes records. A name is only stored when the row has
+// no valid email or phone; matching by name alone is broad, so it's a last resort.
+func Build(records []Record, defaultCountryCode string) *ImportSet {
+	set := &ImportSet{}
+	seenEntries := make(map[Entry]bool)
+	seenCompanies := make(map[string]bool)
+	seenStates := make(map[string]bool)
+
+	for _, rec := range records {
+		set.Report.Rows++
+
+		var entry Entry
+		if rec.Email != "" {
+			if addr, err := mail.ParseAddress(rec.Email); err == nil && strings.Contains(addr.Address, ".") {
+				entry.EmailHash = HashEmail(addr.Address)
+			} else {
+				set.Report.InvalidEmails++
+			}
+		}
+		if rec.Phone != "" {
+			if entry.PhoneHash = HashPhone(rec.Phone, defaultCountryCode); entry.PhoneHash == "" {
+				set.Report.InvalidPhones++
+			}
+		}
+		if entry.EmailHash == "" && entry.PhoneHash == "" {
+			first, last := rec.FirstName, rec.LastName
+			if first == "" && last == "" {
+				first = rec.Name
+			}
+			entry.NameHash = HashName(first, last)
+		}
+
+		empty := true
+		if entry != (Entry{}) {
+			empty = false
+			if seenEntries[entry] {
+				set.Report.DuplicateEntries++
+			} else {
+				seenEntries[entry] = true
+				set.Entries = append(set.Entries, entry)
+			}
+		}
+		if key := CanonicalCompany(rec.Company); key != "" {
+			empty = false
+			if seenCompanies[key] {
+				set.Report.DuplicateSegments++
+			} else {
+				seenCompanies[key] = true
+				set.Companies = append(set.Companies, key)
+			}
+		}
+		if key := NormalizeValue(rec.State); key != "" {
+			empty = false
+			if seenStates[key] {
+				set.Report.DuplicateSegments++
+			} else {
+				seenStates[key] = true
+				set.States = append(set.States, strings.Join(strings.Fields(rec.State), " "))
+			}
+		}
+		if empty {
+			set.Report.EmptyRows++
+		}
+	}
+
+	set.Report.Entries = len(set.Entries)
+	set.Report.Companies = len(set.Companies)
+	set.Report.States = len(set.States)
+	return set
+}
+
+// Insert writes the set into the list in batches, skipping values the list already
+// has. q should be bound to a transaction so a failed import leaves nothing behind.
+func (s *ImportSet) Insert(ctx context.Context, q *queries.Queries, tenantID, listID uuid.UUID, batchSize int) error {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	// Companies are stored canonical, but rows added elsewhere may not be; compare
+	// them the way Load does so they aren't added again in another spelling
+	existing, err := q.ListSuppressionCompanies(ctx, queries.ListSuppressionCompaniesParams{SuppressionListID: listID, TenantID: tenantID})
+	if err != nil {
+		return fmt.Errorf("failed to read companies: %w", err)
+	}
+	listed := make(map[string]bool, len(existing))
+	for _, c := range existing {
+		listed[CanonicalCompany(c)] = true
+	}
+	companies := s.Companies[:0:0]
+	for _, c := range s.Companies {
+		if !listed[c] {
+			companies = append(companies, c)
+		}
+	}
+
+	for start := 0; start < len(s.Entries); start += batchSize {
+		batch := s.Entries[start:min(start+batchSize, len(s.Entries))]
+		params := queries.InsertSuppressionEntriesParams{SuppressionListID: listID}
+		for _, e := range batch {
+			params.NameHashes = append(params.NameHashes, e.NameHash)
+			params.EmailHashes = append(params.EmailHashes, e.EmailHash)
+			params.PhoneHashes = append(params.PhoneHashes, e.PhoneHash)
+		}
+		n, err := q.InsertSuppressionEntries(ctx, params)
+		if err != nil {
+			return fmt.Errorf("failed to insert entries: %w", err)
+		}
+		s.Report.InsertedEntries += n
+	}
+
+	for start := 0; start < len(companies); start += batchSize {
+		n, err := q.InsertSuppressionCompanies(ctx, queries.InsertSuppressionCompaniesParams{
+			SuppressionListID: listID,
+			CompanyNames:      companies[start:min(start+batchSize, len(companies))],
+		})
+		if err != nil {
+			return fmt.Errorf("failed to insert companies: %w", err)
+		}
+		s.Report.InsertedCompanies += n
+	}
+
+	for start := 0; start < len(s.States); start += batchSize {
+		n, err := q.InsertSuppressionStates(ctx, queries.InsertSuppressionStatesParams{
+			SuppressionListID: listID,
+			StateNames:        s.States[start:min(start+batchSize, len(s.States))],
+		})
+		if err != nil {
+			return fmt.Errorf("failed to insert states: %w", err)
+		}
+		s.Report.InsertedStates += n
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package suppression
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestReadRowsCSV(t *testing.T) {
+	in := "email,phone\n jane@example.com,\"555-123-4567, x2\"\nbob@example.com\n"
+	rows, err := ReadRows(strings.NewReader(in), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"email", "phone"}, {"jane@example.com", "555-123-4567, x2"}, {"bob@example.com"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func TestReadRowsXLSX(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+	for i, row := range [][]any{{"Email", "Phone"}, {"jane@example.com", "02079460958"}} {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the first sheet is read
+	if _, err := f.NewSheet("Other"); err != nil {
+		t.Fatal(err)
+	}
+	f.SetCellValue("Other", "A1", "ignored")
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := ReadRows(&buf, "xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"Email", "Phone"}, {"jane@example.com", "02079460958"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func TestReadRowsErrors(t *testing.T) {
+	if _, err := ReadRows(strings.NewReader("a,b"), "txt"); err == nil {
+		t.Error("read an unsupported format")
+	}
+	if _, err := ReadRows(strings.NewReader("not a workbook"), "xlsx"); err == nil {
+		t.Error("read a corrupt workbook")
+	}
+	if _, err := ReadRows(strings.NewReader("a,\"b\n"), "csv"); err == nil {
+		t.Error("read an unterminated quote")
+	}
+}
+
+func TestParseRecords(t *testing.T) {
+	rows := [][]string{
+		{"\ufeffE-Mail", "Phone Number", "Full Name", "First.Name", "surname", "Organization", "Email Address", "Notes", " STATE "},
+		{"jane@example.com", " 555 ", "Jane Doe", "Jane", "Doe", "Acme", "second@example.com", "x", "NY"},
+		{"bob@example.com"}, // Short rows leave the missing fields blank
+		{},
+	}
+	records, err := ParseRecords(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Record{
+		// The first column for a field wins
+		{Email: "jane@example.com", Phone: "555", Name: "Jane Doe", FirstName: "Jane", LastName: "Doe", Company: "Acme", State: "NY"},
+		{Email: "bob@example.com"},
+		{},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %+v, want %+v", records, want)
+	}
+
+	if records, err := ParseRecords([][]string{{"Mobile"}}); err != nil || len(records) != 0 {
+		t.Errorf("header only = %v, %v, want no records", records, err)
+	}
+}
+
+func TestParseRecordsErrors(t *testing.T) {
+	if _, err := ParseRecords(nil); err == nil {
+		t.Error("parsed an empty file")
+	}
+	if _, err := ParseRecords([][]string{{"id", "notes"}, {"1", "x"}}); err == nil {
+		t.Error("parsed a file without recognized columns")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	records := []Record{
+		{Email: "Jane@Example.com", Phone: "555-123-4567"},
+		{Email: "Jane <JANE@example.com>", Phone: "+1 (555) 123-4567 x9"}, // The same contact
+		{Email: "not-an-email", Name: "Bob Jones"},
+		{Email: "ann@localhost", Phone: "12", FirstName: "Ann", LastName: "Lee"},
+		{Phone: "+44 (0)20 7946 0958", Company: "Acme, Inc.", State: "New  York"},
+		{Company: "ACME Incorporated", State: "new york"},
+		{},
+		{Name: " bob  JONES "},
+	}
+	set := Build(records, "1")
+
+	want := []Entry{
+		{EmailHash: HashEmail("jane@example.com"), PhoneHash: HashPhone("5551234567", "1")},
+		// Names only when there's no valid email or phone
+		{NameHash: HashName("Bob Jones", "")},
+		{NameHash: HashName("Ann", "Lee")},
+		{PhoneHash: HashPhone("+442079460958", "")},
+	}
+	if !reflect.DeepEqual(set.Entries, want) {
+		t.Errorf("entries = %+v, want %+v", set.Entries, want)
+	}
+	if !reflect.DeepEqual(set.Companies, []string{"acme"}) {
+		t.Errorf("companies = %q, want [acme]", set.Companies)
+	}
+	if !reflect.DeepEqual(set.States, []string{"New York"}) {
+		t.Errorf("states = %q, want [New York]", set.States)
+	}
+
+	wantReport := Report{
+		Rows:              8,
+		EmptyRows:         1,
+		InvalidEmails:     2,
+		InvalidPhones:     1,
+		DuplicateEntries:  2,
+		DuplicateSegments: 2,
+		Entries:           4,
+		Companies:         1,
+		States:            1,
+	}
+	if set.Report != wantReport {
+		t.Errorf("report = %+v, want %+v", set.Report, wantReport)
+	}
+}
+
+func TestBuildDefaultCountryCode(t *testing.T) {
+	set := Build([]Record{{Phone: "020 7946 0958"}}, "44")
+	if want := HashPhone("+442079460958", ""); len(set.Entries) != 1 || set.Entries[0].PhoneHash != want {
+		t.Errorf("entries = %+v, want the UK number", set.Entries)
+	}
+}
+
+func TestInsert(t *testing.T) {
+	q, mock := newMockQueries(t)
+	tenantID, listID := uuid.New(), uuid.New()
+	set := &ImportSet{
+		Entries: []Entry{
+			{EmailHash: "e1", PhoneHash: "p1"},
+			{NameHash: "n2"},
+			{PhoneHash: "p3"},
+		},
+		Companies: []string{"acme", "globex", "initech"},
+		States:    []string{"New York", "Texas"},
+	}
+
+	// Companies already listed in another spelling aren't added again
+	mock.ExpectQuery(queryName("ListSuppressionCompanies")).
+		WithArgs(listID, tenantID).
+		WillReturnRows(sqlmock.NewRows([]string{"company_name"}).AddRow("Globex Corp."))
+	mock.ExpectExec(queryName("InsertSuppressionEntries")).
+		WithArgs(listID, []string{"", "n2"}, []string{"e1", ""}, []string{"p1", ""}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(queryName("InsertSuppressionEntries")).
+		WithArgs(listID, []string{""}, []string{""}, []string{"p3"}).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(queryName("InsertSuppressionCompanies")).
+		WithArgs(listID, []string{"acme", "initech"}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(queryName("InsertSuppressionStates")).
+		WithArgs(listID, []string{"New York", "Texas"}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := set.Insert(context.Background(), q, tenantID, listID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if r := set.Report; r.InsertedEntries != 2 || r.InsertedCompanies != 1 || r.InsertedStates != 2 {
+		t.Errorf("inserted %d entries, %d companies, %d states, want 2, 1, 2", r.InsertedEntries, r.InsertedCompanies, r.InsertedStates)
+	}
+	if !reflect.DeepEqual(set.Companies, []string{"acme", "globex", "initech"}) {
+		t.Errorf("Insert changed the set's companies to %q", set.Companies)
+	}
+}
+
+func TestInsertFailure(t *testing.T) {
+	q, mock := newMockQueries(t)
+	tenantID, listID := uuid.New(), uuid.New()
+	set := &ImportSet{Entries: []Entry{{EmailHash: "e1"}}, Companies: []string{"acme"}}
+
+	mock.ExpectQuery(queryName("ListSuppressionCompanies")).WillReturnRows(sqlmock.NewRows([]string{"company_name"}))
+	// A batch size of zero means the default
+	mock.ExpectExec(queryName("InsertSuppressionEntries")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(queryName("InsertSuppressionCompanies")).WillReturnError(errors.New("connection reset"))
+
+	err := set.Insert(context.Background(), q, tenantID, listID, 0)
+	if err == nil || !strings.Contains(err.Error(), "failed to insert companies") {
+		t.Errorf("Insert = %v, want the companies failure", err)
+	}
+}
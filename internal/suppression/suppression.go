@@ -0,0 +1,240 @@
+// Package suppression matches leads against a campaign's suppression list.
+//
+// A list suppresses contacts by name, email or phone hash, and whole segments by
+// company, state, revenue size or employee size. Hashes are utils.HashContact of the
+// normalized value (see HashEmail, HashPhone, HashName), the scheme leads are stored
+// with; segment values compare case-insensitively with surrounding whitespace
+// ignored, and company names by their canonical form (CanonicalCompany).
+package suppression
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+// Reasons a lead was suppressed, in the order they are checked
+const (
+	ReasonEmail        = "email"
+	ReasonPhone        = "phone"
+	ReasonName         = "name"
+	ReasonCompany      = "company"
+	ReasonState        = "state"
+	ReasonRevenueSize  = "revenue_size"
+	ReasonEmployeeSize = "employee_size"
+)
+
+// List is the part of a suppression list relevant to one set of leads
+type List struct {
+	ID            uuid.UUID
+	emails        map[string]bool
+	phones        map[string]bool
+	names         map[string]bool
+	companies     map[string]bool
+	states        map[string]bool
+	revenueSizes  map[string]bool
+	employeeSizes map[string]bool
+}
+
+// Load reads the list's segment rules and the contact entries that could match leads.
+// Contact entries are fetched by the leads' hashes, so large lists aren't read in full.
+func Load(ctx context.Context, q *queries.Queries, tenantID, listID uuid.UUID, leads []payload.Lead) (*List, error) {
+	l := &List{ID: listID}
+
+	var emailHashes, phoneHashes, nameHashes []string
+	for i := range leads {
+		if h := normalizeHash(leads[i].Email); h != "" {
+			emailHashes = append(emailHashes, h)
+		}
+		if h := normalizeHash(leads[i].Phone); h != "" {
+			phoneHashes = append(phoneHashes, h)
+		}
+		if h := HashName(leads[i].FirstName, leads[i].LastName); h != "" {
+			nameHashes = append(nameHashes, h)
+		}
+	}
+
+	entries, err := q.ListSuppressionEntriesMatching(ctx, queries.ListSuppressionEntriesMatchingParams{
+		SuppressionListID: listID,
+		TenantID:          tenantID,
+		EmailHashes:       emailHashes,
+		PhoneHashes:       phoneHashes,
+		NameHashes:        nameHashes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.emails, l.phones, l.names = map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for _, e := range entries {
+		if h := normalizeHash(e.EmailHash.String); h != "" {
+			l.emails[h] = true
+		}
+		if h := normalizeHash(e.PhoneHash.String); h != "" {
+			l.phones[h] = true
+		}
+		if h := normalizeHash(e.NameHash.String); h != "" {
+			l.names[h] = true
+		}
+	}
+
+	companies, err := q.ListSuppressionCompanies(ctx, queries.ListSuppressionCompaniesParams{SuppressionListID: listID, TenantID: tenantID})
+	if err != nil {
+		return nil, err
+	}
+	states, err := q.ListSuppressionStates(ctx, queries.ListSuppressionStatesParams{SuppressionListID: listID, TenantID: tenantID})
+	if err != nil {
+		return nil, err
+	}
+	revenueSizes, err := q.ListSuppressionRevenueSizes(ctx, queries.ListSuppressionRevenueSizesParams{SuppressionListID: listID, TenantID: tenantID})
+	if err != nil {
+		return nil, err
+	}
+	employeeSizes, err := q.ListSuppressionEmployeeSizes(ctx, queries.ListSuppressionEmployeeSizesParams{SuppressionListID: listID, TenantID: tenantID})
+	if err != nil {
+		return nil, err
+	}
+
+	l.companies = make(map[string]bool, len(companies))
+	for _, c := range companies {
+		if n := CanonicalCompany(c); n != "" {
+			l.companies[n] = true
+		}
+	}
+	l.states = valueSet(states)
+	l.revenueSizes = valueSet(revenueSizes)
+	l.employeeSizes = valueSet(employeeSizes)
+	return l, nil
+}
+
+// Match returns why the lead is suppressed, or "" if it may be delivered
+func (l *List) Match(lead *payload.Lead) string {
+	switch {
+	case lead.Email != "" && l.emails[normalizeHash(lead.Email)]:
+		return ReasonEmail
+	case lead.Phone != "" && l.phones[normalizeHash(lead.Phone)]:
+		return ReasonPhone
+	case l.names[HashName(lead.FirstName, lead.LastName)]:
+		return ReasonName
+	case l.companies[CanonicalCompany(lead.CompanyName)]:
+		return ReasonCompany
+	case l.states[NormalizeValue(lead.State)]:
+		return ReasonState
+	case l.revenueSizes[NormalizeValue(lead.RevenueSize)]:
+		return ReasonRevenueSize
+	case l.employeeSizes[NormalizeValue(lead.EmployeeSize)]:
+		return ReasonEmployeeSize
+	}
+	return ""
+}
+
+// valueSet builds a lookup of normalized segment values, skipping blanks so empty lead
+// fields never match
+func valueSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		if n := NormalizeValue(v); n != "" {
+			set[n] = true
+		}
+	}
+	return set
+}
+
+// NormalizeValue prepares a segment value (company, state, size range) for comparison
+func NormalizeValue(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// NormalizeEmail lowercases and trims an email address
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizePhone converts a phone number to E.164 ("+15551234567"). An extension
+// ("x12", "ext. 12", "#12", ";ext=12") is dropped, as is a trunk prefix: "(0)" in an
+// international number, or the leading 0 of a national one. Numbers without a leading
+// "+" or "00" (or "011" in the North American plan) get defaultCountryCode, e.g. "1",
+// except that a North American number written with its leading 1 already has it.
+// Returns "" when the number is too short or long to be valid, or is national and
+// there is no default country code.
+func NormalizePhone(phone, defaultCountryCode string) string {
+	phone = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(phone)), "tel:")
+	// "x", "ext", "extension", "#" and ";ext=" all start an extension
+	if i := strings.IndexAny(phone, "x#;"); i >= 0 {
+		phone = phone[:i]
+	}
+	phone = strings.ReplaceAll(phone, "(0)", "")
+	defaultCountryCode = strings.TrimPrefix(strings.TrimSpace(defaultCountryCode), "+")
+
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	switch {
+	case strings.HasPrefix(phone, "+"):
+	case strings.HasPrefix(digits, "00"):
+		digits = digits[2:]
+	case defaultCountryCode == "1" && strings.HasPrefix(digits, "011"):
+		digits = digits[3:]
+	case defaultCountryCode == "":
+		return ""
+	case defaultCountryCode == "1" && len(digits) == 11 && digits[0] == '1':
+		// North American trunk prefix, the same digit as the country code
+	default:
+		digits = defaultCountryCode + strings.TrimPrefix(digits, "0")
+	}
+	if len(digits) < 8 || len(digits) > 15 {
+		return ""
+	}
+	return "+" + digits
+}
+
+// companySuffixes are legal-form words dropped from the end of company names
+var companySuffixes = map[string]bool{
+	"inc": true, "incorporated": true, "llc": true, "llp": true, "lp": true, "ltd": true,
+	"limited": true, "corp": true, "corporation": true, "co": true, "company": true,
+	"plc": true, "gmbh": true, "ag": true, "sa": true, "bv": true, "nv": true, "pty": true,
+}
+
+// CanonicalCompany reduces a company name to a comparable form: lowercase, "&" as
+// "and", punctuation removed, and trailing legal suffixes ("Inc.", "LLC", ...) dropped
+func CanonicalCompany(name string) string {
+	name = strings.ToLower(strings.ReplaceAll(name, "&", " and "))
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for len(words) > 1 && companySuffixes[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	if len(words) > 0 && words[0] == "the" && len(words) > 1 {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// HashEmail hashes an email address the way Lead.EmailHash is stored
+func HashEmail(email string) string {
+	return utils.HashContact(NormalizeEmail(email))
+}
+
+// HashPhone hashes a phone number in E.164 form, the way Lead.PhoneHash is stored
+func HashPhone(phone, defaultCountryCode string) string {
+	return utils.HashContact(NormalizePhone(phone, defaultCountryCode))
+}
+
+// HashName hashes "first last", case- and whitespace-insensitively
+func HashName(first, last string) string {
+	return utils.HashContact(NormalizeValue(first + " " + last))
+}
+
+// normalizeHash makes stored hex hashes comparable regardless of case
+func normalizeHash(h string) string {
+	return strings.ToLower(strings.TrimSpace(h))
+}
@@ -0,0 +1,217 @@
+package suppression
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone, country, want string
+	}{
+		{"(555) 123-4567", "1", "+15551234567"},
+		{"555.123.4567", "1", "+15551234567"},
+		{"1-555-123-4567", "1", "+15551234567"},
+		{"+1 555 123 4567", "1", "+15551234567"},
+		{"001 555 123 4567", "1", "+15551234567"},
+
+		// Extensions
+		{"555-123-4567 x89", "1", "+15551234567"},
+		{"555-123-4567 ext. 89", "1", "+15551234567"},
+		{"555-123-4567 Extension 89", "1", "+15551234567"},
+		{"+1 (555) 123-4567 #89", "1", "+15551234567"},
+		{"tel:+1-555-123-4567;ext=89", "1", "+15551234567"},
+
+		// Trunk prefixes
+		{"020 7946 0958", "44", "+442079460958"},
+		{"+44 (0)20 7946 0958", "1", "+442079460958"},
+		{"0044 (0) 20 7946 0958", "1", "+442079460958"},
+		{"030 123456", "+49", "+4930123456"},
+
+		// Country inference
+		{"011 44 20 7946 0958", "1", "+442079460958"},
+		{"+44 20 7946 0958", "49", "+442079460958"},
+		{"20 7946 0958", "44", "+442079460958"},
+		{"555-123-4567", "", ""}, // National, with nowhere to place it
+		{"+1 555 123 4567", "", "+15551234567"},
+
+		// Too short or long
+		{"", "1", ""},
+		{"911", "1", ""},
+		{"+1 234", "1", ""},
+		{"+1234 5678 9012 3456", "1", ""},
+		{"n/a", "1", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizePhone(tt.phone, tt.country); got != tt.want {
+			t.Errorf("NormalizePhone(%q, %q) = %q, want %q", tt.phone, tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalCompany(t *testing.T) {
+	tests := map[string]string{
+		"Acme, Inc.":              "acme",
+		"ACME Incorporated":       "acme",
+		"The Acme Company, LLC":   "acme",
+		"Acme Co. Ltd":            "acme",
+		"AT&T":                    "at and t",
+		"Johnson & Johnson":       "johnson and johnson",
+		"Müller GmbH":             "müller",
+		"  Globex   Corp  ":       "globex",
+		"Limited Brands Limited":  "limited brands",
+		"Inc.":                    "inc",
+		"The":                     "the",
+		"3M Company":              "3m",
+		"Procter-and-Gamble plc.": "procter and gamble",
+		"":                        "",
+		"--":                      "",
+	}
+	for name, want := range tests {
+		if got := CanonicalCompany(name); got != want {
+			t.Errorf("CanonicalCompany(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNormalizeValue(t *testing.T) {
+	tests := map[string]string{
+		" New  York ": "new york",
+		"$1M-$10M":    "$1m-$10m",
+		"\t51-200\n":  "51-200",
+		"   ":         "",
+	}
+	for in, want := range tests {
+		if got := NormalizeValue(in); got != want {
+			t.Errorf("NormalizeValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// Entries must hash exactly as leads' email_hash and phone_hash do, or nothing matches
+func TestHashesMatchLeadHashing(t *testing.T) {
+	tests := []struct {
+		name, got, normalized string
+	}{
+		{"email", HashEmail("  Jane.Doe@Example.COM "), "jane.doe@example.com"},
+		{"phone", HashPhone("(555) 123-4567 x2", "1"), "+15551234567"},
+		{"international phone", HashPhone("+44 (0)20 7946 0958", "1"), "+442079460958"},
+		{"name", HashName(" Jane", "DOE  "), "jane doe"},
+	}
+	for _, tt := range tests {
+		if want := utils.HashContact(tt.normalized); tt.got != want {
+			t.Errorf("%s hash %s, want utils.HashContact(%q) = %s", tt.name, tt.got, tt.normalized, want)
+		}
+	}
+
+	// The shared scheme: hex SHA3-256 of the normalized value
+	if got, want := HashEmail("abc"), "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"; got != want {
+		t.Errorf("HashEmail(abc) = %s, want %s", got, want)
+	}
+	for name, h := range map[string]string{"email": HashEmail(" "), "phone": HashPhone("123", "1"), "name": HashName("", " ")} {
+		if h != "" {
+			t.Errorf("blank %s hashed to %q, want \"\"", name, h)
+		}
+	}
+}
+
+// arrayConverter passes []string parameters through, as pgx takes them for text[]
+type arrayConverter struct{}
+
+func (arrayConverter) ConvertValue(v any) (driver.Value, error) {
+	if values, ok := v.([]string); ok {
+		return values, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func newMockQueries(t *testing.T) (*queries.Queries, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+	return queries.New(conn), mock
+}
+
+func queryName(name string) string {
+	return `-- name: ` + name + ` `
+}
+
+func TestListMatch(t *testing.T) {
+	q, mock := newMockQueries(t)
+	tenantID, listID := uuid.New(), uuid.New()
+
+	email, phone := HashEmail("jane@example.com"), HashPhone("555-123-4567", "1")
+	name := HashName("John", "Smith")
+	leads := []payload.Lead{
+		{Email: email},
+		{Phone: phone},
+		{FirstName: "JOHN", LastName: "smith"},
+	}
+
+	mock.ExpectQuery(queryName("ListSuppressionEntriesMatching")).
+		WithArgs(listID, tenantID, []string{email}, []string{phone}, []string{name}).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "suppression_list_id", "name_hash", "email_hash", "phone_hash", "created_at", "updated_at"}).
+			// Stored hashes compare regardless of case
+			AddRow(uuid.New(), listID, nil, " "+strings.ToUpper(email), nil, nil, nil).
+			AddRow(uuid.New(), listID, nil, nil, phone, nil, nil).
+			AddRow(uuid.New(), listID, name, nil, nil, nil, nil))
+	for query, values := range map[string][]string{
+		"ListSuppressionCompanies":     {"Acme, Inc.", ""},
+		"ListSuppressionStates":        {" New  York "},
+		"ListSuppressionRevenueSizes":  {"$1M-$10M"},
+		"ListSuppressionEmployeeSizes": {"51-200", "  "},
+	} {
+		rows := sqlmock.NewRows([]string{"value"})
+		for _, v := range values {
+			rows.AddRow(v)
+		}
+		mock.ExpectQuery(queryName(query)).WithArgs(listID, tenantID).WillReturnRows(rows)
+	}
+	mock.MatchExpectationsInOrder(false)
+
+	list, err := Load(context.Background(), q, tenantID, listID, leads)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		lead payload.Lead
+		want string
+	}{
+		{name: "email", lead: payload.Lead{Email: strings.ToUpper(email), CompanyName: "Acme"}, want: ReasonEmail},
+		{name: "phone", lead: payload.Lead{Email: HashEmail("other@example.com"), Phone: phone}, want: ReasonPhone},
+		{name: "name", lead: payload.Lead{FirstName: "John", LastName: "Smith"}, want: ReasonName},
+		{name: "company", lead: payload.Lead{CompanyName: "ACME Incorporated"}, want: ReasonCompany},
+		{name: "state", lead: payload.Lead{State: "new york"}, want: ReasonState},
+		{name: "revenue size", lead: payload.Lead{RevenueSize: "$1m-$10m"}, want: ReasonRevenueSize},
+		{name: "employee size", lead: payload.Lead{EmployeeSize: " 51-200 "}, want: ReasonEmployeeSize},
+		{name: "clear", lead: payload.Lead{FirstName: "Jane", LastName: "Smith", CompanyName: "Acme Labs", State: "NJ"}},
+		// Blank fields never match, even though the list holds blank values
+		{name: "blank"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := list.Match(&tt.lead); got != tt.want {
+				t.Errorf("Match = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
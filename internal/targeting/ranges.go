@@ -0,0 +1,152 @@
+package targeting
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Range is an inclusive numeric range; Max is +Inf for open-ended ranges ("1000+")
+type Range struct {
+	Min float64
+	Max float64
+}
+
+// Contains reports whether o lies entirely inside r
+func (r Range) Contains(o Range) bool {
+	return o.Min >= r.Min && o.Max <= r.Max
+}
+
+// multipliers are the magnitude suffixes accepted after a number
+var multipliers = map[string]float64{
+	"": 1, "k": 1e3, "thousand": 1e3,
+	"m": 1e6, "mm": 1e6, "mil": 1e6, "million": 1e6,
+	"b": 1e9, "bn": 1e9, "billion": 1e9,
+	"t": 1e12, "trillion": 1e12,
+}
+
+// ParseRange parses revenue and employee-size strings such as "$10M-$50M",
+// "$500K - $1M", "10 to 50 million", "51-200", "10,001+", "$1B+", "<$1M", "Under 50"
+// and single values ("250", "$25M"). A suffix on only the upper bound applies to both
+// ("$10-50M" is $10M to $50M).
+func ParseRange(s string) (Range, error) {
+	text := strings.ToLower(strings.TrimSpace(s))
+	if text == "" {
+		return Range{}, fmt.Errorf("empty range")
+	}
+	text = strings.NewReplacer("$", "", "€", "", "£", "", "usd", "", ",", "", "–", "-", "—", "-").Replace(text)
+
+	switch {
+	case strings.HasSuffix(text, "+"):
+		n, _, err := parseAmount(strings.TrimSuffix(text, "+"))
+		if err != nil {
+			return Range{}, fmt.Errorf("range %q: %w", s, err)
+		}
+		return Range{Min: n, Max: math.Inf(1)}, nil
+	case hasAnyPrefix(text, ">=", ">", "over ", "more than ", "greater than ", "above "):
+		n, _, err := parseAmount(trimAnyPrefix(text, ">=", ">", "over ", "more than ", "greater than ", "above "))
+		if err != nil {
+			return Range{}, fmt.Errorf("range %q: %w", s, err)
+		}
+		return Range{Min: n, Max: math.Inf(1)}, nil
+	case hasAnyPrefix(text, "<=", "<", "under ", "less than ", "below ", "up to "):
+		n, _, err := parseAmount(trimAnyPrefix(text, "<=", "<", "under ", "less than ", "below ", "up to "))
+		if err != nil {
+			return Range{}, fmt.Errorf("range %q: %w", s, err)
+		}
+		return Range{Min: 0, Max: n}, nil
+	}
+
+	lower, upper, ok := strings.Cut(text, "-")
+	if !ok {
+		lower, upper, ok = strings.Cut(text, " to ")
+	}
+	if !ok {
+		n, _, err := parseAmount(text)
+		if err != nil {
+			return Range{}, fmt.Errorf("range %q: %w", s, err)
+		}
+		return Range{Min: n, Max: n}, nil
+	}
+
+	lo, loSuffix, err := parseAmount(lower)
+	if err != nil {
+		return Range{}, fmt.Errorf("range %q: %w", s, err)
+	}
+	hi, hiSuffix, err := parseAmount(upper)
+	if err != nil {
+		return Range{}, fmt.Errorf("range %q: %w", s, err)
+	}
+	if loSuffix == "" && hiSuffix != "" {
+		lo *= multipliers[hiSuffix]
+	}
+	if lo > hi {
+		return Range{}, fmt.Errorf("range %q: lower bound exceeds upper bound", s)
+	}
+	return Range{Min: lo, Max: hi}, nil
+}
+
+// parseAmount parses "10", "10.5m", "10 million" and returns the value with its
+// multiplier applied, plus the suffix used
+func parseAmount(s string) (float64, string, error) {
+	s = strings.TrimSpace(s)
+	end := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if end == -1 {
+		end = len(s)
+	}
+	if end == 0 {
+		return 0, "", fmt.Errorf("no number in %q", s)
+	}
+	n, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil {
+		return 0, "", err
+	}
+	suffix := strings.TrimSpace(s[end:])
+	suffix = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(suffix, "employees"), "revenue"))
+	mult, ok := multipliers[suffix]
+	if !ok {
+		return 0, "", fmt.Errorf("unknown suffix %q", suffix)
+	}
+	return n * mult, suffix, nil
+}
+
+// mergeRanges sorts ranges and joins overlapping or adjacent ones, so a lead range
+// spanning two contiguous criteria ("51-200" and "201-500") still fits
+func mergeRanges(ranges []Range) []Range {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := append([]Range(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Min < sorted[j].Min })
+	merged := []Range{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.Min <= last.Max+1 {
+			last.Max = math.Max(last.Max, r.Max)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func trimAnyPrefix(s string, prefixes ...string) string {
+	for _, p := range prefixes {
+		if rest, ok := strings.CutPrefix(s, p); ok {
+			return strings.TrimSpace(rest)
+		}
+	}
+	return s
+}
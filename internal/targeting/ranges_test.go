@@ -0,0 +1,102 @@
+package targeting
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+var inf = math.Inf(1)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Range
+	}{
+		{"51-200", Range{51, 200}},
+		{"10,001+", Range{10001, inf}},
+		{"$10M-$50M", Range{10e6, 50e6}},
+		{"$500K - $1M", Range{500e3, 1e6}},
+		{"$10-50M", Range{10e6, 50e6}}, // The upper bound's suffix applies to both
+		{"10 to 50 million", Range{10e6, 50e6}},
+		{"$1B+", Range{1e9, inf}},
+		{"1.5bn+", Range{1.5e9, inf}},
+		{"<$1M", Range{0, 1e6}},
+		{"Under 50", Range{0, 50}},
+		{"up to 10 employees", Range{0, 10}},
+		{"Over $5M", Range{5e6, inf}},
+		{">= 1000", Range{1000, inf}},
+		{"250", Range{250, 250}},
+		{"$25M", Range{25e6, 25e6}},
+		{"€2m–€5m", Range{2e6, 5e6}},
+		{"USD 100K", Range{100e3, 100e3}},
+		{"  1k-5k  ", Range{1e3, 5e3}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRange(tt.in)
+		if err != nil {
+			t.Errorf("ParseRange(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRange(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "   ", "lots", "$", "50-10", "10 zillion", "-5", "1.2.3", "+"} {
+		if got, err := ParseRange(in); err == nil {
+			t.Errorf("ParseRange(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestRangeContains(t *testing.T) {
+	r := Range{51, 200}
+	tests := []struct {
+		o    Range
+		want bool
+	}{
+		{Range{51, 200}, true},
+		{Range{100, 100}, true},
+		{Range{60, 150}, true},
+		{Range{50, 200}, false},
+		{Range{51, 201}, false},
+		{Range{1, 10}, false},
+		{Range{150, inf}, false},
+	}
+	for _, tt := range tests {
+		if got := r.Contains(tt.o); got != tt.want {
+			t.Errorf("%v.Contains(%v) = %v, want %v", r, tt.o, got, tt.want)
+		}
+	}
+	if !(Range{1000, inf}).Contains(Range{5000, inf}) {
+		t.Error("an open range doesn't contain a narrower open range")
+	}
+}
+
+func TestMergeRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []Range
+		want []Range
+	}{
+		{name: "none", in: nil, want: nil},
+		{name: "one", in: []Range{{1, 10}}, want: []Range{{1, 10}}},
+		{name: "adjacent", in: []Range{{201, 500}, {51, 200}}, want: []Range{{51, 500}}},
+		{name: "overlapping", in: []Range{{1, 100}, {50, 150}}, want: []Range{{1, 150}}},
+		{name: "contained", in: []Range{{1, 1000}, {10, 20}}, want: []Range{{1, 1000}}},
+		{name: "gap", in: []Range{{1, 10}, {50, 100}}, want: []Range{{1, 10}, {50, 100}}},
+		{name: "open ended", in: []Range{{1001, inf}, {501, 1000}, {1, 50}}, want: []Range{{1, 50}, {501, inf}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := append([]Range(nil), tt.in...)
+			if got := mergeRanges(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeRanges(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+			if !reflect.DeepEqual(in, tt.in) {
+				t.Errorf("mergeRanges changed its input to %v", tt.in)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+// Package targeting checks leads against the criteria a buyer bought a campaign on:
+// industries, geo, titles, company revenue and employee size.
+//
+// Criteria are stored as campaigns.targeting_criteria:
+//
+//	{
+//	  "industries": ["Information Technology", "Financial Services"],
+//	  "geo": {"scope": "region", "regions": ["north_america"], "countries": ["GB"], "states": ["CA", "New York"]},
+//	  "titles": ["VP Marketing", "CMO"],
+//	  "revenue_ranges": ["$10M-$50M", "$1B+"],
+//	  "employee_size_ranges": ["51-200", "201-500"],
+//	  "allow_missing": false
+//	}
+//
+// Empty sections don't restrict. A lead with no value for a restricted field fails it
+// unless allow_missing is set.
+package targeting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DylanCoon99/delivery/internal/geo"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+// ErrInvalidCriteria means a campaign's targeting criteria can't be parsed
+var ErrInvalidCriteria = errors.New("invalid targeting criteria")
+
+// Fields a lead can fail on, in the order they are checked
+const (
+	FieldIndustry     = "industry"
+	FieldCountry      = "country"
+	FieldState        = "state"
+	FieldTitle        = "title"
+	FieldRevenueSize  = "revenue_size"
+	FieldEmployeeSize = "employee_size"
+)
+
+// Why a field failed
+const (
+	ProblemMissing     = "missing"
+	ProblemNotTargeted = "not targeted"
+	ProblemUnparseable = "unparseable"
+)
+
+// ScopeGlobal and ScopeRegion are the values of Geo.Scope
+const (
+	ScopeGlobal = "global"
+	ScopeRegion = "region"
+)
+
+// otherIndustries is the catch-all entry of StandardIndustries
+const otherIndustries = "Other industries"
+
+// StandardIndustries is the industry list campaigns are created from
+var StandardIndustries = []string{
+	"Aerospace/Aviation",
+	"Automotive",
+	"Biotech, Medical & Pharmaceuticals",
+	"Commercial Vehicles",
+	"Communications",
+	"Consumer Product Manufacturing",
+	"Defense",
+	"Design & Engineering Firm",
+	"Early Education/Academic",
+	"Energy & Utilities",
+	"Financial Services",
+	"Government",
+	"Healthcare (Hospital Systems)",
+	"Healthcare (Non-Hospital Systems)",
+	"Higher Education/Academic (University)",
+	"Industrial Machinery & Equipment",
+	"Information Technology",
+	"Insurance",
+	"Legal",
+	"Materials/Chemicals",
+	"Manufacturing",
+	"Retail / Hospitality / Consumer Goods",
+	"Research & Lab",
+	"Service Industry",
+	"Telecommunications",
+	"Transportation & Logistics",
+	"Wholesalers / Distributors",
+	otherIndustries,
+}
+
+// Criteria is the stored form of a campaign's targeting
+type Criteria struct {
+	Industries         []string `json:"industries,omitempty"`
+	Geo                Geo      `json:"geo"`
+	Titles             []string `json:"titles,omitempty"`
+	RevenueRanges      []string `json:"revenue_ranges,omitempty"`
+	EmployeeSizeRanges []string `json:"employee_size_ranges,omitempty"`
+	AllowMissing       bool     `json:"allow_missing,omitempty"`
+}
+
+// Geo restricts leads by location. Countries and regions (see geo.Region) combine;
+// States apply to US leads only.
+type Geo struct {
+	Scope     string   `json:"scope,omitempty"`
+	Regions   []string `json:"regions,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+	States    []string `json:"states,omitempty"`
+}
+
+// Mismatch is one reason a lead doesn't meet the criteria
+type Mismatch struct {
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+	Value   string `json:"value,omitempty"`
+}
+
+func (m Mismatch) String() string {
+	if m.Value == "" {
+		return m.Field + " " + m.Problem
+	}
+	return fmt.Sprintf("%s %s: %q", m.Field, m.Problem, m.Value)
+}
+
+// Filter is compiled criteria, ready to evaluate leads
+type Filter struct {
+	industries    []industryMatcher
+	otherIndustry bool
+	countries     map[string]bool
+	states        map[string]bool
+	titles        []titleMatcher
+	revenue       []Range
+	employeeSize  []Range
+	allowMissing  bool
+}
+
+// Parse compiles stored criteria. It returns nil when raw is empty or JSON null, which
+// means the campaign has no targeting.
+func Parse(raw []byte) (*Filter, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var c Criteria
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCriteria, err)
+	}
+	return Compile(c)
+}
+
+// Compile validates criteria and prepares them for matching. It returns nil when no
+// section restricts anything.
+func Compile(c Criteria) (*Filter, error) {
+	f := &Filter{allowMissing: c.AllowMissing}
+
+	for _, industry := range c.Industries {
+		if strings.EqualFold(strings.TrimSpace(industry), otherIndustries) {
+			f.otherIndustry = true
+			continue
+		}
+		if m := newIndustryMatcher(industry); len(m.tokens) > 0 {
+			f.industries = append(f.industries, m)
+		}
+	}
+
+	switch strings.ToLower(c.Geo.Scope) {
+	case "", ScopeGlobal, ScopeRegion:
+	default:
+		return nil, fmt.Errorf("%w: unknown geo scope %q", ErrInvalidCriteria, c.Geo.Scope)
+	}
+	if !strings.EqualFold(c.Geo.Scope, ScopeGlobal) {
+		for _, name := range c.Geo.Regions {
+			codes, ok := geo.Region(name)
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown region %q", ErrInvalidCriteria, name)
+			}
+			for _, code := range codes {
+				f.addCountry(code)
+			}
+		}
+		for _, code := range c.Geo.Countries {
+			f.addCountry(geo.NormalizeCountry(code))
+		}
+		for _, state := range c.Geo.States {
+			if f.states == nil {
+				f.states = make(map[string]bool)
+			}
+			f.states[geo.NormalizeState(state)] = true
+		}
+	}
+
+	for _, title := range c.Titles {
+		if m := newTitleMatcher(title); len(m.tokens) > 0 {
+			f.titles = append(f.titles, m)
+		}
+	}
+
+	var err error
+	if f.revenue, err = parseRanges(c.RevenueRanges); err != nil {
+		return nil, fmt.Errorf("%w: revenue: %v", ErrInvalidCriteria, err)
+	}
+	if f.employeeSize, err = parseRanges(c.EmployeeSizeRanges); err != nil {
+		return nil, fmt.Errorf("%w: employee size: %v", ErrInvalidCriteria, err)
+	}
+
+	if len(f.industries) == 0 && !f.otherIndustry && f.countries == nil && f.states == nil &&
+		len(f.titles) == 0 && len(f.revenue) == 0 && len(f.employeeSize) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func (f *Filter) addCountry(code string) {
+	if code == "" {
+		return
+	}
+	if f.countries == nil {
+		f.countries = make(map[string]bool)
+	}
+	f.countries[code] = true
+}
+
+func parseRanges(values []string) ([]Range, error) {
+	var ranges []Range
+	for _, v := range values {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		r, err := ParseRange(v)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return mergeRanges(ranges), nil
+}
+
+// Evaluate returns every way the lead misses the criteria; none means it matches
+func (f *Filter) Evaluate(lead *payload.Lead) []Mismatch {
+	var misses []Mismatch
+	miss := func(field, problem, value string) {
+		misses = append(misses, Mismatch{Field: field, Problem: problem, Value: value})
+	}
+	missing := func(field string) {
+		if !f.allowMissing {
+			miss(field, ProblemMissing, "")
+		}
+	}
+
+	if len(f.industries) > 0 || f.otherIndustry {
+		switch industry := strings.TrimSpace(lead.Industry); {
+		case industry == "":
+			missing(FieldIndustry)
+		case !f.matchIndustry(industry):
+			miss(FieldIndustry, ProblemNotTargeted, industry)
+		}
+	}
+
+	country := geo.NormalizeCountry(lead.CountryCode)
+	state := strings.TrimSpace(lead.State)
+	if f.countries != nil {
+		switch {
+		case country == "":
+			missing(FieldCountry)
+		case !f.countries[country]:
+			miss(FieldCountry, ProblemNotTargeted, country)
+		}
+	}
+	if f.states != nil && (country == "" || country == "US") {
+		switch {
+		case state == "":
+			missing(FieldState)
+		case !f.states[geo.NormalizeState(state)]:
+			miss(FieldState, ProblemNotTargeted, state)
+		}
+	}
+
+	if len(f.titles) > 0 {
+		switch title := strings.TrimSpace(lead.Title); {
+		case title == "":
+			missing(FieldTitle)
+		case !f.matchTitle(title):
+			miss(FieldTitle, ProblemNotTargeted, title)
+		}
+	}
+
+	f.checkRange(f.revenue, FieldRevenueSize, lead.RevenueSize, miss, missing)
+	f.checkRange(f.employeeSize, FieldEmployeeSize, lead.EmployeeSize, miss, missing)
+	return misses
+}
+
+// checkRange passes a lead whose value, itself often a range, lies inside one of ranges
+func (f *Filter) checkRange(ranges []Range, field, value string, miss func(field, problem, value string), missing func(field string)) {
+	if len(ranges) == 0 {
+		return
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		missing(field)
+		return
+	}
+	r, err := ParseRange(value)
+	if err != nil {
+		miss(field, ProblemUnparseable, value)
+		return
+	}
+	for _, allowed := range ranges {
+		if allowed.Contains(r) {
+			return
+		}
+	}
+	miss(field, ProblemNotTargeted, value)
+}
+
+func (f *Filter) matchTitle(title string) bool {
+	tokens := titleTokens(title)
+	for _, m := range f.titles {
+		if m.matches(tokens) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchIndustry(industry string) bool {
+	lead := newIndustryMatcher(industry)
+	for _, m := range f.industries {
+		if m.matches(lead) {
+			return true
+		}
+	}
+	// "Other industries" takes whatever none of the named industries describe
+	return f.otherIndustry && !isStandardIndustry(lead)
+}
+
+var standardIndustryMatchers []industryMatcher
+
+func init() {
+	for _, industry := range StandardIndustries {
+		if industry != otherIndustries {
+			standardIndustryMatchers = append(standardIndustryMatchers, newIndustryMatcher(industry))
+		}
+	}
+}
+
+// isStandardIndustry reports whether a lead's industry is, or is part of, one of the
+// named industries; "Healthcare" is neither hospital nor non-hospital but still isn't
+// another industry
+func isStandardIndustry(lead industryMatcher) bool {
+	for _, m := range standardIndustryMatchers {
+		if m.related(lead) {
+			return true
+		}
+	}
+	return false
+}
+
+// industryNoise are words industry names use without narrowing them
+var industryNoise = map[string]bool{"systems": true, "firm": true, "firms": true}
+
+// industryMatcher holds an industry's meaningful words, plus each adjacent pair joined
+// ("health care" also yields "healthcare") so spacing differences don't matter
+type industryMatcher struct {
+	tokens   []string
+	expanded map[string]bool
+}
+
+func newIndustryMatcher(industry string) industryMatcher {
+	m := industryMatcher{expanded: make(map[string]bool)}
+	negate := false
+	for _, w := range words(strings.ReplaceAll(industry, "&", " and ")) {
+		switch {
+		case w == "non":
+			// "Non-Hospital" must not match "Hospital"
+			negate = true
+		case fillerWords[w] || industryNoise[w]:
+		case negate:
+			m.tokens = append(m.tokens, "non"+singular(w))
+			negate = false
+		default:
+			m.tokens = append(m.tokens, singular(w))
+		}
+	}
+	for i, t := range m.tokens {
+		m.expanded[t] = true
+		if i > 0 {
+			m.expanded[m.tokens[i-1]+t] = true
+		}
+	}
+	return m
+}
+
+// matches reports whether a lead's industry covers every word of m, the campaign's
+// industry, so a lead labelled "Information Technology & Services" matches
+// "Information Technology" but a lead labelled "Services" doesn't match "Financial
+// Services". Two of m's words may be covered by the lead's one joined word.
+func (m industryMatcher) matches(lead industryMatcher) bool {
+	if len(m.tokens) == 0 || len(lead.tokens) == 0 {
+		return false
+	}
+	for i := 0; i < len(m.tokens); i++ {
+		switch {
+		case lead.expanded[m.tokens[i]]:
+		case i+1 < len(m.tokens) && lead.expanded[m.tokens[i]+m.tokens[i+1]]:
+			i++
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// related reports whether either industry covers the other
+func (m industryMatcher) related(other industryMatcher) bool {
+	return m.matches(other) || other.matches(m)
+}
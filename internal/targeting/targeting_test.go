@@ -0,0 +1,229 @@
+package targeting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+func mustParse(t *testing.T, raw string) *Filter {
+	t.Helper()
+	f, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse(%s): %v", raw, err)
+	}
+	return f
+}
+
+func TestParseNoTargeting(t *testing.T) {
+	for _, raw := range []string{"", "null", "{}", `{"geo": {"scope": "global", "countries": ["US"]}}`, `{"industries": [" "], "revenue_ranges": [""]}`} {
+		if f := mustParse(t, raw); f != nil {
+			t.Errorf("Parse(%s) = %+v, want no filter", raw, f)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, raw := range []string{
+		`{`,
+		`{"industries": "IT"}`,
+		`{"geo": {"scope": "planet"}}`,
+		`{"geo": {"regions": ["atlantis"]}}`,
+		`{"revenue_ranges": ["lots"]}`,
+		`{"employee_size_ranges": ["500-50"]}`,
+	} {
+		if _, err := Parse([]byte(raw)); !errors.Is(err, ErrInvalidCriteria) {
+			t.Errorf("Parse(%s) error = %v, want ErrInvalidCriteria", raw, err)
+		}
+	}
+}
+
+func TestIndustryMatcher(t *testing.T) {
+	tests := []struct {
+		campaign, lead string
+		want           bool
+	}{
+		{"Information Technology", "Information Technology", true},
+		{"Information Technology", "Information Technology & Services", true},
+		{"Financial Services", "financial service", true},
+		{"Healthcare (Hospital Systems)", "Health Care - Hospitals", true},
+		{"Health Care", "Healthcare", true},
+		{"Transportation & Logistics", "Logistics and Transportation", true},
+
+		// The lead must cover the campaign's industry, not the other way round
+		{"Financial Services", "Services", false},
+		{"Healthcare (Hospital Systems)", "Healthcare", false},
+		{"Information Technology & Services", "Information Technology", false},
+		{"Service Industry", "Financial Services", false},
+
+		{"Healthcare (Non-Hospital Systems)", "Healthcare Hospital", false},
+		{"Healthcare (Hospital Systems)", "Healthcare (Non-Hospital)", false},
+		{"Healthcare (Non-Hospital Systems)", "Non-Hospital Healthcare", true},
+		{"Legal", "Insurance", false},
+		{"Legal", "", false},
+	}
+	for _, tt := range tests {
+		if got := newIndustryMatcher(tt.campaign).matches(newIndustryMatcher(tt.lead)); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.campaign, tt.lead, got, tt.want)
+		}
+	}
+}
+
+func TestOtherIndustries(t *testing.T) {
+	f := mustParse(t, `{"industries": ["Legal", "other industries"]}`)
+	tests := []struct {
+		industry string
+		want     bool
+	}{
+		{"Legal Services", true},
+		{"Agriculture", true},
+		{"Mining & Quarrying", true},
+		// Named industries, or parts of them, aren't "other"
+		{"Insurance", false},
+		{"Information Technology & Services", false},
+		{"Healthcare", false},
+		{"Services", false},
+	}
+	for _, tt := range tests {
+		misses := f.Evaluate(&payload.Lead{Industry: tt.industry})
+		if got := len(misses) == 0; got != tt.want {
+			t.Errorf("industry %q matched = %v, want %v (%v)", tt.industry, got, tt.want, misses)
+		}
+	}
+}
+
+func TestGeo(t *testing.T) {
+	f := mustParse(t, `{"geo": {"scope": "region", "regions": ["dach", "North America"], "countries": ["uk"], "states": ["california", "NY"]}}`)
+	tests := []struct {
+		name string
+		lead payload.Lead
+		want []Mismatch
+	}{
+		{name: "region country", lead: payload.Lead{CountryCode: "AT"}},
+		{name: "region country, any state", lead: payload.Lead{CountryCode: "DE", State: "Bavaria"}},
+		{name: "listed country alias", lead: payload.Lead{CountryCode: "GBR"}},
+		{name: "targeted state by name", lead: payload.Lead{CountryCode: "usa", State: "New York"}},
+		{name: "targeted state by code", lead: payload.Lead{CountryCode: "US", State: "ca"}},
+		// States apply to US leads only
+		{name: "non-US state ignored", lead: payload.Lead{CountryCode: "CA", State: "Ontario"}},
+		{name: "non-US without state", lead: payload.Lead{CountryCode: "MX"}},
+		{
+			name: "untargeted state",
+			lead: payload.Lead{CountryCode: "US", State: "Texas"},
+			want: []Mismatch{{Field: FieldState, Problem: ProblemNotTargeted, Value: "Texas"}},
+		},
+		{
+			name: "untargeted country",
+			lead: payload.Lead{CountryCode: "fr"},
+			want: []Mismatch{{Field: FieldCountry, Problem: ProblemNotTargeted, Value: "FR"}},
+		},
+		{
+			name: "US lead without state",
+			lead: payload.Lead{CountryCode: "US"},
+			want: []Mismatch{{Field: FieldState, Problem: ProblemMissing}},
+		},
+		{
+			name: "no location",
+			lead: payload.Lead{},
+			want: []Mismatch{{Field: FieldCountry, Problem: ProblemMissing}, {Field: FieldState, Problem: ProblemMissing}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Evaluate(&tt.lead); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegionExpansion(t *testing.T) {
+	f := mustParse(t, `{"geo": {"regions": ["emea"]}}`)
+	for _, code := range []string{"GB", "DE", "AE", "ZA", "EG"} {
+		if !f.countries[code] {
+			t.Errorf("emea is missing %s", code)
+		}
+	}
+	for _, code := range []string{"US", "JP", "BR"} {
+		if f.countries[code] {
+			t.Errorf("emea includes %s", code)
+		}
+	}
+}
+
+func TestRanges(t *testing.T) {
+	f := mustParse(t, `{"revenue_ranges": ["$10M-$50M", "$50M-$100M"], "employee_size_ranges": ["51-200", "201-500"]}`)
+	tests := []struct {
+		name string
+		lead payload.Lead
+		want []Mismatch
+	}{
+		// Contiguous criteria merge, so a lead spanning both fits
+		{name: "spans merged ranges", lead: payload.Lead{RevenueSize: "$20M - $80M", EmployeeSize: "100-300"}},
+		{name: "single values", lead: payload.Lead{RevenueSize: "$10M", EmployeeSize: "500"}},
+		{
+			name: "outside",
+			lead: payload.Lead{RevenueSize: "$5M-$20M", EmployeeSize: "1,001+"},
+			want: []Mismatch{
+				{Field: FieldRevenueSize, Problem: ProblemNotTargeted, Value: "$5M-$20M"},
+				{Field: FieldEmployeeSize, Problem: ProblemNotTargeted, Value: "1,001+"},
+			},
+		},
+		{
+			name: "unparseable",
+			lead: payload.Lead{RevenueSize: "unknown", EmployeeSize: "100"},
+			want: []Mismatch{{Field: FieldRevenueSize, Problem: ProblemUnparseable, Value: "unknown"}},
+		},
+		{
+			name: "missing",
+			lead: payload.Lead{EmployeeSize: "100"},
+			want: []Mismatch{{Field: FieldRevenueSize, Problem: ProblemMissing}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Evaluate(&tt.lead); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowMissing(t *testing.T) {
+	criteria := `"industries": ["Legal"], "geo": {"countries": ["US"], "states": ["NY"]}, "titles": ["CEO"], "revenue_ranges": ["$1M+"], "employee_size_ranges": ["1-50"]`
+	strict := mustParse(t, `{`+criteria+`}`)
+	lenient := mustParse(t, `{`+criteria+`, "allow_missing": true}`)
+
+	empty := payload.Lead{}
+	want := []Mismatch{
+		{Field: FieldIndustry, Problem: ProblemMissing},
+		{Field: FieldCountry, Problem: ProblemMissing},
+		{Field: FieldState, Problem: ProblemMissing},
+		{Field: FieldTitle, Problem: ProblemMissing},
+		{Field: FieldRevenueSize, Problem: ProblemMissing},
+		{Field: FieldEmployeeSize, Problem: ProblemMissing},
+	}
+	if got := strict.Evaluate(&empty); !reflect.DeepEqual(got, want) {
+		t.Errorf("strict Evaluate = %v, want %v", got, want)
+	}
+	if got := lenient.Evaluate(&empty); len(got) != 0 {
+		t.Errorf("allow_missing Evaluate = %v, want no misses", got)
+	}
+
+	// allow_missing only forgives blank fields
+	wrong := payload.Lead{Industry: "Insurance", CountryCode: "US", State: "NY", Title: "CEO"}
+	if got := lenient.Evaluate(&wrong); !reflect.DeepEqual(got, []Mismatch{{Field: FieldIndustry, Problem: ProblemNotTargeted, Value: "Insurance"}}) {
+		t.Errorf("allow_missing Evaluate = %v, want the industry miss", got)
+	}
+}
+
+func TestMismatchString(t *testing.T) {
+	if got := (Mismatch{Field: FieldTitle, Problem: ProblemMissing}).String(); got != "title missing" {
+		t.Errorf("String = %q", got)
+	}
+	if got := (Mismatch{Field: FieldCountry, Problem: ProblemNotTargeted, Value: "FR"}).String(); got != `country not targeted: "FR"` {
+		t.Errorf("String = %q", got)
+	}
+}
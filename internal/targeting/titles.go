@@ -0,0 +1,162 @@
+package targeting
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// titleAbbreviations expands common job-title shorthand so "VP Mktg" and
+// "Vice President, Marketing" compare equal
+var titleAbbreviations = map[string]string{
+	"ceo":     "chief executive officer",
+	"cfo":     "chief financial officer",
+	"coo":     "chief operating officer",
+	"cto":     "chief technology officer",
+	"cio":     "chief information officer",
+	"ciso":    "chief information security officer",
+	"cmo":     "chief marketing officer",
+	"cro":     "chief revenue officer",
+	"cso":     "chief security officer",
+	"chro":    "chief human resources officer",
+	"evp":     "executive vice president",
+	"svp":     "senior vice president",
+	"avp":     "assistant vice president",
+	"vp":      "vice president",
+	"gm":      "general manager",
+	"md":      "managing director",
+	"dir":     "director",
+	"mgr":     "manager",
+	"sr":      "senior",
+	"snr":     "senior",
+	"jr":      "junior",
+	"asst":    "assistant",
+	"assoc":   "associate",
+	"eng":     "engineering",
+	"engr":    "engineer",
+	"mktg":    "marketing",
+	"ops":     "operations",
+	"it":      "information technology",
+	"hr":      "human resources",
+	"infosec": "information security",
+	"pres":    "president",
+}
+
+// fillerWords carry no meaning when comparing titles or industries
+var fillerWords = map[string]bool{
+	"of": true, "and": true, "the": true, "for": true, "in": true, "to": true, "a": true,
+}
+
+// seniorityQualifiers rank a title below the same title without them; a lead's title
+// may only carry one the campaign's title has too, so "President" doesn't take "Vice
+// President" nor "Director" take "Associate Director"
+var seniorityQualifiers = map[string]bool{
+	"vice": true, "assistant": true, "associate": true, "deputy": true, "junior": true,
+}
+
+// titleMatcher matches lead titles against one campaign title
+type titleMatcher struct {
+	title  string
+	tokens []string
+}
+
+func newTitleMatcher(title string) titleMatcher {
+	return titleMatcher{title: title, tokens: titleTokens(title)}
+}
+
+// matches reports whether every word of the campaign title appears in the lead's
+// title, after expanding abbreviations, ignoring filler words and plurals, and
+// tolerating a one-letter typo in words of five letters or more. A lead title with a
+// seniority qualifier the campaign title lacks doesn't match.
+func (m titleMatcher) matches(leadTokens []string) bool {
+	if len(m.tokens) == 0 {
+		return false
+	}
+	for _, have := range leadTokens {
+		if seniorityQualifiers[have] && !slices.Contains(m.tokens, have) {
+			return false
+		}
+	}
+	for _, want := range m.tokens {
+		found := false
+		for _, have := range leadTokens {
+			if similarWords(want, have) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// titleTokens lowercases, splits on punctuation, expands abbreviations and drops filler
+func titleTokens(title string) []string {
+	var tokens []string
+	// Dotted acronyms ("I.T.", "V.P.") become words before splitting
+	title = strings.NewReplacer(".", "", "&", " and ").Replace(title)
+	for _, word := range words(title) {
+		if expanded, ok := titleAbbreviations[word]; ok {
+			tokens = append(tokens, strings.Fields(expanded)...)
+			continue
+		}
+		if !fillerWords[word] {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func similarWords(a, b string) bool {
+	a, b = singular(a), singular(b)
+	if a == b {
+		return true
+	}
+	if len(a) < 5 || len(b) < 5 {
+		return false
+	}
+	return withinOneEdit(a, b)
+}
+
+func singular(w string) string {
+	if len(w) > 3 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") {
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// withinOneEdit reports whether a and b differ by at most one insertion, deletion
+// or substitution
+func withinOneEdit(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(b)-len(a) > 1 {
+		return false
+	}
+	i, j, edits := 0, 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			i++
+			j++
+			continue
+		}
+		edits++
+		if edits > 1 {
+			return false
+		}
+		if len(a) == len(b) {
+			i++
+		}
+		j++
+	}
+	return edits+(len(b)-j)+(len(a)-i) <= 1
+}
@@ -0,0 +1,85 @@
+package targeting
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTitleTokens(t *testing.T) {
+	tests := map[string][]string{
+		"VP Mktg":                       {"vice", "president", "marketing"},
+		"Vice President, Marketing":     {"vice", "president", "marketing"},
+		"V.P. of Sales & Ops":           {"vice", "president", "sales", "operations"},
+		"Head of I.T.":                  {"head", "information", "technology"},
+		"Sr. Director, Engineering":     {"senior", "director", "engineering"},
+		"":                              nil,
+		"the of and":                    nil,
+		"Chief Executive Officer (CEO)": {"chief", "executive", "officer", "chief", "executive", "officer"},
+	}
+	for title, want := range tests {
+		if got := titleTokens(title); !reflect.DeepEqual(got, want) {
+			t.Errorf("titleTokens(%q) = %q, want %q", title, got, want)
+		}
+	}
+}
+
+func TestTitleMatcher(t *testing.T) {
+	tests := []struct {
+		campaign, lead string
+		want           bool
+	}{
+		{"VP Marketing", "Vice President of Marketing", true},
+		{"VP Marketing", "Vice President, Marketing & Communications", true},
+		{"CMO", "Chief Marketing Officer", true},
+		{"Director of Engineering", "Engineering Directors", true},
+		{"Marketing Manager", "Markting Manager", true}, // One-letter typo
+		{"Marketing Manager", "Senior Marketing Manager", true},
+		{"Vice President", "Executive Vice President", true},
+		{"VP", "SVP Sales", true},
+		{"IT Manager", "IT Mgr", true},
+		{"Marketing Manager", "Sales Manager", false},
+		{"Senior Marketing Manager", "Marketing Manager", false},
+		{"CTO", "CEO", false},
+		{"Manager", "Mgmt", false},
+
+		// Seniority qualifiers the campaign title lacks rank the lead below it
+		{"President", "Vice President", false},
+		{"President", "President", true},
+		{"Director", "Associate Director", false},
+		{"Director of Sales", "Asst. Director of Sales", false},
+		{"Director", "Deputy Director", false},
+		{"Engineer", "Jr. Engineer", false},
+		{"CEO", "Assistant to the CEO", false},
+		{"Executive Assistant", "Executive Assistant to the CEO", true},
+		{"Assistant Vice President", "AVP Finance", true},
+	}
+	for _, tt := range tests {
+		if got := newTitleMatcher(tt.campaign).matches(titleTokens(tt.lead)); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.campaign, tt.lead, got, tt.want)
+		}
+	}
+
+	if newTitleMatcher("the of").matches(titleTokens("the of")) {
+		t.Error("a title of only filler words matched")
+	}
+}
+
+func TestWithinOneEdit(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"manager", "manager", true},
+		{"manager", "manger", true},
+		{"manager", "managers", true},
+		{"manager", "manaxer", true},
+		{"manager", "mangaer", false},
+		{"manager", "man", false},
+		{"", "a", true},
+	}
+	for _, tt := range tests {
+		if got := withinOneEdit(tt.a, tt.b); got != tt.want {
+			t.Errorf("withinOneEdit(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"encoding/hex"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// HashContact is the hash stored in leads.email_hash and leads.phone_hash, and in the
+// ledger and suppression tables compared against them: hex SHA3-256 of the
+// normalized value. Callers normalize first; "" hashes to "" so blanks never match.
+func HashContact(normalized string) string {
+	if normalized == "" {
+		return ""
+	}
+	sum := sha3.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,13 @@
+package utils
+
+import "testing"
+
+func TestHashContact(t *testing.T) {
+	// SHA3-256 test vector; leads hashed by the capture app must compare equal
+	if got, want := HashContact("abc"), "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"; got != want {
+		t.Errorf("HashContact(abc) = %s, want %s", got, want)
+	}
+	if got := HashContact(""); got != "" {
+		t.Errorf("HashContact of a blank value = %q, want \"\"", got)
+	}
+}
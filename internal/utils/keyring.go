@@ -0,0 +1,284 @@
+package utils
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// Values written by EncryptString look like "v2:<keyID>:<base64(nonce|ciphertext|tag)>".
+// Legacy AES-CFB values are bare base64, which never contains ':'.
+const gcmPrefix = "v2:"
+
+// defaultKeyID names APP_ENCRYPTION_KEY when it is the only key configured
+const defaultKeyID = "default"
+
+var (
+	// ErrDecryptFailed means a value was tampered with or encrypted under a different key
+	ErrDecryptFailed = errors.New("decryption failed")
+	// ErrUnknownKeyID means a value names a key the keyring doesn't hold
+	ErrUnknownKeyID = errors.New("unknown encryption key id")
+)
+
+// Keyring holds every key version that may have encrypted a stored value. New values
+// are always encrypted under the current key.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+	legacy  []byte // Key for pre-GCM AES-CFB values, if any
+}
+
+// keyringSecret is the Secrets Manager format:
+// {"current": "2024-06", "keys": {"2024-06": "<base64>", "2023-01": "<base64>"}, "legacy": "<base64>"}
+type keyringSecret struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+	Legacy  string            `json:"legacy,omitempty"`
+}
+
+// NewKeyring validates the keys (32 bytes each, for AES-256) and builds a keyring
+func NewKeyring(current string, keys map[string][]byte, legacy []byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring has no keys")
+	}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 bytes for AES-256", id)
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the keyring", current)
+	}
+	if legacy != nil && len(legacy) != 32 {
+		return nil, errors.New("legacy key must be 32 bytes for AES-256")
+	}
+	return &Keyring{current: current, keys: keys, legacy: legacy}, nil
+}
+
+// CurrentKeyID is the key new values are encrypted with
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+// Encrypt seals plaintext with AES-256-GCM under the current key
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	aead, err := newGCM(k.keys[k.current])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	// The key ID is bound as additional data so a value can't be relabeled to another key
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(k.current))
+	return gcmPrefix + k.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value written by Encrypt under any key in the ring, or a legacy CFB value
+func (k *Keyring) Decrypt(value string) (string, error) {
+	keyID, encoded, ok := parseGCMValue(value)
+	if !ok {
+		if k.legacy == nil {
+			return "", errors.New("legacy value but no legacy key configured")
+		}
+		return decryptCFB(k.legacy, value)
+	}
+
+	key, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKeyID, keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDecryptFailed, err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return "", fmt.Errorf("%w: ciphertext too short", ErrDecryptFailed)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDecryptFailed, err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencrypt reports whether a value is legacy CFB or under a non-current key
+func (k *Keyring) NeedsReencrypt(value string) bool {
+	keyID, _, ok := parseGCMValue(value)
+	return !ok || keyID != k.current
+}
+
+// Reencrypt migrates a stored value to the current key. It returns the value unchanged
+// and false when it is already current, so callers only write back what changed.
+func (k *Keyring) Reencrypt(value string) (string, bool, error) {
+	if !k.NeedsReencrypt(value) {
+		return value, false, nil
+	}
+	plaintext, err := k.Decrypt(value)
+	if err != nil {
+		return "", false, err
+	}
+	reencrypted, err := k.Encrypt(plaintext)
+	if err != nil {
+		return "", false, err
+	}
+	return reencrypted, true, nil
+}
+
+// parseGCMValue splits "v2:<keyID>:<payload>"
+func parseGCMValue(value string) (keyID, encoded string, ok bool) {
+	rest, ok := strings.CutPrefix(value, gcmPrefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var (
+	defaultKeyringMu sync.Mutex
+	defaultKeyring   *Keyring
+)
+
+// DefaultKeyring loads the process keyring on first use and keeps it. A failed load
+// isn't kept, so the next call tries again. APP_ENCRYPTION_SECRET_NAME selects a
+// Secrets Manager secret; otherwise APP_ENCRYPTION_KEYS ("id:base64,id:base64") and
+// APP_ENCRYPTION_KEY_ID are read from the environment. APP_ENCRYPTION_KEY, the
+// original single key, decrypts legacy values and is the only key when no others are set.
+func DefaultKeyring(ctx context.Context) (*Keyring, error) {
+	defaultKeyringMu.Lock()
+	defer defaultKeyringMu.Unlock()
+	if defaultKeyring != nil {
+		return defaultKeyring, nil
+	}
+
+	var keyring *Keyring
+	var err error
+	if name := os.Getenv("APP_ENCRYPTION_SECRET_NAME"); name != "" {
+		keyring, err = LoadKeyringFromSecret(ctx, name)
+	} else {
+		keyring, err = LoadKeyringFromEnv()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defaultKeyring = keyring
+	return keyring, nil
+}
+
+// LoadKeyringFromEnv builds a keyring from APP_ENCRYPTION_KEYS, APP_ENCRYPTION_KEY_ID and APP_ENCRYPTION_KEY
+func LoadKeyringFromEnv() (*Keyring, error) {
+	var legacy []byte
+	if os.Getenv("APP_ENCRYPTION_KEY") != "" {
+		var err error
+		legacy, err = getEncryptionKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make(map[string][]byte)
+	var order []string
+	for _, entry := range strings.Split(os.Getenv("APP_ENCRYPTION_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("APP_ENCRYPTION_KEYS entry %q: want id:base64key", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("APP_ENCRYPTION_KEYS key %s: %w", id, err)
+		}
+		keys[id] = key
+		order = append(order, id)
+	}
+
+	if len(keys) == 0 {
+		if legacy == nil {
+			return nil, errors.New("APP_ENCRYPTION_KEYS and APP_ENCRYPTION_KEY not set")
+		}
+		return NewKeyring(defaultKeyID, map[string][]byte{defaultKeyID: legacy}, legacy)
+	}
+
+	current := os.Getenv("APP_ENCRYPTION_KEY_ID")
+	if current == "" {
+		current = order[0]
+	}
+	return NewKeyring(current, keys, legacy)
+}
+
+// LoadKeyringFromSecret builds a keyring from a Secrets Manager secret in the keyringSecret format
+func LoadKeyringFromSecret(ctx context.Context, secretName string) (*Keyring, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(secretName),
+		VersionStage: aws.String("AWSCURRENT"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var secret keyringSecret
+	if err := json.Unmarshal([]byte(aws.ToString(result.SecretString)), &secret); err != nil {
+		return nil, fmt.Errorf("invalid keyring secret: %w", err)
+	}
+
+	keys := make(map[string][]byte, len(secret.Keys))
+	for id, encoded := range secret.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("keyring secret key %s: %w", id, err)
+		}
+		keys[id] = key
+	}
+
+	var legacy []byte
+	if secret.Legacy != "" {
+		legacy, err = base64.StdEncoding.DecodeString(secret.Legacy)
+		if err != nil {
+			return nil, fmt.Errorf("keyring secret legacy key: %w", err)
+		}
+	}
+
+	current := secret.Current
+	if current == "" && len(keys) == 1 {
+		for id := range keys {
+			current = id
+		}
+	}
+	return NewKeyring(current, keys, legacy)
+}
@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func mustKeyring(t *testing.T, current string, keys map[string][]byte, legacy []byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(current, keys, legacy)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return k
+}
+
+// encryptCFB writes a value the way EncryptString did before GCM: base64(iv|ciphertext)
+func encryptCFB(t *testing.T, key []byte, plaintext string) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, aes.BlockSize+len(plaintext))
+	if _, err := rand.Read(out[:aes.BlockSize]); err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCFBEncrypter(block, out[:aes.BlockSize]).XORKeyStream(out[aes.BlockSize:], []byte(plaintext))
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	k := mustKeyring(t, "k1", map[string][]byte{"k1": testKey(1)}, nil)
+	for _, plaintext := range []string{"", "hunter2", "ünïcode ✓", strings.Repeat("x", 4096)} {
+		value, err := k.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		if !strings.HasPrefix(value, "v2:k1:") {
+			t.Errorf("value %q lacks the v2:k1: prefix", value)
+		}
+		got, err := k.Decrypt(value)
+		if err != nil {
+			t.Fatalf("Decrypt: %v", err)
+		}
+		if got != plaintext {
+			t.Errorf("round trip = %q, want %q", got, plaintext)
+		}
+	}
+
+	a, _ := k.Encrypt("same")
+	b, _ := k.Encrypt("same")
+	if a == b {
+		t.Error("two encryptions of the same value are identical; nonce reused")
+	}
+}
+
+func TestKeyringRejectsTampering(t *testing.T) {
+	k := mustKeyring(t, "k1", map[string][]byte{"k1": testKey(1)}, nil)
+	value, err := k.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "v2:k1:"))
+	sealed[len(sealed)-1] ^= 1
+	tampered := "v2:k1:" + base64.StdEncoding.EncodeToString(sealed)
+
+	if _, err := k.Decrypt(tampered); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("tampered value: err = %v, want ErrDecryptFailed", err)
+	}
+	if _, err := k.Decrypt("v2:k1:AAAA"); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("short value: err = %v, want ErrDecryptFailed", err)
+	}
+	if _, err := k.Decrypt("v2:k1:not base64!"); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("bad base64: err = %v, want ErrDecryptFailed", err)
+	}
+}
+
+// The key ID is authenticated: relabeling a value to another ID fails even when both
+// IDs hold the same key
+func TestKeyringBindsKeyID(t *testing.T) {
+	k := mustKeyring(t, "k1", map[string][]byte{"k1": testKey(1), "k2": testKey(1)}, nil)
+	value, err := k.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relabeled := "v2:k2:" + strings.TrimPrefix(value, "v2:k1:")
+	if _, err := k.Decrypt(relabeled); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("relabeled value: err = %v, want ErrDecryptFailed", err)
+	}
+
+	unknown := "v2:k9:" + strings.TrimPrefix(value, "v2:k1:")
+	if _, err := k.Decrypt(unknown); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("unknown key id: err = %v, want ErrUnknownKeyID", err)
+	}
+}
+
+func TestKeyringDecryptsLegacyCFB(t *testing.T) {
+	legacy := testKey(7)
+	stored := encryptCFB(t, legacy, "legacy secret")
+
+	k := mustKeyring(t, "k1", map[string][]byte{"k1": testKey(1)}, legacy)
+	got, err := k.Decrypt(stored)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if got != "legacy secret" {
+		t.Errorf("Decrypt = %q, want %q", got, "legacy secret")
+	}
+	if !k.NeedsReencrypt(stored) {
+		t.Error("legacy value doesn't need re-encrypting")
+	}
+
+	noLegacy := mustKeyring(t, "k1", map[string][]byte{"k1": testKey(1)}, nil)
+	if _, err := noLegacy.Decrypt(stored); err == nil {
+		t.Error("legacy value decrypted without a legacy key")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	legacy := testKey(7)
+	before := mustKeyring(t, "2023-01", map[string][]byte{"2023-01": testKey(1)}, legacy)
+	old, err := before.Encrypt("rotate me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfb := encryptCFB(t, legacy, "rotate me too")
+
+	after := mustKeyring(t, "2024-06", map[string][]byte{"2023-01": testKey(1), "2024-06": testKey(2)}, legacy)
+	if got, err := after.Decrypt(old); err != nil || got != "rotate me" {
+		t.Fatalf("old value after rotation: %q, %v", got, err)
+	}
+
+	for _, stored := range []string{old, cfb} {
+		if !after.NeedsReencrypt(stored) {
+			t.Errorf("%q doesn't need re-encrypting", stored)
+		}
+		moved, changed, err := after.Reencrypt(stored)
+		if err != nil || !changed {
+			t.Fatalf("Reencrypt = %v, %v", changed, err)
+		}
+		if !strings.HasPrefix(moved, "v2:2024-06:") || after.NeedsReencrypt(moved) {
+			t.Errorf("re-encrypted value %q isn't under the current key", moved)
+		}
+		want, _ := after.Decrypt(stored)
+		if got, err := after.Decrypt(moved); err != nil || got != want {
+			t.Errorf("re-encrypted value decrypts to %q, %v; want %q", got, err, want)
+		}
+
+		again, changed, err := after.Reencrypt(moved)
+		if err != nil || changed || again != moved {
+			t.Errorf("Reencrypt of a current value = %q, %v, %v; want it unchanged", again, changed, err)
+		}
+	}
+
+	// Once the old key is retired its values no longer open
+	retired := mustKeyring(t, "2024-06", map[string][]byte{"2024-06": testKey(2)}, nil)
+	if _, err := retired.Decrypt(old); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("value under a retired key: err = %v, want ErrUnknownKeyID", err)
+	}
+}
+
+func TestNewKeyringValidatesKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		keys    map[string][]byte
+		legacy  []byte
+	}{
+		{"no keys", "k1", nil, nil},
+		{"short key", "k1", map[string][]byte{"k1": make([]byte, 16)}, nil},
+		{"colon in id", "a:b", map[string][]byte{"a:b": testKey(1)}, nil},
+		{"current missing", "k2", map[string][]byte{"k1": testKey(1)}, nil},
+		{"short legacy", "k1", map[string][]byte{"k1": testKey(1)}, make([]byte, 8)},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyring(tt.current, tt.keys, tt.legacy); err == nil {
+			t.Errorf("%s: NewKeyring succeeded", tt.name)
+		}
+	}
+}
+
+func TestLoadKeyringFromEnv(t *testing.T) {
+	t.Setenv("APP_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(testKey(7)))
+	t.Setenv("APP_ENCRYPTION_KEYS", "2023-01:"+base64.StdEncoding.EncodeToString(testKey(1))+", 2024-06:"+base64.StdEncoding.EncodeToString(testKey(2)))
+	t.Setenv("APP_ENCRYPTION_KEY_ID", "2024-06")
+
+	k, err := LoadKeyringFromEnv()
+	if err != nil {
+		t.Fatalf("LoadKeyringFromEnv: %v", err)
+	}
+	if k.CurrentKeyID() != "2024-06" {
+		t.Errorf("current key = %s, want 2024-06", k.CurrentKeyID())
+	}
+	if _, err := k.Decrypt(encryptCFB(t, testKey(7), "legacy")); err != nil {
+		t.Errorf("APP_ENCRYPTION_KEY isn't the legacy key: %v", err)
+	}
+
+	// The original single key alone still works
+	t.Setenv("APP_ENCRYPTION_KEYS", "")
+	t.Setenv("APP_ENCRYPTION_KEY_ID", "")
+	k, err = LoadKeyringFromEnv()
+	if err != nil {
+		t.Fatalf("LoadKeyringFromEnv with only APP_ENCRYPTION_KEY: %v", err)
+	}
+	if k.CurrentKeyID() != defaultKeyID {
+		t.Errorf("current key = %s, want %s", k.CurrentKeyID(), defaultKeyID)
+	}
+}
+
+// A failed load isn't remembered: once the configuration is fixed the next call succeeds
+func TestDefaultKeyringRetriesAfterFailure(t *testing.T) {
+	resetDefaultKeyring := func() {
+		defaultKeyringMu.Lock()
+		defaultKeyring = nil
+		defaultKeyringMu.Unlock()
+	}
+	resetDefaultKeyring()
+	t.Cleanup(resetDefaultKeyring)
+
+	t.Setenv("APP_ENCRYPTION_SECRET_NAME", "")
+	t.Setenv("APP_ENCRYPTION_KEY", "")
+	t.Setenv("APP_ENCRYPTION_KEYS", "")
+	if _, err := DefaultKeyring(context.Background()); err == nil {
+		t.Fatal("DefaultKeyring succeeded with no keys configured")
+	}
+
+	t.Setenv("APP_ENCRYPTION_KEYS", "k1:"+base64.StdEncoding.EncodeToString(testKey(1)))
+	k, err := DefaultKeyring(context.Background())
+	if err != nil {
+		t.Fatalf("DefaultKeyring after fixing the configuration: %v", err)
+	}
+	if again, _ := DefaultKeyring(context.Background()); again != k {
+		t.Error("DefaultKeyring reloaded a keyring it already had")
+	}
+
+	value, err := EncryptString(context.Background(), "via helpers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecryptString(context.Background(), value); err != nil || got != "via helpers" {
+		t.Errorf("DecryptString = %q, %v", got, err)
+	}
+}
+
+func TestParseEncryptedColumn(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    EncryptedColumn
+		wantErr bool
+	}{
+		{in: "delivery_methods.secret", want: EncryptedColumn{Table: "delivery_methods", Column: "secret"}},
+		{in: "app.delivery_methods.secret", want: EncryptedColumn{Table: "app.delivery_methods", Column: "secret"}},
+		{in: "secret", wantErr: true},
+		{in: ".secret", wantErr: true},
+		{in: "table.", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseEncryptedColumn(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseEncryptedColumn(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+}
@@ -1,12 +1,11 @@
 package utils
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"encoding/base64"
 	"errors"
-	"io"
 	"os"
 )
 
@@ -29,38 +28,37 @@ func getEncryptionKey() ([]byte, error) {
 	return key, nil
 }
 
-// EncryptString encrypts a plaintext string using AES-256-CFB and returns base64 ciphertext
-func EncryptString(plaintext string) (string, error) {
-	key, err := getEncryptionKey()
+// EncryptString encrypts a plaintext string with AES-256-GCM under the keyring's current key
+func EncryptString(ctx context.Context, plaintext string) (string, error) {
+	keyring, err := DefaultKeyring(ctx)
 	if err != nil {
 		return "", err
 	}
+	return keyring.Encrypt(plaintext)
+}
 
-	block, err := aes.NewCipher(key)
+// DecryptString decrypts a value from EncryptString, including legacy AES-CFB values
+func DecryptString(ctx context.Context, encrypted string) (string, error) {
+	keyring, err := DefaultKeyring(ctx)
 	if err != nil {
 		return "", err
 	}
-
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
-	iv := ciphertext[:aes.BlockSize]
-
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return "", err
-	}
-
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], []byte(plaintext))
-
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	return keyring.Decrypt(encrypted)
 }
 
-// DecryptString decrypts a base64 ciphertext back to plaintext
-func DecryptString(encrypted string) (string, error) {
-	key, err := getEncryptionKey()
+// ReencryptString migrates a stored value to the current key; changed is false when
+// the value was already current and needs no write
+func ReencryptString(ctx context.Context, encrypted string) (value string, changed bool, err error) {
+	keyring, err := DefaultKeyring(ctx)
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
+	return keyring.Reencrypt(encrypted)
+}
 
+// decryptCFB decrypts a base64 AES-256-CFB value written before the switch to GCM.
+// CFB is unauthenticated, so a tampered value decrypts to garbage rather than failing.
+func decryptCFB(key []byte, encrypted string) (string, error) {
 	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
 	if err != nil {
 		return "", err
@@ -83,4 +81,3 @@ func DecryptString(encrypted string) (string, error) {
 
 	return string(ciphertext), nil
 }
-
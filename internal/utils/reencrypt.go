@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// EncryptedColumn names a text column holding values written by EncryptString
+type EncryptedColumn struct {
+	Table  string // May be schema-qualified, e.g. "public.delivery_methods"
+	Column string
+}
+
+// ParseEncryptedColumn reads "table.column" or "schema.table.column"
+func ParseEncryptedColumn(s string) (EncryptedColumn, error) {
+	i := strings.LastIndex(s, ".")
+	if i <= 0 || i == len(s)-1 {
+		return EncryptedColumn{}, fmt.Errorf("encrypted column %q: want table.column", s)
+	}
+	return EncryptedColumn{Table: s[:i], Column: s[i+1:]}, nil
+}
+
+func (c EncryptedColumn) String() string {
+	return c.Table + "." + c.Column
+}
+
+// ReencryptReport counts what ReencryptColumn did
+type ReencryptReport struct {
+	Reencrypted int
+	Failed      int // Values that didn't decrypt; left as they are
+	Skipped     int // Rows changed by someone else between the read and the write
+}
+
+// ReencryptColumn moves every value in col to the keyring's current key, batchSize
+// rows per transaction. Only values NeedsReencrypt reports are read, so it can be run
+// again after an interruption or another rotation. A row is written only if it still
+// holds the value that was read, so concurrent updates aren't overwritten.
+func ReencryptColumn(ctx context.Context, db *sql.DB, k *Keyring, col EncryptedColumn, batchSize int) (ReencryptReport, error) {
+	var report ReencryptReport
+	table := pgx.Identifier(strings.Split(col.Table, ".")).Sanitize()
+	column := pgx.Identifier{col.Column}.Sanitize()
+	currentPrefix := gcmPrefix + k.CurrentKeyID() + ":"
+
+	// Rows are addressed by ctid, which every table has whatever its key; values that
+	// can't be moved are excluded so each batch makes progress
+	selectQuery := fmt.Sprintf(`SELECT ctid::text, %[2]s FROM %[1]s
+		WHERE %[2]s IS NOT NULL AND %[2]s <> ''
+		  AND left(%[2]s, length($1)) <> $1
+		  AND NOT (%[2]s = ANY($2::text[]))
+		LIMIT $3`, table, column)
+	updateQuery := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = $1 WHERE ctid = $2::tid AND %[2]s = $3`, table, column)
+
+	excluded := []string{} // Not nil: NULL would exclude every row
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return report, err
+		}
+		rows, err := tx.QueryContext(ctx, selectQuery, currentPrefix, excluded, batchSize)
+		if err != nil {
+			tx.Rollback()
+			return report, fmt.Errorf("failed to read %s: %w", col, err)
+		}
+		type stored struct{ ctid, value string }
+		var batch []stored
+		for rows.Next() {
+			var row stored
+			if err := rows.Scan(&row.ctid, &row.value); err != nil {
+				rows.Close()
+				tx.Rollback()
+				return report, err
+			}
+			batch = append(batch, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			tx.Rollback()
+			return report, err
+		}
+		if len(batch) == 0 {
+			tx.Rollback()
+			return report, nil
+		}
+
+		for _, row := range batch {
+			if !k.NeedsReencrypt(row.value) {
+				excluded = append(excluded, row.value)
+				continue
+			}
+			value, _, err := k.Reencrypt(row.value)
+			if err != nil {
+				report.Failed++
+				excluded = append(excluded, row.value)
+				continue
+			}
+			result, err := tx.ExecContext(ctx, updateQuery, value, row.ctid, row.value)
+			if err != nil {
+				tx.Rollback()
+				return report, fmt.Errorf("failed to update %s: %w", col, err)
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				report.Skipped++
+				continue
+			}
+			report.Reencrypted++
+		}
+		if err := tx.Commit(); err != nil {
+			return report, fmt.Errorf("failed to commit %s batch: %w", col, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package validation
+
+import "strings"
+
+// naicsSectors are the two-digit NAICS sectors
+var naicsSectors = map[string]bool{
+	"11": true, "21": true, "22": true, "23": true, "31": true, "32": true, "33": true,
+	"42": true, "44": true, "45": true, "48": true, "49": true, "51": true, "52": true,
+	"53": true, "54": true, "55": true, "56": true, "61": true, "62": true, "71": true,
+	"72": true, "81": true, "92": true,
+}
+
+// naicsSubsectors are the three-digit subsectors of the 2017 and 2022 editions; leads
+// are still coded with either
+var naicsSubsectors = map[string]bool{
+	"111": true, "112": true, "113": true, "114": true, "115": true,
+	"211": true, "212": true, "213": true,
+	"221": true,
+	"236": true, "237": true, "238": true,
+	"311": true, "312": true, "313": true, "314": true, "315": true, "316": true,
+	"321": true, "322": true, "323": true, "324": true, "325": true, "326": true, "327": true,
+	"331": true, "332": true, "333": true, "334": true, "335": true, "336": true, "337": true, "339": true,
+	"423": true, "424": true, "425": true,
+	"441": true, "442": true, "443": true, "444": true, "445": true, "446": true, "447": true,
+	"448": true, "449": true, "451": true, "452": true, "453": true, "454": true, "455": true,
+	"456": true, "457": true, "458": true, "459": true,
+	"481": true, "482": true, "483": true, "484": true, "485": true, "486": true, "487": true, "488": true,
+	"491": true, "492": true, "493": true,
+	"511": true, "512": true, "513": true, "515": true, "516": true, "517": true, "518": true, "519": true,
+	"521": true, "522": true, "523": true, "524": true, "525": true,
+	"531": true, "532": true, "533": true,
+	"541": true,
+	"551": true,
+	"561": true, "562": true,
+	"611": true,
+	"621": true, "622": true, "623": true, "624": true,
+	"711": true, "712": true, "713": true,
+	"721": true, "722": true,
+	"811": true, "812": true, "813": true, "814": true,
+	"921": true, "922": true, "923": true, "924": true, "925": true, "926": true, "927": true, "928": true,
+}
+
+// IsNaicsCode reports whether code is a 2 to 6 digit NAICS code whose sector and, for
+// codes of three or more digits, subsector exist. Industry groups and national
+// industries below the subsector aren't enumerated.
+func IsNaicsCode(code string) bool {
+	if len(code) < 2 || len(code) > 6 || strings.Trim(code, "0123456789") != "" {
+		return false
+	}
+	if len(code) == 2 {
+		return naicsSectors[code]
+	}
+	return naicsSubsectors[code[:3]]
+}
@@ -0,0 +1,174 @@
+// Package validation checks and normalizes lead fields before they are delivered.
+//
+// A Pipeline runs a set of Validators over each lead. Each validator belongs to a
+// check, and a campaign's rules (campaigns.validation_rules) decide what a failed
+// check does:
+//
+//	{"email": "drop", "country_code": "drop", "state": "flag", "naics_code": "flag",
+//	 "linkedin": "flag", "ip_address": "off"}
+//
+// "drop" removes the lead from the delivery, "flag" delivers it but reports the
+// problem, and "off" skips the check. Checks the rules don't mention use
+// DefaultRules, which only flag: a campaign must opt in to dropping leads. Empty
+// fields are never a failure.
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+// ErrInvalidRules means a campaign's validation rules can't be parsed
+var ErrInvalidRules = errors.New("invalid validation rules")
+
+// Actions a rule can take on a failed check
+const (
+	ActionDrop = "drop"
+	ActionFlag = "flag"
+	ActionOff  = "off"
+)
+
+// Checks the standard validators perform
+const (
+	CheckEmail       = "email"
+	CheckCountryCode = "country_code"
+	CheckState       = "state"
+	CheckNaicsCode   = "naics_code"
+	CheckLinkedin    = "linkedin"
+	CheckIPAddress   = "ip_address"
+)
+
+// Rules maps a check to its action
+type Rules map[string]string
+
+// DefaultRules flag every check and drop nothing
+var DefaultRules = Rules{
+	CheckEmail:       ActionFlag,
+	CheckCountryCode: ActionFlag,
+	CheckState:       ActionFlag,
+	CheckNaicsCode:   ActionFlag,
+	CheckLinkedin:    ActionFlag,
+	CheckIPAddress:   ActionFlag,
+}
+
+// ParseRules reads stored rules over DefaultRules. Empty or null raw gives the defaults.
+func ParseRules(raw []byte) (Rules, error) {
+	rules := make(Rules, len(DefaultRules))
+	for check, action := range DefaultRules {
+		rules[check] = action
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return rules, nil
+	}
+
+	var stored map[string]string
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRules, err)
+	}
+	for check, action := range stored {
+		switch action {
+		case ActionDrop, ActionFlag, ActionOff:
+		default:
+			return nil, fmt.Errorf("%w: unknown action %q for %s", ErrInvalidRules, action, check)
+		}
+		rules[check] = action
+	}
+	return rules, nil
+}
+
+// action returns what to do when check fails; unknown checks only flag
+func (r Rules) action(check string) string {
+	if action, ok := r[check]; ok {
+		return action
+	}
+	return ActionFlag
+}
+
+// Issue is one failed check on one lead
+type Issue struct {
+	Check   string `json:"check"`
+	Field   string `json:"field"`
+	Value   string `json:"value,omitempty"`
+	Problem string `json:"problem"`
+	Action  string `json:"action"`
+}
+
+func (i Issue) String() string {
+	if i.Value == "" {
+		return fmt.Sprintf("%s: %s", i.Field, i.Problem)
+	}
+	return fmt.Sprintf("%s %q: %s", i.Field, i.Value, i.Problem)
+}
+
+// Validator checks, and where it can normalizes, part of a lead. Validate returns nil
+// when the lead passes; an error means the check couldn't run (e.g. a key service was
+// unavailable) and the job should be retried.
+type Validator interface {
+	Check() string
+	Validate(ctx context.Context, lead *payload.Lead) (*Issue, error)
+}
+
+// Result is what the pipeline found on one lead
+type Result struct {
+	LeadID  string  `json:"lead_id"`
+	Dropped bool    `json:"dropped"`
+	Issues  []Issue `json:"issues"`
+}
+
+// Pipeline runs validators under a set of rules
+type Pipeline struct {
+	rules      Rules
+	validators []Validator
+}
+
+// NewPipeline builds a pipeline; validators whose check is "off" are left out
+func NewPipeline(rules Rules, validators ...Validator) *Pipeline {
+	p := &Pipeline{rules: rules}
+	for _, v := range validators {
+		if rules.action(v.Check()) != ActionOff {
+			p.validators = append(p.validators, v)
+		}
+	}
+	return p
+}
+
+// Run validates every lead, normalizing fields in place, and returns the leads to
+// deliver and a result for each lead with issues. A dropped lead isn't checked by the
+// validators after the one that dropped it, so its result lists only the issues up to
+// the drop.
+func (p *Pipeline) Run(ctx context.Context, leads []payload.Lead) ([]payload.Lead, []Result, error) {
+	var results []Result
+	kept := leads[:0]
+	for _, lead := range leads {
+		result := Result{LeadID: lead.ID}
+		for _, v := range p.validators {
+			if result.Dropped {
+				break
+			}
+			issue, err := v.Validate(ctx, &lead)
+			if err != nil {
+				return nil, nil, fmt.Errorf("lead %s %s check: %w", lead.ID, v.Check(), err)
+			}
+			if issue == nil {
+				continue
+			}
+			issue.Check = v.Check()
+			issue.Action = p.rules.action(v.Check())
+			if issue.Action == ActionDrop {
+				result.Dropped = true
+			}
+			result.Issues = append(result.Issues, *issue)
+		}
+		if len(result.Issues) > 0 {
+			results = append(results, result)
+		}
+		if !result.Dropped {
+			kept = append(kept, lead)
+		}
+	}
+	return kept, results, nil
+}
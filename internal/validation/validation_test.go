@@ -0,0 +1,141 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+func TestDefaultRulesOnlyFlag(t *testing.T) {
+	for check, action := range DefaultRules {
+		if action != ActionFlag {
+			t.Errorf("default rule for %s is %q, want %q", check, action, ActionFlag)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want map[string]string // Rules that differ from the defaults
+	}{
+		{name: "empty", raw: ""},
+		{name: "null", raw: "null"},
+		{name: "empty object", raw: "{}"},
+		{
+			name: "stored rules over the defaults",
+			raw:  `{"email": "drop", "ip_address": "off"}`,
+			want: map[string]string{CheckEmail: ActionDrop, CheckIPAddress: ActionOff},
+		},
+		{
+			name: "unknown checks are kept",
+			raw:  `{"fax": "drop"}`,
+			want: map[string]string{"fax": ActionDrop},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules([]byte(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Rules{}
+			for check, action := range DefaultRules {
+				want[check] = action
+			}
+			for check, action := range tt.want {
+				want[check] = action
+			}
+			if !reflect.DeepEqual(rules, want) {
+				t.Errorf("ParseRules(%s) = %v, want %v", tt.raw, rules, want)
+			}
+		})
+	}
+
+	// Parsing never changes the defaults
+	rules, _ := ParseRules([]byte(`{"email": "drop"}`))
+	rules[CheckState] = ActionOff
+	if DefaultRules[CheckEmail] != ActionFlag || DefaultRules[CheckState] != ActionFlag {
+		t.Errorf("DefaultRules changed to %v", DefaultRules)
+	}
+
+	for _, raw := range []string{`{"email": "reject"}`, `{"email": ""}`, `["email"]`, `{"email": 1}`, `{`} {
+		if _, err := ParseRules([]byte(raw)); !errors.Is(err, ErrInvalidRules) {
+			t.Errorf("ParseRules(%s) error = %v, want ErrInvalidRules", raw, err)
+		}
+	}
+}
+
+func TestRulesActionUnknownCheck(t *testing.T) {
+	if got := (Rules{}).action("fax"); got != ActionFlag {
+		t.Errorf("action of an unknown check = %q, want %q", got, ActionFlag)
+	}
+}
+
+// fixedEmails serves each lead's Email as its address and counts the leads asked for
+type fixedEmails struct {
+	asked []string
+	err   error
+}
+
+func (f *fixedEmails) address(_ context.Context, lead *payload.Lead) (string, error) {
+	f.asked = append(f.asked, lead.ID)
+	return lead.Email, f.err
+}
+
+func TestPipelineRun(t *testing.T) {
+	emails := &fixedEmails{}
+	rules := Rules{CheckEmail: ActionFlag, CheckCountryCode: ActionDrop, CheckState: ActionFlag, CheckIPAddress: ActionOff}
+	pipeline := NewPipeline(rules, Standard(emails.address)...)
+
+	leads := []payload.Lead{
+		{ID: "ok", Email: "a@example.com", CountryCode: "usa", State: "new york"},
+		{ID: "flagged", Email: "not-an-address", CountryCode: "US", State: "Ontario"},
+		{ID: "dropped", Email: "b@example.com", CountryCode: "Narnia", State: "ny"},
+		{ID: "off", CountryCode: "GB", IPAddress: "10.0.0.1"},
+	}
+	kept, results, err := pipeline.Run(context.Background(), leads)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(kept) != 3 || kept[0].ID != "ok" || kept[1].ID != "flagged" || kept[2].ID != "off" {
+		t.Fatalf("kept %+v", kept)
+	}
+	if kept[0].CountryCode != "US" || kept[0].State != "NY" {
+		t.Errorf("lead not normalized: country %q, state %q", kept[0].CountryCode, kept[0].State)
+	}
+	if kept[2].IPAddress != "10.0.0.1" {
+		t.Errorf("ip address check is off but changed the value to %q", kept[2].IPAddress)
+	}
+
+	want := []Result{
+		{LeadID: "flagged", Issues: []Issue{
+			{Check: CheckState, Field: "state", Value: "Ontario", Problem: "not a US state abbreviation", Action: ActionFlag},
+			{Check: CheckEmail, Field: "email", Value: "***", Problem: "not a valid address", Action: ActionFlag},
+		}},
+		// The drop stops the checks, so the dropped lead's state and email aren't checked
+		{LeadID: "dropped", Dropped: true, Issues: []Issue{
+			{Check: CheckCountryCode, Field: "country_code", Value: "Narnia", Problem: "not an ISO 3166-1 alpha-2 code", Action: ActionDrop},
+		}},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+	if want := []string{"ok", "flagged", "off"}; !reflect.DeepEqual(emails.asked, want) {
+		t.Errorf("asked for the emails of %v, want %v; a dropped lead's email is never needed", emails.asked, want)
+	}
+}
+
+func TestPipelineRunValidatorError(t *testing.T) {
+	emails := &fixedEmails{err: errors.New("kms unavailable")}
+	pipeline := NewPipeline(DefaultRules, Standard(emails.address)...)
+	_, _, err := pipeline.Run(context.Background(), []payload.Lead{{ID: "a", Email: "a@example.com"}})
+	if !errors.Is(err, emails.err) {
+		t.Errorf("Run error = %v, want the email source's error", err)
+	}
+}
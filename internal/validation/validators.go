@@ -0,0 +1,212 @@
+package validation
+
+import (
+	"context"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/DylanCoon99/delivery/internal/geo"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+// Standard returns the standard validators. emails supplies each lead's plaintext
+// address, since Lead.Email may hold only its hash; it returns "" when there is none.
+// The email check comes last, so a lead another check drops is never decrypted.
+func Standard(emails func(ctx context.Context, lead *payload.Lead) (string, error)) []Validator {
+	return []Validator{
+		CountryValidator{},
+		StateValidator{},
+		NaicsValidator{},
+		LinkedinValidator{},
+		IPAddressValidator{},
+		EmailValidator{Address: emails},
+	}
+}
+
+// EmailValidator checks address syntax without any DNS lookups
+type EmailValidator struct {
+	Address func(ctx context.Context, lead *payload.Lead) (string, error)
+}
+
+func (EmailValidator) Check() string { return CheckEmail }
+
+func (v EmailValidator) Validate(ctx context.Context, lead *payload.Lead) (*Issue, error) {
+	address, err := v.Address(ctx, lead)
+	if err != nil || address == "" {
+		return nil, err
+	}
+	if problem := emailProblem(address); problem != "" {
+		return &Issue{Field: "email", Value: maskEmail(address), Problem: problem}, nil
+	}
+	return nil, nil
+}
+
+// emailProblem describes what is wrong with an address, or returns ""
+func emailProblem(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Name != "" || parsed.Address != strings.TrimSpace(address) {
+		return "not a valid address"
+	}
+	local, domain, _ := strings.Cut(parsed.Address, "@")
+	switch {
+	case len(parsed.Address) > 254 || len(local) > 64:
+		return "too long"
+	case strings.HasPrefix(local, ".") || strings.HasSuffix(local, ".") || strings.Contains(local, ".."):
+		return "misplaced dot in local part"
+	case !strings.Contains(domain, ".") || strings.Contains(domain, ".."):
+		return "domain is not fully qualified"
+	}
+	labels := strings.Split(strings.ToLower(domain), ".")
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "invalid domain"
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return "invalid domain"
+			}
+		}
+	}
+	if tld := labels[len(labels)-1]; len(tld) < 2 || strings.Trim(tld, "abcdefghijklmnopqrstuvwxyz") != "" {
+		return "invalid top-level domain"
+	}
+	return ""
+}
+
+// maskEmail keeps enough of an address to find it without reproducing it in reports
+func maskEmail(address string) string {
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	return local[:1] + "***@" + domain
+}
+
+// CountryValidator requires an ISO 3166-1 alpha-2 CountryCode, normalizing case and
+// common aliases ("usa", "UK")
+type CountryValidator struct{}
+
+func (CountryValidator) Check() string { return CheckCountryCode }
+
+func (CountryValidator) Validate(_ context.Context, lead *payload.Lead) (*Issue, error) {
+	if strings.TrimSpace(lead.CountryCode) == "" {
+		return nil, nil
+	}
+	code := geo.NormalizeCountry(lead.CountryCode)
+	if !geo.IsCountry(code) {
+		return &Issue{Field: "country_code", Value: lead.CountryCode, Problem: "not an ISO 3166-1 alpha-2 code"}, nil
+	}
+	lead.CountryCode = code
+	return nil, nil
+}
+
+// StateValidator requires US leads' State to be a state or territory, normalized to
+// its postal abbreviation. Other countries' states are left alone.
+type StateValidator struct{}
+
+func (StateValidator) Check() string { return CheckState }
+
+func (StateValidator) Validate(_ context.Context, lead *payload.Lead) (*Issue, error) {
+	if strings.TrimSpace(lead.State) == "" || geo.NormalizeCountry(lead.CountryCode) != "US" {
+		return nil, nil
+	}
+	code, ok := geo.USStateCode(lead.State)
+	if !ok {
+		return &Issue{Field: "state", Value: lead.State, Problem: "not a US state abbreviation"}, nil
+	}
+	lead.State = code
+	return nil, nil
+}
+
+// NaicsValidator requires NaicsCode to be 2 to 6 digits within a real NAICS sector
+// and subsector
+type NaicsValidator struct{}
+
+func (NaicsValidator) Check() string { return CheckNaicsCode }
+
+func (NaicsValidator) Validate(_ context.Context, lead *payload.Lead) (*Issue, error) {
+	code := strings.TrimSpace(lead.NaicsCode)
+	if code == "" {
+		return nil, nil
+	}
+	if !IsNaicsCode(code) {
+		return &Issue{Field: "naics_code", Value: lead.NaicsCode, Problem: "not a NAICS code"}, nil
+	}
+	lead.NaicsCode = code
+	return nil, nil
+}
+
+// LinkedinValidator requires LinkedIn URLs to point at a profile (/in/) or a company
+// page (/company/, /school/, /showcase/), normalizing them to https://www.linkedin.com
+// without query strings or trailing slashes
+type LinkedinValidator struct{}
+
+func (LinkedinValidator) Check() string { return CheckLinkedin }
+
+func (LinkedinValidator) Validate(_ context.Context, lead *payload.Lead) (*Issue, error) {
+	if value := strings.TrimSpace(lead.LinkedinContact); value != "" {
+		normalized, ok := normalizeLinkedin(value, "in", "pub")
+		if !ok {
+			return &Issue{Field: "linkedin_contact", Value: value, Problem: "not a LinkedIn profile URL"}, nil
+		}
+		lead.LinkedinContact = normalized
+	}
+	if value := strings.TrimSpace(lead.LinkedinCompany); value != "" {
+		normalized, ok := normalizeLinkedin(value, "company", "school", "showcase")
+		if !ok {
+			return &Issue{Field: "linkedin_company", Value: value, Problem: "not a LinkedIn company URL"}, nil
+		}
+		lead.LinkedinCompany = normalized
+	}
+	return nil, nil
+}
+
+func normalizeLinkedin(value string, kinds ...string) (string, bool) {
+	if !strings.Contains(value, "://") {
+		value = "https://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host != "linkedin.com" && !strings.HasSuffix(host, ".linkedin.com") {
+		return "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[1] == "" {
+		return "", false
+	}
+	for _, kind := range kinds {
+		if strings.EqualFold(parts[0], kind) {
+			return "https://www.linkedin.com/" + strings.ToLower(parts[0]) + "/" + parts[1], true
+		}
+	}
+	return "", false
+}
+
+// IPAddressValidator requires IPAddress to parse as a public IPv4 or IPv6 address,
+// normalized to its canonical form
+type IPAddressValidator struct{}
+
+func (IPAddressValidator) Check() string { return CheckIPAddress }
+
+func (IPAddressValidator) Validate(_ context.Context, lead *payload.Lead) (*Issue, error) {
+	value := strings.TrimSpace(lead.IPAddress)
+	if value == "" {
+		return nil, nil
+	}
+	// Stored inet values may carry a prefix length
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimSuffix(value, "/32"), "/128"))
+	if err != nil {
+		return &Issue{Field: "ip_address", Value: value, Problem: "not an IP address"}, nil
+	}
+	addr = addr.Unmap()
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsUnspecified() || addr.IsLinkLocalUnicast() || addr.IsMulticast() {
+		return &Issue{Field: "ip_address", Value: value, Problem: "not a public address"}, nil
+	}
+	lead.IPAddress = addr.String()
+	return nil, nil
+}
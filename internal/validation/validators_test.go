@@ -0,0 +1,236 @@
+package validation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+// checkLead runs v over lead and returns the issue's problem ("" when it passed) and
+// the lead as the validator left it
+func checkLead(t *testing.T, v Validator, lead payload.Lead) (string, payload.Lead) {
+	t.Helper()
+	issue, err := v.Validate(context.Background(), &lead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issue == nil {
+		return "", lead
+	}
+	return issue.Problem, lead
+}
+
+func TestEmailProblem(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"lead@example.com", ""},
+		{"first.last+tag@mail.example.co.uk", ""},
+		{"lead@xn--bcher-kva.example", ""},
+		{"not-an-address", "not a valid address"},
+		{"Lead <lead@example.com>", "not a valid address"},
+		{"lead@@example.com", "not a valid address"},
+		{strings.Repeat("a", 65) + "@example.com", "too long"},
+		{"lead@" + strings.Repeat("a", 250) + ".com", "too long"},
+		{".lead@example.com", "not a valid address"},
+		{"le..ad@example.com", "not a valid address"},
+		{"lead@localhost", "domain is not fully qualified"},
+		{"lead@example..com", "not a valid address"},
+		{"lead@-example.com", "invalid domain"},
+		{"lead@exa_mple.com", "invalid domain"},
+		{"lead@example.c", "invalid top-level domain"},
+		{"lead@example.c0m", "invalid top-level domain"},
+	}
+	for _, tt := range tests {
+		if got := emailProblem(tt.address); got != tt.want {
+			t.Errorf("emailProblem(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestMaskEmail(t *testing.T) {
+	tests := map[string]string{
+		"lead@example.com": "l***@example.com",
+		"@example.com":     "***",
+		"no-at-sign":       "***",
+	}
+	for address, want := range tests {
+		if got := maskEmail(address); got != want {
+			t.Errorf("maskEmail(%q) = %q, want %q", address, got, want)
+		}
+	}
+}
+
+func TestEmailValidator(t *testing.T) {
+	v := EmailValidator{Address: func(_ context.Context, lead *payload.Lead) (string, error) {
+		return lead.Email, nil
+	}}
+	issue, err := v.Validate(context.Background(), &payload.Lead{Email: "secret@nowhere"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issue == nil || issue.Value != "s***@nowhere" || issue.Field != "email" {
+		t.Errorf("issue = %+v, want the masked address", issue)
+	}
+	if problem, _ := checkLead(t, v, payload.Lead{}); problem != "" {
+		t.Errorf("a lead without an email failed with %q", problem)
+	}
+}
+
+func TestCountryValidator(t *testing.T) {
+	tests := []struct {
+		country     string
+		wantProblem bool
+		wantCountry string
+	}{
+		{"US", false, "US"},
+		{" gb ", false, "GB"},
+		{"usa", false, "US"},
+		{"United  Kingdom", false, "GB"},
+		{"", false, ""},
+		{"XX", true, "XX"},
+		{"Narnia", true, "Narnia"},
+	}
+	for _, tt := range tests {
+		problem, lead := checkLead(t, CountryValidator{}, payload.Lead{CountryCode: tt.country})
+		if (problem != "") != tt.wantProblem || lead.CountryCode != tt.wantCountry {
+			t.Errorf("country %q: problem %q, normalized to %q; want problem %v, %q",
+				tt.country, problem, lead.CountryCode, tt.wantProblem, tt.wantCountry)
+		}
+	}
+}
+
+func TestStateValidator(t *testing.T) {
+	tests := []struct {
+		country, state string
+		wantProblem    bool
+		wantState      string
+	}{
+		{"US", "California", false, "CA"},
+		{"usa", "ny", false, "NY"},
+		{"US", "District of Columbia", false, "DC"},
+		{"US", "puerto rico", false, "PR"},
+		{"US", "Ontario", true, "Ontario"},
+		{"US", "", false, ""},
+		{"CA", "Ontario", false, "Ontario"}, // Only US states are checked
+		{"", "Nowhere", false, "Nowhere"},
+	}
+	for _, tt := range tests {
+		problem, lead := checkLead(t, StateValidator{}, payload.Lead{CountryCode: tt.country, State: tt.state})
+		if (problem != "") != tt.wantProblem || lead.State != tt.wantState {
+			t.Errorf("%s state %q: problem %q, normalized to %q; want problem %v, %q",
+				tt.country, tt.state, problem, lead.State, tt.wantProblem, tt.wantState)
+		}
+	}
+}
+
+func TestNaicsValidator(t *testing.T) {
+	problem, lead := checkLead(t, NaicsValidator{}, payload.Lead{NaicsCode: " 541511 "})
+	if problem != "" || lead.NaicsCode != "541511" {
+		t.Errorf("problem %q, code %q; want 541511 trimmed", problem, lead.NaicsCode)
+	}
+	if problem, _ := checkLead(t, NaicsValidator{}, payload.Lead{NaicsCode: "99"}); problem == "" {
+		t.Error("sector 99 passed")
+	}
+}
+
+func TestIsNaicsCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"54", true},
+		{"31", true},
+		{"92", true},
+		{"541", true},
+		{"5415", true},
+		{"541511", true},
+		{"455211", true}, // 2022 edition subsector
+		{"448140", true}, // 2017 edition subsector
+		{"", false},
+		{"5", false},
+		{"1234567", false},
+		{"10", false},
+		{"99", false},
+		{"546", false},
+		{"54a", false},
+		{"-54", false},
+	}
+	for _, tt := range tests {
+		if got := IsNaicsCode(tt.code); got != tt.want {
+			t.Errorf("IsNaicsCode(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+
+	// Every subsector is within a sector
+	for subsector := range naicsSubsectors {
+		if !naicsSectors[subsector[:2]] {
+			t.Errorf("subsector %s has no sector", subsector)
+		}
+	}
+}
+
+func TestLinkedinValidator(t *testing.T) {
+	tests := []struct {
+		contact, company         string
+		wantProblem              string
+		wantContact, wantCompany string
+	}{
+		{
+			contact:     "linkedin.com/in/jane-doe/?trk=x",
+			company:     "http://uk.linkedin.com/company/acme/about",
+			wantContact: "https://www.linkedin.com/in/jane-doe",
+			wantCompany: "https://www.linkedin.com/company/acme",
+		},
+		{contact: "https://www.linkedin.com/pub/jane", wantContact: "https://www.linkedin.com/pub/jane"},
+		{company: "https://www.LinkedIn.com/School/state-u", wantCompany: "https://www.linkedin.com/school/state-u"},
+		{contact: "https://www.linkedin.com/company/acme", wantProblem: "not a LinkedIn profile URL"},
+		{contact: "https://notlinkedin.com/in/jane", wantProblem: "not a LinkedIn profile URL"},
+		{contact: "ftp://linkedin.com/in/jane", wantProblem: "not a LinkedIn profile URL"},
+		{contact: "https://www.linkedin.com/in/", wantProblem: "not a LinkedIn profile URL"},
+		{company: "https://www.linkedin.com/in/jane", wantProblem: "not a LinkedIn company URL"},
+	}
+	for _, tt := range tests {
+		problem, lead := checkLead(t, LinkedinValidator{}, payload.Lead{LinkedinContact: tt.contact, LinkedinCompany: tt.company})
+		if problem != tt.wantProblem {
+			t.Errorf("%q, %q: problem %q, want %q", tt.contact, tt.company, problem, tt.wantProblem)
+			continue
+		}
+		if problem == "" && (lead.LinkedinContact != tt.wantContact || lead.LinkedinCompany != tt.wantCompany) {
+			t.Errorf("%q, %q: normalized to %q, %q; want %q, %q", tt.contact, tt.company,
+				lead.LinkedinContact, lead.LinkedinCompany, tt.wantContact, tt.wantCompany)
+		}
+	}
+}
+
+func TestIPAddressValidator(t *testing.T) {
+	tests := []struct {
+		ip          string
+		wantProblem string
+		wantIP      string
+	}{
+		{ip: "8.8.8.8", wantIP: "8.8.8.8"},
+		{ip: "8.8.8.8/32", wantIP: "8.8.8.8"},
+		{ip: "::ffff:8.8.4.4", wantIP: "8.8.4.4"},
+		{ip: "2001:4860:4860:0:0:0:0:8888/128", wantIP: "2001:4860:4860::8888"},
+		{ip: "", wantIP: ""},
+		{ip: "10.1.2.3", wantProblem: "not a public address"},
+		{ip: "192.168.0.1", wantProblem: "not a public address"},
+		{ip: "127.0.0.1", wantProblem: "not a public address"},
+		{ip: "0.0.0.0", wantProblem: "not a public address"},
+		{ip: "169.254.1.1", wantProblem: "not a public address"},
+		{ip: "fe80::1", wantProblem: "not a public address"},
+		{ip: "224.0.0.1", wantProblem: "not a public address"},
+		{ip: "8.8.8.8/24", wantProblem: "not an IP address"},
+		{ip: "example.com", wantProblem: "not an IP address"},
+	}
+	for _, tt := range tests {
+		problem, lead := checkLead(t, IPAddressValidator{}, payload.Lead{IPAddress: tt.ip})
+		if problem != tt.wantProblem || (problem == "" && lead.IPAddress != tt.wantIP) {
+			t.Errorf("ip %q: problem %q, normalized to %q; want %q, %q", tt.ip, problem, lead.IPAddress, tt.wantProblem, tt.wantIP)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+// jobLeadPageSize is how many leads are read, filtered or written at a time
+const jobLeadPageSize = 1000
+
+// jobLeads are the leads a job delivers once filtering is done: an embedded payload's
+// leads, or a reference job's leads spooled in delivery_job_leads
+type jobLeads interface {
+	Len() int
+	// page returns up to limit leads from index from on. They may be the job's own
+	// copies; callers must not change them.
+	page(ctx context.Context, from, limit int) ([]payload.Lead, error)
+	// split keeps the first n leads and rewrites the job's payload so a retry delivers
+	// only those. The rest move to a new job scheduled at heldAt, which is returned,
+	// or are dropped when heldAt is zero.
+	split(ctx context.Context, q *queries.Queries, n int, heldAt time.Time) (*queries.DeliveryJob, error)
+}
+
+// eachLeadPage calls fn on the leads a page at a time, with the index of each page's
+// first lead
+func eachLeadPage(ctx context.Context, leads jobLeads, fn func(from int, page []payload.Lead) error) error {
+	for from := 0; from < leads.Len(); from += jobLeadPageSize {
+		page, err := leads.page(ctx, from, jobLeadPageSize)
+		if err != nil {
+			return err
+		}
+		if err := fn(from, page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// embeddedLeads are the leads carried in an embedded payload
+type embeddedLeads struct {
+	job *queries.DeliveryJob
+	p   *payload.Payload
+}
+
+func (e *embeddedLeads) Len() int {
+	return len(e.p.Leads)
+}
+
+func (e *embeddedLeads) page(_ context.Context, from, limit int) ([]payload.Lead, error) {
+	return e.p.Leads[from:min(from+limit, len(e.p.Leads))], nil
+}
+
+func (e *embeddedLeads) split(ctx context.Context, q *queries.Queries, n int, heldAt time.Time) (*queries.DeliveryJob, error) {
+	var held *queries.DeliveryJob
+	if !heldAt.IsZero() {
+		rest := *e.p
+		rest.Leads = e.p.Leads[n:]
+		rest.TotalLeads = len(rest.Leads)
+		created, err := createHeldJob(ctx, q, e.job, &rest, heldAt)
+		if err != nil {
+			return nil, err
+		}
+		held = &created
+	}
+
+	// The job keeps only its share, so a retry doesn't split it again
+	e.p.Leads = e.p.Leads[:n]
+	e.p.TotalLeads = n
+	if err := rewriteJobPayload(ctx, q, e.job, e.p); err != nil {
+		return nil, err
+	}
+	return held, nil
+}
+
+// leadPager holds the page of a job's leads a table is reading. Pages are loaded as
+// the rows reach them and copied, so hooks can decrypt contacts without changing the
+// job's leads. Once a row is written its done hooks run, and a later pass reloads the
+// page.
+type leadPager struct {
+	ctx       context.Context // Table.Load has none of its own
+	leads     jobLeads
+	from      int // Index of the page's first lead, or -1 before the first load
+	spent     int // Leads at the start of the page whose rows are done
+	page      []payload.Lead
+	hooks     []func(ctx context.Context, page []payload.Lead) error
+	doneHooks []func(i int)
+}
+
+func newLeadPager(ctx context.Context, leads jobLeads) *leadPager {
+	return &leadPager{ctx: ctx, leads: leads, from: -1}
+}
+
+func (p *leadPager) Len() int {
+	return p.leads.Len()
+}
+
+// onLoad adds a hook run on each page as it is loaded
+func (p *leadPager) onLoad(hook func(ctx context.Context, page []payload.Lead) error) {
+	p.hooks = append(p.hooks, hook)
+}
+
+// onDone adds a hook run with a lead's index in the page once its row is written
+func (p *leadPager) onDone(hook func(i int)) {
+	p.doneHooks = append(p.doneHooks, hook)
+}
+
+// load makes the page holding row the current one
+func (p *leadPager) load(row int) error {
+	if p.from >= 0 && row >= p.from+p.spent && row < p.from+len(p.page) {
+		return nil
+	}
+	from := row - row%jobLeadPageSize
+	page, err := p.leads.page(p.ctx, from, jobLeadPageSize)
+	if err != nil {
+		return err
+	}
+	if row-from >= len(page) {
+		return fmt.Errorf("lead %d of %d is missing", row, p.leads.Len())
+	}
+	p.from, p.spent, p.page = -1, 0, append(p.page[:0], page...)
+	for _, hook := range p.hooks {
+		if err := hook(p.ctx, p.page); err != nil {
+			return err
+		}
+	}
+	p.from = from
+	return nil
+}
+
+// done runs the done hooks for a row of the current page
+func (p *leadPager) done(row int) {
+	if p.from < 0 || row < p.from || row >= p.from+len(p.page) {
+		return
+	}
+	for _, hook := range p.doneHooks {
+		hook(row - p.from)
+	}
+	p.spent = max(p.spent, row-p.from+1)
+}
+
+// lead returns the lead in a row of the current page
+func (p *leadPager) lead(row int) *payload.Lead {
+	return &p.page[row-p.from]
+}
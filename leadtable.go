@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/DylanCoon99/delivery/internal/leadfile"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+// schemaSummary records the column layout a delivery announced
+type schemaSummary struct {
+	Version string `json:"version"`
+	Stable  bool   `json:"stable,omitempty"` // Columns fixed by the field config rather than the data
+	Columns int    `json:"columns"`
+}
+
+// baseColumn is a lead field that can be delivered as a column
+type baseColumn struct {
+	Key       string
+	Header    string
+	Extractor func(lead *payload.Lead) string
+}
+
+// baseColumns are the lead fields in their default delivery order
+var baseColumns = []baseColumn{
+	{Key: "first_name", Header: "First Name", Extractor: func(l *payload.Lead) string { return l.FirstName }},
+	{Key: "last_name", Header: "Last Name", Extractor: func(l *payload.Lead) string { return l.LastName }},
+	{Key: "email", Header: "Email", Extractor: func(l *payload.Lead) string { return l.Email }},
+	{Key: "phone", Header: "Phone Number", Extractor: func(l *payload.Lead) string { return l.Phone }},
+	{Key: "ip_address", Header: "IP Address", Extractor: func(l *payload.Lead) string { return l.IPAddress }},
+	{Key: "company_name", Header: "Company Name", Extractor: func(l *payload.Lead) string { return l.CompanyName }},
+	{Key: "address", Header: "Address", Extractor: func(l *payload.Lead) string { return l.Address }},
+	{Key: "country_code", Header: "Country Code", Extractor: func(l *payload.Lead) string { return l.CountryCode }},
+	{Key: "linkedin_contact", Header: "LinkedIn Contact", Extractor: func(l *payload.Lead) string { return l.LinkedinContact }},
+	{Key: "linkedin_company", Header: "LinkedIn Company", Extractor: func(l *payload.Lead) string { return l.LinkedinCompany }},
+	{Key: "downloaded_asset_name", Header: "Downloaded Asset Name", Extractor: func(l *payload.Lead) string { return l.DownloadedAssetName }},
+	{Key: "publisher_name", Header: "Publisher Name", Extractor: func(l *payload.Lead) string { return l.PublisherName }},
+	{Key: "industry", Header: "Industry", Extractor: func(l *payload.Lead) string { return l.Industry }},
+	{Key: "revenue_size", Header: "Revenue Size", Extractor: func(l *payload.Lead) string { return l.RevenueSize }},
+	{Key: "employee_size", Header: "Employee Size", Extractor: func(l *payload.Lead) string { return l.EmployeeSize }},
+	{Key: "state", Header: "State", Extractor: func(l *payload.Lead) string { return l.State }},
+	{Key: "title", Header: "Title", Extractor: func(l *payload.Lead) string { return l.Title }},
+	{Key: "captured_at", Header: "Date/Time Stamp", Extractor: func(l *payload.Lead) string { return l.CapturedAt }},
+	{Key: "naics_code", Header: "NAICS Code", Extractor: func(l *payload.Lead) string { return l.NaicsCode }},
+}
+
+// baseColumnByKey returns the base column for a csv_field_config key
+func baseColumnByKey(key string) (baseColumn, bool) {
+	for _, col := range baseColumns {
+		if col.Key == key {
+			return col, true
+		}
+	}
+	return baseColumn{}, false
+}
+
+// leadColumnUsage records which columns a job's leads have values for
+type leadColumnUsage struct {
+	base      []bool // Parallel to baseColumns
+	questions []bool // Parallel to the payload's questions
+}
+
+// scanLeads reads a job's leads once before they are delivered, to find the columns
+// that have data and to fingerprint the leads for the snapshot
+func scanLeads(ctx context.Context, leads jobLeads, p *payload.Payload) (*leadColumnUsage, string, error) {
+	usage := &leadColumnUsage{
+		base:      make([]bool, len(baseColumns)),
+		questions: make([]bool, len(p.Questions)),
+	}
+	hasher := payload.NewSnapshotHasher()
+	err := eachLeadPage(ctx, leads, func(_ int, page []payload.Lead) error {
+		for i := range page {
+			hasher.Add(&page[i])
+			usage.add(&page[i], p.Questions)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return usage, hasher.Sum(p.Questions, p.CsvFieldConfig), nil
+}
+
+// add marks the columns lead has a value for. Email and phone are delivered
+// decrypted, so they have one when the lead has a cipher.
+func (u *leadColumnUsage) add(lead *payload.Lead, questions []payload.Question) {
+	for c, col := range baseColumns {
+		if u.base[c] {
+			continue
+		}
+		switch col.Key {
+		case "email":
+			u.base[c] = len(lead.EmailCipher) > 0
+		case "phone":
+			u.base[c] = len(lead.PhoneCipher) > 0
+		default:
+			u.base[c] = col.Extractor(lead) != ""
+		}
+	}
+	for c, q := range questions {
+		if !u.questions[c] && lead.CustomAnswers[q.ID] != "" {
+			u.questions[c] = true
+		}
+	}
+}
+
+// buildLeadTable lays out the delivered columns over the pager's leads: base fields in
+// their default order, then question answers. Columns no lead has a value for, per
+// usage, are left out unless the schema is stable, and with a csv_field_config only
+// the fields it delivers to the buyer are kept. Values are read from the pager's
+// current page as rows are written.
+func buildLeadTable(p *payload.Payload, pager *leadPager, usage *leadColumnUsage, deliverToBuyerKeys map[string]bool, stableSchema bool) *leadfile.Table {
+	questions := p.Questions
+	hasCsvFieldConfig := len(p.CsvFieldConfig) > 0
+
+	table := &leadfile.Table{
+		Rows:   pager.Len(),
+		LeadID: func(row int) string { return pager.lead(row).ID },
+		Load:   pager.load,
+		Done:   pager.done,
+	}
+	// A stable schema delivers every contracted column, even when no lead has a value
+	for i, hasData := range usage.base {
+		if !hasData && !stableSchema {
+			continue
+		}
+		// If csv_field_config is present, only include columns where deliver_to_buyer is true
+		if hasCsvFieldConfig && !deliverToBuyerKeys[baseColumns[i].Key] {
+			continue
+		}
+		extract := baseColumns[i].Extractor
+		table.Columns = append(table.Columns, leadfile.Column{
+			Key:    baseColumns[i].Key,
+			Header: baseColumns[i].Header,
+			Value:  func(row int) string { return extract(pager.lead(row)) },
+		})
+	}
+	baseCount := len(table.Columns)
+	for i, hasData := range usage.questions {
+		if !hasData && !stableSchema {
+			continue
+		}
+		id := questions[i].ID
+		table.Columns = append(table.Columns, leadfile.Column{
+			Key:      id,
+			Header:   questions[i].QuestionText,
+			Question: true,
+			Value:    func(row int) string { return pager.lead(row).CustomAnswers[id] },
+		})
+	}
+
+	log.Printf("CSV will include %d base columns (of %d) and %d question columns (of %d)",
+		baseCount, len(baseColumns), len(table.Columns)-baseCount, len(questions))
+	log.Printf("Header columns: %v", table.Header())
+	return table
+}
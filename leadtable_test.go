@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/envelope"
+	"github.com/DylanCoon99/delivery/internal/leadfile"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+// TestDeliveryFileMemoryIsBounded renders paged batches of very different sizes and
+// checks the live heap at each page load stays the same: a delivery holds a page of
+// leads, not the batch.
+func TestDeliveryFileMemoryIsBounded(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, format := range []string{leadfile.FormatCSV, leadfile.FormatXLSX} {
+		t.Run(format, func(t *testing.T) {
+			cfg, err := leadfile.ParseConfig([]byte(fmt.Sprintf(`{"file_format": %q}`, format)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			small := pagedDeliveryPeakHeap(t, 2*jobLeadPageSize, cfg)
+			large := pagedDeliveryPeakHeap(t, 20*jobLeadPageSize, cfg)
+			// Ten times the leads would add megabytes if any stage kept them
+			const slack = 1 << 20
+			if large > small+slack {
+				t.Errorf("peak live heap grew from %d to %d bytes with ten times the leads", small, large)
+			}
+		})
+	}
+}
+
+// pagedDeliveryPeakHeap renders n generated leads the way a reference job does, a page
+// at a time, and returns the largest live heap seen as pages load
+func pagedDeliveryPeakHeap(t *testing.T, n int, cfg leadfile.Config) uint64 {
+	ctx := context.Background()
+	leads, kms := syntheticLeads(t, n)
+	p := &payload.Payload{Version: payload.CurrentVersion}
+	usage, _, err := scanLeads(ctx, leads, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager := newLeadPager(ctx, leads)
+	decrypter := &pageDecrypter{kms: kms, email: true, phone: true}
+	defer decrypter.wipe()
+	pager.onLoad(decrypter.decrypt)
+	pager.onDone(decrypter.wipeRow)
+	var peak uint64
+	pager.onLoad(func(context.Context, []payload.Lead) error {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		peak = max(peak, stats.HeapAlloc)
+		return nil
+	})
+
+	table := buildLeadTable(p, pager, usage, nil, cfg.StableSchema)
+	file := leadfile.New(table, cfg, leadfile.Metadata{SchemaVersion: table.SchemaVersion(), GeneratedAt: time.Unix(0, 0)})
+	data := file.Open()
+	defer data.Close()
+	if _, err := io.Copy(io.Discard, data); err != nil {
+		t.Fatal(err)
+	}
+	return peak
+}
+
+// BenchmarkDeliveryFile runs a job's leads through the worker's delivery path: scanning
+// the leads, decrypting contacts a page at a time, building the lead table and
+// streaming the file out. "embedded" starts from a payload carrying the leads, which
+// is decoded whole; "paged" reads generated leads a page at a time, as a reference
+// job reads its spool. B/op and allocs/op cover the whole path; B/lead shows how
+// allocation grows with the batch.
+func BenchmarkDeliveryFile(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, source := range []string{"embedded", "paged"} {
+		for _, format := range []string{leadfile.FormatCSV, leadfile.FormatXLSX} {
+			for _, n := range []int{1000, 10000, 100000} {
+				b.Run(fmt.Sprintf("%s/%s/%d", source, format, n), func(b *testing.B) {
+					cfg, err := leadfile.ParseConfig([]byte(fmt.Sprintf(`{"file_format": %q}`, format)))
+					if err != nil {
+						b.Fatal(err)
+					}
+					var raw []byte
+					leads, kms := syntheticLeads(b, n)
+					if source == "embedded" {
+						raw = syntheticPayload(b, leads)
+					}
+
+					b.ReportAllocs()
+					var before, after runtime.MemStats
+					runtime.ReadMemStats(&before)
+					var size int64
+					runs := 0
+					for b.Loop() {
+						p := &payload.Payload{Version: payload.CurrentVersion}
+						var jl jobLeads = leads
+						if raw != nil {
+							if p, err = payload.Decode(raw); err != nil {
+								b.Fatal(err)
+							}
+							jl = &embeddedLeads{p: p}
+						}
+						size = deliverBenchFile(b, p, jl, kms, cfg)
+						runs++
+					}
+					runtime.ReadMemStats(&after)
+					b.ReportMetric(float64(size), "file-bytes")
+					b.ReportMetric(float64(after.TotalAlloc-before.TotalAlloc)/float64(runs*n), "B/lead")
+				})
+			}
+		}
+	}
+}
+
+// deliverBenchFile does one job's worth of work on the leads and returns the file's size
+func deliverBenchFile(b *testing.B, p *payload.Payload, leads jobLeads, kms envelope.KMS, cfg leadfile.Config) int64 {
+	ctx := context.Background()
+	usage, _, err := scanLeads(ctx, leads, p)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pager := newLeadPager(ctx, leads)
+	decrypter := &pageDecrypter{kms: kms, email: true, phone: true}
+	defer decrypter.wipe()
+	pager.onLoad(decrypter.decrypt)
+	pager.onDone(decrypter.wipeRow)
+	table := buildLeadTable(p, pager, usage, nil, cfg.StableSchema)
+	file := leadfile.New(table, cfg, leadfile.Metadata{SchemaVersion: table.SchemaVersion(), GeneratedAt: time.Unix(0, 0)})
+	data := file.Open()
+	defer data.Close()
+	size, err := io.Copy(io.Discard, data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return size
+}
+
+// generatedLeads makes a batch's leads as they are read, so the batch itself takes no
+// memory
+type generatedLeads struct {
+	n       int
+	dek     []byte
+	wrapped []byte
+}
+
+// syntheticLeads returns n generated leads with encrypted contacts, and the key
+// provider that unwraps their data key
+func syntheticLeads(tb testing.TB, n int) (*generatedLeads, envelope.KMS) {
+	tb.Helper()
+	kek := make([]byte, 32)
+	dek := make([]byte, 32)
+	rand.Read(kek)
+	rand.Read(dek)
+	kms := &envelope.LocalKMS{Keys: map[string][]byte{"bench": kek}}
+	wrapped, err := kms.WrapKey("bench", dek)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &generatedLeads{n: n, dek: dek, wrapped: wrapped}, kms
+}
+
+func (g *generatedLeads) Len() int {
+	return g.n
+}
+
+func (g *generatedLeads) page(_ context.Context, from, limit int) ([]payload.Lead, error) {
+	page := make([]payload.Lead, 0, min(limit, g.n-from))
+	for i := from; i < min(from+limit, g.n); i++ {
+		lead, err := g.lead(i)
+		if err != nil {
+			return nil, err
+		}
+		page = append(page, lead)
+	}
+	return page, nil
+}
+
+func (g *generatedLeads) split(context.Context, *queries.Queries, int, time.Time) (*queries.DeliveryJob, error) {
+	return nil, errors.New("generated leads can't be split")
+}
+
+// lead returns the i-th lead. IDs are derived from i, so every read gives the same lead.
+func (g *generatedLeads) lead(i int) (payload.Lead, error) {
+	email, err := envelope.Seal(g.dek, []byte(fmt.Sprintf("lead%d@example.com", i)))
+	if err != nil {
+		return payload.Lead{}, err
+	}
+	phone, err := envelope.Seal(g.dek, []byte(fmt.Sprintf("+1555%07d", i)))
+	if err != nil {
+		return payload.Lead{}, err
+	}
+	return payload.Lead{
+		ID:          uuid.NewSHA1(uuid.NameSpaceOID, []byte(strconv.Itoa(i))).String(),
+		FirstName:   fmt.Sprintf("First%d", i),
+		LastName:    fmt.Sprintf("Last%d", i),
+		Email:       fmt.Sprintf("%064x", i),
+		Phone:       fmt.Sprintf("%064x", i+g.n),
+		EmailCipher: email,
+		PhoneCipher: phone,
+		DekWrapped:  g.wrapped,
+		DekKmsKeyID: "bench",
+		CompanyName: fmt.Sprintf("Company %d", i%500),
+		Title:       "Director of Operations",
+		Industry:    "Manufacturing",
+		State:       "TX",
+		CountryCode: "US",
+		CapturedAt:  "2024-05-01T12:00:00Z",
+	}, nil
+}
+
+// syntheticPayload returns a v2 embedded payload carrying the leads
+func syntheticPayload(tb testing.TB, leads *generatedLeads) []byte {
+	tb.Helper()
+	p := payload.Payload{Version: payload.CurrentVersion, Leads: make([]payload.Lead, leads.n)}
+	for i := range p.Leads {
+		lead, err := leads.lead(i)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		p.Leads[i] = lead
+	}
+	raw, err := json.Marshal(p)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return raw
+}
+
+// schemaConfig is a campaign's delivery configuration: its csv_field_config,
+// questions and column template
+type schemaConfig struct {
+	fields    []payload.CsvFieldConfig
+	questions []payload.Question
+	template  string
+}
+
+// deliveredTable lays out leads the way runJob does and returns the table
+func deliveredTable(t *testing.T, cfg schemaConfig, leads []payload.Lead, stable bool) *leadfile.Table {
+	t.Helper()
+	ctx := context.Background()
+	p := &payload.Payload{Version: payload.CurrentVersion, CsvFieldConfig: cfg.fields, Questions: cfg.questions, Leads: leads}
+	deliverToBuyerKeys := make(map[string]bool)
+	for _, f := range cfg.fields {
+		if f.DeliverToBuyer {
+			deliverToBuyerKeys[f.Key] = true
+		}
+	}
+	layout, err := loadColumnLayout(ctx, nil, testJob(), templateCampaign(cfg.template), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jl := &embeddedLeads{p: p}
+	usage, _, err := scanLeads(ctx, jl, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager := newLeadPager(ctx, jl)
+	table := buildLeadTable(p, pager, usage, deliverToBuyerKeys, stable)
+	layout.apply(table, pager)
+	return table
+}
+
+func TestStableSchemaVersion(t *testing.T) {
+	quietLogs(t)
+	base := schemaConfig{
+		fields: []payload.CsvFieldConfig{
+			{Key: "first_name", DeliverToBuyer: true},
+			{Key: "email", Label: "Email Address", Order: 1, DeliverToBuyer: true},
+			{Key: "title", DeliverToBuyer: true},
+			{Key: "industry", DeliverToBuyer: true},
+			{Key: "phone"},
+		},
+		questions: []payload.Question{{ID: "q1", QuestionText: "Budget?"}, {ID: "q2", QuestionText: "Timeline?"}},
+		template:  `{"columns": [{"key": "title", "label": "Role"}, {"key": "source", "constant": "LeadShip"}]}`,
+	}
+	// Two batches filling different optional columns
+	batchA := []payload.Lead{{ID: "a1", FirstName: "Jane", EmailCipher: []byte("x"), Title: "CTO", CustomAnswers: map[string]string{"q1": "10k"}}}
+	batchB := []payload.Lead{{ID: "b1", FirstName: "Bob", Industry: "Retail", CustomAnswers: map[string]string{"q2": "Q3"}}}
+
+	stableA := deliveredTable(t, base, batchA, true)
+	stableB := deliveredTable(t, base, batchB, true)
+	if stableA.SchemaVersion() != stableB.SchemaVersion() || !reflect.DeepEqual(stableA.Header(), stableB.Header()) {
+		t.Errorf("stable schema changed between batches: %s %v, %s %v",
+			stableA.SchemaVersion(), stableA.Header(), stableB.SchemaVersion(), stableB.Header())
+	}
+	wantHeader := []string{"Email Address", "First Name", "Industry", "Role", "Budget?", "Timeline?", "source"}
+	if !reflect.DeepEqual(stableA.Header(), wantHeader) {
+		t.Errorf("stable header = %v, want %v", stableA.Header(), wantHeader)
+	}
+
+	// Without a stable schema the columns follow the data
+	if deliveredTable(t, base, batchA, false).SchemaVersion() == deliveredTable(t, base, batchB, false).SchemaVersion() {
+		t.Error("data-driven schemas of batches with different columns share a version")
+	}
+
+	changes := map[string]func(cfg *schemaConfig){
+		"field label": func(cfg *schemaConfig) {
+			cfg.fields[0].Label = "Given Name"
+		},
+		"field order": func(cfg *schemaConfig) {
+			cfg.fields[3].Order = 2
+		},
+		"delivered field": func(cfg *schemaConfig) {
+			cfg.fields[4].DeliverToBuyer = true
+		},
+		"question added": func(cfg *schemaConfig) {
+			cfg.questions = append(cfg.questions, payload.Question{ID: "q3", QuestionText: "Headcount?"})
+		},
+		"question text": func(cfg *schemaConfig) {
+			cfg.questions[1].QuestionText = "When?"
+		},
+		"template label": func(cfg *schemaConfig) {
+			cfg.template = `{"columns": [{"key": "title", "label": "Job Title"}, {"key": "source", "constant": "LeadShip"}]}`
+		},
+		"template column added": func(cfg *schemaConfig) {
+			cfg.template = `{"columns": [{"key": "title", "label": "Role"}, {"key": "source", "constant": "LeadShip"}, {"key": "name", "computed": "full_name"}]}`
+		},
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			cfg := base
+			cfg.fields = slices.Clone(base.fields)
+			cfg.questions = slices.Clone(base.questions)
+			change(&cfg)
+			if v := deliveredTable(t, cfg, batchA, true).SchemaVersion(); v == stableA.SchemaVersion() {
+				t.Errorf("schema version %s unchanged", v)
+			}
+		})
+	}
+
+	// Constant values aren't part of the schema
+	cfg := base
+	cfg.template = `{"columns": [{"key": "title", "label": "Role"}, {"key": "source", "constant": "Other"}]}`
+	if v := deliveredTable(t, cfg, batchB, true).SchemaVersion(); v != stableA.SchemaVersion() {
+		t.Errorf("schema version changed to %s with a constant's value", v)
+	}
+}
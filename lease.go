@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+const (
+	defaultJobLeaseSeconds = 600 // Must outlive the slowest delivery, or the job is reaped mid-send
+	defaultJobClaimLimit   = 100
+)
+
+// jobLeaseSeconds reads JOB_LEASE_SECONDS, falling back to the default
+func jobLeaseSeconds() int32 {
+	if v, err := strconv.Atoi(os.Getenv("JOB_LEASE_SECONDS")); err == nil && v > 0 {
+		return int32(v)
+	}
+	return defaultJobLeaseSeconds
+}
+
+// leaseOwner identifies this invocation on the jobs it claims.
+// The Lambda request ID is unique per invocation; outside Lambda a random ID is used.
+func leaseOwner(ctx context.Context) string {
+	if lc, ok := lambdacontext.FromContext(ctx); ok && lc.AwsRequestID != "" {
+		return lc.AwsRequestID
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s", host, uuid.NewString())
+}
+
+// claimDueJobs atomically moves up to limit of a tenant's due jobs into 'processing' under owner's lease.
+// Rows locked by a concurrent claim are skipped, so overlapping invocations never share a job.
+func claimDueJobs(ctx context.Context, q *queries.Queries, owner string, tenantID uuid.UUID, limit int32) ([]queries.DeliveryJob, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin claim transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	jobs, err := q.WithTx(tx).ClaimDueJobsForTenant(ctx, queries.ClaimDueJobsForTenantParams{
+		LeaseOwner:   utils.SqlNullString(owner),
+		Limit:        limit,
+		LeaseSeconds: jobLeaseSeconds(),
+		TenantID:     tenantID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim due jobs: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit job claim: %w", err)
+	}
+	return jobs, nil
+}
+
+var (
+	// errLeaseLost means the job was reaped or re-claimed before its outcome was recorded
+	errLeaseLost = errors.New("lost lease on job")
+	// errHistoryNotRecorded means a job was released but its delivery history entry
+	// wasn't written
+	errHistoryNotRecorded = errors.New("failed to insert history")
+)
+
+// reapExpiredLeases settles jobs abandoned by crashed or timed-out invocations like any
+// other failed attempt: the abandoned run counted its attempt when it started, so a job
+// out of attempts under its method's retry policy is failed and the rest go back to
+// 'pending' after the policy's backoff.
+func reapExpiredLeases(ctx context.Context, q *queries.Queries) {
+	jobs, err := q.ListExpiredJobLeases(ctx, defaultJobClaimLimit)
+	if err != nil {
+		log.Printf("Failed to list expired job leases: %v", err)
+		return
+	}
+
+	var retried, failed int
+	for _, job := range jobs {
+		policy := fallbackRetryPolicy
+		method, err := q.GetDeliveryMethod(ctx, queries.GetDeliveryMethodParams{ID: job.DeliveryMethodID, TenantID: job.TenantID})
+		switch {
+		case err == nil:
+			policy = retryPolicyFor(&method)
+		case !errors.Is(err, sql.ErrNoRows):
+			log.Printf("Failed to fetch delivery method for expired job %s: %v", job.ID, err)
+			continue
+		}
+
+		attempts := max(job.Attempts, 1)
+		cause := fmt.Errorf("lease held by %s expired on attempt %d/%d", job.LeaseOwner.String, attempts, policy.MaxAttempts)
+		status, historyStatus := "pending", "retry_scheduled"
+		nextAttemptAt := sql.NullTime{Time: time.Now().Add(policy.backoff(attempts)), Valid: true}
+		if attempts >= policy.MaxAttempts {
+			status, historyStatus, nextAttemptAt = "failed", "failed", sql.NullTime{}
+		}
+		if err := releaseJob(ctx, q, &job, status, historyStatus, cause, nextAttemptAt, nil); err != nil {
+			if !errors.Is(err, errLeaseLost) {
+				log.Printf("Failed to reap job %s: %v", job.ID, err)
+			}
+			continue
+		}
+		if status == "failed" {
+			failed++
+		} else {
+			retried++
+		}
+	}
+	if retried+failed > 0 {
+		log.Printf("Reaped %d jobs with expired leases: %d back to pending, %d failed", retried+failed, retried, failed)
+	}
+}
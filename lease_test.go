@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+)
+
+var historyColumns = []string{"id", "tenant_id", "job_id", "buyer_id", "delivery_method_id", "status", "error_message", "payload_summary", "created_at"}
+
+// expiredJob is a processing job whose lease has run out
+func expiredJob(attempts int32) *queries.DeliveryJob {
+	job := testJob()
+	job.Status = "processing"
+	job.Attempts = attempts
+	job.LeaseOwner = sql.NullString{String: "worker-" + job.ID.String()[:8], Valid: true}
+	job.LeaseExpiresAt = sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}
+	return job
+}
+
+// errorMessage matches a last_error or error_message argument containing want, or a
+// NULL one when want is empty
+func errorMessage(want string) argFunc {
+	return func(v driver.Value) bool {
+		if want == "" {
+			return v == nil
+		}
+		s, ok := v.(string)
+		return ok && strings.Contains(s, want)
+	}
+}
+
+// expectRelease answers ReleaseDeliveryJob for job with status, checking the retry
+// delay falls within [minDelay, maxDelay], or that none is set when maxDelay is zero
+func expectRelease(mock sqlmock.Sqlmock, job *queries.DeliveryJob, status, cause string, minDelay, maxDelay time.Duration) *sqlmock.ExpectedQuery {
+	var next any = nil
+	if maxDelay > 0 {
+		next = argFunc(func(v driver.Value) bool {
+			at, ok := v.(time.Time)
+			delay := time.Until(at)
+			return ok && delay > minDelay-time.Second && delay <= maxDelay
+		})
+	}
+	return mock.ExpectQuery(queryName("ReleaseDeliveryJob")).
+		WithArgs(job.ID, status, job.TenantID, errorMessage(cause), job.LeaseOwner.String, next)
+}
+
+func expectHistory(mock sqlmock.Sqlmock, job *queries.DeliveryJob, status, cause string) {
+	mock.ExpectQuery(queryName("CreateDeliveryHistory")).
+		WithArgs(job.TenantID, job.ID, job.BuyerID, job.DeliveryMethodID, status, errorMessage(cause), nil).
+		WillReturnRows(sqlmock.NewRows(historyColumns).AddRow(uuid.New(), job.TenantID, job.ID, job.BuyerID, job.DeliveryMethodID, status, cause, nil, time.Now()))
+}
+
+func TestReapExpiredLeases(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+
+	retry := expiredJob(1)     // API method, 1 of 5 attempts
+	exhausted := expiredJob(3) // Method gone, 3 of the fallback's 3 attempts
+	unclaimed := expiredJob(0) // Reaped before its attempt was counted
+	unclaimed.DeliveryID = uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	broken := expiredJob(1) // Method lookup fails; left for the next sweep
+	lost := expiredJob(1)   // Re-claimed by another worker in the meantime
+
+	rows := sqlmock.NewRows(jobColumns)
+	for _, job := range []*queries.DeliveryJob{retry, exhausted, unclaimed, broken, lost} {
+		rows.AddRow(jobRow(job)...)
+	}
+	mock.ExpectQuery(queryName("ListExpiredJobLeases")).WithArgs(defaultJobClaimLimit).WillReturnRows(rows)
+
+	expectMethod := func(job *queries.DeliveryJob, methodType, config string) {
+		mock.ExpectQuery(queryName("GetDeliveryMethod")).
+			WithArgs(job.DeliveryMethodID, job.TenantID).
+			WillReturnRows(sqlmock.NewRows(deliveryMethodColumns).
+				AddRow(job.DeliveryMethodID, job.TenantID, nil, methodType, []byte(config), true, nil, nil))
+	}
+
+	expectMethod(retry, "api", `{}`)
+	retryCause := "expired on attempt 1/5"
+	expectRelease(mock, retry, "pending", retryCause, 15*time.Second, 30*time.Second).
+		WillReturnRows(sqlmock.NewRows(jobColumns).AddRow(jobRow(retry)...))
+	expectHistory(mock, retry, "retry_scheduled", retryCause)
+
+	mock.ExpectQuery(queryName("GetDeliveryMethod")).
+		WithArgs(exhausted.DeliveryMethodID, exhausted.TenantID).
+		WillReturnRows(sqlmock.NewRows(deliveryMethodColumns))
+	exhaustedCause := "expired on attempt 3/3"
+	expectRelease(mock, exhausted, "failed", exhaustedCause, 0, 0).
+		WillReturnRows(sqlmock.NewRows(jobColumns).AddRow(jobRow(exhausted)...))
+	expectHistory(mock, exhausted, "failed", exhaustedCause)
+
+	// The configured policy applies; attempts count from one
+	expectMethod(unclaimed, "email", `{"retry": {"base_delay_sec": 600, "max_attempts": 2}}`)
+	unclaimedCause := "expired on attempt 1/2"
+	expectRelease(mock, unclaimed, "pending", unclaimedCause, 5*time.Minute, 10*time.Minute).
+		WillReturnRows(sqlmock.NewRows(jobColumns).AddRow(jobRow(unclaimed)...))
+	mock.ExpectExec(queryName("UpdateDeliveryStatus")).
+		WithArgs(unclaimed.DeliveryID.UUID, unclaimed.TenantID, "pending").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(mock, unclaimed, "retry_scheduled", unclaimedCause)
+
+	mock.ExpectQuery(queryName("GetDeliveryMethod")).
+		WithArgs(broken.DeliveryMethodID, broken.TenantID).
+		WillReturnError(errors.New("connection reset"))
+
+	// The release matches no row, so nothing else is recorded
+	expectMethod(lost, "api", `{}`)
+	expectRelease(mock, lost, "pending", "expired on attempt 1/5", 15*time.Second, 30*time.Second).
+		WillReturnRows(sqlmock.NewRows(jobColumns))
+
+	reapExpiredLeases(context.Background(), q)
+}
+
+func TestReapExpiredLeasesListFails(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	mock.ExpectQuery(queryName("ListExpiredJobLeases")).WillReturnError(errors.New("connection reset"))
+	reapExpiredLeases(context.Background(), q)
+}
+
+func TestReleaseJobHistoryNotRecorded(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	job := expiredJob(1)
+	cause := errors.New("buyer API returned 503")
+
+	expectRelease(mock, job, "failed", cause.Error(), 0, 0).
+		WillReturnRows(sqlmock.NewRows(jobColumns).AddRow(jobRow(job)...))
+	mock.ExpectQuery(queryName("CreateDeliveryHistory")).WillReturnError(errors.New("connection reset"))
+
+	err := releaseJob(context.Background(), q, job, "failed", "failed", cause, sql.NullTime{}, nil)
+	if !errors.Is(err, errHistoryNotRecorded) {
+		t.Errorf("releaseJob = %v, want errHistoryNotRecorded", err)
+	}
+}
+
+func TestReleaseJobLeaseLost(t *testing.T) {
+	q, mock := newMockQueries(t)
+	job := expiredJob(1)
+	expectRelease(mock, job, "success", "", 0, 0).WillReturnRows(sqlmock.NewRows(jobColumns))
+
+	err := releaseJob(context.Background(), q, job, "success", "success", nil, sql.NullTime{}, nil)
+	if !errors.Is(err, errLeaseLost) {
+		t.Errorf("releaseJob = %v, want errLeaseLost", err)
+	}
+}
@@ -11,14 +11,15 @@ import (
     "sync"
 
     "net/url"
+    "path"
     "time"
     "bytes"
     "net/http"
     "io"
-    "encoding/csv"
     "encoding/json"
     "encoding/base64"
     "mime/multipart"
+    "net/textproto"
     "github.com/google/uuid"
     "github.com/aws/aws-lambda-go/lambda"
     "github.com/aws/aws-sdk-go-v2/aws"
@@ -29,7 +30,13 @@ import (
     sesv2types "github.com/aws/aws-sdk-go-v2/service/sesv2/types"
     _ "github.com/jackc/pgx/v5/stdlib"
     //"github.com/DylanCoon99/delivery/cmd/types"
+    "github.com/DylanCoon99/delivery/internal/envelope"
+    "github.com/DylanCoon99/delivery/internal/leadfile"
+    "github.com/DylanCoon99/delivery/internal/payload"
+    "github.com/DylanCoon99/delivery/internal/targeting"
     "github.com/DylanCoon99/delivery/internal/utils"
+    "github.com/DylanCoon99/delivery/internal/validation"
+    "github.com/DylanCoon99/delivery/signature"
     "github.com/DylanCoon99/delivery/internal/database/queries"
 
 )
@@ -39,6 +46,7 @@ var (
     dbQueries   *queries.Queries
     sesClient   *ses.Client
     sesV2Client *sesv2.Client
+    awsCfg      aws.Config // Base config for per-method clients (S3)
 
     // Credential caching
     credentialsMu      sync.Mutex
@@ -46,16 +54,52 @@ var (
     credentialsTTL     = 15 * time.Minute // Refresh credentials every 15 minutes
 )
 
+// SenderAddress is the From address of every delivery and notification email
+const SenderAddress = "notifications@mail.lead-ship.com"
+
+// schemaVersionHeader carries the delivered file's schema version to API buyers
+const schemaVersionHeader = "X-LeadShip-Schema-Version"
+
+// sesMaxMessageSize is the largest raw message SES accepts, attachment included
+const sesMaxMessageSize = 10 << 20
+
 // ErrEmailSuppressed indicates the email address is on a suppression list
 var ErrEmailSuppressed = errors.New("email address is suppressed")
 
 // ErrPermanentAPIFailure indicates a non-retryable API error (4xx responses)
 var ErrPermanentAPIFailure = errors.New("permanent API failure")
 
+// isPermanentDeliveryFailure reports whether a delivery error must not be retried
+func isPermanentDeliveryFailure(err error) bool {
+    return errors.Is(err, ErrEmailSuppressed) ||
+           errors.Is(err, payload.ErrInvalidPayload) ||
+           errors.Is(err, ErrPermanentAPIFailure) ||
+           errors.Is(err, ErrPermanentSFTPFailure) ||
+           errors.Is(err, envelope.ErrCorrupt)
+}
+
+const defaultFilenamePattern = "leads_{timestamp}.csv"
+
+// deliveryFilename expands a method's filename pattern for a job.
+// Supported placeholders: {timestamp}, {date}, {job_id}, {buyer_id}.
+func deliveryFilename(pattern string, job *queries.DeliveryJob, now time.Time) string {
+    if pattern == "" {
+        pattern = defaultFilenamePattern
+    }
+    name := strings.NewReplacer(
+        "{timestamp}", now.Format("20060102_150405"),
+        "{date}", now.Format("20060102"),
+        "{job_id}", job.ID.String(),
+        "{buyer_id}", job.BuyerID.String(),
+    ).Replace(pattern)
+    // The pattern names a file, never a path
+    return path.Base(name)
+}
+
 type APIDeliveryConfig struct {
     URL        string            `json:"url"`
     Method     string            `json:"method,omitempty"`      // HTTP method, defaults to POST
-    AuthType   string            `json:"auth_type,omitempty"`   // "api_key", "bearer", "basic", or empty
+    AuthType   string            `json:"auth_type,omitempty"`   // "api_key", "bearer", "basic", "hmac", "oauth2", or empty
     APIKey     string            `json:"api_key,omitempty"`     // API key value
     AuthHeader string            `json:"auth_header,omitempty"` // Header name for API key (default: X-API-Key)
     BearerToken string           `json:"bearer_token,omitempty"`
@@ -63,6 +107,15 @@ type APIDeliveryConfig struct {
     BasicPass  string            `json:"basic_pass,omitempty"`
     Headers    map[string]string `json:"headers,omitempty"`     // Additional custom headers
     TimeoutSec int               `json:"timeout_sec,omitempty"` // Request timeout in seconds
+    Format     string            `json:"format,omitempty"`      // "multipart_csv" (default), "json_array", "ndjson" or "per_lead"
+    BatchSize  int               `json:"batch_size,omitempty"`  // Leads per request for json_array/ndjson; 0 sends all in one request
+    FieldMap   map[string]string `json:"field_map,omitempty"`   // Column key or header -> JSON field name
+    HMACSecrets []string         `json:"hmac_secrets,omitempty"` // Active signing secrets, current first; two while rotating
+    SignatureHeader string       `json:"signature_header,omitempty"` // Defaults to X-LeadShip-Signature
+    OAuth2     *OAuth2Config     `json:"oauth2,omitempty"`      // Client-credentials settings for auth_type "oauth2"
+    StatusClasses map[string]string `json:"status_classes,omitempty"` // "429" or "4xx" -> "retryable"/"permanent", overriding the defaults
+
+    schemaVersion string // Sent as schemaVersionHeader with every request
 }
 
 /*
@@ -187,7 +240,9 @@ func isAuthError(err error) bool {
            strings.Contains(errStr, "SQLSTATE 28000")
 }
 
-func init() {
+// setup connects to the database and AWS once per cold start. It runs from main
+// rather than init so the package's tests don't need either.
+func setup() {
     log.Println("Initializing Lambda function...")
 
     // Initial database connection
@@ -201,6 +256,7 @@ func init() {
         log.Fatalf("Failed to load AWS config: %v", err)
     }
 
+    awsCfg = cfg
     sesClient = ses.NewFromConfig(cfg)
     sesV2Client = sesv2.NewFromConfig(cfg)
     log.Println("SES clients initialized")
@@ -216,14 +272,8 @@ func handler(ctx context.Context) error {
         log.Printf("Warning: failed to refresh credentials: %v", err)
     }
 
-    limit := int32(50) // default
-    offset := int32(0) // default
-
     // Try to fetch tenants, retry with fresh credentials on auth error
-    tenants, err := dbQueries.ListTenants(ctx, queries.ListTenantsParams{
-        Limit:  limit,
-        Offset: offset,
-    })
+    tenantIDs, err := listTenantIDs(ctx, dbQueries)
     if err != nil {
         // If auth error, refresh credentials and retry once
         if isAuthError(err) {
@@ -232,10 +282,7 @@ func handler(ctx context.Context) error {
                 return fmt.Errorf("failed to refresh credentials: %w", refreshErr)
             }
             // Retry the query
-            tenants, err = dbQueries.ListTenants(ctx, queries.ListTenantsParams{
-                Limit:  limit,
-                Offset: offset,
-            })
+            tenantIDs, err = listTenantIDs(ctx, dbQueries)
             if err != nil {
                 return fmt.Errorf("failed to fetch tenants after credential refresh: %w", err)
             }
@@ -243,9 +290,14 @@ func handler(ctx context.Context) error {
             return fmt.Errorf("failed to fetch tenants: %w", err)
         }
     }
-    log.Printf("Got tenants: %v", tenants)
+    log.Printf("Sweeping %d tenants", len(tenantIDs))
 
-    if err := processJobs(ctx, dbQueries); err != nil {
+    // Turn due cron schedules into jobs before claiming, so this run can deliver them
+    for _, tenantID := range tenantIDs {
+        runDueSchedules(ctx, dbQueries, tenantID)
+    }
+
+    if err := processJobs(ctx, dbQueries, tenantIDs); err != nil {
         log.Printf("Error processing jobs %v",  err)
     }
 
@@ -253,29 +305,56 @@ func handler(ctx context.Context) error {
 }
 
 
-func processJobs(ctx context.Context, q *queries.Queries) error {
-    
-    pending, err := q.GetDueJobs(ctx)
+func processJobs(ctx context.Context, q *queries.Queries, tenantIDs []uuid.UUID) error {
+
+    // Return jobs left behind by crashed or timed-out invocations before claiming
+    reapExpiredLeases(ctx, q)
+
+    owner := leaseOwner(ctx)
+    log.Printf("Processing due jobs as %s", owner)
+
+    processTenantJobs(ctx, q, owner, tenantIDs)
+
+    return nil
+}
 
+// processJob runs one claimed job. A run that stops on an error before settling the
+// job (a database outage, say) releases it for a retry, so the job doesn't sit leased
+// until the reaper finds it.
+func processJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob) error {
+    err := runJob(ctx, q, job)
     if err != nil {
-        return err
-    } else {
-        log.Printf("Got Pending Jobs: %v", pending)
+        retryJobAfterError(ctx, q, job, err)
     }
+    return err
+}
 
-    for _, job := range pending {
-        if err := processJob(ctx, q, &job); err != nil {
-            log.Printf("job %s failed: %v", job.ID, err)
-        }
+// retryJobAfterError puts a job back to pending after its method's backoff, or fails it
+// once it is out of attempts. A job the run already settled no longer holds our lease
+// and is left as it is.
+func retryJobAfterError(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, cause error) {
+    policy := fallbackRetryPolicy
+    if method, err := q.GetDeliveryMethod(ctx, queries.GetDeliveryMethodParams{ID: job.DeliveryMethodID, TenantID: job.TenantID}); err == nil {
+        policy = retryPolicyFor(&method)
     }
 
-    return nil
+    status, historyStatus := "pending", "retry_scheduled"
+    nextAttemptAt := sql.NullTime{Time: time.Now().Add(policy.backoff(job.Attempts + 1)), Valid: true}
+    if job.Attempts+1 >= policy.MaxAttempts {
+        status, historyStatus, nextAttemptAt = "failed", "failed", sql.NullTime{}
+    }
+    if err := releaseJob(ctx, q, job, status, historyStatus, cause, nextAttemptAt, nil); err != nil {
+        if !errors.Is(err, errLeaseLost) {
+            log.Printf("Failed to release job %s after error: %v", job.ID, err)
+        }
+        return
+    }
+    log.Printf("Job %s stopped on an error (attempt %d/%d), now %s", job.ID, job.Attempts+1, policy.MaxAttempts, status)
 }
 
-func processJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob) error {
-    
-    const maxRetries = 3
-    
+// runJob delivers one claimed job and records the outcome
+func runJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob) error {
+
     // Increment attempts
     if err := q.IncrementDeliveryJobAttempts(ctx, queries.IncrementDeliveryJobAttemptsParams{
         ID:       job.ID,
@@ -286,317 +365,104 @@ func processJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJo
 
 
     // Parse payload
-    var payload map[string]interface{}
-    if err := json.Unmarshal(job.Payload, &payload); err != nil {
-        return fmt.Errorf("failed to parse payload: %w", err)
-    }
-
-    // Extract leads
-    leadsData, ok := payload["leads"].([]interface{})
-    if !ok {
-        return fmt.Errorf("invalid leads data in payload")
-    }
-
-    log.Printf("Leads: %v", leadsData)
-
-    // Extract campaign questions (for custom column headers)
-    type QuestionInfo struct {
-        ID           string
-        QuestionText string
-        DisplayOrder int
-    }
-    var questions []QuestionInfo
-
-    // Debug: log the raw questions data from payload
-    rawQuestions := payload["questions"]
-    log.Printf("Raw questions from payload: %v (type: %T)", rawQuestions, rawQuestions)
-
-    if questionsData, ok := payload["questions"].([]interface{}); ok {
-        log.Printf("Found %d questions in payload", len(questionsData))
-        for _, qInterface := range questionsData {
-            if qMap, ok := qInterface.(map[string]interface{}); ok {
-                q := QuestionInfo{}
-                if id, ok := qMap["id"].(string); ok {
-                    q.ID = id
-                }
-                if text, ok := qMap["question_text"].(string); ok {
-                    q.QuestionText = text
-                }
-                if order, ok := qMap["display_order"].(float64); ok {
-                    q.DisplayOrder = int(order)
-                }
-                log.Printf("Extracted question: ID=%s, Text=%s, Order=%d", q.ID, q.QuestionText, q.DisplayOrder)
-                questions = append(questions, q)
-            }
-        }
-    } else {
-        log.Printf("Warning: Could not extract questions from payload - type assertion failed")
-    }
-
-    // Sort questions by display_order
-    for i := 0; i < len(questions)-1; i++ {
-        for j := i + 1; j < len(questions); j++ {
-            if questions[j].DisplayOrder < questions[i].DisplayOrder {
-                questions[i], questions[j] = questions[j], questions[i]
-            }
-        }
+    jobPayload, err := payload.Decode(job.Payload)
+    if err != nil {
+        // Retrying can't fix a malformed payload
+        return failJob(ctx, q, job, err)
     }
 
-    // Parse csv_field_config from payload for deliver_to_buyer filtering
-    type CsvFieldConfigEntry struct {
-        Key            string `json:"key"`
-        Label          string `json:"label"`
-        DeliverToBuyer bool   `json:"deliver_to_buyer"`
-        Required       bool   `json:"required"`
-        Order          int32  `json:"order"`
-    }
-    var csvFieldConfig []CsvFieldConfigEntry
-    if cfgData, ok := payload["csv_field_config"].([]interface{}); ok && len(cfgData) > 0 {
-        for _, cfgInterface := range cfgData {
-            if cfgMap, ok := cfgInterface.(map[string]interface{}); ok {
-                entry := CsvFieldConfigEntry{}
-                if key, ok := cfgMap["key"].(string); ok {
-                    entry.Key = key
-                }
-                if label, ok := cfgMap["label"].(string); ok {
-                    entry.Label = label
-                }
-                if dtb, ok := cfgMap["deliver_to_buyer"].(bool); ok {
-                    entry.DeliverToBuyer = dtb
-                }
-                if req, ok := cfgMap["required"].(bool); ok {
-                    entry.Required = req
-                }
-                if order, ok := cfgMap["order"].(float64); ok {
-                    entry.Order = int32(order)
-                }
-                csvFieldConfig = append(csvFieldConfig, entry)
+    // Reference payloads carry only the batch; deliver its leads as they are now
+    snapshot := &snapshotSummary{Mode: payload.ModeEmbedded}
+    if jobPayload.IsReference() {
+        if err := resolveReferencePayload(ctx, q, job, jobPayload); err != nil {
+            if errors.Is(err, errReferenceUnresolvable) {
+                return failJob(ctx, q, job, err)
             }
+            return err
         }
-        log.Printf("Parsed %d csv_field_config entries", len(csvFieldConfig))
+        snapshot.Mode = payload.ModeReference
     }
-
-    // Build a set of field keys that should be delivered to the buyer
-    deliverToBuyerKeys := make(map[string]bool)
-    hasCsvFieldConfig := len(csvFieldConfig) > 0
-    if hasCsvFieldConfig {
-        for _, cfg := range csvFieldConfig {
-            if cfg.DeliverToBuyer {
-                deliverToBuyerKeys[cfg.Key] = true
-            }
-        }
-        log.Printf("deliver_to_buyer keys: %v", deliverToBuyerKeys)
+    campaign, err := payloadCampaign(ctx, q, job, jobPayload)
+    if err != nil {
+        return err
     }
 
-    // Helper function to safely extract string values
-    extractString := func(field interface{}) string {
-        if field == nil {
-            return ""
-        }
-        // Handle nested map structure (e.g., map[String:value Valid:true])
-        if fieldMap, ok := field.(map[string]interface{}); ok {
-            if strVal, exists := fieldMap["String"]; exists && strVal != nil {
-                if str, ok := strVal.(string); ok {
-                    return str
-                }
-            }
-        }
-        // Handle direct string
-        if str, ok := field.(string); ok {
-            return str
+    // Resolve the column template, whose required fields are one of the filters
+    layout, err := loadColumnLayout(ctx, q, job, campaign, jobPayload)
+    if err != nil {
+        if errors.Is(err, errInvalidColumnTemplate) {
+            return failJob(ctx, q, job, err)
         }
-        return ""
+        return err
     }
 
-    // Helper function to extract IP address (stored as IPNet structure)
-    extractIPAddress := func(field interface{}) string {
-        if field == nil {
-            return ""
-        }
-        if fieldMap, ok := field.(map[string]interface{}); ok {
-            // Check Valid flag
-            if valid, exists := fieldMap["Valid"]; exists {
-                if validBool, ok := valid.(bool); ok && !validBool {
-                    return ""
-                }
-            }
-            // Extract IP from IPNet structure: { IPNet: { IP: "x.x.x.x" }, Valid: true }
-            if ipNet, exists := fieldMap["IPNet"]; exists && ipNet != nil {
-                if ipNetMap, ok := ipNet.(map[string]interface{}); ok {
-                    if ip, exists := ipNetMap["IP"]; exists && ip != nil {
-                        if ipStr, ok := ip.(string); ok {
-                            return ipStr
-                        }
-                    }
-                }
+    // Filter the leads a page at a time. A reference batch is read from the database
+    // and what survives is spooled for the delivery, so it is never in memory whole.
+    filters := &leadFilters{q: q, job: job, campaign: campaign, p: jobPayload, layout: layout}
+    var leads jobLeads
+    if jobPayload.IsReference() {
+        spool := newLeadSpool(q, job, jobPayload)
+        // The spool holds lead data; it only lives as long as the attempt
+        defer func() {
+            if err := spool.clear(ctx); err != nil {
+                log.Printf("Job %s: %v", job.ID, err)
             }
-        }
-        return ""
+        }()
+        err = spool.fill(ctx, filters)
+        snapshot.LoadedAt = time.Now().UTC()
+        leads = spool
+    } else {
+        jobPayload.Leads, err = filters.apply(ctx, jobPayload.Leads)
+        leads = &embeddedLeads{job: job, p: jobPayload}
     }
-
-    // Helper function to extract timestamp (stored as Time structure)
-    extractTimestamp := func(field interface{}) string {
-        if field == nil {
-            return ""
-        }
-        if fieldMap, ok := field.(map[string]interface{}); ok {
-            // Check Valid flag
-            if valid, exists := fieldMap["Valid"]; exists {
-                if validBool, ok := valid.(bool); ok && !validBool {
-                    return ""
-                }
-            }
-            // Extract Time value
-            if timeVal, exists := fieldMap["Time"]; exists && timeVal != nil {
-                if timeStr, ok := timeVal.(string); ok {
-                    return timeStr
-                }
-            }
-        }
-        return ""
-    }
-
-    // Helper function to extract custom answers from a lead
-    extractCustomAnswers := func(lead map[string]interface{}) map[string]interface{} {
-        if ca, ok := lead["CustomAnswers"]; ok && ca != nil {
-            if caMap, ok := ca.(map[string]interface{}); ok {
-                if rawMsg, hasRaw := caMap["RawMessage"]; hasRaw {
-                    if valid, hasValid := caMap["Valid"]; hasValid {
-                        if validBool, ok := valid.(bool); ok && validBool {
-                            if rawMap, ok := rawMsg.(map[string]interface{}); ok {
-                                return rawMap
-                            }
-                        }
-                    }
-                } else {
-                    return caMap
-                }
-            }
+    if err != nil {
+        if errors.Is(err, errReferenceUnresolvable) ||
+            errors.Is(err, targeting.ErrInvalidCriteria) ||
+            errors.Is(err, validation.ErrInvalidRules) ||
+            errors.Is(err, envelope.ErrCorrupt) ||
+            errors.Is(err, errMissingRequiredField) {
+            return failJob(ctx, q, job, err)
         }
-        return nil
+        return err
     }
-
-    // Define column structure for dynamic CSV generation
-    type ColumnDef struct {
-        Key       string
-        Header    string
-        Extractor func(lead map[string]interface{}) string
-    }
-
-    // Build column definitions for base fields
-    baseColumns := []ColumnDef{
-        {Key: "first_name", Header: "First Name", Extractor: func(l map[string]interface{}) string { return extractString(l["FirstName"]) }},
-        {Key: "last_name", Header: "Last Name", Extractor: func(l map[string]interface{}) string { return extractString(l["LastName"]) }},
-        {Key: "email", Header: "Email", Extractor: func(l map[string]interface{}) string { return extractString(l["EmailHash"]) }},
-        {Key: "phone", Header: "Phone Number", Extractor: func(l map[string]interface{}) string { return extractString(l["PhoneHash"]) }},
-        {Key: "ip_address", Header: "IP Address", Extractor: func(l map[string]interface{}) string { return extractIPAddress(l["IpAddress"]) }},
-        {Key: "company_name", Header: "Company Name", Extractor: func(l map[string]interface{}) string { return extractString(l["CompanyName"]) }},
-        {Key: "address", Header: "Address", Extractor: func(l map[string]interface{}) string { return extractString(l["Address"]) }},
-        {Key: "country_code", Header: "Country Code", Extractor: func(l map[string]interface{}) string { return extractString(l["CountryCode"]) }},
-        {Key: "linkedin_contact", Header: "LinkedIn Contact", Extractor: func(l map[string]interface{}) string { return extractString(l["LinkedinContact"]) }},
-        {Key: "linkedin_company", Header: "LinkedIn Company", Extractor: func(l map[string]interface{}) string { return extractString(l["LinkedinCompany"]) }},
-        {Key: "downloaded_asset_name", Header: "Downloaded Asset Name", Extractor: func(l map[string]interface{}) string { return extractString(l["DownloadedAssetName"]) }},
-        {Key: "publisher_name", Header: "Publisher Name", Extractor: func(l map[string]interface{}) string { return extractString(l["PublisherName"]) }},
-        {Key: "industry", Header: "Industry", Extractor: func(l map[string]interface{}) string { return extractString(l["Industry"]) }},
-        {Key: "revenue_size", Header: "Revenue Size", Extractor: func(l map[string]interface{}) string { return extractString(l["RevenueSize"]) }},
-        {Key: "employee_size", Header: "Employee Size", Extractor: func(l map[string]interface{}) string { return extractString(l["EmployeeSize"]) }},
-        {Key: "state", Header: "State", Extractor: func(l map[string]interface{}) string { return extractString(l["State"]) }},
-        {Key: "title", Header: "Title", Extractor: func(l map[string]interface{}) string { return extractString(l["Title"]) }},
-        {Key: "captured_at", Header: "Date/Time Stamp", Extractor: func(l map[string]interface{}) string { return extractTimestamp(l["CapturedAt"]) }},
-        {Key: "naics_code", Header: "NAICS Code", Extractor: func(l map[string]interface{}) string { return extractString(l["NaicsCode"]) }},
-    }
-
-    // First pass: extract all values and track which columns have data
-    type LeadValues struct {
-        BaseValues     []string
-        QuestionValues []string
-    }
-    allLeadValues := make([]LeadValues, 0, len(leadsData))
-    columnHasData := make([]bool, len(baseColumns))
-    questionHasData := make([]bool, len(questions))
-
-    for _, leadInterface := range leadsData {
-        lead := leadInterface.(map[string]interface{})
-
-        // Extract base column values
-        baseValues := make([]string, len(baseColumns))
-        for i, col := range baseColumns {
-            val := col.Extractor(lead)
-            baseValues[i] = val
-            if val != "" {
-                columnHasData[i] = true
-            }
-        }
-
-        // Extract question values
-        customAnswers := extractCustomAnswers(lead)
-        questionValues := make([]string, len(questions))
-        for i, q := range questions {
-            answer := ""
-            if customAnswers != nil {
-                if val, exists := customAnswers[q.ID]; exists && val != nil {
-                    answer = extractString(val)
-                }
-            }
-            questionValues[i] = answer
-            if answer != "" {
-                questionHasData[i] = true
-            }
-        }
-
-        allLeadValues = append(allLeadValues, LeadValues{
-            BaseValues:     baseValues,
-            QuestionValues: questionValues,
-        })
+    filters.report(ctx)
+    if outcome := filters.outcome(); outcome != "" && leads.Len() == 0 {
+        log.Printf("Job %s: every lead removed before delivery (%s), nothing to deliver", job.ID, outcome)
+        return settleJob(ctx, q, job, "success", outcome, nil, filters.summary())
+    }
+
+    // Reserve room under the campaign's lead caps, holding back what doesn't fit
+    reservation, paced, err := reserveCampaignLeads(ctx, q, job, campaign, leads, time.Now())
+    var hold *pacingHold
+    switch {
+    case errors.As(err, &hold):
+        summary := filters.summary()
+        summary.Pacing = paced
+        return holdJob(ctx, q, job, hold, summary)
+    case errors.Is(err, errCampaignEnded), errors.Is(err, errCampaignTargetReached):
+        log.Printf("Job %s not delivered: %v", job.ID, err)
+        summary := filters.summary()
+        summary.Pacing = paced
+        return settleJob(ctx, q, job, "failed", "cap_reached", err, summary)
+    case err != nil:
+        return err
     }
+    // Anything short of a successful delivery gives the reserved leads back
+    defer reservation.release(ctx, q)
 
-    // Build header with only columns that should be delivered
-    var header []string
-    var includedBaseColumns []int
-    var includedQuestionColumns []int
-
-    for i, hasData := range columnHasData {
-        if !hasData {
-            continue
-        }
-        // If csv_field_config is present, only include columns where deliver_to_buyer is true
-        if hasCsvFieldConfig {
-            if !deliverToBuyerKeys[baseColumns[i].Key] {
-                continue
-            }
-        }
-        header = append(header, baseColumns[i].Header)
-        includedBaseColumns = append(includedBaseColumns, i)
+    // One pass over the leads finds the columns with data and fingerprints the snapshot
+    usage, snapshotHash, err := scanLeads(ctx, leads, jobPayload)
+    if err != nil {
+        return err
     }
-    for i, hasData := range questionHasData {
-        if hasData {
-            header = append(header, questions[i].QuestionText)
-            includedQuestionColumns = append(includedQuestionColumns, i)
-        }
+    snapshot.LeadBatchID = jobPayload.LeadBatchID
+    snapshot.LeadCount = leads.Len()
+    snapshot.Hash = snapshotHash
+    if jobPayload.IsReference() {
+        jobPayload.TotalLeads = leads.Len()
     }
 
-    log.Printf("CSV will include %d base columns (of %d) and %d question columns (of %d)",
-        len(includedBaseColumns), len(baseColumns), len(includedQuestionColumns), len(questions))
-    log.Printf("Header columns: %v", header)
-
-    // Generate CSV
-    csvBuffer := new(bytes.Buffer)
-    writer := csv.NewWriter(csvBuffer)
-    writer.Write(header)
-
-    // Write lead data with only included columns
-    for _, leadValues := range allLeadValues {
-        var row []string
-        for _, idx := range includedBaseColumns {
-            row = append(row, leadValues.BaseValues[idx])
-        }
-        for _, idx := range includedQuestionColumns {
-            row = append(row, leadValues.QuestionValues[idx])
-        }
-        writer.Write(row)
-    }
-    writer.Flush()
-
+    log.Printf("Payload v%d: %d leads, %d questions", jobPayload.Version, leads.Len(), len(jobPayload.Questions))
 
     method, err := q.GetDeliveryMethod(ctx, queries.GetDeliveryMethodParams{
         ID:       job.DeliveryMethodID,
@@ -604,67 +470,241 @@ func processJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJo
     })
     
     if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return failJob(ctx, q, job, fmt.Errorf("delivery method %s not found", job.DeliveryMethodID))
+        }
         return fmt.Errorf("failed to fetch delivery method: %w", err)
     }
 
+    retryPolicy := retryPolicyFor(&method)
+
+    // File format and schema settings of the method
+    fileConfig, err := leadfile.ParseConfig(method.Config)
+    if err != nil {
+        return failJob(ctx, q, job, err)
+    }
+
+    // csv_field_config from payload for deliver_to_buyer filtering
+    csvFieldConfig := jobPayload.CsvFieldConfig
+    if len(csvFieldConfig) > 0 {
+        log.Printf("Parsed %d csv_field_config entries", len(csvFieldConfig))
+    }
+
+    // Build a set of field keys that should be delivered to the buyer
+    deliverToBuyerKeys := make(map[string]bool)
+    hasCsvFieldConfig := len(csvFieldConfig) > 0
+    if hasCsvFieldConfig {
+        for _, cfg := range csvFieldConfig {
+            if cfg.DeliverToBuyer {
+                deliverToBuyerKeys[cfg.Key] = true
+            }
+        }
+        log.Printf("deliver_to_buyer keys: %v", deliverToBuyerKeys)
+    }
+
+    // Email and phone are stored as hashes and ciphers; decrypt them for every column that
+    // can reach the buyer, which is all of them when there's no csv_field_config, so a
+    // hash or cipher is never delivered in their place
+    decryptEmail := !hasCsvFieldConfig || deliverToBuyerKeys["email"]
+    decryptPhone := !hasCsvFieldConfig || deliverToBuyerKeys["phone"]
+    // Rows are read a page at a time as they're written; each page is decrypted as it loads
+    pager := newLeadPager(ctx, leads)
+    if decryptEmail || decryptPhone {
+        keyProvider, err := leadKeyProvider()
+        if err != nil {
+            return fmt.Errorf("lead key provider unavailable: %w", err)
+        }
+        decrypter := &pageDecrypter{kms: keyProvider, email: decryptEmail, phone: decryptPhone}
+        defer decrypter.wipe()
+        pager.onLoad(decrypter.decrypt)
+        pager.onDone(decrypter.wipeRow)
+    }
+
+    // Assemble the delivered lead table, then relabel, add and order columns per the template
+    table := buildLeadTable(jobPayload, pager, usage, deliverToBuyerKeys, fileConfig.StableSchema)
+    layout.apply(table, pager)
+
+    schema := &schemaSummary{Version: table.SchemaVersion(), Stable: fileConfig.StableSchema, Columns: len(table.Columns)}
+    log.Printf("Delivering schema %s (%d columns, stable: %t)", schema.Version, schema.Columns, schema.Stable)
+
+    summary := filters.summary()
+    summary.LeadCount = table.Rows
+    summary.Snapshot = snapshot
+    summary.Pacing = paced
+    summary.Schema = schema
+
+
+    // The file is rendered as each destination reads it, never held whole in memory
+    file := leadfile.New(table, fileConfig, leadfile.Metadata{
+        JobID:         job.ID.String(),
+        BuyerID:       job.BuyerID.String(),
+        LeadBatchID:   jobPayload.LeadBatchID,
+        SnapshotHash:  snapshot.Hash,
+        SchemaVersion: schema.Version,
+        GeneratedAt:   time.Now(),
+    })
+    filename := file.Filename(fmt.Sprintf("leads_%s", time.Now().Format("20060102_150405")))
 
     // Execute delivery
     var deliveryErr error
+    var settled map[string]bool // Leads earlier attempts settled with an API buyer, see previouslySettledLeads
 
     switch method.MethodType.String {
     case "email":
-        deliveryErr = deliverEmail(ctx, job, &method, csvBuffer.Bytes(), fmt.Sprintf("leads_%s.csv", time.Now().Format("20060102_150405")))
+        deliveryErr = deliverEmail(ctx, job, &method, jobPayload, file, filename)
     case "api":
         var cfg APIDeliveryConfig
         if err := json.Unmarshal(method.Config, &cfg); err != nil {
-            return fmt.Errorf("invalid api config json: %w", err)
+            return failJob(ctx, q, job, fmt.Errorf("invalid api config json: %w", err))
         }
         if cfg.URL == "" {
-            return fmt.Errorf("api method missing url config")
+            return failJob(ctx, q, job, fmt.Errorf("api method missing url config"))
+        }
+        if !isValidAPIFormat(cfg.Format) {
+            return failJob(ctx, q, job, fmt.Errorf("unknown api format: %s", cfg.Format))
+        }
+        if cfg.AuthType == "hmac" && (len(cfg.HMACSecrets) == 0 || len(cfg.HMACSecrets) > signature.MaxActiveSecrets) {
+            return failJob(ctx, q, job, fmt.Errorf("api method hmac auth needs 1 to %d hmac_secrets", signature.MaxActiveSecrets))
+        }
+        if cfg.AuthType == "oauth2" && (cfg.OAuth2 == nil || cfg.OAuth2.TokenURL == "" || cfg.OAuth2.ClientID == "") {
+            return failJob(ctx, q, job, fmt.Errorf("api method oauth2 auth missing token_url or client_id"))
+        }
+        if err := isValidStatusClasses(cfg.StatusClasses); err != nil {
+            return failJob(ctx, q, job, fmt.Errorf("invalid api config: %w", err))
         }
-        deliveryErr = deliverAPI(ctx, job, &method, cfg, csvBuffer.Bytes(), fmt.Sprintf("leads_%s.csv", time.Now().Format("20060102_150405")))
+        // Leads an earlier attempt of this job already settled with the buyer aren't resent
+        if cfg.Format != "" && cfg.Format != apiFormatMultipartCSV {
+            settled = previouslySettledLeads(ctx, q, job)
+        }
+        cfg.schemaVersion = file.SchemaVersion
+        deliveryErr = deliverAPI(ctx, job, &method, cfg, table, file, filename, settled, summary)
+    case "sftp":
+        var cfg SFTPDeliveryConfig
+        if err := json.Unmarshal(method.Config, &cfg); err != nil {
+            return failJob(ctx, q, job, fmt.Errorf("invalid sftp config json: %w", err))
+        }
+        if cfg.Host == "" || cfg.Username == "" {
+            return failJob(ctx, q, job, fmt.Errorf("sftp method missing host or username config"))
+        }
+        deliveryErr = deliverSFTP(ctx, job, cfg, file)
+    case "s3":
+        var cfg S3DeliveryConfig
+        if err := json.Unmarshal(method.Config, &cfg); err != nil {
+            return failJob(ctx, q, job, fmt.Errorf("invalid s3 config json: %w", err))
+        }
+        if cfg.Bucket == "" {
+            return failJob(ctx, q, job, fmt.Errorf("s3 method missing bucket config"))
+        }
+        deliveryErr = deliverS3(ctx, job, jobPayload, cfg, file)
     default:
-        return fmt.Errorf("unknown delivery method type: %s", method.MethodType.String)
+        return failJob(ctx, q, job, fmt.Errorf("unknown delivery method type: %s", method.MethodType.String))
     }
 
 
-    // Update job status
     // Determine final status
     var status string
-    lastErr := sql.NullString{Valid: false}
+    nextAttemptAt := sql.NullTime{Valid: false}
 
     if deliveryErr != nil {
-        // Check if this is a permanent failure (suppressed email or 4xx API error - no retry)
-        if errors.Is(deliveryErr, ErrEmailSuppressed) || errors.Is(deliveryErr, ErrPermanentAPIFailure) {
+        // Check if this is a permanent failure (suppressed email, 4xx API error, SFTP auth/host key - no retry)
+        if isPermanentDeliveryFailure(deliveryErr) {
             status = "failed"
             log.Printf("Job %s permanently failed: %v", job.ID, deliveryErr)
-        } else if job.Attempts+1 < maxRetries {
-            // Retryable error - keep as pending
+        } else if job.Attempts+1 < retryPolicy.MaxAttempts {
+            // Retryable error - back to pending, held until the backoff elapses
             status = "pending"
-            log.Printf("Job %s failed (attempt %d/%d), will retry: %v", job.ID, job.Attempts+1, maxRetries, deliveryErr)
+            delay := retryPolicy.backoff(job.Attempts + 1)
+            // A buyer's Retry-After header says when it will accept us again
+            if wait := retryAfter(deliveryErr); wait > 0 {
+                delay = wait
+            }
+            nextAttemptAt = sql.NullTime{Time: time.Now().Add(delay), Valid: true}
+            log.Printf("Job %s failed (attempt %d/%d), will retry in %s: %v", job.ID, job.Attempts+1, retryPolicy.MaxAttempts, delay.Round(time.Second), deliveryErr)
         } else {
             status = "failed" // Max retries reached
             log.Printf("Job %s failed after %d attempts: %v", job.ID, job.Attempts+1, deliveryErr)
         }
-        lastErr = sql.NullString{String: deliveryErr.Error(), Valid: true}
     } else {
         status = "success"
         log.Printf("Job %s completed successfully on attempt %d", job.ID, job.Attempts+1)
     }
 
+    historyStatus := status
+    if deliveryErr != nil && status == "pending" {
+        historyStatus = "retry_scheduled"
+    } else if errors.Is(deliveryErr, ErrEmailSuppressed) {
+        historyStatus = "suppressed"
+    }
+
+    // Update job status, release our lease and add history. Once the job is released the
+    // outcome stands, even if its history entry is missing.
+    releaseErr := releaseJob(ctx, q, job, status, historyStatus, deliveryErr, nextAttemptAt, summary)
+    if releaseErr != nil && !errors.Is(releaseErr, errHistoryNotRecorded) {
+        return releaseErr
+    }
+
+    // The leads were counted against the campaign when reserved; keep the ones the buyer
+    // accepted and add them to its ledger. JSON records settle lead by lead, so a job that
+    // succeeded may have had some rejected, and one that failed for good may have got
+    // some through; a job going back to pending gives all of them back and the retry
+    // counts the earlier ones again.
+    var accepted []int // Nil when every lead was delivered
+    if summary.API != nil {
+        accepted = summary.API.acceptedRows(settled)
+    }
+    if status == "success" || (status == "failed" && summary.API != nil) {
+        delivered := leads.Len()
+        if accepted != nil {
+            delivered = len(accepted)
+        }
+        reservation.confirm(ctx, q, delivered)
+        recordDeliveredLeads(ctx, q, job, campaign, leads, accepted)
+    }
+
+    if releaseErr != nil {
+        return releaseErr
+    }
+    return deliveryErr
+}
+
+// failJob permanently fails a job that can't be delivered as enqueued (e.g. a malformed
+// payload), recording the reason in the job, its delivery and the delivery history
+func failJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, cause error) error {
+    log.Printf("Job %s permanently failed: %v", job.ID, cause)
+    if err := settleJob(ctx, q, job, "failed", "failed", cause, nil); err != nil {
+        return fmt.Errorf("%v (while failing job: %w)", err, cause)
+    }
+    return cause
+}
 
+// settleJob records a final job status without a delivery attempt: it releases the
+// job, updates its delivery and writes the history entry
+func settleJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, status, historyStatus string, cause error, summary *deliverySummary) error {
+    return releaseJob(ctx, q, job, status, historyStatus, cause, sql.NullTime{}, summary)
+}
 
-    // Update job status
-    if _, err := q.UpdateDeliveryJobStatus(ctx, queries.UpdateDeliveryJobStatusParams{
-        ID:        job.ID,
-        Status:    status,
-        TenantID:  job.TenantID,
-        LastError: lastErr,
+// releaseJob is settleJob for any status: a pending job is held until nextAttemptAt.
+// It fails with errLeaseLost once the job no longer holds job.LeaseOwner's lease.
+func releaseJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, status, historyStatus string, cause error, nextAttemptAt sql.NullTime, summary *deliverySummary) error {
+    errMsg := ""
+    if cause != nil {
+        errMsg = cause.Error()
+    }
+
+    if _, err := q.ReleaseDeliveryJob(ctx, queries.ReleaseDeliveryJobParams{
+        ID:            job.ID,
+        Status:        status,
+        TenantID:      job.TenantID,
+        LastError:     utils.SqlNullString(errMsg),
+        LeaseOwner:    job.LeaseOwner,
+        NextAttemptAt: nextAttemptAt,
     }); err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return fmt.Errorf("%w %s before recording status %s", errLeaseLost, job.ID, status)
+        }
         return fmt.Errorf("failed to update job status: %w", err)
     }
 
-    // Update delivery status if delivery_id is set
     if job.DeliveryID.Valid {
         if err := q.UpdateDeliveryStatus(ctx, queries.UpdateDeliveryStatusParams{
             ID:       job.DeliveryID.UUID,
@@ -675,57 +715,18 @@ func processJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJo
         }
     }
 
-    // If delivery was successful, increment the campaign's delivered lead count
-    if status == "success" {
-        // Extract campaign_id and total_leads from payload
-        if campaignIDStr, ok := payload["campaign_id"].(string); ok && campaignIDStr != "" {
-            campaignID, parseErr := uuid.Parse(campaignIDStr)
-            if parseErr == nil {
-                // Get total leads count from payload
-                totalLeads := int32(0)
-                if leadsData, ok := payload["leads"].([]interface{}); ok {
-                    totalLeads = int32(len(leadsData))
-                } else if totalLeadsVal, ok := payload["total_leads"].(float64); ok {
-                    totalLeads = int32(totalLeadsVal)
-                }
-
-                if totalLeads > 0 {
-                    if err := q.IncrementCampaignDeliveredCount(ctx, queries.IncrementCampaignDeliveredCountParams{
-                        ID:                 campaignID,
-                        TenantID:           job.TenantID,
-                        DeliveredLeadCount: totalLeads,
-                    }); err != nil {
-                        log.Printf("Failed to increment campaign delivered count: %v", err)
-                    } else {
-                        log.Printf("Successfully incremented campaign %s delivered count by %d", campaignID, totalLeads)
-                    }
-                }
-            }
-        }
-    }
-
-
-    // Add history entry
-    historyStatus := status
-    if deliveryErr != nil && status == "pending" {
-        historyStatus = "retry_scheduled"
-    } else if errors.Is(deliveryErr, ErrEmailSuppressed) {
-        historyStatus = "suppressed"
-    }
-
-    // Add history
     if _, err := q.CreateDeliveryHistory(ctx, queries.CreateDeliveryHistoryParams{
-        TenantID:          job.TenantID,
-        JobID:             utils.NullUUID(job.ID),
-        BuyerID:           utils.NullUUID(job.BuyerID),
-        DeliveryMethodID:  utils.NullUUID(job.DeliveryMethodID),
-        Status:            utils.SqlNullString(historyStatus),
-        ErrorMessage:      utils.SqlNullString(lastErr.String),
+        TenantID:         job.TenantID,
+        JobID:            utils.NullUUID(job.ID),
+        BuyerID:          utils.NullUUID(job.BuyerID),
+        DeliveryMethodID: utils.NullUUID(job.DeliveryMethodID),
+        Status:           utils.SqlNullString(historyStatus),
+        ErrorMessage:     utils.SqlNullString(errMsg),
+        PayloadSummary:   summary.nullRawMessage(),
     }); err != nil {
-        return fmt.Errorf("failed to insert history: %w", err)
+        return fmt.Errorf("%w: %w", errHistoryNotRecorded, err)
     }
-
-    return deliveryErr
+    return nil
 }
 
 
@@ -757,34 +758,14 @@ func isEmailSuppressed(ctx context.Context, email string) (bool, string, error)
     return checkSESSuppressionList(ctx, email)
 }
 
-// SES email sender with retry-friendly error handling
-func deliverEmail(ctx context.Context, job *queries.DeliveryJob, method *queries.DeliveryMethod, csvData []byte, filename string) error {
-    const SenderAddress = "notifications@mail.lead-ship.com"
-    
+// emailRecipient extracts the recipient email and lead count from a job payload
+// and checks the recipient against the SES suppression list
+func emailRecipient(ctx context.Context, jobPayload *payload.Payload) (string, int, error) {
+    leadCount := jobPayload.LeadCount()
+    recipientEmail := jobPayload.RecipientEmail
 
-    // Parse payload to get lead count and recipient email
-    var payload map[string]interface{}
-    leadCount := 0
-    recipientEmail := ""
-    
-    if err := json.Unmarshal(job.Payload, &payload); err != nil {
-        return fmt.Errorf("failed to parse job payload: %w", err)
-    }
-    
-    // Extract lead count
-    if leads, ok := payload["leads"].([]interface{}); ok {
-        leadCount = len(leads)
-    }
-    
-    // Extract recipient email from payload
-    if emailVal, exists := payload["recipient_email"]; exists && emailVal != nil {
-        if email, ok := emailVal.(string); ok && email != "" {
-            recipientEmail = email
-        }
-    }
-    
     if recipientEmail == "" {
-        return fmt.Errorf("recipient email not found in job payload")
+        return "", 0, fmt.Errorf("recipient email not found in job payload")
     }
 
     // Check if email is suppressed before attempting to send
@@ -795,17 +776,61 @@ func deliverEmail(ctx context.Context, job *queries.DeliveryJob, method *queries
     }
     if suppressed {
         log.Printf("Skipping delivery to suppressed email %s, reason: %s", recipientEmail, reason)
-        return fmt.Errorf("%w: %s", ErrEmailSuppressed, reason)
+        return "", 0, fmt.Errorf("%w: %s", ErrEmailSuppressed, reason)
+    }
+
+    return recipientEmail, leadCount, nil
+}
+
+// SES email sender with retry-friendly error handling
+func deliverEmail(ctx context.Context, job *queries.DeliveryJob, method *queries.DeliveryMethod, jobPayload *payload.Payload, file *leadfile.File, filename string) error {
+    recipientEmail, leadCount, err := emailRecipient(ctx, jobPayload)
+    if err != nil {
+        return err
     }
 
     // Create email body with attachment
     subject := "New Lead Delivery"
-    bodyText := fmt.Sprintf("Please find attached %d leads in CSV format.", leadCount)
+    bodyText := fmt.Sprintf("Please find attached %d leads in %s format.\n\nSchema version: %s", leadCount, strings.ToUpper(file.Format), file.SchemaVersion)
     
-    // Build MIME message with attachment
-    boundary := "boundary123"
+    // SES takes the message in one call, so unlike other destinations it's built in memory
+    attachment := file.Open()
+    rawMessage, err := mimeMessage(recipientEmail, subject, bodyText, filename, file.ContentType, attachment)
+    attachment.Close()
+    if err != nil {
+        return fmt.Errorf("failed to build email: %w", err)
+    }
+    // The message holds decrypted contact details; clear it once it's sent
+    defer envelope.Zero(rawMessage)
+    if len(rawMessage) > sesMaxMessageSize {
+        return fmt.Errorf("email of %d bytes exceeds the SES limit of %d; deliver this buyer by S3 or SFTP", len(rawMessage), sesMaxMessageSize)
+    }
+    
+    log.Printf("Attempting to send email to %s with %d leads (%d bytes)", recipientEmail, leadCount, len(rawMessage))
+    
+    _, err = sesClient.SendRawEmail(ctx, &ses.SendRawEmailInput{
+        RawMessage: &types.RawMessage{
+            Data: rawMessage,
+        },
+        Source: aws.String(SenderAddress),
+    })
     
-    rawMessage := fmt.Sprintf(`From: %s
+    if err != nil {
+        log.Printf("Email delivery failed: %v", err)
+        return fmt.Errorf("email delivery failed: %w", err)
+    }
+    
+    log.Printf("Email successfully sent to %s", recipientEmail)
+    return nil
+}
+
+// mimeMessage builds a raw SES message with a plain-text body and one base64 attachment,
+// encoding the attachment as it is read
+func mimeMessage(to, subject, bodyText, filename, contentType string, attachment io.Reader) ([]byte, error) {
+    boundary := "boundary123"
+
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, `From: %s
 To: %s
 Subject: %s
 MIME-Version: 1.0
@@ -817,82 +842,171 @@ Content-Type: text/plain; charset=UTF-8
 %s
 
 --%s
-Content-Type: text/csv; name="%s"
+Content-Type: %s; name="%s"
 Content-Disposition: attachment; filename="%s"
 Content-Transfer-Encoding: base64
 
-%s
---%s--`,
+`,
         SenderAddress,
-        recipientEmail,
+        to,
         subject,
         boundary,
         boundary,
         bodyText,
         boundary,
+        contentType,
         filename,
         filename,
-        base64.StdEncoding.EncodeToString(csvData),
-        boundary,
     )
-    
-    log.Printf("Attempting to send email to %s with %d leads", recipientEmail, leadCount)
-    
-    _, err = sesClient.SendRawEmail(ctx, &ses.SendRawEmailInput{
-        RawMessage: &types.RawMessage{
-            Data: []byte(rawMessage),
-        },
-        Source: aws.String(SenderAddress),
-    })
-    
-    if err != nil {
-        log.Printf("Email delivery failed: %v", err)
-        return fmt.Errorf("email delivery failed: %w", err)
+
+    encoder := base64.NewEncoder(base64.StdEncoding, &buf)
+    if _, err := io.Copy(encoder, attachment); err != nil {
+        return nil, err
     }
-    
-    log.Printf("Email successfully sent to %s", recipientEmail)
-    return nil
+    if err := encoder.Close(); err != nil {
+        return nil, err
+    }
+    fmt.Fprintf(&buf, "\n--%s--", boundary)
+    return buf.Bytes(), nil
 }
 
-// deliverAPI sends leads as a CSV file to a configured HTTP endpoint
-func deliverAPI(ctx context.Context, job *queries.DeliveryJob, method *queries.DeliveryMethod, cfg APIDeliveryConfig, csvData []byte, filename string) error {
-    // Create multipart form with CSV file
-    var requestBody bytes.Buffer
-    multipartWriter := multipart.NewWriter(&requestBody)
+// deliverAPI sends leads to a configured HTTP endpoint, either as a multipart CSV upload
+// or as JSON records (see deliverAPIRecords)
+func deliverAPI(ctx context.Context, job *queries.DeliveryJob, method *queries.DeliveryMethod, cfg APIDeliveryConfig, table *leadfile.Table, file *leadfile.File, filename string, settled map[string]bool, summary *deliverySummary) error {
+    if cfg.Format != "" && cfg.Format != apiFormatMultipartCSV {
+        return deliverAPIRecords(ctx, job, cfg, table, settled, summary)
+    }
+
+    // Every attempt writes the form again, so they must share one boundary
+    form := multipart.NewWriter(io.Discard)
+    boundary := form.Boundary()
+    writeForm := func(w io.Writer) error {
+        multipartWriter := multipart.NewWriter(w)
+        if err := multipartWriter.SetBoundary(boundary); err != nil {
+            return err
+        }
+
+        // Add metadata fields
+        multipartWriter.WriteField("job_id", job.ID.String())
+        multipartWriter.WriteField("buyer_id", job.BuyerID.String())
+        multipartWriter.WriteField("tenant_id", job.TenantID.String())
+        multipartWriter.WriteField("schema_version", file.SchemaVersion)
+
+        // Add the lead file with its own content type, which CreateFormFile would leave as octet-stream
+        partHeader := make(textproto.MIMEHeader)
+        partHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+        partHeader.Set("Content-Type", file.ContentType)
+        fileWriter, err := multipartWriter.CreatePart(partHeader)
+        if err != nil {
+            return fmt.Errorf("failed to create form file: %w", err)
+        }
+        if _, err := file.WriteTo(fileWriter); err != nil {
+            return fmt.Errorf("failed to write %s data: %w", file.Format, err)
+        }
+
+        // Close the multipart writer to finalize the boundary
+        if err := multipartWriter.Close(); err != nil {
+            return fmt.Errorf("failed to close multipart writer: %w", err)
+        }
+        return nil
+    }
+    contentType := form.FormDataContentType()
 
-    // Add metadata fields
-    multipartWriter.WriteField("job_id", job.ID.String())
-    multipartWriter.WriteField("buyer_id", job.BuyerID.String())
-    multipartWriter.WriteField("tenant_id", job.TenantID.String())
+    // The form streams into the request; an HMAC signature is taken over one rendering
+    // and the identical next one is sent
+    body := apiBody{open: func() io.ReadCloser { return leadfile.Stream(writeForm) }}
+    log.Printf("Streaming %s file %s to %s", strings.ToUpper(file.Format), filename, cfg.URL)
 
-    // Add CSV file
-    fileWriter, err := multipartWriter.CreateFormFile("file", filename)
+    httpStatus, err := sendAPIRequest(ctx, cfg, body, contentType)
+    summary.APIResponse = newAPIResponseSummary(httpStatus, err)
+    return err
+}
+
+// apiBody is the body of an API request: data already in memory, or a stream that open
+// renders afresh for each attempt
+type apiBody struct {
+    data []byte
+    open func() io.ReadCloser
+}
+
+// sendAPIRequest sends one request to the configured endpoint with the method's auth and headers.
+// Returns the response status code; non-2xx responses are returned as *apiStatusError,
+// classified by the method's status_classes (permanent ones match ErrPermanentAPIFailure).
+func sendAPIRequest(ctx context.Context, cfg APIDeliveryConfig, body apiBody, contentType string) (int, error) {
+    // Configure timeout
+    timeout := 30 * time.Second
+    if cfg.TimeoutSec > 0 {
+        timeout = time.Duration(cfg.TimeoutSec) * time.Second
+    }
+
+    client := &http.Client{
+        Timeout: timeout,
+    }
+
+    // Execute the request
+    resp, err := doAPIRequest(ctx, client, cfg, body, contentType)
     if err != nil {
-        return fmt.Errorf("failed to create form file: %w", err)
+        return 0, err
+    }
+
+    // A cached OAuth2 token can be revoked before it expires; fetch a fresh one and retry once
+    if resp.StatusCode == http.StatusUnauthorized && cfg.AuthType == "oauth2" {
+        resp.Body.Close()
+        log.Printf("API rejected cached OAuth2 token, refreshing and retrying")
+        invalidateOAuth2Token(cfg)
+        resp, err = doAPIRequest(ctx, client, cfg, body, contentType)
+        if err != nil {
+            return 0, err
+        }
     }
-    if _, err := fileWriter.Write(csvData); err != nil {
-        return fmt.Errorf("failed to write csv data: %w", err)
+    defer resp.Body.Close()
+
+    // Read response body for error reporting
+    respBody, _ := io.ReadAll(resp.Body)
+
+    // Handle response status codes
+    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+        log.Printf("API delivery successful: status %d", resp.StatusCode)
+        return resp.StatusCode, nil
     }
 
-    // Close the multipart writer to finalize the boundary
-    if err := multipartWriter.Close(); err != nil {
-        return fmt.Errorf("failed to close multipart writer: %w", err)
+    statusErr := &apiStatusError{
+        StatusCode: resp.StatusCode,
+        Class:      classifyStatus(cfg, resp.StatusCode),
+        RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+        Body:       string(respBody),
     }
 
+    // Most 4xx errors are permanent (bad request, unauthorized, forbidden, not found)
+    if statusErr.Class == apiClassPermanent {
+        log.Printf("API delivery permanently failed: status %d, body: %s", resp.StatusCode, string(respBody))
+        return resp.StatusCode, statusErr
+    }
+
+    // 5xx errors and timeouts/rate limits are retryable
+    log.Printf("API delivery failed (retryable): status %d, retry after %s, body: %s", resp.StatusCode, statusErr.RetryAfter, string(respBody))
+    return resp.StatusCode, statusErr
+}
+
+// doAPIRequest builds the request with the method's auth and custom headers and sends it
+func doAPIRequest(ctx context.Context, client *http.Client, cfg APIDeliveryConfig, body apiBody, contentType string) (*http.Response, error) {
     // Determine HTTP method (default to POST)
     httpMethod := cfg.Method
     if httpMethod == "" {
         httpMethod = "POST"
     }
 
-    // Create the request
-    req, err := http.NewRequestWithContext(ctx, httpMethod, cfg.URL, &requestBody)
+    // Create the request; a streamed body is attached once the headers are set
+    var reader io.Reader
+    if body.open == nil {
+        reader = bytes.NewReader(body.data)
+    }
+    req, err := http.NewRequestWithContext(ctx, httpMethod, cfg.URL, reader)
     if err != nil {
-        return fmt.Errorf("failed to create request: %w", err)
+        return nil, fmt.Errorf("failed to create request: %w", err)
     }
 
-    // Set content type with multipart boundary
-    req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+    req.Header.Set("Content-Type", contentType)
 
     // Apply authentication based on auth_type
     switch cfg.AuthType {
@@ -906,6 +1020,26 @@ func deliverAPI(ctx context.Context, job *queries.DeliveryJob, method *queries.D
         req.Header.Set("Authorization", "Bearer "+cfg.BearerToken)
     case "basic":
         req.SetBasicAuth(cfg.BasicUser, cfg.BasicPass)
+    case "hmac":
+        sig, err := signBody(body, time.Now(), cfg.HMACSecrets)
+        if err != nil {
+            return nil, err
+        }
+        headerName := cfg.SignatureHeader
+        if headerName == "" {
+            headerName = signature.HeaderName
+        }
+        req.Header.Set(headerName, sig)
+    case "oauth2":
+        token, err := oauth2Token(cfg)
+        if err != nil {
+            return nil, err
+        }
+        token.SetAuthHeader(req)
+    }
+
+    if cfg.schemaVersion != "" {
+        req.Header.Set(schemaVersionHeader, cfg.schemaVersion)
     }
 
     // Apply any custom headers
@@ -913,45 +1047,41 @@ func deliverAPI(ctx context.Context, job *queries.DeliveryJob, method *queries.D
         req.Header.Set(key, value)
     }
 
-    // Configure timeout
-    timeout := 30 * time.Second
-    if cfg.TimeoutSec > 0 {
-        timeout = time.Duration(cfg.TimeoutSec) * time.Second
-    }
-
-    client := &http.Client{
-        Timeout: timeout,
+    // Its size isn't known until the file is written, so it goes chunked
+    if body.open != nil {
+        req.Body = body.open()
     }
 
-    log.Printf("Sending API request to %s with CSV file %s (%d bytes)", cfg.URL, filename, len(csvData))
-
-    // Execute the request
     resp, err := client.Do(req)
     if err != nil {
-        return fmt.Errorf("api request failed: %w", err)
+        return nil, fmt.Errorf("api request failed: %w", err)
     }
-    defer resp.Body.Close()
-
-    // Read response body for error reporting
-    respBody, _ := io.ReadAll(resp.Body)
+    return resp, nil
+}
 
-    // Handle response status codes
-    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-        log.Printf("API delivery successful: status %d", resp.StatusCode)
-        return nil
+// signBody signs the request body for hmac auth, rendering a streamed body once to
+// sign it rather than buffering it
+func signBody(body apiBody, t time.Time, secrets []string) (string, error) {
+    if body.open == nil {
+        sig, err := signature.Sign(body.data, t, secrets...)
+        if err != nil {
+            return "", fmt.Errorf("%w: %v", ErrPermanentAPIFailure, err)
+        }
+        return sig, nil
     }
-
-    // 4xx errors are permanent failures (bad request, unauthorized, forbidden, not found)
-    if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-        log.Printf("API delivery permanently failed: status %d, body: %s", resp.StatusCode, string(respBody))
-        return fmt.Errorf("%w: status %d - %s", ErrPermanentAPIFailure, resp.StatusCode, string(respBody))
+    if len(secrets) == 0 || len(secrets) > signature.MaxActiveSecrets {
+        return "", fmt.Errorf("%w: hmac auth needs 1 to %d secrets", ErrPermanentAPIFailure, signature.MaxActiveSecrets)
     }
-
-    // 5xx errors are retryable
-    log.Printf("API delivery failed (retryable): status %d, body: %s", resp.StatusCode, string(respBody))
-    return fmt.Errorf("api responded with status %d: %s", resp.StatusCode, string(respBody))
+    data := body.open()
+    defer data.Close()
+    sig, err := signature.SignReader(data, t, secrets...)
+    if err != nil {
+        return "", fmt.Errorf("failed to render body for signing: %w", err)
+    }
+    return sig, nil
 }
 
 func main() {
+    setup()
     lambda.Start(handler)
 }
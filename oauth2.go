@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// OAuth2Config holds client-credentials settings for APIDeliveryConfig auth_type "oauth2"
+type OAuth2Config struct {
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes,omitempty"`
+	Audience     string   `json:"audience,omitempty"` // Sent as the "audience" token request parameter (Auth0 and similar)
+}
+
+// Token sources are cached per credential set for the life of the Lambda container,
+// so warm invocations reuse a token until shortly before it expires
+var (
+	oauth2TokenSourcesMu sync.Mutex
+	oauth2TokenSources   = map[string]oauth2.TokenSource{}
+)
+
+// oauth2CacheKey identifies a credential set. The secret is hashed in so a rotated
+// secret gets a fresh token instead of reusing one issued to the old secret.
+func oauth2CacheKey(cfg *OAuth2Config) string {
+	secretHash := sha256.Sum256([]byte(cfg.ClientSecret))
+	return strings.Join([]string{
+		cfg.TokenURL,
+		cfg.ClientID,
+		hex.EncodeToString(secretHash[:8]),
+		strings.Join(cfg.Scopes, " "),
+		cfg.Audience,
+	}, "|")
+}
+
+// oauth2Token returns a cached token for the method's credentials, fetching a new one
+// when none is cached or the cached one is about to expire
+func oauth2Token(cfg APIDeliveryConfig) (*oauth2.Token, error) {
+	key := oauth2CacheKey(cfg.OAuth2)
+
+	oauth2TokenSourcesMu.Lock()
+	source, ok := oauth2TokenSources[key]
+	if !ok {
+		cc := clientcredentials.Config{
+			ClientID:     cfg.OAuth2.ClientID,
+			ClientSecret: cfg.OAuth2.ClientSecret,
+			TokenURL:     cfg.OAuth2.TokenURL,
+			Scopes:       cfg.OAuth2.Scopes,
+		}
+		if cfg.OAuth2.Audience != "" {
+			cc.EndpointParams = url.Values{"audience": {cfg.OAuth2.Audience}}
+		}
+		// The source outlives this job, so it gets its own context rather than the job's
+		tokenCtx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: 30 * time.Second})
+		source = cc.TokenSource(tokenCtx)
+		oauth2TokenSources[key] = source
+	}
+	oauth2TokenSourcesMu.Unlock()
+
+	token, err := source.Token()
+	if err != nil {
+		invalidateOAuth2Token(cfg)
+		tokenErr := &oauth2TokenError{Class: apiClassRetryable, Err: err}
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.Response != nil {
+			// Classified like a buyer's response with no overrides: the endpoint rejecting
+			// our client credentials won't fix itself, but timeouts and rate limits will
+			tokenErr.StatusCode = retrieveErr.Response.StatusCode
+			tokenErr.Class = classifyStatus(APIDeliveryConfig{}, tokenErr.StatusCode)
+			tokenErr.RetryAfter = parseRetryAfter(retrieveErr.Response.Header.Get("Retry-After"), time.Now())
+		}
+		return nil, tokenErr
+	}
+	return token, nil
+}
+
+// oauth2TokenError is a failed token request. It isn't an *apiStatusError so the job's
+// summary doesn't report the token endpoint's status as the buyer's response.
+type oauth2TokenError struct {
+	StatusCode int // Zero when the endpoint couldn't be reached
+	Class      string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *oauth2TokenError) Error() string {
+	if e.Class == apiClassPermanent {
+		return fmt.Sprintf("%v: oauth2 token request rejected: %v", ErrPermanentAPIFailure, e.Err)
+	}
+	return fmt.Sprintf("oauth2 token request failed: %v", e.Err)
+}
+
+// Unwrap makes rejected requests match ErrPermanentAPIFailure
+func (e *oauth2TokenError) Unwrap() []error {
+	if e.Class == apiClassPermanent {
+		return []error{ErrPermanentAPIFailure, e.Err}
+	}
+	return []error{e.Err}
+}
+
+// invalidateOAuth2Token drops the cached token so the next request fetches a new one
+func invalidateOAuth2Token(cfg APIDeliveryConfig) {
+	oauth2TokenSourcesMu.Lock()
+	delete(oauth2TokenSources, oauth2CacheKey(cfg.OAuth2))
+	oauth2TokenSourcesMu.Unlock()
+}
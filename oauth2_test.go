@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOAuth2TokenClassifiesEndpointErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		permanent  bool
+		wantWait   time.Duration
+	}{
+		{name: "bad credentials", status: http.StatusUnauthorized, permanent: true},
+		{name: "bad request", status: http.StatusBadRequest, permanent: true},
+		{name: "timeout", status: http.StatusRequestTimeout},
+		{name: "rate limited", status: http.StatusTooManyRequests, retryAfter: "120", wantWait: 2 * time.Minute},
+		{name: "rate limited until a date", status: http.StatusTooManyRequests, retryAfter: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), wantWait: time.Hour},
+		{name: "unavailable", status: http.StatusServiceUnavailable, retryAfter: "30", wantWait: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"error":"nope"}`))
+			}))
+			defer server.Close()
+
+			cfg := APIDeliveryConfig{OAuth2: &OAuth2Config{TokenURL: server.URL, ClientID: "client", ClientSecret: "secret"}}
+			_, err := oauth2Token(cfg)
+			if err == nil {
+				t.Fatal("oauth2Token succeeded")
+			}
+			if got := errors.Is(err, ErrPermanentAPIFailure); got != tt.permanent {
+				t.Errorf("permanent = %v, want %v (err %v)", got, tt.permanent, err)
+			}
+			// Dates are rounded to the second
+			if got := retryAfter(err); got < tt.wantWait-time.Second || got > tt.wantWait {
+				t.Errorf("retryAfter = %s, want %s", got, tt.wantWait)
+			}
+			// The buyer's API never answered, so no response is recorded for it
+			if summary := newAPIResponseSummary(0, err); summary.HTTPStatus != 0 {
+				t.Errorf("summary records token endpoint status %d", summary.HTTPStatus)
+			}
+		})
+	}
+}
+
+func TestOAuth2TokenUnreachableIsRetryable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	cfg := APIDeliveryConfig{OAuth2: &OAuth2Config{TokenURL: server.URL, ClientID: "client", ClientSecret: "secret"}}
+	_, err := oauth2Token(cfg)
+	if err == nil || errors.Is(err, ErrPermanentAPIFailure) {
+		t.Errorf("err = %v, want a retryable error", err)
+	}
+}
+
+func TestOAuth2TokenIsCached(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"tok","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	cfg := APIDeliveryConfig{OAuth2: &OAuth2Config{TokenURL: server.URL, ClientID: "client", ClientSecret: "secret"}}
+	defer invalidateOAuth2Token(cfg)
+	for range 3 {
+		token, err := oauth2Token(cfg)
+		if err != nil {
+			t.Fatalf("oauth2Token: %v", err)
+		}
+		if token.AccessToken != "tok" {
+			t.Errorf("access token = %q", token.AccessToken)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("token endpoint called %d times, want 1", requests)
+	}
+}
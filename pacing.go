@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+// Campaign pacing modes. "even" spreads the remaining target over the days left
+// until end_date, on top of any daily and weekly caps.
+const (
+	pacingASAP = "asap"
+	pacingEven = "even"
+)
+
+// Counting windows in campaign_delivery_counts; days are UTC, weeks start Monday
+const (
+	windowDay  = "day"
+	windowWeek = "week"
+)
+
+// What limited a job's allowance
+const (
+	limitTarget = "target"
+	limitWeekly = "weekly"
+	limitDaily  = "daily"
+	limitEven   = "even"
+)
+
+var (
+	// errCampaignEnded means the campaign's end_date has passed
+	errCampaignEnded = errors.New("campaign has ended")
+	// errCampaignTargetReached means the campaign already has its target lead count
+	errCampaignTargetReached = errors.New("campaign target lead count reached")
+)
+
+// pacingHold defers a whole job until a campaign starts or its next window opens
+type pacingHold struct {
+	Until  time.Time
+	Reason string
+}
+
+func (h *pacingHold) Error() string {
+	return fmt.Sprintf("%s; held until %s", h.Reason, h.Until.Format(time.RFC3339))
+}
+
+// pacingSummary records how caps shaped a delivery
+type pacingSummary struct {
+	Requested int       `json:"requested"`
+	Reserved  int       `json:"reserved"`
+	Limit     string    `json:"limit,omitempty"` // What capped the job, if anything did
+	Held      int       `json:"held,omitempty"`  // Leads moved to HeldJobID for a later window
+	HeldJobID string    `json:"held_job_id,omitempty"`
+	HeldUntil time.Time `json:"held_until,omitzero"`
+	Dropped   int       `json:"dropped,omitempty"` // Leads past the target lead count, not delivered
+}
+
+// leadReservation is the share of a campaign's caps a job holds while it delivers.
+// The counts are taken up front so concurrent jobs can't both fill the last slots;
+// release gives them back if the delivery doesn't succeed.
+type leadReservation struct {
+	tenantID   uuid.UUID
+	campaignID uuid.UUID
+	count      int32
+	dayStart   time.Time
+	weekStart  time.Time
+	confirmed  bool
+}
+
+// reserveCampaignLeads checks the campaign's date range and caps and reserves room for
+// the job's leads, splitting off what doesn't fit. Leads over a daily or weekly cap move
+// to a new job scheduled for the next window; leads over the target lead count are
+// dropped. When nothing fits it returns a *pacingHold, or errCampaignTargetReached, and
+// when the campaign has ended errCampaignEnded. A nil campaign reserves nothing.
+func reserveCampaignLeads(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, campaign *queries.Campaign, leads jobLeads, now time.Time) (*leadReservation, *pacingSummary, error) {
+	if campaign == nil {
+		return nil, nil, nil
+	}
+	now = now.UTC()
+	if campaign.StartDate.Valid && now.Before(campaign.StartDate.Time) {
+		return nil, nil, &pacingHold{Until: campaign.StartDate.Time, Reason: "campaign has not started"}
+	}
+	if campaign.EndDate.Valid && !now.Before(campaignEnd(campaign.EndDate.Time)) {
+		return nil, nil, fmt.Errorf("%w: ended %s", errCampaignEnded, campaign.EndDate.Time.Format(time.DateOnly))
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin reservation transaction: %w", err)
+	}
+	defer tx.Rollback()
+	qtx := q.WithTx(tx)
+
+	locked, err := qtx.LockCampaignForDelivery(ctx, queries.LockCampaignForDeliveryParams{
+		ID:       campaign.ID,
+		TenantID: job.TenantID,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lock campaign: %w", err)
+	}
+
+	r := &leadReservation{tenantID: job.TenantID, campaignID: campaign.ID}
+	r.dayStart, r.weekStart = pacingWindows(now)
+
+	counts, err := qtx.GetCampaignWindowCounts(ctx, queries.GetCampaignWindowCountsParams{
+		CampaignID: campaign.ID,
+		TenantID:   job.TenantID,
+		DayStart:   r.dayStart,
+		WeekStart:  r.weekStart,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to count campaign windows: %w", err)
+	}
+
+	summary := &pacingSummary{Requested: leads.Len()}
+	allowance := campaignAllowance(&locked, counts, leads.Len(), r.dayStart, r.weekStart)
+	allowed := allowance.allowed
+	summary.Limit = allowance.limit
+
+	if allowed == 0 {
+		if allowance.limit == limitTarget {
+			return nil, summary, fmt.Errorf("%w (%d of %d)", errCampaignTargetReached, locked.DeliveredLeadCount, locked.TargetLeadCount.Int32)
+		}
+		return nil, summary, &pacingHold{Until: allowance.nextWindow, Reason: allowance.limit + " lead cap reached"}
+	}
+
+	if overflow := leads.Len() - allowed; overflow > 0 {
+		held, err := leads.split(ctx, qtx, allowed, allowance.holdUntil())
+		if err != nil {
+			return nil, nil, err
+		}
+		if held == nil {
+			summary.Dropped = overflow
+		} else {
+			summary.Held = overflow
+			summary.HeldJobID = held.ID.String()
+			summary.HeldUntil = allowance.nextWindow
+		}
+	}
+
+	r.count = int32(allowed)
+	summary.Reserved = allowed
+	if err := r.add(ctx, qtx, r.count); err != nil {
+		return nil, nil, fmt.Errorf("failed to reserve campaign leads: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit reservation: %w", err)
+	}
+
+	if summary.Limit != "" {
+		log.Printf("Job %s: campaign %s %s limit allows %d of %d leads (held %d, dropped %d)",
+			job.ID, campaign.ID, summary.Limit, allowed, summary.Requested, summary.Held, summary.Dropped)
+	}
+	return r, summary, nil
+}
+
+// pacingWindows returns the UTC day and the Monday-start week containing now
+func pacingWindows(now time.Time) (dayStart, weekStart time.Time) {
+	dayStart = now.UTC().Truncate(24 * time.Hour)
+	weekStart = dayStart.AddDate(0, 0, -(int(dayStart.Weekday())+6)%7)
+	return dayStart, weekStart
+}
+
+// leadAllowance is how many of a job's leads a campaign's caps let it deliver now
+type leadAllowance struct {
+	allowed    int
+	limit      string    // The tightest cap, when it allows fewer than requested
+	nextWindow time.Time // When the limiting window reopens
+}
+
+// campaignAllowance applies the campaign's target, weekly and daily caps and even
+// pacing to requested leads, given the leads already counted in the current day and
+// week windows
+func campaignAllowance(campaign *queries.Campaign, counts queries.GetCampaignWindowCountsRow, requested int, dayStart, weekStart time.Time) leadAllowance {
+	a := leadAllowance{allowed: requested}
+	capAt := func(remaining int, limit string) {
+		if remaining < a.allowed {
+			a.allowed, a.limit = max(remaining, 0), limit
+		}
+	}
+	if campaign.TargetLeadCount.Valid {
+		capAt(int(campaign.TargetLeadCount.Int32-campaign.DeliveredLeadCount), limitTarget)
+	}
+	if campaign.WeeklyLeadCap.Valid {
+		capAt(int(campaign.WeeklyLeadCap.Int32-counts.WeekCount), limitWeekly)
+	}
+	if campaign.DailyLeadCap.Valid {
+		capAt(int(campaign.DailyLeadCap.Int32-counts.DayCount), limitDaily)
+	}
+	if campaign.Pacing.String == pacingEven && campaign.TargetLeadCount.Valid && campaign.EndDate.Valid {
+		// Today's even share of what was left at the start of the day
+		remaining := int(campaign.TargetLeadCount.Int32-campaign.DeliveredLeadCount) + int(counts.DayCount)
+		days := int(campaignEnd(campaign.EndDate.Time).Sub(dayStart).Hours()+23) / 24
+		capAt((remaining+days-1)/max(days, 1)-int(counts.DayCount), limitEven)
+	}
+
+	a.nextWindow = dayStart.Add(24 * time.Hour)
+	if a.limit == limitWeekly {
+		a.nextWindow = weekStart.AddDate(0, 0, 7)
+	}
+	return a
+}
+
+// holdUntil is when leads over the allowance are delivered, or zero when they are over
+// the target lead count and dropped
+func (a leadAllowance) holdUntil() time.Time {
+	if a.limit == limitTarget {
+		return time.Time{}
+	}
+	return a.nextWindow
+}
+
+// confirm keeps the reservation for the delivered leads and gives back the rest
+func (r *leadReservation) confirm(ctx context.Context, q *queries.Queries, delivered int) {
+	if r == nil {
+		return
+	}
+	if unused := r.count - int32(delivered); unused > 0 {
+		if err := r.add(ctx, q, -unused); err != nil {
+			log.Printf("Failed to release %d undelivered leads of campaign %s: %v", unused, r.campaignID, err)
+		} else {
+			r.count -= unused
+		}
+	}
+	r.confirmed = true
+}
+
+// release gives an unconfirmed reservation back. A reservation lost to a crash stays
+// counted, which can only under-deliver.
+func (r *leadReservation) release(ctx context.Context, q *queries.Queries) {
+	if r == nil || r.confirmed || r.count == 0 {
+		return
+	}
+	if err := r.add(ctx, q, -r.count); err != nil {
+		log.Printf("Failed to release %d reserved leads of campaign %s: %v", r.count, r.campaignID, err)
+		return
+	}
+	r.count = 0
+}
+
+// add moves the campaign's delivered count and both window counts by n
+func (r *leadReservation) add(ctx context.Context, q *queries.Queries, n int32) error {
+	if err := q.IncrementCampaignDeliveredCount(ctx, queries.IncrementCampaignDeliveredCountParams{
+		ID:                 r.campaignID,
+		TenantID:           r.tenantID,
+		DeliveredLeadCount: n,
+	}); err != nil {
+		return err
+	}
+	windows := []struct {
+		period string
+		start  time.Time
+	}{{windowDay, r.dayStart}, {windowWeek, r.weekStart}}
+	for _, w := range windows {
+		if err := q.AddCampaignWindowLeads(ctx, queries.AddCampaignWindowLeadsParams{
+			TenantID:    r.tenantID,
+			CampaignID:  r.campaignID,
+			Period:      w.period,
+			WindowStart: w.start,
+			LeadCount:   n,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createHeldJob enqueues leads that didn't fit this window as a new job with payload
+// held
+func createHeldJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, held *payload.Payload, at time.Time) (queries.DeliveryJob, error) {
+	held.Version = payload.CurrentVersion
+	raw, err := json.Marshal(held)
+	if err != nil {
+		return queries.DeliveryJob{}, fmt.Errorf("failed to encode held leads: %w", err)
+	}
+
+	created, err := q.CreateDeliveryJob(ctx, queries.CreateDeliveryJobParams{
+		TenantID:         job.TenantID,
+		BuyerID:          job.BuyerID,
+		DeliveryMethodID: job.DeliveryMethodID,
+		DeliveryID:       job.DeliveryID,
+		ScheduledAt:      at,
+		Payload:          raw,
+		Description:      utils.SqlNullString(fmt.Sprintf("%d leads held from job %s by campaign pacing", held.LeadCount(), job.ID)),
+	})
+	if err != nil {
+		return queries.DeliveryJob{}, fmt.Errorf("failed to create held job: %w", err)
+	}
+	return created, nil
+}
+
+// rewriteJobPayload stores p as the job's payload
+func rewriteJobPayload(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, p *payload.Payload) error {
+	p.Version = payload.CurrentVersion
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to encode job payload: %w", err)
+	}
+	if err := q.UpdateDeliveryJobPayload(ctx, queries.UpdateDeliveryJobPayloadParams{
+		ID:       job.ID,
+		TenantID: job.TenantID,
+		Payload:  raw,
+	}); err != nil {
+		return fmt.Errorf("failed to update job payload: %w", err)
+	}
+	return nil
+}
+
+// campaignEnd is when a campaign stops delivering. A date-only end_date includes the
+// whole day.
+func campaignEnd(end time.Time) time.Time {
+	end = end.UTC()
+	if end.Equal(end.Truncate(24 * time.Hour)) {
+		return end.Add(24 * time.Hour)
+	}
+	return end
+}
+
+// holdJob returns a job to pending until hold.Until without counting the attempt, and
+// records why in the delivery history
+func holdJob(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, hold *pacingHold, summary *deliverySummary) error {
+	log.Printf("Job %s: %v", job.ID, hold)
+	if _, err := q.HoldDeliveryJob(ctx, queries.HoldDeliveryJobParams{
+		ID:            job.ID,
+		TenantID:      job.TenantID,
+		LastError:     utils.SqlNullString(hold.Error()),
+		LeaseOwner:    job.LeaseOwner,
+		NextAttemptAt: sql.NullTime{Time: hold.Until, Valid: true},
+	}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w %s before holding it", errLeaseLost, job.ID)
+		}
+		return fmt.Errorf("failed to hold job: %w", err)
+	}
+
+	if _, err := q.CreateDeliveryHistory(ctx, queries.CreateDeliveryHistoryParams{
+		TenantID:         job.TenantID,
+		JobID:            utils.NullUUID(job.ID),
+		BuyerID:          utils.NullUUID(job.BuyerID),
+		DeliveryMethodID: utils.NullUUID(job.DeliveryMethodID),
+		Status:           utils.SqlNullString("held"),
+		ErrorMessage:     utils.SqlNullString(hold.Error()),
+		PayloadSummary:   summary.nullRawMessage(),
+	}); err != nil {
+		return fmt.Errorf("failed to insert history: %w", err)
+	}
+	return nil
+}
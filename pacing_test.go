@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+)
+
+func TestPacingWindows(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name     string
+		now      time.Time
+		day      time.Time
+		weekFrom time.Time
+	}{
+		{name: "monday midnight", now: day(16), day: day(16), weekFrom: day(16)},
+		{name: "sunday before midnight", now: time.Date(2026, 3, 15, 23, 59, 59, 0, time.UTC), day: day(15), weekFrom: day(9)},
+		{name: "midweek", now: time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC), day: day(18), weekFrom: day(16)},
+		{name: "saturday", now: time.Date(2026, 3, 21, 8, 0, 0, 0, time.UTC), day: day(21), weekFrom: day(16)},
+		// Windows are UTC whatever zone now is in
+		{name: "monday east of UTC", now: time.Date(2026, 3, 16, 1, 0, 0, 0, time.FixedZone("", 5*3600)), day: day(15), weekFrom: day(9)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dayStart, weekStart := pacingWindows(tt.now)
+			if !dayStart.Equal(tt.day) || !weekStart.Equal(tt.weekFrom) {
+				t.Errorf("pacingWindows(%s) = %s, %s, want %s, %s", tt.now, dayStart, weekStart, tt.day, tt.weekFrom)
+			}
+		})
+	}
+}
+
+func TestCampaignAllowance(t *testing.T) {
+	// A Wednesday, in the week starting Monday the 16th
+	dayStart := time.Date(2026, 3, 18, 0, 0, 0, 0, time.UTC)
+	weekStart := time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)
+	tomorrow := dayStart.AddDate(0, 0, 1)
+	nextWeek := weekStart.AddDate(0, 0, 7)
+
+	n := func(v int32) sql.NullInt32 { return sql.NullInt32{Int32: v, Valid: true} }
+	date := func(d int) sql.NullTime {
+		return sql.NullTime{Time: time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC), Valid: true}
+	}
+	even := sql.NullString{String: pacingEven, Valid: true}
+
+	tests := []struct {
+		name      string
+		campaign  queries.Campaign
+		counts    queries.GetCampaignWindowCountsRow
+		requested int
+		allowed   int
+		limit     string
+		holdUntil time.Time
+	}{
+		{name: "no caps", requested: 30, allowed: 30, holdUntil: tomorrow},
+		{
+			name:      "under the daily cap",
+			campaign:  queries.Campaign{DailyLeadCap: n(50)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 10, WeekCount: 10},
+			requested: 30, allowed: 30, holdUntil: tomorrow,
+		},
+		{
+			name:      "exactly fills the daily cap",
+			campaign:  queries.Campaign{DailyLeadCap: n(50)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 20, WeekCount: 20},
+			requested: 30, allowed: 30, holdUntil: tomorrow,
+		},
+		{
+			name:      "partial under the daily cap",
+			campaign:  queries.Campaign{DailyLeadCap: n(50)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 40, WeekCount: 40},
+			requested: 30, allowed: 10, limit: limitDaily, holdUntil: tomorrow,
+		},
+		{
+			name:      "daily cap reached",
+			campaign:  queries.Campaign{DailyLeadCap: n(50)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 50, WeekCount: 50},
+			requested: 30, allowed: 0, limit: limitDaily, holdUntil: tomorrow,
+		},
+		{
+			name:      "counts past a lowered cap",
+			campaign:  queries.Campaign{DailyLeadCap: n(50)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 60, WeekCount: 60},
+			requested: 30, allowed: 0, limit: limitDaily, holdUntil: tomorrow,
+		},
+		{
+			name:      "partial under the weekly cap holds until monday",
+			campaign:  queries.Campaign{WeeklyLeadCap: n(100)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 0, WeekCount: 90},
+			requested: 30, allowed: 10, limit: limitWeekly, holdUntil: nextWeek,
+		},
+		{
+			name:      "daily cap tighter than weekly",
+			campaign:  queries.Campaign{DailyLeadCap: n(20), WeeklyLeadCap: n(100)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 15, WeekCount: 85},
+			requested: 30, allowed: 5, limit: limitDaily, holdUntil: tomorrow,
+		},
+		{
+			name:      "weekly cap tighter than daily",
+			campaign:  queries.Campaign{DailyLeadCap: n(50), WeeklyLeadCap: n(100)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 10, WeekCount: 95},
+			requested: 30, allowed: 5, limit: limitWeekly, holdUntil: nextWeek,
+		},
+		{
+			// Tomorrow's daily window would still be over the weekly cap
+			name:      "both caps reached holds until monday",
+			campaign:  queries.Campaign{DailyLeadCap: n(50), WeeklyLeadCap: n(100)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 50, WeekCount: 100},
+			requested: 30, allowed: 0, limit: limitWeekly, holdUntil: nextWeek,
+		},
+		{
+			name:      "partial drop at the target",
+			campaign:  queries.Campaign{TargetLeadCount: n(100), DeliveredLeadCount: 90},
+			requested: 30, allowed: 10, limit: limitTarget,
+		},
+		{
+			name:      "target reached",
+			campaign:  queries.Campaign{TargetLeadCount: n(100), DeliveredLeadCount: 100},
+			requested: 30, allowed: 0, limit: limitTarget,
+		},
+		{
+			// Leads past the target are dropped rather than held for a cap that reopens
+			name:      "target ties the daily cap",
+			campaign:  queries.Campaign{TargetLeadCount: n(100), DeliveredLeadCount: 95, DailyLeadCap: n(20)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 15, WeekCount: 15},
+			requested: 30, allowed: 5, limit: limitTarget,
+		},
+		{
+			name:      "daily cap tighter than the target",
+			campaign:  queries.Campaign{TargetLeadCount: n(100), DeliveredLeadCount: 50, DailyLeadCap: n(20)},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 15, WeekCount: 15},
+			requested: 30, allowed: 5, limit: limitDaily, holdUntil: tomorrow,
+		},
+		{
+			// 60 left over 10 days through the 27th
+			name:      "even share",
+			campaign:  queries.Campaign{TargetLeadCount: n(100), DeliveredLeadCount: 40, EndDate: date(27), Pacing: even},
+			requested: 30, allowed: 6, limit: limitEven, holdUntil: tomorrow,
+		},
+		{
+			name:      "even share less what today delivered",
+			campaign:  queries.Campaign{TargetLeadCount: n(100), DeliveredLeadCount: 44, EndDate: date(27), Pacing: even},
+			counts:    queries.GetCampaignWindowCountsRow{DayCount: 4, WeekCount: 4},
+			requested: 30, allowed: 2, limit: limitEven, holdUntil: tomorrow,
+		},
+		{
+			name:      "even share rounds up",
+			campaign:  queries.Campaign{TargetLeadCount: n(100), DeliveredLeadCount: 39, EndDate: date(27), Pacing: even},
+			requested: 30, allowed: 7, limit: limitEven, holdUntil: tomorrow,
+		},
+		{
+			name:      "even share on the last day is the rest",
+			campaign:  queries.Campaign{TargetLeadCount: n(100), DeliveredLeadCount: 40, EndDate: date(18), Pacing: even},
+			requested: 50, allowed: 50, holdUntil: tomorrow,
+		},
+		{
+			name:      "even pacing needs an end date",
+			campaign:  queries.Campaign{TargetLeadCount: n(100), DeliveredLeadCount: 40, Pacing: even},
+			requested: 30, allowed: 30, holdUntil: tomorrow,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := campaignAllowance(&tt.campaign, tt.counts, tt.requested, dayStart, weekStart)
+			if got.allowed != tt.allowed || got.limit != tt.limit {
+				t.Errorf("allowance = %d (%q), want %d (%q)", got.allowed, got.limit, tt.allowed, tt.limit)
+			}
+			if hold := got.holdUntil(); !hold.Equal(tt.holdUntil) {
+				t.Errorf("holdUntil = %s, want %s", hold, tt.holdUntil)
+			}
+		})
+	}
+}
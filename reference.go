@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+// errReferenceUnresolvable marks a reference payload whose batch or campaign is gone;
+// retrying won't bring them back
+var errReferenceUnresolvable = errors.New("referenced leads unavailable")
+
+// snapshotSummary records exactly which lead data a delivery sent
+type snapshotSummary struct {
+	Mode        string    `json:"mode"`
+	LeadBatchID string    `json:"lead_batch_id,omitempty"`
+	LeadCount   int       `json:"lead_count"`
+	Hash        string    `json:"hash"`
+	LoadedAt    time.Time `json:"loaded_at,omitempty"` // When reference-mode leads were read
+}
+
+// resolveReferencePayload fills a reference payload with the campaign's questions and
+// its csv_field_config. The batch's leads are read later, a page at a time, by
+// leadSpool.fill.
+func resolveReferencePayload(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, p *payload.Payload) error {
+	campaignID := uuid.MustParse(p.CampaignID) // Checked by payload.Decode
+
+	campaign, err := q.GetCampaignByID(ctx, queries.GetCampaignByIDParams{
+		ID:       campaignID,
+		TenantID: job.TenantID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: campaign %s not found", errReferenceUnresolvable, campaignID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch campaign: %w", err)
+	}
+
+	campaignQuestions, err := q.ListCampaignQuestions(ctx, queries.ListCampaignQuestionsParams{
+		CampaignID: campaign.ID,
+		TenantID:   job.TenantID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list campaign questions: %w", err)
+	}
+	p.Questions = nil
+	for _, cq := range campaignQuestions {
+		p.Questions = append(p.Questions, payload.Question{
+			ID:           cq.ID.String(),
+			QuestionText: cq.QuestionText,
+			DisplayOrder: int(cq.DisplayOrder),
+		})
+	}
+
+	p.CsvFieldConfig = nil
+	if campaign.CsvFieldConfig.Valid {
+		if err := json.Unmarshal(campaign.CsvFieldConfig.RawMessage, &p.CsvFieldConfig); err != nil {
+			return fmt.Errorf("%w: invalid csv_field_config on campaign %s: %v", errReferenceUnresolvable, campaign.ID, err)
+		}
+	}
+	return nil
+}
+
+// leadSpool holds the leads a reference job kept after filtering, in
+// delivery_job_leads, so the batch is never held in memory whole. Leads keep the
+// batch's order, which lets a cursor split the job. The spool only lives for one
+// attempt: it is filled anew by each attempt and cleared when the attempt ends.
+type leadSpool struct {
+	q     *queries.Queries
+	job   *queries.DeliveryJob
+	p     *payload.Payload
+	count int
+}
+
+func newLeadSpool(q *queries.Queries, job *queries.DeliveryJob, p *payload.Payload) *leadSpool {
+	return &leadSpool{q: q, job: job, p: p}
+}
+
+func (s *leadSpool) Len() int {
+	return s.count
+}
+
+// fill reads the payload's leads from its batch a page at a time, between its After
+// and Through cursors, runs each page through the filters and spools what they keep.
+// A batch without leads in range returns an error wrapping errReferenceUnresolvable.
+func (s *leadSpool) fill(ctx context.Context, filters *leadFilters) error {
+	if err := s.clear(ctx); err != nil {
+		return err
+	}
+	filters.earlier = s
+
+	batchID := uuid.MustParse(s.p.LeadBatchID) // Checked by payload.Decode
+	params := queries.ListLeadsByBatchPageParams{
+		TenantID:    s.job.TenantID,
+		LeadBatchID: utils.NullUUID(batchID),
+		Limit:       jobLeadPageSize,
+	}
+	if s.p.After != nil {
+		params.AfterCreatedAt, params.AfterID = cursorParams(s.p.After)
+	}
+	if s.p.Through != nil {
+		params.ThroughCreatedAt, params.ThroughID = cursorParams(s.p.Through)
+	}
+
+	read := 0
+	for {
+		rows, err := s.q.ListLeadsByBatchPage(ctx, params)
+		if err != nil {
+			return fmt.Errorf("failed to load leads for batch %s: %w", batchID, err)
+		}
+		read += len(rows)
+
+		leads := make([]payload.Lead, len(rows))
+		createdAt := make(map[string]sql.NullTime, len(rows))
+		for i, row := range rows {
+			leads[i] = payload.FromLead(row)
+			createdAt[leads[i].ID] = row.CreatedAt
+		}
+		kept, err := filters.apply(ctx, leads)
+		if err != nil {
+			return err
+		}
+		if err := s.append(ctx, kept, createdAt); err != nil {
+			return err
+		}
+
+		if len(rows) < jobLeadPageSize {
+			break
+		}
+		last := rows[len(rows)-1]
+		params.AfterCreatedAt = last.CreatedAt
+		params.AfterID = uuid.NullUUID{UUID: last.ID, Valid: true}
+	}
+
+	if read == 0 {
+		return fmt.Errorf("%w: lead batch %s has no leads", errReferenceUnresolvable, batchID)
+	}
+	log.Printf("Read %d leads for batch %s, kept %d (%d questions)", read, batchID, s.count, len(s.p.Questions))
+	return nil
+}
+
+// cursorParams converts a payload cursor, checked by payload.Decode, to query
+// parameters
+func cursorParams(c *payload.Cursor) (sql.NullTime, uuid.NullUUID) {
+	var createdAt sql.NullTime
+	if c.CreatedAt != nil {
+		createdAt = sql.NullTime{Time: *c.CreatedAt, Valid: true}
+	}
+	return createdAt, uuid.NullUUID{UUID: uuid.MustParse(c.ID), Valid: true}
+}
+
+// clear removes every spooled lead
+func (s *leadSpool) clear(ctx context.Context) error {
+	if err := s.q.DeleteDeliveryJobLeads(ctx, queries.DeleteDeliveryJobLeadsParams{
+		JobID:    s.job.ID,
+		TenantID: s.job.TenantID,
+	}); err != nil {
+		return fmt.Errorf("failed to clear spooled leads: %w", err)
+	}
+	s.count = 0
+	return nil
+}
+
+// append spools leads after the ones already kept. createdAt holds the leads'
+// created_at by ID, for the cursor of a split.
+func (s *leadSpool) append(ctx context.Context, leads []payload.Lead, createdAt map[string]sql.NullTime) error {
+	if len(leads) == 0 {
+		return nil
+	}
+	params := queries.InsertDeliveryJobLeadsParams{
+		JobID:         s.job.ID,
+		TenantID:      s.job.TenantID,
+		FirstPosition: int32(s.count),
+	}
+	for i := range leads {
+		raw, err := json.Marshal(&leads[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode lead %s: %w", leads[i].ID, err)
+		}
+		created := ""
+		if t := createdAt[leads[i].ID]; t.Valid {
+			created = t.Time.Format(time.RFC3339Nano)
+		}
+		params.LeadIds = append(params.LeadIds, leads[i].ID)
+		params.CreatedAts = append(params.CreatedAts, created)
+		params.EmailHashes = append(params.EmailHashes, ledgerHash(leads[i].Email))
+		params.PhoneHashes = append(params.PhoneHashes, ledgerHash(leads[i].Phone))
+		params.Leads = append(params.Leads, string(raw))
+	}
+	if err := s.q.InsertDeliveryJobLeads(ctx, params); err != nil {
+		return fmt.Errorf("failed to spool leads: %w", err)
+	}
+	s.count += len(leads)
+	return nil
+}
+
+// contacts returns the spooled leads sharing an email or phone hash with the given
+// ones, earliest first
+func (s *leadSpool) contacts(ctx context.Context, emailHashes, phoneHashes []string) ([]queries.ListDeliveryJobContactMatchesRow, error) {
+	return s.q.ListDeliveryJobContactMatches(ctx, queries.ListDeliveryJobContactMatchesParams{
+		JobID:       s.job.ID,
+		TenantID:    s.job.TenantID,
+		EmailHashes: emailHashes,
+		PhoneHashes: phoneHashes,
+	})
+}
+
+func (s *leadSpool) page(ctx context.Context, from, limit int) ([]payload.Lead, error) {
+	rows, err := s.q.ListDeliveryJobLeads(ctx, queries.ListDeliveryJobLeadsParams{
+		JobID:        s.job.ID,
+		TenantID:     s.job.TenantID,
+		FromPosition: int32(from),
+		Limit:        int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spooled leads: %w", err)
+	}
+	leads := make([]payload.Lead, len(rows))
+	for i, row := range rows {
+		if err := json.Unmarshal(row.Lead, &leads[i]); err != nil {
+			return nil, fmt.Errorf("failed to decode spooled lead %s: %w", row.LeadID, err)
+		}
+	}
+	return leads, nil
+}
+
+// split keeps the first n spooled leads by ending the job's range at the last of them.
+// The held job stays in reference mode and starts after that lead, so it reads the
+// rest of the batch when it runs.
+func (s *leadSpool) split(ctx context.Context, q *queries.Queries, n int, heldAt time.Time) (*queries.DeliveryJob, error) {
+	last, err := q.GetDeliveryJobLead(ctx, queries.GetDeliveryJobLeadParams{
+		JobID:    s.job.ID,
+		TenantID: s.job.TenantID,
+		Position: int32(n - 1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spooled lead %d: %w", n-1, err)
+	}
+	cursor := &payload.Cursor{ID: last.LeadID.String()}
+	if last.LeadCreatedAt.Valid {
+		cursor.CreatedAt = &last.LeadCreatedAt.Time
+	}
+
+	// Questions and csv_field_config are resolved again when the jobs run
+	stored := *s.p
+	stored.Questions = nil
+	stored.CsvFieldConfig = nil
+
+	var held *queries.DeliveryJob
+	if !heldAt.IsZero() {
+		rest := stored
+		rest.After = cursor
+		rest.TotalLeads = s.count - n
+		created, err := createHeldJob(ctx, q, s.job, &rest, heldAt)
+		if err != nil {
+			return nil, err
+		}
+		held = &created
+	}
+
+	// The job keeps only its share, so a retry doesn't split it again
+	if err := q.DeleteDeliveryJobLeads(ctx, queries.DeleteDeliveryJobLeadsParams{
+		JobID:        s.job.ID,
+		TenantID:     s.job.TenantID,
+		FromPosition: int32(n),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to trim spooled leads: %w", err)
+	}
+	stored.Through = cursor
+	stored.TotalLeads = n
+	if err := rewriteJobPayload(ctx, q, s.job, &stored); err != nil {
+		return nil, err
+	}
+	s.p.Through = cursor
+	s.count = n
+	return held, nil
+}
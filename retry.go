@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+)
+
+// RetryPolicy controls how a failed job is rescheduled. It is read from the
+// "retry" key of DeliveryMethod.Config; zero fields fall back to the method type's defaults.
+type RetryPolicy struct {
+	BaseDelaySec int   `json:"base_delay_sec,omitempty"` // Delay before the first retry
+	MaxDelaySec  int   `json:"max_delay_sec,omitempty"`  // Cap on the exponential delay
+	MaxAttempts  int32 `json:"max_attempts,omitempty"`   // Total attempts before the job is failed
+}
+
+// defaultRetryPolicies holds per method type defaults
+var defaultRetryPolicies = map[string]RetryPolicy{
+	"email": {BaseDelaySec: 60, MaxDelaySec: 3600, MaxAttempts: 3},
+	"api":   {BaseDelaySec: 30, MaxDelaySec: 1800, MaxAttempts: 5},
+}
+
+// fallbackRetryPolicy applies to method types without an entry in defaultRetryPolicies
+var fallbackRetryPolicy = RetryPolicy{BaseDelaySec: 60, MaxDelaySec: 3600, MaxAttempts: 3}
+
+// retryPolicyFor merges the method's configured retry policy over its type defaults
+func retryPolicyFor(method *queries.DeliveryMethod) RetryPolicy {
+	policy, ok := defaultRetryPolicies[method.MethodType.String]
+	if !ok {
+		policy = fallbackRetryPolicy
+	}
+
+	var cfg struct {
+		Retry *RetryPolicy `json:"retry"`
+	}
+	if len(method.Config) == 0 || json.Unmarshal(method.Config, &cfg) != nil || cfg.Retry == nil {
+		return policy
+	}
+
+	if cfg.Retry.BaseDelaySec > 0 {
+		policy.BaseDelaySec = cfg.Retry.BaseDelaySec
+	}
+	if cfg.Retry.MaxDelaySec > 0 {
+		policy.MaxDelaySec = cfg.Retry.MaxDelaySec
+	}
+	if cfg.Retry.MaxAttempts > 0 {
+		policy.MaxAttempts = cfg.Retry.MaxAttempts
+	}
+	if policy.MaxDelaySec < policy.BaseDelaySec {
+		policy.MaxDelaySec = policy.BaseDelaySec
+	}
+	return policy
+}
+
+// backoff returns the delay before the next attempt after `attempt` failed attempts (1-based).
+// The delay doubles per attempt up to the cap; the upper half is randomized so that jobs
+// failing together against the same endpoint don't retry in lockstep.
+func (p RetryPolicy) backoff(attempt int32) time.Duration {
+	base := seconds(p.BaseDelaySec)
+	maxDelay := seconds(p.MaxDelaySec)
+
+	delay := base
+	for i := int32(1); i < attempt && delay < maxDelay; i++ {
+		if delay > maxDelay/2 {
+			delay = maxDelay
+		} else {
+			delay *= 2
+		}
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// seconds converts a configured number of seconds to a Duration, saturating instead of
+// overflowing into a negative delay
+func seconds(n int) time.Duration {
+	if n > int(math.MaxInt64/time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelaySec: 30, MaxDelaySec: 1800}
+	tests := []struct {
+		attempt int32
+		delay   time.Duration // Before jitter takes up to half of it off
+	}{
+		{attempt: 0, delay: 30 * time.Second},
+		{attempt: 1, delay: 30 * time.Second},
+		{attempt: 2, delay: time.Minute},
+		{attempt: 3, delay: 2 * time.Minute},
+		{attempt: 6, delay: 16 * time.Minute},
+		{attempt: 7, delay: 30 * time.Minute}, // 32 minutes, capped
+		{attempt: 100, delay: 30 * time.Minute},
+		{attempt: math.MaxInt32, delay: 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		seen := map[time.Duration]bool{}
+		for range 200 {
+			got := policy.backoff(tt.attempt)
+			if got < tt.delay/2 || got > tt.delay {
+				t.Fatalf("backoff(%d) = %s, want within [%s, %s]", tt.attempt, got, tt.delay/2, tt.delay)
+			}
+			seen[got] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("backoff(%d) always %v, want jitter", tt.attempt, seen)
+		}
+	}
+}
+
+func TestBackoffLargeDelays(t *testing.T) {
+	maxDelay := time.Duration(math.MaxInt32) * time.Second
+	tests := []struct {
+		policy  RetryPolicy
+		attempt int32
+		delay   time.Duration
+	}{
+		{policy: RetryPolicy{BaseDelaySec: 1, MaxDelaySec: math.MaxInt32}, attempt: 31, delay: 1 << 30 * time.Second},
+		{policy: RetryPolicy{BaseDelaySec: 1, MaxDelaySec: math.MaxInt32}, attempt: 32, delay: maxDelay},
+		{policy: RetryPolicy{BaseDelaySec: 1, MaxDelaySec: math.MaxInt32}, attempt: math.MaxInt32, delay: maxDelay},
+		// Seconds past what a Duration holds saturate rather than wrap negative
+		{policy: RetryPolicy{BaseDelaySec: 60, MaxDelaySec: math.MaxInt}, attempt: 1, delay: time.Minute},
+		{policy: RetryPolicy{BaseDelaySec: 60, MaxDelaySec: math.MaxInt}, attempt: math.MaxInt32, delay: math.MaxInt64},
+		{policy: RetryPolicy{BaseDelaySec: math.MaxInt, MaxDelaySec: math.MaxInt}, attempt: 2, delay: math.MaxInt64},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.backoff(tt.attempt); got < tt.delay/2 || got > tt.delay {
+			t.Errorf("%+v backoff(%d) = %s, want within [%s, %s]", tt.policy, tt.attempt, got, tt.delay/2, tt.delay)
+		}
+	}
+}
+
+func TestBackoffZeroPolicy(t *testing.T) {
+	for _, policy := range []RetryPolicy{{}, {BaseDelaySec: -5, MaxDelaySec: -1}, {MaxDelaySec: 60}} {
+		if got := policy.backoff(3); got != 0 {
+			t.Errorf("%+v backoff = %s, want 0", policy, got)
+		}
+	}
+}
+
+func TestRetryPolicyFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		methodType string
+		config     string
+		want       RetryPolicy
+	}{
+		{name: "email defaults", methodType: "email", want: defaultRetryPolicies["email"]},
+		{name: "api defaults", methodType: "api", config: `{"url": "https://example.com"}`, want: defaultRetryPolicies["api"]},
+		{name: "fallback", methodType: "sftp", want: fallbackRetryPolicy},
+		{name: "no method type", want: fallbackRetryPolicy},
+		{name: "invalid config", methodType: "api", config: `{"retry": 5}`, want: defaultRetryPolicies["api"]},
+		{name: "null retry", methodType: "api", config: `{"retry": null}`, want: defaultRetryPolicies["api"]},
+		{
+			name: "partial override", methodType: "api", config: `{"retry": {"max_attempts": 10}}`,
+			want: RetryPolicy{BaseDelaySec: 30, MaxDelaySec: 1800, MaxAttempts: 10},
+		},
+		{
+			name: "full override", methodType: "s3", config: `{"retry": {"base_delay_sec": 5, "max_delay_sec": 50, "max_attempts": 2}}`,
+			want: RetryPolicy{BaseDelaySec: 5, MaxDelaySec: 50, MaxAttempts: 2},
+		},
+		{
+			name: "non-positive fields keep defaults", methodType: "email", config: `{"retry": {"base_delay_sec": 0, "max_delay_sec": -1, "max_attempts": -3}}`,
+			want: defaultRetryPolicies["email"],
+		},
+		{
+			name: "cap below base", methodType: "api", config: `{"retry": {"base_delay_sec": 600, "max_delay_sec": 60}}`,
+			want: RetryPolicy{BaseDelaySec: 600, MaxDelaySec: 600, MaxAttempts: 5},
+		},
+		{
+			name: "base above the default cap", methodType: "email", config: `{"retry": {"base_delay_sec": 7200}}`,
+			want: RetryPolicy{BaseDelaySec: 7200, MaxDelaySec: 7200, MaxAttempts: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := &queries.DeliveryMethod{
+				MethodType: sql.NullString{String: tt.methodType, Valid: tt.methodType != ""},
+				Config:     json.RawMessage(tt.config),
+			}
+			if got := retryPolicyFor(method); got != tt.want {
+				t.Errorf("retryPolicyFor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/envelope"
+	"github.com/DylanCoon99/delivery/internal/leadfile"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+type S3DeliveryConfig struct {
+	Bucket               string `json:"bucket"`
+	Prefix               string `json:"prefix,omitempty"`         // Key prefix, e.g. "incoming/leads"
+	Region               string `json:"region,omitempty"`         // Defaults to the worker's region
+	Endpoint             string `json:"endpoint,omitempty"`       // Custom S3-compatible endpoint (e.g. MinIO)
+	UsePathStyle         bool   `json:"use_path_style,omitempty"` // Required by most S3-compatible servers
+	AccessKeyID          string `json:"access_key_id,omitempty"`  // Static credentials for non-AWS endpoints
+	SecretAccessKey      string `json:"secret_access_key,omitempty"`
+	RoleARN              string `json:"role_arn,omitempty"`           // Assumed before writing, for cross-account buckets
+	ExternalID           string `json:"external_id,omitempty"`        // External ID required by the role's trust policy
+	RoleSessionSec       int    `json:"role_session_sec,omitempty"`   // Length of the assumed role session, 1h by default
+	ServerSideEncryption string `json:"sse,omitempty"`                // "AES256" or "aws:kms"
+	FilenamePattern      string `json:"filename_pattern,omitempty"`   // Supports {timestamp}, {date}, {job_id}, {buyer_id}, {schema_version}
+	NotifyRecipient      bool   `json:"notify_recipient,omitempty"`   // Email the payload recipient a presigned download link
+	PresignExpirySec     int    `json:"presign_expiry_sec,omitempty"` // Capped by the role session when role_arn is set
+}
+
+const (
+	defaultPresignExpiry = 72 * time.Hour
+	maxPresignExpiry     = 7 * 24 * time.Hour // SigV4 limit
+	defaultRoleSession   = time.Hour
+	minRoleSession       = 15 * time.Minute // STS minimum for an assumed role session
+	maxRoleSession       = 12 * time.Hour   // IAM maximum for an assumed role session
+)
+
+// roleSession returns how long an assumed role session lasts. It is kept short and
+// independent of the link expiry; raising it also needs the role's maximum session
+// duration raised in IAM.
+func (cfg S3DeliveryConfig) roleSession() time.Duration {
+	session := defaultRoleSession
+	if cfg.RoleSessionSec > 0 {
+		session = time.Duration(cfg.RoleSessionSec) * time.Second
+	}
+	return min(max(session, minRoleSession), maxRoleSession)
+}
+
+// presignExpiry returns how long download links stay valid. Links signed with
+// assumed-role credentials stop working when the role session ends, so with role_arn
+// set they last at most role_session_sec (1h by default).
+func (cfg S3DeliveryConfig) presignExpiry() time.Duration {
+	expiry := defaultPresignExpiry
+	if cfg.PresignExpirySec > 0 {
+		expiry = time.Duration(cfg.PresignExpirySec) * time.Second
+	}
+	if expiry > maxPresignExpiry {
+		expiry = maxPresignExpiry
+	}
+	if cfg.RoleARN != "" {
+		expiry = min(expiry, cfg.roleSession())
+	}
+	return expiry
+}
+
+// newS3Client builds a client for the method's bucket, layering endpoint overrides,
+// static credentials and role assumption over the worker's AWS config
+func newS3Client(cfg S3DeliveryConfig) *s3.Client {
+	conf := awsCfg.Copy()
+	if cfg.Region != "" {
+		conf.Region = cfg.Region
+	}
+	if cfg.AccessKeyID != "" {
+		conf.Credentials = credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	}
+	if cfg.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(conf), cfg.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "lead-delivery"
+			o.Duration = cfg.roleSession()
+			if cfg.ExternalID != "" {
+				o.ExternalID = aws.String(cfg.ExternalID)
+			}
+		})
+		conf.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	return s3.NewFromConfig(conf, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.UsePathStyle
+	})
+}
+
+// deliverS3 writes the lead file to the configured bucket and optionally emails the
+// recipient a time-limited download link instead of an attachment
+func deliverS3(ctx context.Context, job *queries.DeliveryJob, jobPayload *payload.Payload, cfg S3DeliveryConfig, file *leadfile.File) error {
+	// Resolve the recipient first so a suppressed address fails before anything is written
+	var recipientEmail string
+	var leadCount int
+	if cfg.NotifyRecipient {
+		var err error
+		recipientEmail, leadCount, err = emailRecipient(ctx, jobPayload)
+		if err != nil {
+			return err
+		}
+	}
+
+	client := newS3Client(cfg)
+	// Named for the job's creation so a retry, say after the link email failed,
+	// overwrites the object it already wrote instead of adding a second copy
+	filename := file.Filename(deliveryFilename(cfg.FilenamePattern, job, job.CreatedAt))
+	key := strings.TrimPrefix(path.Join(cfg.Prefix, filename), "/")
+
+	input := &s3.PutObjectInput{
+		Bucket:             aws.String(cfg.Bucket),
+		Key:                aws.String(key),
+		ContentType:        aws.String(file.ContentType),
+		ContentDisposition: aws.String(fmt.Sprintf("attachment; filename=%q", filename)),
+		Metadata: map[string]string{
+			"job-id":         job.ID.String(),
+			"buyer-id":       job.BuyerID.String(),
+			"schema-version": file.SchemaVersion,
+		},
+	}
+	if cfg.ServerSideEncryption != "" {
+		input.ServerSideEncryption = s3types.ServerSideEncryption(cfg.ServerSideEncryption)
+	}
+
+	log.Printf("Uploading %s to s3://%s/%s", filename, cfg.Bucket, key)
+	data := file.Open()
+	defer data.Close()
+	size, err := uploadS3(ctx, client, input, data)
+	if err != nil {
+		log.Printf("S3 delivery failed: %v", err)
+		return fmt.Errorf("s3 upload failed: %w", err)
+	}
+	log.Printf("S3 delivery successful: s3://%s/%s (%d bytes)", cfg.Bucket, key, size)
+
+	if !cfg.NotifyRecipient {
+		return nil
+	}
+
+	expiry := cfg.presignExpiry()
+	// The cached role credentials may be part way through their session already
+	if creds, err := client.Options().Credentials.Retrieve(ctx); err == nil && creds.CanExpire {
+		expiry = min(expiry, time.Until(creds.Expires).Truncate(time.Second))
+	}
+	presigned, err := s3.NewPresignClient(client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(cfg.Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return fmt.Errorf("failed to presign download link: %w", err)
+	}
+
+	return sendDownloadLinkEmail(ctx, recipientEmail, leadCount, filename, presigned.URL, expiry)
+}
+
+// s3PartSize is the size of each part of a multipart upload, and so the most of a file
+// held in memory at once. S3's minimum part size is 5 MB.
+const s3PartSize = 8 << 20
+
+// uploadS3 streams data to the object input describes and returns the bytes written.
+// Data that fits in one part goes up in a single PutObject; anything larger goes part by
+// part in a multipart upload, which is aborted if a part fails.
+func uploadS3(ctx context.Context, client *s3.Client, input *s3.PutObjectInput, data io.Reader) (int64, error) {
+	buf := make([]byte, s3PartSize)
+	// Parts hold decrypted contact details; clear the buffer once the upload is done
+	defer envelope.Zero(buf)
+
+	n, err := io.ReadFull(data, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	if n < len(buf) {
+		input.Body = bytes.NewReader(buf[:n])
+		if _, err := client.PutObject(ctx, input); err != nil {
+			return 0, err
+		}
+		return int64(n), nil
+	}
+
+	upload, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		ContentType:          input.ContentType,
+		ContentDisposition:   input.ContentDisposition,
+		Metadata:             input.Metadata,
+		ServerSideEncryption: input.ServerSideEncryption,
+		ChecksumAlgorithm:    s3types.ChecksumAlgorithmCrc32,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	abort := func(err error) (int64, error) {
+		// Runs even when ctx is done, so the bucket isn't left holding the parts
+		if _, abortErr := client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: upload.UploadId,
+		}); abortErr != nil {
+			log.Printf("Failed to abort multipart upload of s3://%s/%s: %v", aws.ToString(input.Bucket), aws.ToString(input.Key), abortErr)
+		}
+		return 0, err
+	}
+
+	var parts []s3types.CompletedPart
+	var size int64
+	for partNumber := int32(1); n > 0; partNumber++ {
+		part, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            input.Bucket,
+			Key:               input.Key,
+			UploadId:          upload.UploadId,
+			PartNumber:        aws.Int32(partNumber),
+			Body:              bytes.NewReader(buf[:n]),
+			ChecksumAlgorithm: s3types.ChecksumAlgorithmCrc32,
+		})
+		if err != nil {
+			return abort(fmt.Errorf("failed to upload part %d: %w", partNumber, err))
+		}
+		parts = append(parts, s3types.CompletedPart{
+			ETag:          part.ETag,
+			ChecksumCRC32: part.ChecksumCRC32,
+			PartNumber:    aws.Int32(partNumber),
+		})
+		size += int64(n)
+
+		n, err = io.ReadFull(data, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return abort(err)
+		}
+	}
+
+	if _, err := client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		return abort(fmt.Errorf("failed to complete multipart upload: %w", err))
+	}
+	return size, nil
+}
+
+// sendDownloadLinkEmail notifies the recipient that a lead file is ready to download
+func sendDownloadLinkEmail(ctx context.Context, recipientEmail string, leadCount int, filename, url string, expiry time.Duration) error {
+	expiresAt := time.Now().Add(expiry).UTC().Format("Jan 2, 2006 15:04 MST")
+	bodyText := fmt.Sprintf("Your delivery of %d leads (%s) is ready.\n\nDownload: %s\n\nThis link expires %s.",
+		leadCount, filename, url, expiresAt)
+
+	_, err := sesClient.SendEmail(ctx, &ses.SendEmailInput{
+		Destination: &types.Destination{
+			ToAddresses: []string{recipientEmail},
+		},
+		Message: &types.Message{
+			Body: &types.Body{
+				Text: &types.Content{
+					Data:    aws.String(bodyText),
+					Charset: aws.String("UTF-8"),
+				},
+			},
+			Subject: &types.Content{
+				Data:    aws.String("New Lead Delivery"),
+				Charset: aws.String("UTF-8"),
+			},
+		},
+		Source: aws.String(SenderAddress),
+	})
+	if err != nil {
+		log.Printf("Download link email failed: %v", err)
+		return fmt.Errorf("download link email failed: %w", err)
+	}
+
+	log.Printf("Download link sent to %s", recipientEmail)
+	return nil
+}
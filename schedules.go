@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	_ "time/tzdata" // Lambda base images don't ship a zoneinfo database
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"github.com/sqlc-dev/pqtype"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+// Reasons a schedule can't enqueue that a later run won't fix on its own. Both commit the
+// run rather than rolling it back, so next_run_at still advances.
+var (
+	// errScheduleNoBuyer disables the schedule: without its buyer it can never run
+	errScheduleNoBuyer = errors.New("schedule has no buyer")
+	// errScheduleNoMethod skips the run: the buyer may still add a delivery method
+	errScheduleNoMethod = errors.New("buyer has no delivery method")
+)
+
+// runDueSchedules executes every due delivery schedule of a tenant
+func runDueSchedules(ctx context.Context, q *queries.Queries, tenantID uuid.UUID) {
+	schedules, err := q.ListDueSchedules(ctx, tenantID)
+	if err != nil {
+		log.Printf("Failed to list due schedules for tenant %s: %v", tenantID, err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		if err := runSchedule(ctx, q, schedule); err != nil {
+			log.Printf("schedule %s failed: %v", schedule.ID, err)
+		}
+	}
+}
+
+// runSchedule enqueues jobs for one schedule and advances last_run_at/next_run_at in the same
+// transaction, so a run is never enqueued twice or lost between overlapping invocations.
+func runSchedule(ctx context.Context, q *queries.Queries, due queries.DeliverySchedule) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin schedule transaction: %w", err)
+	}
+	defer tx.Rollback()
+	qtx := q.WithTx(tx)
+
+	schedule, err := qtx.LockDueSchedule(ctx, queries.LockDueScheduleParams{
+		ID:       due.ID,
+		TenantID: due.TenantID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil // Another invocation is running or already ran it
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock schedule: %w", err)
+	}
+
+	now := time.Now()
+	nextRun, err := nextScheduleRun(schedule, now)
+	if err != nil {
+		// Retrying every minute can't fix the configuration; stop until it's edited
+		if disableErr := disableSchedule(ctx, qtx, schedule, err); disableErr != nil {
+			return fmt.Errorf("invalid schedule: %w (and failed to disable it: %v)", err, disableErr)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit disabling schedule: %w", err)
+		}
+		return fmt.Errorf("invalid schedule, disabled: %w", err)
+	}
+
+	// A schedule without next_run_at has never been evaluated; only initialize it
+	lastRun := sql.NullTime{Valid: false}
+	if schedule.NextRunAt.Valid {
+		enqueued, err := enqueueScheduledJobs(ctx, qtx, schedule, now)
+		switch {
+		case errors.Is(err, errScheduleNoBuyer):
+			if disableErr := disableSchedule(ctx, qtx, schedule, err); disableErr != nil {
+				return fmt.Errorf("%w (and failed to disable the schedule: %v)", err, disableErr)
+			}
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("failed to commit disabling schedule: %w", err)
+			}
+			return fmt.Errorf("schedule disabled: %w", err)
+		case errors.Is(err, errScheduleNoMethod):
+			if auditErr := auditSchedule(ctx, qtx, schedule, "delivery_schedule.skipped", err); auditErr != nil {
+				return fmt.Errorf("%w (and failed to record the skipped run: %v)", err, auditErr)
+			}
+			log.Printf("Schedule %s skipped: %v, next run at %s", schedule.ID, err, nextRun.Format(time.RFC3339))
+		case err != nil:
+			return err
+		default:
+			log.Printf("Schedule %s enqueued %d jobs, next run at %s", schedule.ID, enqueued, nextRun.Format(time.RFC3339))
+		}
+		lastRun = sql.NullTime{Time: now, Valid: true}
+	}
+
+	if _, err := qtx.UpdateDeliverySchedule(ctx, queries.UpdateDeliveryScheduleParams{
+		ID:        schedule.ID,
+		TenantID:  schedule.TenantID,
+		LastRunAt: lastRun,
+		NextRunAt: sql.NullTime{Time: nextRun, Valid: true},
+	}); err != nil {
+		return fmt.Errorf("failed to advance schedule: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit schedule run: %w", err)
+	}
+	return nil
+}
+
+// disableSchedule deactivates a schedule that can't be evaluated or run and records why
+// in the tenant's audit log. q must be bound to the schedule's transaction.
+func disableSchedule(ctx context.Context, q *queries.Queries, schedule queries.DeliverySchedule, cause error) error {
+	if _, err := q.UpdateDeliverySchedule(ctx, queries.UpdateDeliveryScheduleParams{
+		ID:       schedule.ID,
+		TenantID: schedule.TenantID,
+		IsActive: sql.NullBool{Bool: false, Valid: true},
+	}); err != nil {
+		return err
+	}
+	return auditSchedule(ctx, q, schedule, "delivery_schedule.disabled", cause)
+}
+
+// auditSchedule records what happened to a schedule, and why, in the tenant's audit log
+func auditSchedule(ctx context.Context, q *queries.Queries, schedule queries.DeliverySchedule, action string, cause error) error {
+	details, err := json.Marshal(map[string]string{
+		"schedule_id":     schedule.ID.String(),
+		"cron_expression": schedule.CronExpression.String,
+		"timezone":        schedule.Timezone.String,
+		"error":           cause.Error(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = q.CreateAuditLog(ctx, queries.CreateAuditLogParams{
+		TenantID: schedule.TenantID,
+		Action:   action,
+		Details:  pqtype.NullRawMessage{RawMessage: details, Valid: true},
+	})
+	return err
+}
+
+// nextScheduleRun evaluates the cron expression in the schedule's IANA timezone (UTC if unset).
+// Runs missed while the worker was down are not replayed; the next run is always after `after`.
+// The expression is read as wall-clock time: a run in the hour skipped when clocks spring
+// forward happens an hour later, and one in the hour repeated when they fall back runs once.
+func nextScheduleRun(schedule queries.DeliverySchedule, after time.Time) (time.Time, error) {
+	if !schedule.CronExpression.Valid || schedule.CronExpression.String == "" {
+		return time.Time{}, fmt.Errorf("missing cron expression")
+	}
+
+	loc := time.UTC
+	if schedule.Timezone.Valid && schedule.Timezone.String != "" {
+		var err error
+		loc, err = time.LoadLocation(schedule.Timezone.String)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown timezone %q: %w", schedule.Timezone.String, err)
+		}
+	}
+
+	spec, err := cron.ParseStandard(schedule.CronExpression.String)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad cron expression %q: %w", schedule.CronExpression.String, err)
+	}
+
+	// Step through wall-clock times, which have no DST, and place each in loc
+	next := wallClock(after.In(loc))
+	for {
+		next = spec.Next(next)
+		if next.IsZero() {
+			return time.Time{}, fmt.Errorf("cron expression %q never runs", schedule.CronExpression.String)
+		}
+		run := time.Date(next.Year(), next.Month(), next.Day(), next.Hour(), next.Minute(), next.Second(), 0, loc)
+		// A wall-clock time that doesn't exist in loc comes back shifted; move it past the gap
+		if shifted := wallClock(run.In(loc)); !shifted.Equal(next) {
+			run = run.Add(next.Sub(shifted))
+		}
+		if run.After(after) {
+			return run.UTC(), nil
+		}
+	}
+}
+
+// wallClock returns t's date and clock reading as a UTC time
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// enqueueScheduledJobs creates a delivery and a delivery job for each of the buyer's lead
+// batches that hasn't been scheduled yet. q must be bound to the schedule's transaction.
+// A buyer that is gone returns errScheduleNoBuyer, one without a delivery method
+// errScheduleNoMethod.
+func enqueueScheduledJobs(ctx context.Context, q *queries.Queries, schedule queries.DeliverySchedule, now time.Time) (int, error) {
+	if !schedule.BuyerID.Valid {
+		return 0, errScheduleNoBuyer
+	}
+
+	buyer, err := q.GetBuyerByID(ctx, queries.GetBuyerByIDParams{
+		ID:       schedule.BuyerID.UUID,
+		TenantID: schedule.TenantID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: buyer %s not found", errScheduleNoBuyer, schedule.BuyerID.UUID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch buyer: %w", err)
+	}
+
+	method, err := q.GetDeliveryMethodByBuyerID(ctx, queries.GetDeliveryMethodByBuyerIDParams{
+		BuyerID:  schedule.BuyerID,
+		TenantID: schedule.TenantID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: buyer %s", errScheduleNoMethod, buyer.ID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch delivery method for buyer %s: %w", buyer.ID, err)
+	}
+
+	batches, err := q.ListUndeliveredLeadBatchesForBuyer(ctx, queries.ListUndeliveredLeadBatchesForBuyerParams{
+		TenantID: schedule.TenantID,
+		BuyerID:  schedule.BuyerID,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pending lead batches: %w", err)
+	}
+
+	enqueued := 0
+	for _, batch := range batches {
+		leadCount, err := q.CountLeadsByBatch(ctx, queries.CountLeadsByBatchParams{
+			TenantID:    schedule.TenantID,
+			LeadBatchID: utils.NullUUID(batch.ID),
+		})
+		if err != nil {
+			return enqueued, fmt.Errorf("failed to count leads for batch %s: %w", batch.ID, err)
+		}
+		if leadCount == 0 {
+			continue // Nothing uploaded yet; pick it up on a later run
+		}
+
+		// The worker loads the batch's leads, questions and field config when it sends
+		jobPayload := payload.Payload{
+			Version:        payload.CurrentVersion,
+			Mode:           payload.ModeReference,
+			CampaignID:     batch.CampaignID.String(),
+			LeadBatchID:    batch.ID.String(),
+			RecipientEmail: buyer.ContactEmail.String,
+			TotalLeads:     int(leadCount),
+		}
+
+		payloadJSON, err := json.Marshal(jobPayload)
+		if err != nil {
+			return enqueued, fmt.Errorf("failed to encode job payload: %w", err)
+		}
+
+		delivery, err := q.ScheduleDelivery(ctx, queries.ScheduleDeliveryParams{
+			TenantID:    schedule.TenantID,
+			CampaignID:  batch.CampaignID,
+			LeadBatchID: batch.ID,
+			ScheduledAt: sql.NullTime{Time: now, Valid: true},
+		})
+		if err != nil {
+			return enqueued, fmt.Errorf("failed to create delivery for batch %s: %w", batch.ID, err)
+		}
+
+		if _, err := q.CreateDeliveryJob(ctx, queries.CreateDeliveryJobParams{
+			TenantID:         schedule.TenantID,
+			BuyerID:          buyer.ID,
+			DeliveryMethodID: method.ID,
+			DeliveryID:       utils.NullUUID(delivery.ID),
+			ScheduledAt:      now,
+			Payload:          payloadJSON,
+			Description:      utils.SqlNullString(fmt.Sprintf("Scheduled delivery of %s (schedule %s)", batch.BatchName, schedule.ID)),
+		}); err != nil {
+			return enqueued, fmt.Errorf("failed to create job for batch %s: %w", batch.ID, err)
+		}
+		enqueued++
+	}
+
+	return enqueued, nil
+}
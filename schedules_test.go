@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+)
+
+var scheduleColumns = []string{"id", "tenant_id", "buyer_id", "cron_expression", "timezone", "is_active", "last_run_at", "next_run_at", "created_at", "updated_at"}
+
+var deliveryMethodColumns = []string{"id", "tenant_id", "buyer_id", "method_type", "config", "is_active", "created_at", "updated_at"}
+
+var auditLogColumns = []string{"id", "tenant_id", "actor_id", "action", "details", "created_at", "updated_at"}
+
+func testSchedule(cronExpression, timezone string) queries.DeliverySchedule {
+	return queries.DeliverySchedule{
+		ID:             uuid.New(),
+		TenantID:       uuid.New(),
+		BuyerID:        uuid.NullUUID{UUID: uuid.New(), Valid: true},
+		CronExpression: sql.NullString{String: cronExpression, Valid: cronExpression != ""},
+		Timezone:       sql.NullString{String: timezone, Valid: timezone != ""},
+		IsActive:       sql.NullBool{Bool: true, Valid: true},
+		NextRunAt:      sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true},
+	}
+}
+
+func TestNextScheduleRun(t *testing.T) {
+	utc := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.UTC()
+	}
+	tests := []struct {
+		name     string
+		cron, tz string
+		after    string
+		want     string
+	}{
+		{name: "utc daily", cron: "0 9 * * *", after: "2026-03-07T10:00:00Z", want: "2026-03-08T09:00:00Z"},
+		{name: "utc explicit", cron: "0 9 * * *", tz: "UTC", after: "2026-03-07T08:00:00Z", want: "2026-03-07T09:00:00Z"},
+		{name: "exactly at a run", cron: "0 9 * * *", after: "2026-03-07T09:00:00Z", want: "2026-03-08T09:00:00Z"},
+		{name: "every 15 minutes", cron: "*/15 * * * *", after: "2026-03-07T09:07:30Z", want: "2026-03-07T09:15:00Z"},
+		{name: "new york winter", cron: "0 9 * * *", tz: "America/New_York", after: "2026-01-15T12:00:00Z", want: "2026-01-15T14:00:00Z"},
+		{name: "new york summer", cron: "0 9 * * *", tz: "America/New_York", after: "2026-07-15T12:00:00Z", want: "2026-07-15T13:00:00Z"},
+		{name: "tokyo weekdays", cron: "0 9 * * 1-5", tz: "Asia/Tokyo", after: "2026-03-06T01:00:00Z", want: "2026-03-09T00:00:00Z"},
+		{name: "kolkata half hour offset", cron: "0 9 * * *", tz: "Asia/Kolkata", after: "2026-03-07T00:00:00Z", want: "2026-03-07T03:30:00Z"},
+		{name: "berlin weekly", cron: "0 8 * * 1", tz: "Europe/Berlin", after: "2026-03-24T00:00:00Z", want: "2026-03-30T06:00:00Z"},
+
+		// Clocks spring forward in New York at 02:00 on 2026-03-08
+		{name: "daily across spring forward", cron: "0 9 * * *", tz: "America/New_York", after: "2026-03-07T15:00:00Z", want: "2026-03-08T13:00:00Z"},
+		{name: "run in the skipped hour", cron: "30 2 * * *", tz: "America/New_York", after: "2026-03-07T12:00:00Z", want: "2026-03-08T07:30:00Z"},
+		{name: "after the skipped hour", cron: "30 2 * * *", tz: "America/New_York", after: "2026-03-08T07:30:00Z", want: "2026-03-09T06:30:00Z"},
+		{name: "hourly across spring forward", cron: "30 * * * *", tz: "America/New_York", after: "2026-03-08T06:30:00Z", want: "2026-03-08T07:30:00Z"},
+
+		// And fall back at 02:00 on 2026-11-01, repeating 01:00-02:00
+		{name: "run in the repeated hour", cron: "30 1 * * *", tz: "America/New_York", after: "2026-10-31T12:00:00Z", want: "2026-11-01T05:30:00Z"},
+		{name: "repeated hour runs once", cron: "30 1 * * *", tz: "America/New_York", after: "2026-11-01T05:30:00Z", want: "2026-11-02T06:30:00Z"},
+		{name: "daily across fall back", cron: "0 9 * * *", tz: "America/New_York", after: "2026-10-31T14:00:00Z", want: "2026-11-01T14:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextScheduleRun(testSchedule(tt.cron, tt.tz), utc(tt.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := utc(tt.want); !got.Equal(want) || got.Location() != time.UTC {
+				t.Errorf("next run %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestNextScheduleRunInvalid(t *testing.T) {
+	tests := []struct {
+		cron, tz string
+		want     string
+	}{
+		{cron: "", want: "missing cron expression"},
+		{cron: "0 9 * * *", tz: "Mars/Olympus_Mons", want: "unknown timezone"},
+		{cron: "0 25 * * *", want: "bad cron expression"},
+		{cron: "every day", want: "bad cron expression"},
+		{cron: "0 0 30 2 *", want: "never runs"},
+	}
+	for _, tt := range tests {
+		_, err := nextScheduleRun(testSchedule(tt.cron, tt.tz), time.Now())
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("nextScheduleRun(%q, %q) error = %v, want %q", tt.cron, tt.tz, err, tt.want)
+		}
+	}
+}
+
+// expectLockSchedule begins the schedule's transaction and locks it
+func expectLockSchedule(mock sqlmock.Sqlmock, s queries.DeliverySchedule) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(queryName("LockDueSchedule")).
+		WithArgs(s.ID, s.TenantID).
+		WillReturnRows(sqlmock.NewRows(scheduleColumns).
+			AddRow(s.ID, s.TenantID, s.BuyerID, s.CronExpression, s.Timezone, s.IsActive, nil, s.NextRunAt, nil, nil))
+}
+
+// expectAudit expects an audit log entry for the schedule with action
+func expectAudit(mock sqlmock.Sqlmock, s queries.DeliverySchedule, action string) {
+	details := argFunc(func(v driver.Value) bool {
+		b, _ := v.([]byte)
+		var d map[string]string
+		return json.Unmarshal(b, &d) == nil && d["schedule_id"] == s.ID.String() && d["error"] != ""
+	})
+	mock.ExpectQuery(queryName("CreateAuditLog")).
+		WithArgs(s.TenantID, nil, action, details).
+		WillReturnRows(sqlmock.NewRows(auditLogColumns).AddRow(uuid.New(), s.TenantID, nil, action, nil, nil, nil))
+}
+
+// expectAdvance expects the schedule's last and next run to be set
+func expectAdvance(mock sqlmock.Sqlmock, s queries.DeliverySchedule) {
+	set := argFunc(func(v driver.Value) bool { _, ok := v.(time.Time); return ok })
+	mock.ExpectQuery(queryName("UpdateDeliverySchedule")).
+		WithArgs(s.ID, nil, nil, nil, set, set, s.TenantID).
+		WillReturnRows(sqlmock.NewRows(scheduleColumns).AddRow(s.ID, s.TenantID, s.BuyerID, nil, nil, true, nil, nil, nil, nil))
+}
+
+func TestRunScheduleDisablesWhenBuyerGone(t *testing.T) {
+	quietLogs(t)
+	q, mock := useMockDB(t)
+	s := testSchedule("0 9 * * *", "")
+
+	expectLockSchedule(mock, s)
+	mock.ExpectQuery(queryName("GetBuyerByID")).
+		WithArgs(s.BuyerID.UUID, s.TenantID).
+		WillReturnRows(sqlmock.NewRows(buyerColumns))
+	mock.ExpectQuery(queryName("UpdateDeliverySchedule")).
+		WithArgs(s.ID, nil, nil, false, nil, nil, s.TenantID).
+		WillReturnRows(sqlmock.NewRows(scheduleColumns).AddRow(s.ID, s.TenantID, s.BuyerID, nil, nil, false, nil, nil, nil, nil))
+	expectAudit(mock, s, "delivery_schedule.disabled")
+	mock.ExpectCommit()
+
+	err := runSchedule(context.Background(), q, s)
+	if !errors.Is(err, errScheduleNoBuyer) {
+		t.Errorf("runSchedule error = %v, want errScheduleNoBuyer", err)
+	}
+}
+
+func TestRunScheduleSkipsWithoutDeliveryMethod(t *testing.T) {
+	quietLogs(t)
+	q, mock := useMockDB(t)
+	s := testSchedule("0 9 * * *", "")
+
+	expectLockSchedule(mock, s)
+	expectBuyer(mock, &queries.DeliveryJob{BuyerID: s.BuyerID.UUID, TenantID: s.TenantID}, nil)
+	mock.ExpectQuery(queryName("GetDeliveryMethodByBuyerID")).
+		WithArgs(s.BuyerID, s.TenantID).
+		WillReturnRows(sqlmock.NewRows(deliveryMethodColumns))
+	expectAudit(mock, s, "delivery_schedule.skipped")
+	// The run is recorded and the schedule moves on, still active
+	expectAdvance(mock, s)
+	mock.ExpectCommit()
+
+	if err := runSchedule(context.Background(), q, s); err != nil {
+		t.Errorf("runSchedule: %v", err)
+	}
+}
+
+func TestRunScheduleRollsBackTransientFailures(t *testing.T) {
+	quietLogs(t)
+	q, mock := useMockDB(t)
+	s := testSchedule("0 9 * * *", "")
+
+	expectLockSchedule(mock, s)
+	mock.ExpectQuery(queryName("GetBuyerByID")).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	if err := runSchedule(context.Background(), q, s); err == nil || errors.Is(err, errScheduleNoBuyer) {
+		t.Errorf("runSchedule error = %v, want the fetch failure", err)
+	}
+}
+
+func TestRunScheduleFirstEvaluationOnlyInitializes(t *testing.T) {
+	q, mock := useMockDB(t)
+	s := testSchedule("0 9 * * *", "")
+	s.NextRunAt = sql.NullTime{}
+
+	expectLockSchedule(mock, s)
+	set := argFunc(func(v driver.Value) bool { _, ok := v.(time.Time); return ok })
+	mock.ExpectQuery(queryName("UpdateDeliverySchedule")).
+		WithArgs(s.ID, nil, nil, nil, nil, set, s.TenantID).
+		WillReturnRows(sqlmock.NewRows(scheduleColumns).AddRow(s.ID, s.TenantID, s.BuyerID, nil, nil, true, nil, nil, nil, nil))
+	mock.ExpectCommit()
+
+	if err := runSchedule(context.Background(), q, s); err != nil {
+		t.Errorf("runSchedule: %v", err)
+	}
+}
+
+func TestEnqueueScheduledJobsOnlyFinishedBatches(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	s := testSchedule("0 9 * * *", "")
+	methodID, campaignID, deliveryID := uuid.New(), uuid.New(), uuid.New()
+	empty, ready := uuid.New(), uuid.New()
+	now := time.Date(2026, 3, 7, 9, 0, 0, 0, time.UTC)
+
+	expectBuyer(mock, &queries.DeliveryJob{BuyerID: s.BuyerID.UUID, TenantID: s.TenantID}, nil)
+	mock.ExpectQuery(queryName("GetDeliveryMethodByBuyerID")).
+		WithArgs(s.BuyerID, s.TenantID).
+		WillReturnRows(sqlmock.NewRows(deliveryMethodColumns).AddRow(methodID, s.TenantID, s.BuyerID, "email", []byte(`{}`), true, nil, nil))
+	// Only finished batches of the buyer's active campaigns that have no delivery yet
+	finished := queryName("ListUndeliveredLeadBatchesForBuyer")
+	for _, clause := range []string{`lb.status IN ('completed', 'ready')`, `COALESCE(c.is_active, TRUE)`, `NOT EXISTS`, `d.lead_batch_id = lb.id`} {
+		finished += `(?s).*` + regexp.QuoteMeta(clause)
+	}
+	mock.ExpectQuery(finished).
+		WithArgs(s.TenantID, s.BuyerID).
+		WillReturnRows(sqlmock.NewRows(leadBatchColumns).
+			AddRow(empty, s.TenantID, campaignID, nil, "Empty", 0, "ready", nil, nil, nil).
+			AddRow(ready, s.TenantID, campaignID, nil, "March", 5, "completed", nil, nil, nil))
+
+	// A batch without leads yet waits for a later run
+	mock.ExpectQuery(queryName("CountLeadsByBatch")).
+		WithArgs(s.TenantID, empty).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(queryName("CountLeadsByBatch")).
+		WithArgs(s.TenantID, ready).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	mock.ExpectQuery(queryName("ScheduleDelivery")).
+		WithArgs(s.TenantID, campaignID, ready, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "campaign_id", "scheduled_at", "delivered_at", "status", "created_at", "updated_at", "lead_batch_id"}).
+			AddRow(deliveryID, s.TenantID, campaignID, now, nil, "pending", nil, nil, ready))
+	jobPayload := argFunc(func(v driver.Value) bool {
+		b, _ := v.([]byte)
+		var p payload.Payload
+		return json.Unmarshal(b, &p) == nil && p.Mode == payload.ModeReference &&
+			p.LeadBatchID == ready.String() && p.CampaignID == campaignID.String() && p.TotalLeads == 5 && len(p.Leads) == 0
+	})
+	mock.ExpectQuery(queryName("CreateDeliveryJob")).
+		WithArgs(s.TenantID, s.BuyerID.UUID, methodID, deliveryID, now, nil, nil, jobPayload, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(jobColumns).AddRow(jobRow(&queries.DeliveryJob{ID: uuid.New(), TenantID: s.TenantID})...))
+
+	enqueued, err := enqueueScheduledJobs(context.Background(), q, s, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enqueued != 1 {
+		t.Errorf("enqueued %d jobs, want 1", enqueued)
+	}
+}
+
+func TestEnqueueScheduledJobsWithoutBuyer(t *testing.T) {
+	q, _ := newMockQueries(t)
+	s := testSchedule("0 9 * * *", "")
+	s.BuyerID = uuid.NullUUID{}
+	if _, err := enqueueScheduledJobs(context.Background(), q, s, time.Now()); !errors.Is(err, errScheduleNoBuyer) {
+		t.Errorf("enqueueScheduledJobs error = %v, want errScheduleNoBuyer", err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/leadfile"
+)
+
+// ErrPermanentSFTPFailure indicates a non-retryable SFTP error (bad credentials, host key mismatch, denied path)
+var ErrPermanentSFTPFailure = errors.New("permanent SFTP failure")
+
+// errHostKeyMismatch is returned by the host key callback when the server's key doesn't match the configured fingerprint
+var errHostKeyMismatch = errors.New("host key fingerprint mismatch")
+
+type SFTPDeliveryConfig struct {
+	Host                 string `json:"host"`
+	Port                 int    `json:"port,omitempty"` // Defaults to 22
+	Username             string `json:"username"`
+	Password             string `json:"password,omitempty"`
+	PrivateKey           string `json:"private_key,omitempty"` // PEM encoded
+	PrivateKeyPassphrase string `json:"private_key_passphrase,omitempty"`
+	HostKeyFingerprint   string `json:"host_key_fingerprint"`       // "SHA256:..." as printed by ssh-keygen -lf
+	RemoteDir            string `json:"remote_dir,omitempty"`       // Defaults to the login directory
+	FilenamePattern      string `json:"filename_pattern,omitempty"` // Supports {timestamp}, {date}, {job_id}, {buyer_id}, {schema_version}
+	TimeoutSec           int    `json:"timeout_sec,omitempty"`      // Connect timeout in seconds
+}
+
+// sshClientConfig builds the SSH client configuration. A host key fingerprint is required;
+// we never connect to an unverified server.
+func sshClientConfig(cfg SFTPDeliveryConfig) (*ssh.ClientConfig, error) {
+	if cfg.HostKeyFingerprint == "" {
+		return nil, fmt.Errorf("%w: host_key_fingerprint is required", ErrPermanentSFTPFailure)
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if cfg.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(cfg.PrivateKey), []byte(cfg.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(cfg.PrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid private key: %v", ErrPermanentSFTPFailure, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("%w: password or private_key is required", ErrPermanentSFTPFailure)
+	}
+
+	expected := strings.TrimSpace(cfg.HostKeyFingerprint)
+	timeout := 30 * time.Second
+	if cfg.TimeoutSec > 0 {
+		timeout = time.Duration(cfg.TimeoutSec) * time.Second
+	}
+
+	return &ssh.ClientConfig{
+		User: cfg.Username,
+		Auth: auth,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if actual := ssh.FingerprintSHA256(key); actual != expected {
+				return fmt.Errorf("%w: got %s", errHostKeyMismatch, actual)
+			}
+			return nil
+		},
+		Timeout: timeout,
+	}, nil
+}
+
+// dialSFTP opens an SSH connection and starts an SFTP session on it
+func dialSFTP(ctx context.Context, cfg SFTPDeliveryConfig) (*sftp.Client, error) {
+	clientConfig, err := sshClientConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 22
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+
+	dialer := net.Dialer{Timeout: clientConfig.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("sftp connect to %s failed: %w", addr, err)
+	}
+	return startSFTP(ctx, conn, addr, clientConfig)
+}
+
+// startSFTP runs the SSH handshake on conn and starts an SFTP session, closing conn if
+// either fails. The SSH client doesn't watch ctx, so conn gets a deadline of the
+// connect timeout or ctx's deadline, whichever is sooner, and one in the past if ctx
+// is cancelled, until the session is up.
+func startSFTP(ctx context.Context, conn net.Conn, addr string, clientConfig *ssh.ClientConfig) (*sftp.Client, error) {
+	deadline := time.Now().Add(clientConfig.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, classifySFTPError(fmt.Errorf("ssh handshake with %s failed: %w", addr, err))
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		stop()
+		sshClient.Close()
+		return nil, fmt.Errorf("failed to start sftp session: %w", err)
+	}
+	if !stop() {
+		client.Close()
+		return nil, fmt.Errorf("sftp connect to %s: %w", addr, ctx.Err())
+	}
+	conn.SetDeadline(time.Time{})
+	return client, nil
+}
+
+// classifySFTPError marks failures that retrying cannot fix as ErrPermanentSFTPFailure
+func classifySFTPError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, errHostKeyMismatch) || strings.Contains(err.Error(), "unable to authenticate") {
+		return fmt.Errorf("%w: %w", ErrPermanentSFTPFailure, err)
+	}
+	// The client reports missing paths and denied access as os errors, other statuses as is
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("%w: %w", ErrPermanentSFTPFailure, err)
+	}
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.FxCode() {
+		case sftp.ErrSSHFxPermissionDenied, sftp.ErrSSHFxNoSuchFile:
+			return fmt.Errorf("%w: %w", ErrPermanentSFTPFailure, err)
+		}
+	}
+	return err
+}
+
+// uploadSFTP writes data to dir/name atomically: the file is written under a temporary
+// name and renamed into place, so buyers polling the directory never see a partial file.
+// It takes an established client so it can run against an in-process SFTP server.
+func uploadSFTP(client *sftp.Client, dir, name string, data io.Reader) error {
+	if dir == "" {
+		dir = "."
+	}
+	finalPath := path.Join(dir, name)
+	tempPath := path.Join(dir, "."+name+".part")
+
+	f, err := client.Create(tempPath)
+	if err != nil {
+		return classifySFTPError(fmt.Errorf("failed to create %s: %w", tempPath, err))
+	}
+
+	if _, err := f.ReadFrom(data); err != nil {
+		f.Close()
+		client.Remove(tempPath)
+		return classifySFTPError(fmt.Errorf("failed to write %s: %w", tempPath, err))
+	}
+	if err := f.Close(); err != nil {
+		client.Remove(tempPath)
+		return classifySFTPError(fmt.Errorf("failed to close %s: %w", tempPath, err))
+	}
+
+	// posix-rename overwrites an existing target; fall back to plain rename on servers without it
+	if err := client.PosixRename(tempPath, finalPath); err != nil {
+		if renameErr := client.Rename(tempPath, finalPath); renameErr != nil {
+			client.Remove(tempPath)
+			return classifySFTPError(fmt.Errorf("failed to rename %s to %s: %w", tempPath, finalPath, renameErr))
+		}
+	}
+	return nil
+}
+
+// deliverSFTP uploads the lead file to the buyer's SFTP server
+func deliverSFTP(ctx context.Context, job *queries.DeliveryJob, cfg SFTPDeliveryConfig, file *leadfile.File) error {
+	client, err := dialSFTP(ctx, cfg)
+	if err != nil {
+		log.Printf("SFTP delivery failed to connect: %v", err)
+		return err
+	}
+	defer client.Close()
+
+	filename := file.Filename(deliveryFilename(cfg.FilenamePattern, job, time.Now()))
+	log.Printf("Uploading %s to sftp://%s/%s", filename, cfg.Host, cfg.RemoteDir)
+
+	// The file is written straight into the upload as it's rendered
+	data := file.Open()
+	defer data.Close()
+	if err := uploadSFTP(client, cfg.RemoteDir, filename, data); err != nil {
+		log.Printf("SFTP delivery failed: %v", err)
+		return err
+	}
+
+	log.Printf("SFTP delivery successful: %s", filename)
+	return nil
+}
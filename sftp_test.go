@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const sftpTestPassword = "s3cret"
+
+// sftpTestServer serves an in-memory SFTP filesystem on one end of a net.Pipe, accepting
+// sftpTestPassword, and returns the other end and the server's host key fingerprint
+func sftpTestServer(t *testing.T) (net.Conn, string) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != sftpTestPassword {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	clientSide, serverSide := net.Pipe()
+	t.Cleanup(func() {
+		clientSide.Close()
+		serverSide.Close()
+	})
+	go func() {
+		_, chans, reqs, err := ssh.NewServerConn(newQueuedWriteConn(serverSide), config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			if newChannel.ChannelType() != "session" {
+				newChannel.Reject(ssh.UnknownChannelType, "sessions only")
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				for req := range requests {
+					ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+					req.Reply(ok, nil)
+					if ok {
+						server := sftp.NewRequestServer(channel, sftp.InMemHandler())
+						go func() {
+							server.Serve()
+							server.Close()
+						}()
+					}
+				}
+			}()
+		}
+	}()
+	return clientSide, ssh.FingerprintSHA256(hostKey.PublicKey())
+}
+
+func sftpTestConfig(fingerprint, password string) SFTPDeliveryConfig {
+	return SFTPDeliveryConfig{
+		Host:               "buyer.example.com",
+		Username:           "buyer",
+		Password:           password,
+		HostKeyFingerprint: fingerprint,
+		TimeoutSec:         5,
+	}
+}
+
+func startTestSFTP(t *testing.T, conn net.Conn, cfg SFTPDeliveryConfig) (*sftp.Client, error) {
+	t.Helper()
+	clientConfig, err := sshClientConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return startSFTP(context.Background(), conn, "buyer.example.com:22", clientConfig)
+}
+
+func TestUploadSFTPRenamesTempFileIntoPlace(t *testing.T) {
+	conn, fingerprint := sftpTestServer(t)
+	client, err := startTestSFTP(t, conn, sftpTestConfig(fingerprint, sftpTestPassword))
+	if err != nil {
+		t.Fatalf("startSFTP: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.MkdirAll("/incoming"); err != nil {
+		t.Fatal(err)
+	}
+	old, err := client.Create("/incoming/leads.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Write([]byte("old file"))
+	old.Close()
+
+	// While the upload is being written, only the temporary file may hold it
+	var seenPart bool
+	data := io.MultiReader(strings.NewReader("id,email\n"), readerFunc(func(p []byte) (int, error) {
+		if _, err := client.Stat("/incoming/.leads.csv.part"); err != nil {
+			t.Errorf("temp file missing during upload: %v", err)
+		}
+		if content := readSFTPFile(t, client, "/incoming/leads.csv"); content != "old file" {
+			t.Errorf("target changed during upload: %q", content)
+		}
+		seenPart = true
+		return 0, io.EOF
+	}))
+	if err := uploadSFTP(client, "/incoming", "leads.csv", data); err != nil {
+		t.Fatalf("uploadSFTP: %v", err)
+	}
+
+	if !seenPart {
+		t.Fatal("upload never read the whole file")
+	}
+	if got := readSFTPFile(t, client, "/incoming/leads.csv"); got != "id,email\n" {
+		t.Errorf("uploaded file = %q, want %q", got, "id,email\n")
+	}
+	if _, err := client.Stat("/incoming/.leads.csv.part"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temp file left behind: %v", err)
+	}
+}
+
+func TestUploadSFTPMissingDirIsPermanent(t *testing.T) {
+	conn, fingerprint := sftpTestServer(t)
+	client, err := startTestSFTP(t, conn, sftpTestConfig(fingerprint, sftpTestPassword))
+	if err != nil {
+		t.Fatalf("startSFTP: %v", err)
+	}
+	defer client.Close()
+
+	err = uploadSFTP(client, "/missing", "leads.csv", strings.NewReader("id\n"))
+	if !errors.Is(err, ErrPermanentSFTPFailure) {
+		t.Fatalf("err = %v, want ErrPermanentSFTPFailure", err)
+	}
+}
+
+func TestStartSFTPAuthFailureIsPermanent(t *testing.T) {
+	conn, fingerprint := sftpTestServer(t)
+	_, err := startTestSFTP(t, conn, sftpTestConfig(fingerprint, "wrong"))
+	if !errors.Is(err, ErrPermanentSFTPFailure) {
+		t.Fatalf("err = %v, want ErrPermanentSFTPFailure", err)
+	}
+}
+
+func TestStartSFTPHostKeyMismatchIsPermanent(t *testing.T) {
+	conn, _ := sftpTestServer(t)
+	_, err := startTestSFTP(t, conn, sftpTestConfig("SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", sftpTestPassword))
+	if !errors.Is(err, ErrPermanentSFTPFailure) || !errors.Is(err, errHostKeyMismatch) {
+		t.Fatalf("err = %v, want a permanent host key mismatch", err)
+	}
+}
+
+func TestStartSFTPHandshakeStopsWithContext(t *testing.T) {
+	// A server that never answers
+	clientSide, serverSide := net.Pipe()
+	defer serverSide.Close()
+
+	clientConfig, err := sshClientConfig(sftpTestConfig("SHA256:unused", sftpTestPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = startSFTP(ctx, clientSide, "buyer.example.com:22", clientConfig)
+	if err == nil {
+		t.Fatal("startSFTP succeeded against a silent server")
+	}
+	if errors.Is(err, ErrPermanentSFTPFailure) {
+		t.Errorf("timeout classified as permanent: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("handshake took %s, want it cut off by the context", elapsed)
+	}
+}
+
+// queuedWriteConn hands writes to a goroutine, so both ends of a net.Pipe can send their
+// SSH version line at once instead of each waiting for the other to read
+type queuedWriteConn struct {
+	net.Conn
+	writes chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newQueuedWriteConn(conn net.Conn) *queuedWriteConn {
+	c := &queuedWriteConn{Conn: conn, writes: make(chan []byte, 64), closed: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case b := <-c.writes:
+				if _, err := conn.Write(b); err != nil {
+					c.Close()
+				}
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *queuedWriteConn) Write(p []byte) (int, error) {
+	select {
+	case c.writes <- append([]byte(nil), p...):
+		return len(p), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *queuedWriteConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+func readSFTPFile(t *testing.T, client *sftp.Client, name string) string {
+	t.Helper()
+	f, err := client.Open(name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(data)
+}
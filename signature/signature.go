@@ -0,0 +1,159 @@
+// Package signature signs lead deliveries sent to buyer API endpoints and lets
+// buyers verify them.
+//
+// Each signed request carries a header of the form
+//
+//	X-LeadShip-Signature: t=1700000000,v1=5257a869...,v1=9d1c0e7b...
+//
+// where t is the Unix time the request was signed and each v1 is the hex
+// HMAC-SHA256 of "<t>.<raw request body>" under one of the sender's active
+// secrets. Two v1 values are present while a secret is being rotated; a request
+// is authentic if any of them matches a secret the receiver holds.
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderName is the request header carrying the signature
+const HeaderName = "X-LeadShip-Signature"
+
+// DefaultTolerance is how far a signature's timestamp may be from the receiver's clock
+const DefaultTolerance = 5 * time.Minute
+
+// MaxActiveSecrets is how many secrets may sign a request at once (current and previous)
+const MaxActiveSecrets = 2
+
+var (
+	ErrInvalidHeader    = errors.New("signature: malformed signature header")
+	ErrTimestampExpired = errors.New("signature: timestamp outside tolerance")
+	ErrNoValidSignature = errors.New("signature: no signature matches")
+	ErrNoSecrets        = errors.New("signature: no secrets provided")
+	ErrTooManySecrets   = fmt.Errorf("signature: at most %d active secrets", MaxActiveSecrets)
+)
+
+// ComputeSignature returns the hex HMAC-SHA256 of "<t>.<body>" under secret
+func ComputeSignature(t time.Time, body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(t.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the signature header value for body at time t, with one v1 entry per secret
+func Sign(body []byte, t time.Time, secrets ...string) (string, error) {
+	return SignReader(bytes.NewReader(body), t, secrets...)
+}
+
+// SignReader is Sign for a body read to EOF from r, so a large body is signed without
+// being held in memory. The body sent must be exactly the bytes read.
+func SignReader(r io.Reader, t time.Time, secrets ...string) (string, error) {
+	if len(secrets) == 0 {
+		return "", ErrNoSecrets
+	}
+	if len(secrets) > MaxActiveSecrets {
+		return "", ErrTooManySecrets
+	}
+
+	prefix := []byte(strconv.FormatInt(t.Unix(), 10) + ".")
+	macs := make([]io.Writer, len(secrets))
+	for i, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(prefix)
+		macs[i] = mac
+	}
+	if _, err := io.Copy(io.MultiWriter(macs...), r); err != nil {
+		return "", fmt.Errorf("signature: reading body: %w", err)
+	}
+
+	parts := []string{"t=" + strconv.FormatInt(t.Unix(), 10)}
+	for _, mac := range macs {
+		parts = append(parts, "v1="+hex.EncodeToString(mac.(hash.Hash).Sum(nil)))
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// parseHeader splits a signature header into its timestamp and v1 signatures
+func parseHeader(header string) (time.Time, []string, error) {
+	var ts time.Time
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return time.Time{}, nil, ErrInvalidHeader
+		}
+		switch key {
+		case "t":
+			unix, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return time.Time{}, nil, ErrInvalidHeader
+			}
+			ts = time.Unix(unix, 0)
+		case "v1":
+			sigs = append(sigs, value)
+		}
+		// Unknown schemes are ignored so new ones can be added without breaking receivers
+	}
+	if ts.IsZero() || len(sigs) == 0 {
+		return time.Time{}, nil, ErrInvalidHeader
+	}
+	return ts, sigs, nil
+}
+
+// Verify checks a signature header against the raw body. It succeeds if the timestamp is
+// within tolerance of now and any v1 signature matches any of the receiver's secrets.
+// Pass both the old and new secret while the sender rotates.
+func Verify(header string, body []byte, tolerance time.Duration, secrets ...string) error {
+	if len(secrets) == 0 {
+		return ErrNoSecrets
+	}
+
+	ts, sigs, err := parseHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if tolerance > 0 {
+		if age := time.Since(ts); age > tolerance || age < -tolerance {
+			return ErrTimestampExpired
+		}
+	}
+
+	for _, secret := range secrets {
+		expected := []byte(ComputeSignature(ts, body, secret))
+		for _, sig := range sigs {
+			if hmac.Equal(expected, []byte(sig)) {
+				return nil
+			}
+		}
+	}
+	return ErrNoValidSignature
+}
+
+// VerifyRequest reads and verifies r's body, then restores it so handlers can read it again.
+// It returns the body bytes for convenience.
+func VerifyRequest(r *http.Request, tolerance time.Duration, secrets ...string) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("signature: reading body: %w", err)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := Verify(r.Header.Get(HeaderName), body, tolerance, secrets...); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
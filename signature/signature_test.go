@@ -0,0 +1,234 @@
+package signature
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+const (
+	oldSecret = "whsec_old"
+	newSecret = "whsec_new"
+)
+
+var body = []byte(`{"leads":[{"id":"1","email":"a@example.com"}]}`)
+
+func mustSign(t *testing.T, body []byte, ts time.Time, secrets ...string) string {
+	t.Helper()
+	header, err := Sign(body, ts, secrets...)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return header
+}
+
+func TestSignHeaderFormat(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	header := mustSign(t, body, ts, newSecret, oldSecret)
+
+	want := "t=1700000000,v1=" + ComputeSignature(ts, body, newSecret) + ",v1=" + ComputeSignature(ts, body, oldSecret)
+	if header != want {
+		t.Errorf("header = %s\nwant %s", header, want)
+	}
+}
+
+func TestSignLimitsSecrets(t *testing.T) {
+	if _, err := Sign(body, time.Now()); !errors.Is(err, ErrNoSecrets) {
+		t.Errorf("no secrets: err = %v, want ErrNoSecrets", err)
+	}
+	if _, err := Sign(body, time.Now(), "a", "b", "c"); !errors.Is(err, ErrTooManySecrets) {
+		t.Errorf("three secrets: err = %v, want ErrTooManySecrets", err)
+	}
+}
+
+// A streamed body signs exactly like the same bytes in memory, however it is read
+func TestSignReaderMatchesSign(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	want := mustSign(t, body, ts, newSecret, oldSecret)
+	for name, r := range map[string]io.Reader{
+		"whole":       bytes.NewReader(body),
+		"byte a time": iotest.OneByteReader(bytes.NewReader(body)),
+		"half reads":  iotest.HalfReader(bytes.NewReader(body)),
+	} {
+		got, err := SignReader(r, ts, newSecret, oldSecret)
+		if err != nil {
+			t.Fatalf("%s: SignReader: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("%s: SignReader = %s, want %s", name, got, want)
+		}
+	}
+
+	readErr := errors.New("render failed")
+	if _, err := SignReader(iotest.ErrReader(readErr), ts, newSecret); !errors.Is(err, readErr) {
+		t.Errorf("failing reader: err = %v, want %v", err, readErr)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		header  string
+		body    []byte
+		secrets []string
+		want    error
+	}{
+		{
+			name:    "valid",
+			header:  mustSign(t, body, now, newSecret),
+			body:    body,
+			secrets: []string{newSecret},
+		},
+		{
+			name:    "tampered body",
+			header:  mustSign(t, body, now, newSecret),
+			body:    bytes.Replace(body, []byte("a@example.com"), []byte("b@example.com"), 1),
+			secrets: []string{newSecret},
+			want:    ErrNoValidSignature,
+		},
+		{
+			name:    "tampered signature",
+			header:  strings.Replace(mustSign(t, body, now, newSecret), "v1=", "v1=0", 1),
+			body:    body,
+			secrets: []string{newSecret},
+			want:    ErrNoValidSignature,
+		},
+		{
+			name:    "tampered timestamp",
+			header:  strings.Replace(mustSign(t, body, now, newSecret), "t="+strconv.FormatInt(now.Unix(), 10), "t="+strconv.FormatInt(now.Unix()-1, 10), 1),
+			body:    body,
+			secrets: []string{newSecret},
+			want:    ErrNoValidSignature,
+		},
+		{
+			name:    "wrong secret",
+			header:  mustSign(t, body, now, newSecret),
+			body:    body,
+			secrets: []string{"whsec_other"},
+			want:    ErrNoValidSignature,
+		},
+		{
+			name:    "expired timestamp",
+			header:  mustSign(t, body, now.Add(-DefaultTolerance-time.Minute), newSecret),
+			body:    body,
+			secrets: []string{newSecret},
+			want:    ErrTimestampExpired,
+		},
+		{
+			name:    "timestamp in the future",
+			header:  mustSign(t, body, now.Add(DefaultTolerance+time.Minute), newSecret),
+			body:    body,
+			secrets: []string{newSecret},
+			want:    ErrTimestampExpired,
+		},
+		{
+			name:    "unknown scheme ignored",
+			header:  mustSign(t, body, now, newSecret) + ",v0=deadbeef",
+			body:    body,
+			secrets: []string{newSecret},
+		},
+		{
+			name:   "no secrets",
+			header: mustSign(t, body, now, newSecret),
+			body:   body,
+			want:   ErrNoSecrets,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.header, tt.body, DefaultTolerance, tt.secrets...)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// While the sender rotates it signs with both secrets; a receiver holding either one,
+// or both, accepts the request
+func TestVerifyDuringRotation(t *testing.T) {
+	now := time.Now()
+	header := mustSign(t, body, now, newSecret, oldSecret)
+	for _, secrets := range [][]string{{oldSecret}, {newSecret}, {oldSecret, newSecret}, {"whsec_other", oldSecret}} {
+		if err := Verify(header, body, DefaultTolerance, secrets...); err != nil {
+			t.Errorf("receiver holding %v: %v", secrets, err)
+		}
+	}
+
+	// After the rotation the old secret alone no longer verifies
+	header = mustSign(t, body, now, newSecret)
+	if err := Verify(header, body, DefaultTolerance, oldSecret); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("old secret after rotation: err = %v, want ErrNoValidSignature", err)
+	}
+}
+
+func TestVerifyMalformedHeader(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"garbage",
+		"t=1700000000",
+		"v1=abcdef",
+		"t=notanumber,v1=abcdef",
+		"t=1700000000,v1",
+		",,,",
+	} {
+		if err := Verify(header, body, 0, newSecret); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidHeader", header, err)
+		}
+	}
+}
+
+// A zero tolerance skips the timestamp check, for replaying stored requests
+func TestVerifyZeroToleranceSkipsTimestamp(t *testing.T) {
+	header := mustSign(t, body, time.Unix(1700000000, 0), newSecret)
+	if err := Verify(header, body, 0, newSecret); err != nil {
+		t.Errorf("Verify with zero tolerance: %v", err)
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	newRequest := func(header string, body []byte) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/leads", bytes.NewReader(body))
+		if header != "" {
+			r.Header.Set(HeaderName, header)
+		}
+		return r
+	}
+
+	r := newRequest(mustSign(t, body, time.Now(), newSecret, oldSecret), body)
+	got, err := VerifyRequest(r, DefaultTolerance, oldSecret)
+	if err != nil {
+		t.Fatalf("VerifyRequest: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("returned body = %q, want %q", got, body)
+	}
+	// The handler can still read the body
+	again, err := io.ReadAll(r.Body)
+	if err != nil || !bytes.Equal(again, body) {
+		t.Errorf("body after VerifyRequest = %q, %v", again, err)
+	}
+
+	tampered := newRequest(mustSign(t, body, time.Now(), newSecret), []byte(`{"leads":[]}`))
+	if _, err := VerifyRequest(tampered, DefaultTolerance, newSecret); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("tampered request: err = %v, want ErrNoValidSignature", err)
+	}
+
+	unsigned := newRequest("", body)
+	if _, err := VerifyRequest(unsigned, DefaultTolerance, newSecret); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("unsigned request: err = %v, want ErrInvalidHeader", err)
+	}
+
+	expired := newRequest(mustSign(t, body, time.Now().Add(-time.Hour), newSecret), body)
+	if _, err := VerifyRequest(expired, DefaultTolerance, newSecret); !errors.Is(err, ErrTimestampExpired) {
+		t.Errorf("expired request: err = %v, want ErrTimestampExpired", err)
+	}
+}
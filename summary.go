@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/sqlc-dev/pqtype"
+)
+
+// deliverySummary is recorded as delivery_history.payload_summary.
+// Pipeline stages and delivery methods fill in their own sections.
+type deliverySummary struct {
+	LeadCount   int                 `json:"lead_count"`
+	Snapshot    *snapshotSummary    `json:"snapshot,omitempty"`
+	Suppression *suppressionSummary `json:"suppression,omitempty"`
+	Targeting   *targetingSummary   `json:"targeting,omitempty"`
+	Validation  *validationSummary  `json:"validation,omitempty"`
+	Columns     *columnSummary      `json:"columns,omitempty"`
+	Duplicates  *duplicateSummary   `json:"duplicates,omitempty"`
+	Pacing      *pacingSummary      `json:"pacing,omitempty"`
+	Schema      *schemaSummary      `json:"schema,omitempty"`
+	API         *apiDeliverySummary `json:"api,omitempty"`
+	APIResponse *apiResponseSummary `json:"api_response,omitempty"` // Classification of the last API response
+}
+
+// nullRawMessage encodes the summary for CreateDeliveryHistory
+func (s *deliverySummary) nullRawMessage() pqtype.NullRawMessage {
+	if s == nil {
+		return pqtype.NullRawMessage{Valid: false}
+	}
+	raw, err := json.Marshal(s)
+	if err != nil {
+		log.Printf("Failed to encode payload summary: %v", err)
+		return pqtype.NullRawMessage{Valid: false}
+	}
+	return pqtype.NullRawMessage{RawMessage: raw, Valid: true}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/suppression"
+)
+
+// suppressionSummary records which leads the campaign's suppression list removed
+type suppressionSummary struct {
+	ListID  string           `json:"list_id"`
+	Checked int              `json:"checked"`
+	Removed int              `json:"removed"`
+	Reasons map[string]int   `json:"reasons,omitempty"` // Reason -> leads removed for it
+	Leads   []suppressedLead `json:"leads,omitempty"`
+}
+
+type suppressedLead struct {
+	LeadID string `json:"lead_id"`
+	Reason string `json:"reason"`
+}
+
+// payloadCampaign fetches the campaign the payload's leads were bought for, or nil
+// when the payload names none
+func payloadCampaign(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, p *payload.Payload) (*queries.Campaign, error) {
+	if p.CampaignID == "" {
+		return nil, nil
+	}
+	campaign, err := q.GetCampaignByID(ctx, queries.GetCampaignByIDParams{
+		ID:       uuid.MustParse(p.CampaignID), // Checked by payload.Decode
+		TenantID: job.TenantID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch campaign: %w", err)
+	}
+	return &campaign, nil
+}
+
+// applySuppressionList removes leads matching the campaign's suppression list from a
+// page of leads and returns the rest. The summary is nil when there is no campaign or
+// the campaign has no list.
+func applySuppressionList(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, campaign *queries.Campaign, leads []payload.Lead) ([]payload.Lead, *suppressionSummary, error) {
+	if campaign == nil || !campaign.SuppressionListID.Valid {
+		return leads, nil, nil
+	}
+
+	list, err := suppression.Load(ctx, q, job.TenantID, campaign.SuppressionListID.UUID, leads)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load suppression list %s: %w", campaign.SuppressionListID.UUID, err)
+	}
+
+	result := &suppressionSummary{
+		ListID:  list.ID.String(),
+		Checked: len(leads),
+		Reasons: make(map[string]int),
+	}
+	kept := leads[:0]
+	for _, lead := range leads {
+		if reason := list.Match(&lead); reason != "" {
+			result.Removed++
+			result.Reasons[reason]++
+			result.Leads = append(result.Leads, suppressedLead{LeadID: lead.ID, Reason: reason})
+			continue
+		}
+		kept = append(kept, lead)
+	}
+
+	if result.Removed > 0 {
+		log.Printf("Suppression list %s removed %d of %d leads: %v", list.ID, result.Removed, result.Checked, result.Reasons)
+	}
+	return kept, result, nil
+}
+
+// add counts another page's results in s
+func (s *suppressionSummary) add(page *suppressionSummary) {
+	s.Checked += page.Checked
+	s.Removed += page.Removed
+	for reason, n := range page.Reasons {
+		s.Reasons[reason] += n
+	}
+	s.Leads = append(s.Leads, page.Leads...)
+}
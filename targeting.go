@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/targeting"
+	"github.com/DylanCoon99/delivery/internal/utils"
+)
+
+// quarantineStageTargeting marks lead_quarantine rows written by applyTargeting
+const quarantineStageTargeting = "targeting"
+
+// targetingSummary records which leads missed the campaign's targeting criteria
+type targetingSummary struct {
+	Checked     int               `json:"checked"`
+	Quarantined int               `json:"quarantined"`
+	Fields      map[string]int    `json:"fields,omitempty"` // Field -> leads that failed it
+	Leads       []quarantinedLead `json:"leads,omitempty"`
+}
+
+type quarantinedLead struct {
+	LeadID  string   `json:"lead_id"`
+	Reasons []string `json:"reasons"`
+}
+
+// applyTargeting removes leads that don't meet the campaign's targeting criteria from a
+// page of leads, quarantining them for review, and returns the rest. The summary is
+// nil when the campaign has no criteria. Criteria that can't be parsed return an error
+// wrapping targeting.ErrInvalidCriteria.
+func applyTargeting(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, campaign *queries.Campaign, leads []payload.Lead) ([]payload.Lead, *targetingSummary, error) {
+	if campaign == nil || !campaign.TargetingCriteria.Valid {
+		return leads, nil, nil
+	}
+	filter, err := targeting.Parse(campaign.TargetingCriteria.RawMessage)
+	if err != nil {
+		return nil, nil, fmt.Errorf("campaign %s: %w", campaign.ID, err)
+	}
+	if filter == nil {
+		return leads, nil, nil
+	}
+
+	result := &targetingSummary{
+		Checked: len(leads),
+		Fields:  make(map[string]int),
+	}
+	params := queries.InsertLeadQuarantineParams{
+		TenantID:   job.TenantID,
+		CampaignID: utils.NullUUID(campaign.ID),
+		JobID:      utils.NullUUID(job.ID),
+		Stage:      quarantineStageTargeting,
+	}
+	kept := leads[:0]
+	for _, lead := range leads {
+		misses := filter.Evaluate(&lead)
+		if len(misses) == 0 {
+			kept = append(kept, lead)
+			continue
+		}
+		reasons := make([]string, len(misses))
+		for i, m := range misses {
+			reasons[i] = m.String()
+			result.Fields[m.Field]++
+		}
+		result.Quarantined++
+		result.Leads = append(result.Leads, quarantinedLead{LeadID: lead.ID, Reasons: reasons})
+		params.LeadIds = append(params.LeadIds, lead.ID)
+		params.Reasons = append(params.Reasons, strings.Join(reasons, "; "))
+	}
+
+	if result.Quarantined > 0 {
+		// Only leads stored in leads get a lead_quarantine row; embedded leads without one
+		// are still held back and listed in the summary
+		recorded, err := q.InsertLeadQuarantine(ctx, params)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to quarantine leads: %w", err)
+		}
+		log.Printf("Targeting quarantined %d of %d leads (%d recorded): %v", result.Quarantined, result.Checked, recorded, result.Fields)
+	}
+	return kept, result, nil
+}
+
+// add counts another page's results in s
+func (s *targetingSummary) add(page *targetingSummary) {
+	s.Checked += page.Checked
+	s.Quarantined += page.Quarantined
+	for field, n := range page.Fields {
+		s.Fields[field] += n
+	}
+	s.Leads = append(s.Leads, page.Leads...)
+}
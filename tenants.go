@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+)
+
+const (
+	tenantPageSize            = 50
+	defaultTenantJobBudget    = 10               // Jobs claimed per tenant per round
+	invocationDeadlineReserve = 30 * time.Second // Stop claiming when this close to the Lambda timeout
+)
+
+// tenantJobBudget reads TENANT_JOB_BUDGET, falling back to the default
+func tenantJobBudget() int32 {
+	if v, err := strconv.Atoi(os.Getenv("TENANT_JOB_BUDGET")); err == nil && v > 0 {
+		return int32(v)
+	}
+	return defaultTenantJobBudget
+}
+
+// jobClaimLimit reads JOB_CLAIM_LIMIT, the cap on jobs processed per invocation
+func jobClaimLimit() int {
+	if v, err := strconv.Atoi(os.Getenv("JOB_CLAIM_LIMIT")); err == nil && v > 0 {
+		return v
+	}
+	return defaultJobClaimLimit
+}
+
+// listTenantIDs pages through every tenant
+func listTenantIDs(ctx context.Context, q *queries.Queries) ([]uuid.UUID, error) {
+	var all []uuid.UUID
+	after := uuid.Nil
+	for {
+		page, err := q.ListTenantIDsAfter(ctx, queries.ListTenantIDsAfterParams{
+			ID:    after,
+			Limit: tenantPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if len(page) < tenantPageSize {
+			return all, nil
+		}
+		after = page[len(page)-1]
+	}
+}
+
+// nearDeadline reports whether the invocation should stop picking up new work
+func nearDeadline(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < invocationDeadlineReserve
+}
+
+// processTenantJobs works tenants' due jobs round-robin: each round claims at most
+// budget jobs per tenant, and only tenants that filled their budget get another round.
+// The starting tenant rotates per invocation so the total limit doesn't always favor the same tenants.
+func processTenantJobs(ctx context.Context, q *queries.Queries, owner string, tenantIDs []uuid.UUID) {
+	if len(tenantIDs) == 0 {
+		return
+	}
+
+	start := rand.IntN(len(tenantIDs))
+	active := append(append([]uuid.UUID{}, tenantIDs[start:]...), tenantIDs[:start]...)
+
+	claim := func(tenantID uuid.UUID, limit int32) ([]queries.DeliveryJob, error) {
+		return claimDueJobs(ctx, q, owner, tenantID, limit)
+	}
+	process := func(job *queries.DeliveryJob) {
+		if err := processJob(ctx, q, job); err != nil {
+			log.Printf("job %s failed: %v", job.ID, err)
+		}
+	}
+	sweepTenants(ctx, active, tenantJobBudget(), jobClaimLimit(), claim, process)
+}
+
+// sweepTenants runs processTenantJobs' rounds over tenants in the given order, claiming
+// up to budget jobs per tenant per round and remaining jobs in all
+func sweepTenants(ctx context.Context, active []uuid.UUID, budget int32, remaining int,
+	claim func(tenantID uuid.UUID, limit int32) ([]queries.DeliveryJob, error), process func(job *queries.DeliveryJob)) {
+	for round := 1; len(active) > 0 && remaining > 0; round++ {
+		var next []uuid.UUID
+		for _, tenantID := range active {
+			if remaining <= 0 || nearDeadline(ctx) {
+				log.Printf("Stopping job sweep in round %d: claim limit or deadline reached", round)
+				return
+			}
+
+			limit := min(budget, int32(remaining))
+			jobs, err := claim(tenantID, limit)
+			if err != nil {
+				log.Printf("Failed to claim jobs for tenant %s: %v", tenantID, err)
+				continue
+			}
+			if len(jobs) == 0 {
+				continue
+			}
+			log.Printf("Round %d: claimed %d jobs for tenant %s", round, len(jobs), tenantID)
+
+			for _, job := range jobs {
+				process(&job)
+			}
+			remaining -= len(jobs)
+
+			// A full budget means the tenant may have more due jobs
+			if int32(len(jobs)) == limit {
+				next = append(next, tenantID)
+			}
+		}
+		active = next
+	}
+}
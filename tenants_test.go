@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+)
+
+// tenantBacklogs fakes claimDueJobs over a number of due jobs per tenant, recording
+// each claim's limit and which tenant each processed job belonged to
+type tenantBacklogs struct {
+	due       map[uuid.UUID]int
+	fail      map[uuid.UUID]bool
+	claims    []int32
+	processed []uuid.UUID
+}
+
+func (b *tenantBacklogs) claim(tenantID uuid.UUID, limit int32) ([]queries.DeliveryJob, error) {
+	b.claims = append(b.claims, limit)
+	if b.fail[tenantID] {
+		return nil, errors.New("connection reset")
+	}
+	n := min(b.due[tenantID], int(limit))
+	b.due[tenantID] -= n
+	jobs := make([]queries.DeliveryJob, n)
+	for i := range jobs {
+		jobs[i] = queries.DeliveryJob{ID: uuid.New(), TenantID: tenantID}
+	}
+	return jobs, nil
+}
+
+func (b *tenantBacklogs) process(job *queries.DeliveryJob) {
+	b.processed = append(b.processed, job.TenantID)
+}
+
+// runs condenses processed into each tenant's consecutive jobs
+func (b *tenantBacklogs) runs(names map[uuid.UUID]string) []string {
+	var runs []string
+	for i, tenantID := range b.processed {
+		if i == 0 || b.processed[i-1] != tenantID {
+			runs = append(runs, "")
+		}
+		runs[len(runs)-1] += names[tenantID]
+	}
+	return runs
+}
+
+func TestSweepTenantsBacklogDoesntStarve(t *testing.T) {
+	quietLogs(t)
+	busy, small, medium := uuid.New(), uuid.New(), uuid.New()
+	names := map[uuid.UUID]string{busy: "a", small: "b", medium: "c"}
+	tests := []struct {
+		name      string
+		due       map[uuid.UUID]int
+		budget    int32
+		remaining int
+		runs      []string
+		claims    []int32
+	}{
+		{
+			// Every tenant gets a turn before the busy one's second round
+			name:      "backlog first in line",
+			due:       map[uuid.UUID]int{busy: 1000, small: 2, medium: 5},
+			budget:    3,
+			remaining: 14,
+			runs:      []string{"aaa", "bb", "ccc", "aaa", "cc", "a"},
+			claims:    []int32{3, 3, 3, 3, 3, 1},
+		},
+		{
+			// The claim limit cuts the busy tenant short, not the others
+			name:      "claim limit",
+			due:       map[uuid.UUID]int{busy: 1000, small: 2, medium: 5},
+			budget:    3,
+			remaining: 10,
+			runs:      []string{"aaa", "bb", "ccc", "aa"},
+			claims:    []int32{3, 3, 3, 2},
+		},
+		{
+			name:      "quiet tenants drop out",
+			due:       map[uuid.UUID]int{busy: 6, small: 0, medium: 3},
+			budget:    3,
+			remaining: 100,
+			runs:      []string{"aaa", "ccc", "aaa"},
+			claims:    []int32{3, 3, 3, 3, 3, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &tenantBacklogs{due: tt.due}
+			sweepTenants(context.Background(), []uuid.UUID{busy, small, medium}, tt.budget, tt.remaining, b.claim, b.process)
+			if got := b.runs(names); !reflect.DeepEqual(got, tt.runs) {
+				t.Errorf("processed %q, want %q", got, tt.runs)
+			}
+			if !reflect.DeepEqual(b.claims, tt.claims) {
+				t.Errorf("claim limits %v, want %v", b.claims, tt.claims)
+			}
+		})
+	}
+}
+
+func TestSweepTenantsClaimFailure(t *testing.T) {
+	quietLogs(t)
+	broken, ok := uuid.New(), uuid.New()
+	b := &tenantBacklogs{due: map[uuid.UUID]int{broken: 10, ok: 4}, fail: map[uuid.UUID]bool{broken: true}}
+	sweepTenants(context.Background(), []uuid.UUID{broken, ok}, 3, 100, b.claim, b.process)
+
+	// The failing tenant sits out the later rounds; the other is fully worked
+	if got := b.runs(map[uuid.UUID]string{broken: "x", ok: "o"}); !reflect.DeepEqual(got, []string{"oooo"}) {
+		t.Errorf("processed %q, want the healthy tenant's 4 jobs", got)
+	}
+	if !reflect.DeepEqual(b.claims, []int32{3, 3, 3}) {
+		t.Errorf("claim limits %v", b.claims)
+	}
+}
+
+func TestSweepTenantsDeadline(t *testing.T) {
+	quietLogs(t)
+	first, second := uuid.New(), uuid.New()
+	b := &tenantBacklogs{due: map[uuid.UUID]int{first: 10, second: 10}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	process := func(job *queries.DeliveryJob) {
+		b.process(job)
+		cancel() // The invocation runs out of time during the first job
+	}
+	sweepTenants(ctx, []uuid.UUID{first, second}, 3, 100, b.claim, process)
+
+	// The claimed batch is finished, but no more are claimed
+	if len(b.processed) != 3 || len(b.claims) != 1 {
+		t.Errorf("processed %d jobs over %d claims after the deadline, want 3 over 1", len(b.processed), len(b.claims))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), invocationDeadlineReserve/2)
+	defer cancel()
+	b = &tenantBacklogs{due: map[uuid.UUID]int{first: 10}}
+	sweepTenants(ctx, []uuid.UUID{first}, 3, 100, b.claim, b.process)
+	if len(b.claims) != 0 {
+		t.Errorf("claimed %v inside the deadline reserve", b.claims)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/database/queries"
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/utils"
+	"github.com/DylanCoon99/delivery/internal/validation"
+)
+
+// quarantineStageValidation marks lead_quarantine rows written by applyValidation
+const quarantineStageValidation = "validation"
+
+// validationSummary records the leads that failed validation checks
+type validationSummary struct {
+	Checked      int                 `json:"checked"`
+	Dropped      int                 `json:"dropped"`
+	Flagged      int                 `json:"flagged"`
+	Checks       map[string]int      `json:"checks,omitempty"` // Check -> leads that failed it
+	Leads        []validation.Result `json:"leads,omitempty"`
+	ReportSentTo string              `json:"report_sent_to,omitempty"` // Supplier sent the rejected-leads report
+
+	recorded int64 // Dropped leads newly quarantined by this attempt
+}
+
+// applyValidation normalizes a page of leads and removes those failing a check the
+// campaign's validation rules drop on, quarantining them, and returns the rest.
+// Campaigns without rules use validation.DefaultRules. Rules that can't be parsed
+// return an error wrapping validation.ErrInvalidRules. The supplier's report is sent
+// by reportValidation once every page is checked.
+func applyValidation(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, campaign *queries.Campaign, leads []payload.Lead) ([]payload.Lead, *validationSummary, error) {
+	var raw []byte
+	if campaign != nil && campaign.ValidationRules.Valid {
+		raw = campaign.ValidationRules.RawMessage
+	}
+	rules, err := validation.ParseRules(raw)
+	if err != nil {
+		if campaign != nil {
+			return nil, nil, fmt.Errorf("campaign %s: %w", campaign.ID, err)
+		}
+		return nil, nil, err
+	}
+
+	// Email is checked on the decrypted address; the keys are wiped once the checks are done
+	emails, wipe := leadEmailSource()
+	defer wipe()
+
+	pipeline := validation.NewPipeline(rules, validation.Standard(emails)...)
+	checked := len(leads)
+	kept, results, err := pipeline.Run(ctx, leads)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &validationSummary{
+		Checked: checked,
+		Checks:  make(map[string]int),
+		Leads:   results,
+	}
+	if len(results) == 0 {
+		return kept, result, nil
+	}
+
+	params := queries.InsertLeadQuarantineParams{
+		TenantID: job.TenantID,
+		JobID:    utils.NullUUID(job.ID),
+		Stage:    quarantineStageValidation,
+	}
+	if campaign != nil {
+		params.CampaignID = utils.NullUUID(campaign.ID)
+	}
+	for _, r := range results {
+		var reasons []string
+		for _, issue := range r.Issues {
+			result.Checks[issue.Check]++
+			if issue.Action == validation.ActionDrop {
+				reasons = append(reasons, issue.String())
+			}
+		}
+		if !r.Dropped {
+			result.Flagged++
+			continue
+		}
+		result.Dropped++
+		params.LeadIds = append(params.LeadIds, r.LeadID)
+		params.Reasons = append(params.Reasons, strings.Join(reasons, "; "))
+	}
+
+	if result.Dropped > 0 {
+		result.recorded, err = q.InsertLeadQuarantine(ctx, params)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to quarantine leads: %w", err)
+		}
+	}
+	log.Printf("Job %s: validation dropped %d (%d recorded) and flagged %d of %d leads: %v",
+		job.ID, result.Dropped, result.recorded, result.Flagged, result.Checked, result.Checks)
+	return kept, result, nil
+}
+
+// add counts another page's results in s
+func (s *validationSummary) add(page *validationSummary) {
+	s.Checked += page.Checked
+	s.Dropped += page.Dropped
+	s.Flagged += page.Flagged
+	for check, n := range page.Checks {
+		s.Checks[check] += n
+	}
+	s.Leads = append(s.Leads, page.Leads...)
+	s.recorded += page.recorded
+}
+
+// reportValidation sends the supplier the report of every lead with issues once all of
+// the job's leads are checked. Every buyer's job for a batch checks the same leads, so
+// the first job with issues claims the batch and reports; the rest, and retries, send
+// nothing. A claim whose report couldn't be sent is released for a later job.
+func reportValidation(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, p *payload.Payload, result *validationSummary) {
+	if result == nil || len(result.Leads) == 0 {
+		return
+	}
+	batchID, err := uuid.Parse(p.LeadBatchID)
+	if err != nil {
+		return
+	}
+	claimed, err := q.ClaimLeadBatchValidationReport(ctx, queries.ClaimLeadBatchValidationReportParams{ID: batchID, TenantID: job.TenantID})
+	if err != nil {
+		log.Printf("Job %s: failed to claim validation report for batch %s: %v", job.ID, batchID, err)
+		return
+	}
+	if claimed == 0 {
+		log.Printf("Job %s: validation report for batch %s already sent", job.ID, batchID)
+		return
+	}
+	result.ReportSentTo = sendValidationReport(ctx, q, job, batchID, result.Leads)
+	if result.ReportSentTo == "" {
+		if err := q.ReleaseLeadBatchValidationReport(ctx, queries.ReleaseLeadBatchValidationReportParams{ID: batchID, TenantID: job.TenantID}); err != nil {
+			log.Printf("Job %s: failed to release validation report for batch %s: %v", job.ID, batchID, err)
+		}
+	}
+}
+
+// sendValidationReport emails the batch's supplier a CSV of the leads with validation
+// issues and returns the address it was sent to. Reports are best effort: failures are
+// logged, and batches without a supplier contact send nothing.
+func sendValidationReport(ctx context.Context, q *queries.Queries, job *queries.DeliveryJob, batchID uuid.UUID, results []validation.Result) string {
+	batch, err := q.GetLeadBatchByID(ctx, queries.GetLeadBatchByIDParams{ID: batchID, TenantID: job.TenantID})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Job %s: failed to fetch lead batch for validation report: %v", job.ID, err)
+		}
+		return ""
+	}
+	if !batch.SupplierID.Valid {
+		return ""
+	}
+	supplier, err := q.GetSupplierByID(ctx, queries.GetSupplierByIDParams{ID: batch.SupplierID.UUID, TenantID: job.TenantID})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Job %s: failed to fetch supplier for validation report: %v", job.ID, err)
+		}
+		return ""
+	}
+	recipient := strings.TrimSpace(supplier.ContactEmail.String)
+	if recipient == "" {
+		return ""
+	}
+	if suppressed, reason, _ := isEmailSuppressed(ctx, recipient); suppressed {
+		log.Printf("Job %s: not sending validation report to suppressed email %s, reason: %s", job.ID, recipient, reason)
+		return ""
+	}
+
+	report, err := validationReportCSV(results)
+	if err != nil {
+		log.Printf("Job %s: failed to build validation report: %v", job.ID, err)
+		return ""
+	}
+	dropped := 0
+	for _, r := range results {
+		if r.Dropped {
+			dropped++
+		}
+	}
+	subject := fmt.Sprintf("Lead validation report: %s", batch.BatchName)
+	bodyText := fmt.Sprintf("%d leads of batch %s were rejected and %d were delivered with problems. Details are attached.",
+		dropped, batch.BatchName, len(results)-dropped)
+	filename := fmt.Sprintf("rejected_leads_%s.csv", batch.ID)
+
+	message, err := mimeMessage(recipient, subject, bodyText, filename, "text/csv", bytes.NewReader(report))
+	if err != nil {
+		log.Printf("Job %s: failed to build validation report email: %v", job.ID, err)
+		return ""
+	}
+	if _, err := sesClient.SendRawEmail(ctx, &ses.SendRawEmailInput{
+		RawMessage: &types.RawMessage{
+			Data: message,
+		},
+		Source: aws.String(SenderAddress),
+	}); err != nil {
+		log.Printf("Job %s: failed to send validation report to %s: %v", job.ID, recipient, err)
+		return ""
+	}
+	log.Printf("Job %s: sent validation report for %d leads to supplier %s", job.ID, len(results), supplier.ID)
+	return recipient
+}
+
+// validationReportCSV writes one row per issue
+func validationReportCSV(results []validation.Result) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"lead_id", "action", "check", "field", "value", "problem"}); err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		for _, issue := range r.Issues {
+			if err := w.Write([]string{r.LeadID, issue.Action, issue.Check, issue.Field, issue.Value, issue.Problem}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
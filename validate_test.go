@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+
+	"github.com/DylanCoon99/delivery/internal/payload"
+	"github.com/DylanCoon99/delivery/internal/validation"
+)
+
+var leadBatchColumns = []string{"id", "tenant_id", "campaign_id", "supplier_id", "batch_name", "total_leads", "status", "created_at", "updated_at", "validation_reported_at"}
+
+func flaggedSummary() *validationSummary {
+	return &validationSummary{Checked: 1, Flagged: 1, Leads: []validation.Result{{
+		LeadID: "a",
+		Issues: []validation.Issue{{Check: validation.CheckState, Field: "state", Value: "Ontario", Problem: "not a US state abbreviation", Action: validation.ActionFlag}},
+	}}}
+}
+
+func TestReportValidationOncePerBatch(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	job := testJob()
+	batchID := uuid.New()
+	p := &payload.Payload{LeadBatchID: batchID.String()}
+
+	// Another buyer's job already reported the batch
+	mock.ExpectExec(queryName("ClaimLeadBatchValidationReport")).
+		WithArgs(batchID, job.TenantID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	result := flaggedSummary()
+	reportValidation(context.Background(), q, job, p, result)
+	if result.ReportSentTo != "" {
+		t.Errorf("report sent to %s for a batch already reported", result.ReportSentTo)
+	}
+}
+
+func TestReportValidationReleasesUnsentClaim(t *testing.T) {
+	quietLogs(t)
+	q, mock := newMockQueries(t)
+	job := testJob()
+	batchID := uuid.New()
+	p := &payload.Payload{LeadBatchID: batchID.String()}
+
+	// The batch has no supplier to report to, so the claim is given up
+	mock.ExpectExec(queryName("ClaimLeadBatchValidationReport")).
+		WithArgs(batchID, job.TenantID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(queryName("GetLeadBatchByID")).
+		WithArgs(batchID, job.TenantID).
+		WillReturnRows(sqlmock.NewRows(leadBatchColumns).
+			AddRow(batchID, job.TenantID, uuid.New(), nil, "Batch", 1, "completed", nil, nil, nil))
+	mock.ExpectExec(queryName("ReleaseLeadBatchValidationReport")).
+		WithArgs(batchID, job.TenantID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	reportValidation(context.Background(), q, job, p, flaggedSummary())
+}
+
+func TestReportValidationNothingToReport(t *testing.T) {
+	q, _ := newMockQueries(t)
+	job := testJob()
+
+	// No issues, or no batch to claim, touch nothing
+	reportValidation(context.Background(), q, job, &payload.Payload{LeadBatchID: uuid.NewString()}, &validationSummary{Checked: 3})
+	reportValidation(context.Background(), q, job, &payload.Payload{}, flaggedSummary())
+	reportValidation(context.Background(), q, job, &payload.Payload{}, nil)
+}